@@ -2,6 +2,7 @@ package blocks
 
 import (
 	"github.com/titosilva/drmchain-pos/blocks/merkle"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
 	"github.com/titosilva/drmchain-pos/transactions"
 )
 
@@ -9,9 +10,11 @@ type Block struct {
 	ForgerTag       string
 	ForgerSignature []byte
 	Index           uint64
+	Timestamp       int64 // Unix seconds, set by the forger
 	Hash            []byte
 	Merkle          *merkle.MerkleTree
 	Transations     []transactions.Transaction
+	StorageProofs   []storageproofs.Proof
 
 	Previous *Block
 }
@@ -0,0 +1,37 @@
+package blocksconfig
+
+import "github.com/titosilva/drmchain-pos/internal/di"
+
+type PruningMode string
+
+const (
+	// Keep every block
+	PruningArchive PruningMode = "archive"
+	// Keep the last N blocks, older blocks are only recoverable through snapshots
+	PruningKeepLast PruningMode = "keep_last"
+	// Keep the last N blocks, only the headers of older blocks are kept
+	PruningHeadersOnly PruningMode = "headers_only"
+)
+
+type BlocksConfig struct {
+	PruningMode      PruningMode
+	KeepLast         uint64
+	SnapshotInterval uint64 // Blocks between periodic snapshots, 0 disables them
+
+	// Stakes of the validators whose signatures make a snapshot trusted, from genesis or a checkpoint
+	// obtained out of band. When empty, the stakes of the local history are used.
+	TrustedValidators map[string]uint64
+}
+
+func Factory(diCtx *di.DIContext) *BlocksConfig {
+	return &BlocksConfig{
+		PruningMode:       PruningArchive,
+		KeepLast:          1000,
+		SnapshotInterval:  100,
+		TrustedValidators: make(map[string]uint64),
+	}
+}
+
+func GetFromDI(diCtx *di.DIContext) *BlocksConfig {
+	return di.GetService[BlocksConfig](diCtx)
+}
@@ -1,12 +1,20 @@
 package blocksdi
 
 import (
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/blockstore"
 	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/blocks/snapshots"
+	"github.com/titosilva/drmchain-pos/blocks/snapshots/snapshotnetwork"
 	"github.com/titosilva/drmchain-pos/internal/di"
 )
 
 func AddBlocksServices(diCtx *di.DIContext) *di.DIContext {
+	di.AddSingleton(diCtx, blocksconfig.Factory)
+	di.AddSingleton(diCtx, blockstore.Factory)
 	di.AddSingleton(diCtx, history.Factory)
+	di.AddSingleton(diCtx, snapshots.Factory)
+	di.AddSingleton(diCtx, snapshotnetwork.Factory)
 
 	return diCtx
 }
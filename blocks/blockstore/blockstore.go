@@ -0,0 +1,399 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/merkle"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/patterns/longtask"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+var (
+	ErrBlockNotFound       = errors.New("block not found")
+	ErrBlockPruned         = errors.New("block was pruned")
+	ErrTransactionsPruned  = errors.New("block transactions were pruned, only the header is available")
+	ErrUnknownPruningMode  = errors.New("unknown pruning mode")
+	errPruningNotAvailable = errors.New("nothing to prune")
+)
+
+const (
+	prunedKey   = "blocks/pruned"
+	snapshotKey = "blocks/snapshot"
+)
+
+type BlockHeader struct {
+	Index           int64
+	Timestamp       int64
+	ForgerTag       string
+	ForgerSignature []byte
+	Hash            []byte
+	MerkleRoot      []byte
+}
+
+type storedBlock struct {
+	Header             BlockHeader
+	TransactionsPruned bool
+	Transactions       [][]byte
+	StorageProofs      []storageproofs.Proof
+}
+
+// BlockStore persists blocks in a BlobStorage and prunes them according to the configured mode.
+type BlockStore struct {
+	storage  storage.BlobStorage
+	mode     blocksconfig.PruningMode
+	keepLast uint64
+
+	pruneMux    *sync.Mutex
+	pruneTask   *longtask.LongTask[any]
+	prunedUntil uint64
+
+	// Index of the latest stored snapshot. Blocks after it are never deleted, as nothing else could replace them.
+	snapshotIndex uint64
+}
+
+func Factory(diCtx *di.DIContext) *BlockStore {
+	config := blocksconfig.GetFromDI(diCtx)
+	return New(storage.GetFromDI(diCtx), config.PruningMode, config.KeepLast)
+}
+
+func GetFromDI(diCtx *di.DIContext) *BlockStore {
+	return di.GetService[BlockStore](diCtx)
+}
+
+func New(store storage.BlobStorage, mode blocksconfig.PruningMode, keepLast uint64) *BlockStore {
+	bs := &BlockStore{
+		storage:  store,
+		mode:     mode,
+		keepLast: keepLast,
+		pruneMux: &sync.Mutex{},
+	}
+
+	if prunedUntil, err := bs.loadIndex(prunedKey); err == nil {
+		bs.prunedUntil = prunedUntil
+	}
+
+	if snapshotIndex, err := bs.loadIndex(snapshotKey); err == nil {
+		bs.snapshotIndex = snapshotIndex
+	}
+
+	return bs
+}
+
+// Put stores the block with all its transactions.
+func (bs *BlockStore) Put(block *blocks.Block) error {
+	stored := storedBlock{
+		Header:        headerOf(block),
+		Transactions:  make([][]byte, 0, len(block.Transations)),
+		StorageProofs: block.StorageProofs,
+	}
+
+	for _, tx := range block.Transations {
+		stored.Transactions = append(stored.Transactions, tx.GetRaw())
+	}
+
+	return bs.write(block.Index, stored)
+}
+
+// Get loads a full block. Fails with ErrBlockPruned or ErrTransactionsPruned if the block was pruned.
+func (bs *BlockStore) Get(index uint64) (*blocks.Block, error) {
+	stored, err := bs.read(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.TransactionsPruned {
+		return nil, ErrTransactionsPruned
+	}
+
+	return toBlock(stored)
+}
+
+// GetStorageInputs loads the block with only what the storage proof registry is derived from:
+// its header, storage proofs and storage commitment transactions. They are kept in the headers_only mode,
+// so a pruned node can still rebuild the outcomes of the storage challenges.
+func (bs *BlockStore) GetStorageInputs(index uint64) (*blocks.Block, error) {
+	stored, err := bs.read(index)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := toBlock(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	commitments := make([]transactions.Transaction, 0)
+	for _, tx := range block.Transations {
+		if _, err := storageproofs.ParseCommitmentTransaction(tx); err == nil {
+			commitments = append(commitments, tx)
+		}
+	}
+	block.Transations = commitments
+
+	return block, nil
+}
+
+func toBlock(stored *storedBlock) (*blocks.Block, error) {
+	encoder := transactions.NewTransactionAsn1Encoder()
+	block := &blocks.Block{
+		ForgerTag:       stored.Header.ForgerTag,
+		ForgerSignature: stored.Header.ForgerSignature,
+		Index:           uint64(stored.Header.Index),
+		Timestamp:       stored.Header.Timestamp,
+		Hash:            stored.Header.Hash,
+		Merkle:          merkle.NewTree(),
+		Transations:     make([]transactions.Transaction, 0, len(stored.Transactions)),
+		StorageProofs:   stored.StorageProofs,
+	}
+
+	for _, raw := range stored.Transactions {
+		tx, err := encoder.DecodeTransaction(raw)
+		if err != nil {
+			return nil, errorutil.WithInner("failed to decode stored transaction", err)
+		}
+
+		block.Transations = append(block.Transations, tx)
+		block.Merkle.Add(raw)
+	}
+
+	return block, nil
+}
+
+// GetHeader loads only the header of a block. Headers are kept in the headers_only mode.
+func (bs *BlockStore) GetHeader(index uint64) (*BlockHeader, error) {
+	stored, err := bs.read(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored.Header, nil
+}
+
+// GetPrunedUntil returns the highest block index that was pruned.
+func (bs *BlockStore) GetPrunedUntil() uint64 {
+	bs.pruneMux.Lock()
+	defer bs.pruneMux.Unlock()
+	return bs.prunedUntil
+}
+
+// StartPruning prunes the store in the background every time a block is received in the subscription.
+func (bs *BlockStore) StartPruning(sub *observable.Subscription[*blocks.Block]) {
+	bs.pruneTask = longtask.Run(func(cancellation context.Context) any {
+		for {
+			select {
+			case block := <-sub.Channel():
+				if block == nil {
+					continue
+				}
+
+				if err := bs.Prune(block.Index); err != nil && !errors.Is(err, errPruningNotAvailable) {
+					log.Println("failed to prune blocks: ", err)
+				}
+			case <-sub.WaitClose():
+				return true
+			case <-cancellation.Done():
+				return false
+			}
+		}
+	}).Finally(func() {
+		sub.Unsubscribe()
+	})
+
+	go bs.pruneTask.Await()
+}
+
+func (bs *BlockStore) StopPruning() {
+	if bs.pruneTask != nil {
+		bs.pruneTask.Cancel()
+	}
+}
+
+// SetSnapshotIndex records that a snapshot of the state at index is stored,
+// so that the keep_last mode may delete the blocks up to it.
+func (bs *BlockStore) SetSnapshotIndex(index uint64) error {
+	bs.pruneMux.Lock()
+	defer bs.pruneMux.Unlock()
+
+	if index <= bs.snapshotIndex {
+		return nil
+	}
+
+	bs.snapshotIndex = index
+	return bs.storage.Store(snapshotKey, []byte(strconv.FormatUint(index, 10)))
+}
+
+// MarkPrunedUntil records that the blocks up to index are not available, as after restoring from a snapshot.
+func (bs *BlockStore) MarkPrunedUntil(index uint64) error {
+	bs.pruneMux.Lock()
+	defer bs.pruneMux.Unlock()
+
+	if index <= bs.prunedUntil {
+		return nil
+	}
+
+	bs.prunedUntil = index
+	return bs.storage.Store(prunedKey, []byte(strconv.FormatUint(index, 10)))
+}
+
+// Prune applies the pruning mode considering lastIndex as the tip of the chain.
+// The keep_last mode only deletes blocks covered by the latest stored snapshot.
+func (bs *BlockStore) Prune(lastIndex uint64) error {
+	bs.pruneMux.Lock()
+	defer bs.pruneMux.Unlock()
+
+	if bs.mode == blocksconfig.PruningArchive || lastIndex <= bs.keepLast {
+		return errPruningNotAvailable
+	}
+
+	until := lastIndex - bs.keepLast
+	if bs.mode == blocksconfig.PruningKeepLast {
+		until = min(until, bs.snapshotIndex)
+	}
+
+	if until <= bs.prunedUntil {
+		return errPruningNotAvailable
+	}
+
+	for index := bs.prunedUntil + 1; index <= until; index++ {
+		if err := bs.pruneBlock(index); err != nil {
+			return err
+		}
+
+		bs.prunedUntil = index
+	}
+
+	return bs.storage.Store(prunedKey, []byte(strconv.FormatUint(bs.prunedUntil, 10)))
+}
+
+func (bs *BlockStore) pruneBlock(index uint64) error {
+	exists, err := bs.storage.Exists(keyFor(index))
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	switch bs.mode {
+	case blocksconfig.PruningKeepLast:
+		return bs.storage.Delete(keyFor(index))
+	case blocksconfig.PruningHeadersOnly:
+		stored, err := bs.readRaw(index)
+		if err != nil {
+			return err
+		}
+
+		// The storage proofs and commitments are kept, as the registry of storage outcomes is derived from them
+		stored.TransactionsPruned = true
+		stored.Transactions = commitmentsOf(stored.Transactions)
+		return bs.write(index, *stored)
+	default:
+		return ErrUnknownPruningMode
+	}
+}
+
+// commitmentsOf keeps the raw storage commitment transactions
+func commitmentsOf(raws [][]byte) [][]byte {
+	encoder := transactions.NewTransactionAsn1Encoder()
+	kept := make([][]byte, 0)
+	for _, raw := range raws {
+		tx, err := encoder.DecodeTransaction(raw)
+		if err != nil {
+			continue
+		}
+
+		if _, err := storageproofs.ParseCommitmentTransaction(tx); err == nil {
+			kept = append(kept, raw)
+		}
+	}
+
+	return kept
+}
+
+func (bs *BlockStore) read(index uint64) (*storedBlock, error) {
+	exists, err := bs.storage.Exists(keyFor(index))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if index <= bs.GetPrunedUntil() {
+			return nil, ErrBlockPruned
+		}
+
+		return nil, ErrBlockNotFound
+	}
+
+	return bs.readRaw(index)
+}
+
+func (bs *BlockStore) readRaw(index uint64) (*storedBlock, error) {
+	data, err := bs.storage.Retrieve(keyFor(index))
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedBlock
+	if err := encodings.Decode(data, &stored); err != nil {
+		return nil, errorutil.WithInner("failed to decode stored block", err)
+	}
+
+	return &stored, nil
+}
+
+func (bs *BlockStore) write(index uint64, stored storedBlock) error {
+	data, err := encodings.Encode(stored)
+	if err != nil {
+		return errorutil.WithInner("failed to encode block", err)
+	}
+
+	return bs.storage.Store(keyFor(index), data)
+}
+
+func (bs *BlockStore) loadIndex(key string) (uint64, error) {
+	exists, err := bs.storage.Exists(key)
+	if err != nil || !exists {
+		return 0, errPruningNotAvailable
+	}
+
+	data, err := bs.storage.Retrieve(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(data), 10, 64)
+}
+
+func headerOf(block *blocks.Block) BlockHeader {
+	header := BlockHeader{
+		Index:           int64(block.Index),
+		Timestamp:       block.Timestamp,
+		ForgerTag:       block.ForgerTag,
+		ForgerSignature: block.ForgerSignature,
+		Hash:            block.Hash,
+	}
+
+	if block.Merkle != nil {
+		header.MerkleRoot = block.Merkle.GetRoot()
+	}
+
+	return header
+}
+
+func keyFor(index uint64) string {
+	return fmt.Sprintf("blocks/%020d", index)
+}
@@ -0,0 +1,250 @@
+package blockstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/blockstore"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func Test__Prune__ShouldDeleteOldBlocks__WhenKeepingLastBlocks(t *testing.T) {
+	// Arrange
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningKeepLast, 3)
+	putBlocks(t, store, 10)
+	if err := store.SetSnapshotIndex(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := store.Prune(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	for i := uint64(1); i <= 7; i++ {
+		if _, err := store.Get(i); !errors.Is(err, blockstore.ErrBlockPruned) {
+			t.Errorf("Expected block %d to be pruned, got %v", i, err)
+		}
+	}
+
+	for i := uint64(8); i <= 10; i++ {
+		block, err := store.Get(i)
+		if err != nil {
+			t.Fatalf("Expected block %d to be kept, got %v", i, err)
+		}
+
+		if len(block.Transations) != 1 {
+			t.Errorf("Expected 1 transaction in block %d, got %d", i, len(block.Transations))
+		}
+	}
+
+	if _, err := store.Get(11); !errors.Is(err, blockstore.ErrBlockNotFound) {
+		t.Errorf("Expected ErrBlockNotFound for a future block, got %v", err)
+	}
+}
+
+func Test__Prune__ShouldOnlyDeleteBlocksCoveredBySnapshot__WhenKeepingLastBlocks(t *testing.T) {
+	// Arrange
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningKeepLast, 3)
+	putBlocks(t, store, 10)
+
+	// Act
+	store.Prune(10)
+	_, errWithoutSnapshot := store.Get(1)
+
+	if err := store.SetSnapshotIndex(4); err != nil {
+		t.Fatal(err)
+	}
+	store.Prune(10)
+
+	// Assert
+	if errWithoutSnapshot != nil {
+		t.Errorf("Expected block 1 to be kept without a snapshot, got %v", errWithoutSnapshot)
+	}
+
+	for i := uint64(1); i <= 4; i++ {
+		if _, err := store.Get(i); !errors.Is(err, blockstore.ErrBlockPruned) {
+			t.Errorf("Expected block %d to be pruned, got %v", i, err)
+		}
+	}
+
+	if _, err := store.Get(5); err != nil {
+		t.Errorf("Expected block 5 after the snapshot to be kept, got %v", err)
+	}
+}
+
+func Test__Prune__ShouldKeepHeaders__WhenHeadersOnly(t *testing.T) {
+	// Arrange
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningHeadersOnly, 2)
+	putBlocks(t, store, 5)
+
+	// Act
+	if err := store.Prune(5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if _, err := store.Get(1); !errors.Is(err, blockstore.ErrTransactionsPruned) {
+		t.Errorf("Expected transactions of block 1 to be pruned, got %v", err)
+	}
+
+	header, err := store.GetHeader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Index != 1 || header.ForgerTag != "forger" {
+		t.Errorf("Unexpected header %v", header)
+	}
+
+	if _, err := store.Get(4); err != nil {
+		t.Errorf("Expected block 4 to be kept, got %v", err)
+	}
+}
+
+func Test__Prune__ShouldKeepEverything__WhenArchive(t *testing.T) {
+	// Arrange
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0)
+	putBlocks(t, store, 5)
+
+	// Act
+	store.Prune(5)
+
+	// Assert
+	for i := uint64(1); i <= 5; i++ {
+		if _, err := store.Get(i); err != nil {
+			t.Errorf("Expected block %d to be kept, got %v", i, err)
+		}
+	}
+}
+
+func Test__New__ShouldRememberPrunedBlocks__WhenReopened(t *testing.T) {
+	// Arrange
+	path := t.TempDir()
+	store := blockstore.New(localstorage.New(path), blocksconfig.PruningKeepLast, 1)
+	putBlocks(t, store, 3)
+	if err := store.SetSnapshotIndex(3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Prune(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	reopened := blockstore.New(localstorage.New(path), blocksconfig.PruningKeepLast, 1)
+
+	// Assert
+	if _, err := reopened.Get(1); !errors.Is(err, blockstore.ErrBlockPruned) {
+		t.Errorf("Expected block 1 to be pruned, got %v", err)
+	}
+}
+
+func Test__Get__ShouldReturnStorageProofs__WhenBlockHasProofs(t *testing.T) {
+	// Arrange
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0)
+	block := &blocks.Block{
+		ForgerTag: "forger",
+		Index:     1,
+		StorageProofs: []storageproofs.Proof{
+			{ContentId: "content", ProverTag: "forger", BlockIndex: 1, Chunks: []storageproofs.ChunkProof{{Index: 3, Chunk: []byte{1}}}},
+		},
+	}
+
+	if err := store.Put(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	stored, err := store.Get(1)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stored.StorageProofs) != 1 || stored.StorageProofs[0].Chunks[0].Index != 3 {
+		t.Errorf("Unexpected storage proofs %v", stored.StorageProofs)
+	}
+}
+
+func Test__Prune__ShouldKeepStorageInputs__WhenHeadersOnly(t *testing.T) {
+	// Arrange
+	holder, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitment, err := storageproofs.NewCommitmentTransaction(holder, storageproofs.ContentCommitment{ContentId: "content", Root: []byte{1}, Chunks: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningHeadersOnly, 2)
+	block := &blocks.Block{
+		ForgerTag:     holder.GetTag(),
+		Index:         1,
+		Transations:   []transactions.Transaction{commitment, &transactions.TransactionShape{SourceTag: holder.GetTag(), Content: []byte{1}}},
+		StorageProofs: []storageproofs.Proof{{ContentId: "content", ProverTag: holder.GetTag(), BlockIndex: 1}},
+	}
+
+	putBlocks(t, store, 5)
+	if err := store.Put(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := store.Prune(5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if _, err := store.Get(1); !errors.Is(err, blockstore.ErrTransactionsPruned) {
+		t.Fatalf("Expected transactions of block 1 to be pruned, got %v", err)
+	}
+
+	inputs, err := store.GetStorageInputs(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inputs.StorageProofs) != 1 || inputs.StorageProofs[0].ContentId != "content" {
+		t.Errorf("Expected the storage proofs to be kept, got %v", inputs.StorageProofs)
+	}
+
+	if len(inputs.Transations) != 1 {
+		t.Fatalf("Expected only the commitment transaction to be kept, got %d transactions", len(inputs.Transations))
+	}
+
+	if kept, err := storageproofs.ParseCommitmentTransaction(inputs.Transations[0]); err != nil || kept.ContentId != "content" {
+		t.Errorf("Expected the commitment to be kept, got %v (%v)", kept, err)
+	}
+}
+
+func putBlocks(t *testing.T, store *blockstore.BlockStore, count int) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= count; i++ {
+		block := &blocks.Block{
+			ForgerTag: "forger",
+			Index:     uint64(i),
+			Hash:      []byte{byte(i)},
+			Transations: []transactions.Transaction{
+				&transactions.TransactionShape{SourceTag: id.GetTag(), Content: []byte{byte(i)}},
+			},
+		}
+
+		if err := store.Put(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -2,11 +2,19 @@ package history
 
 import (
 	"errors"
+	"iter"
+	"log"
 
 	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blockstore"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/drm/drmstate"
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/clru"
 	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/internal/structures/kv"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
 )
 
 var ErrBlockIndex = errors.New("block index is not the expected")
@@ -14,15 +22,43 @@ var ErrBlockIndex = errors.New("block index is not the expected")
 type BlockHistory struct {
 	lastBlocks    *clru.Cache[uint64, *blocks.Block]
 	currentStakes *cmap.CMap[string, uint64]
+	nonces        *cmap.CMap[string, uint64]
 	lastIndex     uint64
+	appended      *observable.Observable[*blocks.Block]
+	store         *blockstore.BlockStore
+	drm           *drmstate.State
+	storage       *storageproofs.Registry
+	allowlist     *allowlist.Allowlist // Tracked with TrackAllowlist, if set
+}
+
+// State is the derived state of the chain at a given block.
+// It is enough to continue appending blocks without replaying the ones before it.
+type State struct {
+	Index     uint64
+	BlockHash []byte
+	Stakes    map[string]uint64
+	Nonces    map[string]uint64
+	Contents  []drmstate.Content
+	Licenses  []drmstate.License
+	Storage   storageproofs.RegistryState
+	Allowlist allowlist.State
 }
 
 func Factory(diCtx *di.DIContext) *BlockHistory {
+	return New(blockstore.GetFromDI(diCtx))
+}
+
+func New(store *blockstore.BlockStore) *BlockHistory {
 	// TODO: recover data from disk
 	return &BlockHistory{
 		lastBlocks:    clru.New[uint64, *blocks.Block](100),
 		currentStakes: cmap.New[string, uint64](),
+		nonces:        cmap.New[string, uint64](),
 		lastIndex:     0,
+		appended:      observable.New[*blocks.Block](),
+		store:         store,
+		drm:           drmstate.New(),
+		storage:       storageproofs.NewRegistry(),
 	}
 }
 
@@ -35,6 +71,34 @@ func (bh *BlockHistory) GetLastBlock() *blocks.Block {
 	return block
 }
 
+// GetBlock returns the block with the given index, from memory or from the block store.
+// Fails with blockstore.ErrBlockPruned or blockstore.ErrTransactionsPruned when the block was pruned.
+func (bh *BlockHistory) GetBlock(index uint64) (*blocks.Block, error) {
+	if block, found := bh.lastBlocks.Get(index); found && block.Index == index {
+		return block, nil
+	}
+
+	return bh.store.Get(index)
+}
+
+// GetBlockHeader returns the header of the block with the given index from the block store.
+func (bh *BlockHistory) GetBlockHeader(index uint64) (*blockstore.BlockHeader, error) {
+	return bh.store.GetHeader(index)
+}
+
+// StartPruning prunes the block store in the background as new blocks are appended.
+func (bh *BlockHistory) StartPruning() {
+	bh.store.StartPruning(bh.Subscribe())
+}
+
+func (bh *BlockHistory) StopPruning() {
+	bh.store.StopPruning()
+}
+
+func (bh *BlockHistory) GetLastIndex() uint64 {
+	return bh.lastIndex
+}
+
 func (bh *BlockHistory) GetStakes(tag string) uint64 {
 	stakes, found := bh.currentStakes.Get(tag)
 
@@ -45,22 +109,135 @@ func (bh *BlockHistory) GetStakes(tag string) uint64 {
 	return stakes
 }
 
+// GetNonce returns how many transactions from tag were appended to the chain.
+func (bh *BlockHistory) GetNonce(tag string) uint64 {
+	nonce, found := bh.nonces.Get(tag)
+
+	if !found {
+		return 0
+	}
+
+	return nonce
+}
+
+// AllStakes returns a snapshot of the current stake table.
+func (bh *BlockHistory) AllStakes() iter.Seq[kv.KeyValue[string, uint64]] {
+	return bh.currentStakes.All()
+}
+
+// AllNonces returns a snapshot of the current nonces.
+func (bh *BlockHistory) AllNonces() iter.Seq[kv.KeyValue[string, uint64]] {
+	return bh.nonces.All()
+}
+
+// GetDRMState returns the content registry and licenses derived from the appended blocks.
+func (bh *BlockHistory) GetDRMState() *drmstate.State {
+	return bh.drm
+}
+
+// GetStorageRegistry returns the registry of storage commitments and challenge outcomes derived from the appended blocks.
+func (bh *BlockHistory) GetStorageRegistry() *storageproofs.Registry {
+	return bh.storage
+}
+
+// TrackAllowlist makes the history apply the validator set transactions of the blocks appended from now on
+// to the allowlist, before notifying them, and carry the allowlist in the states it is restored from.
+func (bh *BlockHistory) TrackAllowlist(list *allowlist.Allowlist) {
+	bh.allowlist = list
+}
+
+// GetAllowlistState returns the state of the tracked allowlist, or a zero state if none is tracked.
+func (bh *BlockHistory) GetAllowlistState() allowlist.State {
+	if bh.allowlist == nil {
+		return allowlist.State{}
+	}
+
+	return bh.allowlist.State()
+}
+
+// Subscribe returns a subscription that receives every block after it is appended.
+func (bh *BlockHistory) Subscribe() *observable.Subscription[*blocks.Block] {
+	return bh.appended.Subscribe()
+}
+
 func (bh *BlockHistory) Append(block *blocks.Block) error {
 	if bh.lastIndex != 0 && block.Index != bh.lastIndex+1 {
 		return ErrBlockIndex
 	}
 
+	if err := bh.store.Put(block); err != nil {
+		return err
+	}
+
+	block.Previous = bh.GetLastBlock()
 	bh.lastBlocks.Put(block.Index, block)
 	bh.lastIndex = block.Index
-	block.Previous = bh.GetLastBlock()
 
+	bh.drm.Advance(drmstate.BlockContext{Height: block.Index, Timestamp: block.Timestamp})
 	for _, tx := range block.Transations {
 		bh.IncrementStakes(tx.GetSource().GetTag(), 1)
+		bh.incrementNonce(tx.GetSourceTag())
+
+		if err := bh.drm.Apply(tx); err != nil {
+			log.Println("ignoring invalid DRM transaction ", tx.GetHash(), ": ", err)
+		}
+	}
+
+	var prevHash []byte
+	if block.Previous != nil {
+		prevHash = block.Previous.Hash
+	}
+
+	if err := bh.storage.ApplyBlock(block.Index, prevHash, block.ForgerTag, block.Transations, block.StorageProofs); err != nil {
+		log.Println("failed to apply block ", block.Index, " to the storage registry: ", err)
+	}
+
+	if bh.allowlist != nil {
+		bh.allowlist.ApplyBlock(block)
 	}
 
+	bh.appended.Notify(block)
 	return nil
 }
 
+// Restore replaces the current state with the given one.
+// Blocks appended after this must continue from state.Index.
+func (bh *BlockHistory) Restore(state State) {
+	bh.currentStakes = cmap.New[string, uint64]()
+	for tag, stakes := range state.Stakes {
+		bh.currentStakes.Set(tag, stakes)
+	}
+
+	bh.nonces = cmap.New[string, uint64]()
+	for tag, nonce := range state.Nonces {
+		bh.nonces.Set(tag, nonce)
+	}
+
+	bh.drm.Restore(drmstate.BlockContext{Height: state.Index}, state.Contents, state.Licenses)
+	bh.storage.Restore(state.Storage, state.Index)
+	if bh.allowlist != nil {
+		bh.allowlist.Restore(state.Allowlist)
+	}
+
+	// The restored block has no transactions, only what is needed to continue the chain
+	bh.lastBlocks = clru.New[uint64, *blocks.Block](100)
+	bh.lastBlocks.Put(state.Index, &blocks.Block{
+		Index: state.Index,
+		Hash:  state.BlockHash,
+	})
+	bh.lastIndex = state.Index
+
+	// Blocks before the state were never stored here, so they read as pruned rather than missing
+	if err := bh.store.MarkPrunedUntil(state.Index); err != nil {
+		log.Println("failed to mark blocks before the restored state as pruned: ", err)
+	}
+}
+
+// SetSnapshotIndex records that a snapshot of the state at index is stored, so the blocks up to it can be pruned.
+func (bh *BlockHistory) SetSnapshotIndex(index uint64) error {
+	return bh.store.SetSnapshotIndex(index)
+}
+
 func (bh *BlockHistory) IncrementStakes(tag string, amount uint64) {
 	stakes, found := bh.currentStakes.Get(tag)
 
@@ -70,3 +247,13 @@ func (bh *BlockHistory) IncrementStakes(tag string, amount uint64) {
 
 	bh.currentStakes.Set(tag, stakes+amount)
 }
+
+func (bh *BlockHistory) incrementNonce(tag string) {
+	nonce, found := bh.nonces.Get(tag)
+
+	if !found {
+		nonce = 0
+	}
+
+	bh.nonces.Set(tag, nonce+1)
+}
@@ -0,0 +1,268 @@
+package snapshots
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/blocks/merkle"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/drm/drmstate"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+)
+
+type StakeEntry struct {
+	Tag    string
+	Stakes int64
+}
+
+type NonceEntry struct {
+	Tag   string
+	Nonce int64
+}
+
+type SnapshotSignature struct {
+	Tag       string
+	Signature []byte
+}
+
+// Snapshot is a signed copy of the chain state at a given block.
+// Entries are sorted by tag, so that every node computes the same state root.
+type Snapshot struct {
+	Index      int64
+	BlockHash  []byte
+	Stakes     []StakeEntry
+	Nonces     []NonceEntry
+	Contents   []drmstate.Content
+	Licenses   []drmstate.License
+	Storage    storageproofs.RegistryState
+	Allowlist  allowlist.State
+	StateRoot  []byte
+	Signatures []SnapshotSignature
+}
+
+// signedPart is what validators sign. Signatures do not cover each other.
+type signedPart struct {
+	Index     int64
+	BlockHash []byte
+	StateRoot []byte
+}
+
+// FromState creates an unsigned snapshot of the given state.
+func FromState(state history.State) *Snapshot {
+	s := &Snapshot{
+		Index:      int64(state.Index),
+		BlockHash:  state.BlockHash,
+		Stakes:     make([]StakeEntry, 0, len(state.Stakes)),
+		Nonces:     make([]NonceEntry, 0, len(state.Nonces)),
+		Contents:   slices.Clone(state.Contents),
+		Licenses:   slices.Clone(state.Licenses),
+		Storage:    state.Storage,
+		Allowlist:  state.Allowlist,
+		Signatures: make([]SnapshotSignature, 0),
+	}
+
+	for tag, stakes := range state.Stakes {
+		s.Stakes = append(s.Stakes, StakeEntry{Tag: tag, Stakes: int64(stakes)})
+	}
+	sort.Slice(s.Stakes, func(i, j int) bool { return s.Stakes[i].Tag < s.Stakes[j].Tag })
+
+	for tag, nonce := range state.Nonces {
+		s.Nonces = append(s.Nonces, NonceEntry{Tag: tag, Nonce: int64(nonce)})
+	}
+	sort.Slice(s.Nonces, func(i, j int) bool { return s.Nonces[i].Tag < s.Nonces[j].Tag })
+	sort.Slice(s.Contents, func(i, j int) bool { return s.Contents[i].ContentId < s.Contents[j].ContentId })
+	sort.Slice(s.Licenses, func(i, j int) bool { return s.Licenses[i].LicenseId < s.Licenses[j].LicenseId })
+
+	s.StateRoot = s.ComputeStateRoot()
+	return s
+}
+
+// ToState converts the snapshot back to a state that can be restored in a BlockHistory.
+func (s *Snapshot) ToState() history.State {
+	state := history.State{
+		Index:     uint64(s.Index),
+		BlockHash: s.BlockHash,
+		Stakes:    make(map[string]uint64, len(s.Stakes)),
+		Nonces:    make(map[string]uint64, len(s.Nonces)),
+		Contents:  slices.Clone(s.Contents),
+		Licenses:  slices.Clone(s.Licenses),
+		Storage:   s.Storage,
+		Allowlist: s.Allowlist,
+	}
+
+	for _, e := range s.Stakes {
+		state.Stakes[e.Tag] = uint64(e.Stakes)
+	}
+
+	for _, e := range s.Nonces {
+		state.Nonces[e.Tag] = uint64(e.Nonce)
+	}
+
+	return state
+}
+
+// ComputeStateRoot returns the merkle root of every state entry.
+func (s *Snapshot) ComputeStateRoot() []byte {
+	tree := merkle.NewTree()
+
+	for _, e := range s.Stakes {
+		bs, _ := encodings.Encode(e)
+		tree.Add(append([]byte("stake:"), bs...))
+	}
+
+	for _, e := range s.Nonces {
+		bs, _ := encodings.Encode(e)
+		tree.Add(append([]byte("nonce:"), bs...))
+	}
+
+	for _, e := range s.Contents {
+		bs, _ := encodings.Encode(e)
+		tree.Add(append([]byte("content:"), bs...))
+	}
+
+	for _, e := range s.Licenses {
+		bs, _ := encodings.Encode(e)
+		tree.Add(append([]byte("license:"), bs...))
+	}
+
+	// Forgers are elected with the storage outcomes, so nodes bootstrapped from the snapshot must weigh alike
+	bs, _ := encodings.Encode(s.Storage)
+	tree.Add(append([]byte("storage:"), bs...))
+
+	// Validator set updates are not replayed after a bootstrap, so the snapshot carries what they made of the allowlist
+	bs, _ = encodings.Encode(s.Allowlist)
+	tree.Add(append([]byte("allowlist:"), bs...))
+
+	root := tree.GetRoot()
+	if root == nil {
+		return []byte{}
+	}
+
+	return root
+}
+
+// IsConsistent checks that the state root matches the entries of the snapshot.
+func (s *Snapshot) IsConsistent() bool {
+	return slices.Equal(s.StateRoot, s.ComputeStateRoot())
+}
+
+// GetSignedData returns the data that is signed by each validator.
+func (s *Snapshot) GetSignedData() []byte {
+	bs, err := encodings.Encode(signedPart{
+		Index:     s.Index,
+		BlockHash: s.BlockHash,
+		StateRoot: s.StateRoot,
+	})
+
+	if err != nil {
+		panic("failed to encode snapshot signed data")
+	}
+
+	return bs
+}
+
+// Sign adds the signature of id to the snapshot.
+func (s *Snapshot) Sign(id identity.PrivateIdentity) error {
+	signature, err := signatures.Sign(id, s.GetSignedData())
+	if err != nil {
+		return err
+	}
+
+	return s.AddSignature(SnapshotSignature{Tag: id.GetTag(), Signature: signature})
+}
+
+// AddSignature adds a signature made by another validator, if it is valid and new.
+func (s *Snapshot) AddSignature(sig SnapshotSignature) error {
+	if s.HasSigned(sig.Tag) {
+		return nil
+	}
+
+	if !s.isValidSignature(sig) {
+		return ErrInvalidSignature
+	}
+
+	s.Signatures = append(s.Signatures, sig)
+	return nil
+}
+
+func (s *Snapshot) HasSigned(tag string) bool {
+	for _, sig := range s.Signatures {
+		if sig.Tag == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Snapshot) isValidSignature(sig SnapshotSignature) bool {
+	id, err := identity.FromTag(sig.Tag)
+	if err != nil {
+		return false
+	}
+
+	return signatures.Verify(id, s.GetSignedData(), sig.Signature)
+}
+
+// GetTotalStakes returns the sum of every stake in the snapshot.
+func (s *Snapshot) GetTotalStakes() uint64 {
+	total := uint64(0)
+	for _, e := range s.Stakes {
+		total += uint64(e.Stakes)
+	}
+
+	return total
+}
+
+// GetSignedStakes returns the sum of the stakes of every valid signer, according to the given validators.
+// The stakes carried by the snapshot are not used, or a snapshot could vouch for itself.
+func (s *Snapshot) GetSignedStakes(validators map[string]uint64) uint64 {
+	signed := uint64(0)
+	seen := make(map[string]bool, len(s.Signatures))
+	for _, sig := range s.Signatures {
+		if seen[sig.Tag] || !s.isValidSignature(sig) {
+			continue
+		}
+
+		seen[sig.Tag] = true
+		signed += validators[sig.Tag]
+	}
+
+	return signed
+}
+
+// IsTrusted checks if the snapshot is consistent and signed by more than the given fraction
+// (numerator/denominator) of the stakes of the validators, which the node must already trust.
+func (s *Snapshot) IsTrusted(validators map[string]uint64, numerator, denominator uint64) bool {
+	if !s.IsConsistent() {
+		return false
+	}
+
+	total := uint64(0)
+	for _, stakes := range validators {
+		total += stakes
+	}
+
+	if total == 0 {
+		return false
+	}
+
+	return s.GetSignedStakes(validators)*denominator > total*numerator
+}
+
+func (s *Snapshot) Serialize() ([]byte, error) {
+	return encodings.Encode(s)
+}
+
+func Deserialize(data []byte) (*Snapshot, error) {
+	var s Snapshot
+	if err := encodings.Decode(data, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,249 @@
+package snapshotnetwork
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/blocks/snapshots"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/patterns/longtask"
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cbag"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+)
+
+const (
+	// SnapshotsTopic is the tunnel topic of snapshot requests and responses
+	SnapshotsTopic = "snapshots"
+
+	KindRequest  = "snapshot_request"
+	KindResponse = "snapshot_response"
+
+	// Index used to request the latest snapshot of a peer
+	LatestIndex = -1
+)
+
+type SnapshotMessage struct {
+	Kind     string
+	Index    int64
+	Snapshot []byte
+}
+
+// SnapshotNetworkHandler serves stored snapshots to peers and requests snapshots from them.
+type SnapshotNetworkHandler struct {
+	net         *network.Network
+	snapshotter *snapshots.Snapshotter
+	listenTask  *longtask.LongTask[any]
+	tunnelSubs  *cbag.CBag[*observable.Subscription[[]byte]]
+	received    *observable.Observable[*snapshots.Snapshot]
+}
+
+func Factory(diCtx *di.DIContext) *SnapshotNetworkHandler {
+	return &SnapshotNetworkHandler{
+		net:         di.GetService[network.Network](diCtx),
+		snapshotter: snapshots.GetFromDI(diCtx),
+		tunnelSubs:  cbag.New[*observable.Subscription[[]byte]](),
+		received:    observable.New[*snapshots.Snapshot](),
+	}
+}
+
+func GetFromDI(diCtx *di.DIContext) *SnapshotNetworkHandler {
+	return di.GetService[SnapshotNetworkHandler](diCtx)
+}
+
+func (snh *SnapshotNetworkHandler) ObserveSnapshots() {
+	connectionsSub := snh.net.GetConnections().Subscribe()
+
+	for conn := range snh.net.GetConnections().Current().All() {
+		snh.handleConnection(conn)
+	}
+
+	task := longtask.Run(func(cancellation context.Context) any {
+		log.Println("Starting snapshot observer.")
+
+		for {
+			select {
+			case conn := <-connectionsSub.Channel():
+				snh.handleConnection(conn)
+			case <-connectionsSub.WaitClose():
+				log.Println("Network closed. Stopping snapshot observer.")
+				snh.StopObservingSnapshots()
+				return true
+			case <-cancellation.Done():
+				log.Println("Snapshot observer cancelled. Stopping snapshot observer.")
+				snh.StopObservingSnapshots()
+				return false
+			}
+		}
+	}).Finally(func() {
+		connectionsSub.Unsubscribe()
+	})
+
+	snh.listenTask = task
+	go snh.listenTask.Await()
+}
+
+func (snh *SnapshotNetworkHandler) StopObservingSnapshots() {
+	log.Println("Stopping snapshot observer.")
+	snh.listenTask.Cancel()
+
+	for sub := range snh.tunnelSubs.All() {
+		sub.Unsubscribe()
+	}
+}
+
+func (snh *SnapshotNetworkHandler) handleConnection(conn network.Connection) {
+	if conn == nil {
+		return
+	}
+
+	// Handled before returning, so requests sent once ObserveSnapshots returns are answered
+	tunnel := conn.GetTunnel()
+	tunnelSub := tunnel.Handle(SnapshotsTopic)
+	snh.tunnelSubs.Add(tunnelSub)
+
+	go snh.listenTunnel(tunnel, tunnelSub)
+}
+
+func (snh *SnapshotNetworkHandler) listenTunnel(tunnel tunnel.TopicTunnel, tunnelSub *observable.Subscription[[]byte]) {
+	defer tunnelSub.Unsubscribe()
+	defer snh.tunnelSubs.Remove(tunnelSub)
+
+	for {
+		select {
+		case data := <-tunnelSub.Channel():
+			if len(data) == 0 {
+				continue
+			}
+
+			go snh.handleMessage(tunnel, data)
+		case <-tunnelSub.WaitClose():
+			return
+		}
+	}
+}
+
+func (snh *SnapshotNetworkHandler) handleMessage(tunnel tunnel.TopicTunnel, data []byte) {
+	var msg SnapshotMessage
+	if err := encodings.Decode(data, &msg); err != nil {
+		return
+	}
+
+	switch msg.Kind {
+	case KindRequest:
+		snh.answerRequest(tunnel, msg.Index)
+	case KindResponse:
+		snapshot, err := snapshots.Deserialize(msg.Snapshot)
+		if err != nil {
+			log.Println("Error decoding snapshot: ", err)
+			return
+		}
+
+		snh.received.Notify(snapshot)
+	}
+}
+
+func (snh *SnapshotNetworkHandler) answerRequest(tunnel tunnel.TopicTunnel, index int64) {
+	var snapshot *snapshots.Snapshot
+	var err error
+
+	if index == LatestIndex {
+		snapshot, err = snh.snapshotter.Latest()
+	} else {
+		snapshot, err = snh.snapshotter.Get(index)
+	}
+
+	if err != nil {
+		log.Println("Cannot answer snapshot request: ", err)
+		return
+	}
+
+	raw, err := snapshot.Serialize()
+	if err != nil {
+		log.Println("Error encoding snapshot: ", err)
+		return
+	}
+
+	answer, err := encodings.Encode(SnapshotMessage{
+		Kind:     KindResponse,
+		Index:    snapshot.Index,
+		Snapshot: raw,
+	})
+	if err != nil {
+		log.Println("Error encoding snapshot message: ", err)
+		return
+	}
+
+	tunnel.Send(SnapshotsTopic, answer)
+}
+
+// RequestLatest asks every connected peer for its latest snapshot and waits for the answers until timeout.
+// Signatures for the same state are merged, and the trusted snapshot with the highest index is returned.
+func (snh *SnapshotNetworkHandler) RequestLatest(timeout time.Duration) (*snapshots.Snapshot, error) {
+	sub := snh.received.Subscribe()
+	defer sub.Unsubscribe()
+
+	request, err := encodings.Encode(SnapshotMessage{
+		Kind:     KindRequest,
+		Index:    LatestIndex,
+		Snapshot: []byte{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for conn := range snh.net.GetConnections().Current().All() {
+		go conn.GetTunnel().Send(SnapshotsTopic, request)
+	}
+
+	candidates := make(map[string]*snapshots.Snapshot)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		select {
+		case snapshot := <-sub.Channel():
+			if snapshot == nil || !snapshot.IsConsistent() {
+				continue
+			}
+
+			key := string(snapshot.GetSignedData())
+			candidate, found := candidates[key]
+			if !found {
+				// The whole state is kept, only the signatures are merged from every answer
+				unsigned := *snapshot
+				unsigned.Signatures = make([]snapshots.SnapshotSignature, 0)
+				candidate = &unsigned
+				candidates[key] = candidate
+			}
+
+			for _, sig := range snapshot.Signatures {
+				candidate.AddSignature(sig)
+			}
+		case <-ctx.Done():
+			return snh.bestTrusted(candidates)
+		}
+	}
+}
+
+func (snh *SnapshotNetworkHandler) bestTrusted(candidates map[string]*snapshots.Snapshot) (*snapshots.Snapshot, error) {
+	var best *snapshots.Snapshot
+	for _, candidate := range candidates {
+		if !snh.snapshotter.IsTrusted(candidate) {
+			continue
+		}
+
+		if best == nil || candidate.Index > best.Index {
+			best = candidate
+		}
+	}
+
+	if best == nil {
+		return nil, snapshots.ErrNotTrusted
+	}
+
+	return best, nil
+}
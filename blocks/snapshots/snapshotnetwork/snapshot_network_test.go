@@ -0,0 +1,119 @@
+package snapshotnetwork_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksdi"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/blocks/snapshots"
+	"github.com/titosilva/drmchain-pos/blocks/snapshots/snapshotnetwork"
+	"github.com/titosilva/drmchain-pos/drm"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/memnet"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func Test__RequestLatest__ShouldKeepDRMState__WhenContentWasRegisteredBeforeTheSnapshot(t *testing.T) {
+	// Arrange
+	mem := memnet.New(1)
+	serverDI := openNode(t, mem, 2603)
+	clientDI := openNode(t, mem, 2605)
+
+	owner := getIdentity(t, serverDI)
+	register, err := drm.NewTransaction(owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie", ContentHash: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue, err := drm.NewTransaction(owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: owner.GetTag()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := history.GetFromDI(serverDI)
+	if err := served.Append(&blocks.Block{Index: 1, Hash: []byte{1}, Transations: []transactions.Transaction{register, issue}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := snapshots.GetFromDI(serverDI).Take(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots.GetFromDI(clientDI).TrustValidators(map[string]uint64{owner.GetTag(): served.GetStakes(owner.GetTag())})
+
+	server := di.GetService[network.Network](serverDI)
+	client := di.GetService[network.Network](clientDI)
+	if err := client.GetConnections().ConnectTo(server.GetSelf(), network.Address{Host: "localhost", Port: 2603}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, diCtx := range []*di.DIContext{serverDI, clientDI} {
+		handler := snapshotnetwork.GetFromDI(diCtx)
+		handler.ObserveSnapshots()
+		t.Cleanup(handler.StopObservingSnapshots)
+	}
+
+	// Act
+	latest, err := snapshotnetwork.GetFromDI(clientDI).RequestLatest(500 * time.Millisecond)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the snapshot of the server, got %s", err)
+	}
+
+	if len(latest.Contents) != 1 || latest.Contents[0].ContentId != "movie" {
+		t.Errorf("Expected the registered content in the snapshot, got %v", latest.Contents)
+	}
+
+	if len(latest.Licenses) != 1 {
+		t.Errorf("Expected the issued license in the snapshot, got %v", latest.Licenses)
+	}
+
+	if !latest.IsConsistent() {
+		t.Error("Expected the snapshot to be consistent")
+	}
+}
+
+// openNode opens the network of a node with its own storage, through the in-memory network
+func openNode(t *testing.T, mem *memnet.Network, port int) *di.DIContext {
+	storageDir := t.TempDir()
+
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = blocksdi.AddBlocksServices(diCtx)
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	diCtx = mem.AddTransport(diCtx)
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+
+	config := networkconfig.GetFromDI(diCtx)
+	config.HandshakeHost = network.Address{Host: "localhost", Port: port}.String()
+	config.GossipHost = network.Address{Host: "localhost", Port: port + 1}.String()
+
+	net := di.GetService[network.Network](diCtx)
+	if err := net.Open(); err != nil {
+		t.Fatalf("Error opening network: %s", err)
+	}
+	t.Cleanup(func() { net.Close() })
+
+	return diCtx
+}
+
+func getIdentity(t *testing.T, diCtx *di.DIContext) identity.PrivateIdentity {
+	id, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
@@ -0,0 +1,299 @@
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/patterns/longtask"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/storage"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid snapshot signature")
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrRootMismatch     = errors.New("snapshot state root does not match")
+	ErrNotTrusted       = errors.New("snapshot is not signed by enough stakes")
+)
+
+const (
+	// A snapshot is trusted when more than 2/3 of the stakes signed it
+	TrustNumerator   = 2
+	TrustDenominator = 3
+
+	latestKey = "snapshots/latest"
+)
+
+type Snapshotter struct {
+	history  *history.BlockHistory
+	storage  storage.BlobStorage
+	id       identity.PrivateIdentity
+	interval uint64
+	trusted  map[string]uint64
+	task     *longtask.LongTask[any]
+}
+
+func Factory(diCtx *di.DIContext) *Snapshotter {
+	idProv := identityprovider.GetFromDI(diCtx)
+	id, err := idProv.GetIdentity()
+	if err != nil {
+		return nil
+	}
+
+	config := blocksconfig.GetFromDI(diCtx)
+	s := New(history.GetFromDI(diCtx), storage.GetFromDI(diCtx), id, config.SnapshotInterval)
+	s.TrustValidators(config.TrustedValidators)
+
+	return s
+}
+
+func GetFromDI(diCtx *di.DIContext) *Snapshotter {
+	return di.GetService[Snapshotter](diCtx)
+}
+
+func New(bh *history.BlockHistory, store storage.BlobStorage, id identity.PrivateIdentity, interval uint64) *Snapshotter {
+	return &Snapshotter{
+		history:  bh,
+		storage:  store,
+		id:       id,
+		interval: interval,
+		trusted:  make(map[string]uint64),
+	}
+}
+
+// TrustValidators sets the stakes of the validators whose signatures make a snapshot trusted.
+func (s *Snapshotter) TrustValidators(stakes map[string]uint64) {
+	s.trusted = maps.Clone(stakes)
+}
+
+// IsTrusted checks that the snapshot is signed by more than 2/3 of the stakes of the trusted validators,
+// or of the local history when none were set. Stakes carried by the snapshot itself are never used.
+func (s *Snapshotter) IsTrusted(snapshot *Snapshot) bool {
+	validators := s.trusted
+	if len(validators) == 0 {
+		validators = make(map[string]uint64)
+		for kv := range s.history.AllStakes() {
+			validators[kv.Key] = kv.Value
+		}
+	}
+
+	return snapshot.IsTrusted(validators, TrustNumerator, TrustDenominator)
+}
+
+// Start takes a snapshot every time a block with an index multiple of the interval is appended.
+// An interval of 0 disables periodic snapshots.
+func (s *Snapshotter) Start() {
+	if s.interval == 0 {
+		log.Println("snapshot interval is 0, periodic snapshots are disabled")
+		return
+	}
+
+	sub := s.history.Subscribe()
+
+	s.task = longtask.Run(func(cancellation context.Context) any {
+		for {
+			select {
+			case block := <-sub.Channel():
+				if block == nil || block.Index%s.interval != 0 {
+					continue
+				}
+
+				if _, err := s.Take(); err != nil {
+					log.Println("failed to take snapshot at block ", block.Index, ": ", err)
+				}
+			case <-sub.WaitClose():
+				return true
+			case <-cancellation.Done():
+				return false
+			}
+		}
+	}).Finally(func() {
+		sub.Unsubscribe()
+	})
+
+	go s.task.Await()
+}
+
+func (s *Snapshotter) Stop() {
+	if s.task != nil {
+		s.task.Cancel()
+	}
+}
+
+// Take creates a snapshot of the current state, signs and stores it.
+func (s *Snapshotter) Take() (*Snapshot, error) {
+	snapshot := FromState(s.currentState())
+
+	if err := snapshot.Sign(s.id); err != nil {
+		return nil, errorutil.WithInner("failed to sign snapshot", err)
+	}
+
+	if err := s.Store(snapshot); err != nil {
+		return nil, err
+	}
+
+	log.Println("snapshot taken at block ", snapshot.Index)
+	return snapshot, nil
+}
+
+func (s *Snapshotter) currentState() history.State {
+	state := history.State{
+		Index:  s.history.GetLastIndex(),
+		Stakes: make(map[string]uint64),
+		Nonces: make(map[string]uint64),
+	}
+
+	if last := s.history.GetLastBlock(); last != nil {
+		state.BlockHash = last.Hash
+	}
+
+	for kv := range s.history.AllStakes() {
+		state.Stakes[kv.Key] = kv.Value
+	}
+
+	for kv := range s.history.AllNonces() {
+		state.Nonces[kv.Key] = kv.Value
+	}
+
+	state.Contents = slices.Collect(s.history.GetDRMState().AllContents())
+	state.Licenses = slices.Collect(s.history.GetDRMState().AllLicenses())
+	state.Storage = s.history.GetStorageRegistry().State()
+	state.Allowlist = s.history.GetAllowlistState()
+
+	return state
+}
+
+// Store saves the snapshot, merging its signatures with the stored one for the same index.
+// The latest pointer only moves forward.
+func (s *Snapshotter) Store(snapshot *Snapshot) error {
+	if !snapshot.IsConsistent() {
+		return ErrRootMismatch
+	}
+
+	stored, err := s.Get(snapshot.Index)
+	if err == nil {
+		if err := merge(stored, snapshot); err != nil {
+			return err
+		}
+		snapshot = stored
+	} else if !errors.Is(err, ErrSnapshotNotFound) {
+		return err
+	}
+
+	data, err := snapshot.Serialize()
+	if err != nil {
+		return errorutil.WithInner("failed to serialize snapshot", err)
+	}
+
+	if err := s.storage.Store(keyFor(snapshot.Index), data); err != nil {
+		return errorutil.WithInner("failed to store snapshot", err)
+	}
+
+	latest, err := s.latestIndex()
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return err
+	}
+
+	if err == nil && snapshot.Index <= latest {
+		return nil
+	}
+
+	if err := s.storage.Store(latestKey, []byte(strconv.FormatInt(snapshot.Index, 10))); err != nil {
+		return err
+	}
+
+	return s.history.SetSnapshotIndex(uint64(snapshot.Index))
+}
+
+// merge adds the signatures of other to target. Both must refer to the same state.
+func merge(target *Snapshot, other *Snapshot) error {
+	if string(target.GetSignedData()) != string(other.GetSignedData()) {
+		return ErrRootMismatch
+	}
+
+	for _, sig := range other.Signatures {
+		if err := target.AddSignature(sig); err != nil {
+			log.Println("ignoring invalid snapshot signature from ", sig.Tag)
+		}
+	}
+
+	return nil
+}
+
+// Get loads the snapshot taken at the given block index.
+func (s *Snapshotter) Get(index int64) (*Snapshot, error) {
+	key := keyFor(index)
+	exists, err := s.storage.Exists(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrSnapshotNotFound
+	}
+
+	data, err := s.storage.Retrieve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return Deserialize(data)
+}
+
+// Latest loads the snapshot with the highest index stored.
+func (s *Snapshotter) Latest() (*Snapshot, error) {
+	index, err := s.latestIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(index)
+}
+
+func (s *Snapshotter) latestIndex() (int64, error) {
+	exists, err := s.storage.Exists(latestKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return 0, ErrSnapshotNotFound
+	}
+
+	data, err := s.storage.Retrieve(latestKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// Bootstrap restores the history from the given snapshot, if it is trusted by IsTrusted.
+// Only the blocks after the snapshot index need to be synced afterwards.
+func (s *Snapshotter) Bootstrap(snapshot *Snapshot) error {
+	if !s.IsTrusted(snapshot) {
+		return ErrNotTrusted
+	}
+
+	if err := s.Store(snapshot); err != nil {
+		return err
+	}
+
+	s.history.Restore(snapshot.ToState())
+	log.Println("history restored from snapshot at block ", snapshot.Index)
+	return nil
+}
+
+func keyFor(index int64) string {
+	return fmt.Sprintf("snapshots/%020d", index)
+}
@@ -0,0 +1,354 @@
+package snapshots_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/blockstore"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/blocks/snapshots"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func Test__Bootstrap__ShouldRestoreHistory__WhenSnapshotIsSignedByEnoughStakes(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 3)
+	bh := buildHistory(t, ids)
+	snapshotter := snapshots.New(bh, localstorage.New(t.TempDir()), ids[0], 1)
+
+	snapshot, err := snapshotter.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids[1:] {
+		if err := snapshot.Sign(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored := newHistory(t)
+	newSnapshotter := snapshots.New(restored, localstorage.New(t.TempDir()), ids[1], 1)
+	newSnapshotter.TrustValidators(stakesOf(bh))
+
+	// Act
+	err = newSnapshotter.Bootstrap(snapshot)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.GetLastIndex() != bh.GetLastIndex() {
+		t.Errorf("Expected last index %d, got %d", bh.GetLastIndex(), restored.GetLastIndex())
+	}
+
+	for _, id := range ids {
+		if restored.GetStakes(id.GetTag()) != bh.GetStakes(id.GetTag()) {
+			t.Errorf("Stakes of %s were not restored", id.GetTag())
+		}
+
+		if restored.GetNonce(id.GetTag()) != bh.GetNonce(id.GetTag()) {
+			t.Errorf("Nonce of %s was not restored", id.GetTag())
+		}
+	}
+
+	if _, err := restored.GetBlock(1); !errors.Is(err, blockstore.ErrBlockPruned) {
+		t.Errorf("Expected blocks before the snapshot to read as pruned, got %v", err)
+	}
+
+	next := &blocks.Block{Index: bh.GetLastIndex() + 1}
+	if err := restored.Append(next); err != nil {
+		t.Errorf("Expected to append block after snapshot, got %s", err)
+	}
+}
+
+func Test__Bootstrap__ShouldFail__WhenSnapshotIsNotSignedByEnoughStakes(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 3)
+	bh := buildHistory(t, ids)
+	snapshotter := snapshots.New(bh, localstorage.New(t.TempDir()), ids[0], 1)
+
+	snapshot, err := snapshotter.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSnapshotter := snapshots.New(newHistory(t), localstorage.New(t.TempDir()), ids[1], 1)
+	newSnapshotter.TrustValidators(stakesOf(bh))
+
+	// Act
+	err = newSnapshotter.Bootstrap(snapshot)
+
+	// Assert
+	if err != snapshots.ErrNotTrusted {
+		t.Errorf("Expected ErrNotTrusted, got %v", err)
+	}
+}
+
+func Test__IsTrusted__ShouldReturnFalse__WhenStateWasTampered(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 2)
+	validators := map[string]uint64{ids[0].GetTag(): 5, ids[1].GetTag(): 5}
+	snapshot := snapshots.FromState(history.State{
+		Index:  10,
+		Stakes: validators,
+		Nonces: map[string]uint64{},
+	})
+
+	for _, id := range ids {
+		if err := snapshot.Sign(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	snapshot.Stakes[0].Stakes = 100
+
+	// Assert
+	if snapshot.IsTrusted(validators, snapshots.TrustNumerator, snapshots.TrustDenominator) {
+		t.Error("Expected tampered snapshot not to be trusted")
+	}
+}
+
+func Test__Bootstrap__ShouldFail__WhenSnapshotGivesItsOnlySignerEveryStake(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 3)
+	bh := buildHistory(t, ids)
+	attacker, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := snapshots.FromState(history.State{
+		Index:  bh.GetLastIndex() + 1,
+		Stakes: map[string]uint64{attacker.GetTag(): 1000},
+		Nonces: map[string]uint64{},
+	})
+	if err := forged.Sign(attacker); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotter := snapshots.New(newHistory(t), localstorage.New(t.TempDir()), ids[0], 1)
+	snapshotter.TrustValidators(stakesOf(bh))
+
+	// Act
+	err = snapshotter.Bootstrap(forged)
+
+	// Assert
+	if err != snapshots.ErrNotTrusted {
+		t.Errorf("Expected ErrNotTrusted, got %v", err)
+	}
+}
+
+func Test__Store__ShouldMergeSignatures__WhenSameSnapshotIsStoredTwice(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 2)
+	bh := buildHistory(t, ids)
+	snapshotter := snapshots.New(bh, localstorage.New(t.TempDir()), ids[0], 1)
+
+	if _, err := snapshotter.Take(); err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := snapshots.New(bh, localstorage.New(t.TempDir()), ids[1], 1).Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := snapshotter.Store(remote); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	latest, err := snapshotter.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(latest.Signatures) != 2 {
+		t.Errorf("Expected 2 signatures, got %d", len(latest.Signatures))
+	}
+}
+
+func Test__Bootstrap__ShouldRestoreStorageRegistry__WhenSnapshotCarriesIt(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 3)
+	bh := buildHistory(t, ids)
+
+	tx, err := storageproofs.NewCommitmentTransaction(ids[0], storageproofs.ContentCommitment{ContentId: "content", Root: []byte{1}, Chunks: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The forger holds the content but answers no challenge, so it is recorded as failing one
+	if err := bh.Append(&blocks.Block{Index: 4, Hash: []byte{4}, ForgerTag: ids[0].GetTag(), Transations: []transactions.Transaction{tx}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bh.Append(&blocks.Block{Index: 5, Hash: []byte{5}, ForgerTag: ids[0].GetTag()}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := snapshots.New(bh, localstorage.New(t.TempDir()), ids[0], 1).Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids[1:] {
+		if err := snapshot.Sign(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored := newHistory(t)
+	newSnapshotter := snapshots.New(restored, localstorage.New(t.TempDir()), ids[1], 1)
+	newSnapshotter.TrustValidators(stakesOf(bh))
+
+	// Act
+	err = newSnapshotter.Bootstrap(snapshot)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, restoredRegistry := bh.GetStorageRegistry(), restored.GetStorageRegistry()
+	if restoredRegistry.GetOutcomes(ids[0].GetTag()) != registry.GetOutcomes(ids[0].GetTag()) {
+		t.Errorf("Expected outcomes %+v, got %+v", registry.GetOutcomes(ids[0].GetTag()), restoredRegistry.GetOutcomes(ids[0].GetTag()))
+	}
+
+	if restoredRegistry.Weigh(ids[0].GetTag(), 100) != registry.Weigh(ids[0].GetTag(), 100) {
+		t.Errorf("Expected the restored history to weigh the forger alike")
+	}
+
+	snapshot.Storage.Outcomes = nil
+	if snapshot.IsConsistent() {
+		t.Error("Expected the state root to cover the storage registry")
+	}
+}
+
+func Test__Bootstrap__ShouldRestoreAllowlist__WhenSnapshotCarriesIt(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 3)
+	bh := buildHistory(t, ids)
+	list := allowlist.New(true, []string{}, []string{ids[0].GetTag()})
+	bh.TrackAllowlist(list)
+
+	tx, err := allowlist.NewValidatorSetTransaction(ids[0], 1, []string{"validator"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bh.Append(&blocks.Block{Index: 4, Hash: []byte{4}, Transations: []transactions.Transaction{tx}}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := snapshots.New(bh, localstorage.New(t.TempDir()), ids[0], 1).Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids[1:] {
+		if err := snapshot.Sign(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored := newHistory(t)
+	restoredList := allowlist.New(true, []string{}, []string{ids[0].GetTag()})
+	restored.TrackAllowlist(restoredList)
+
+	newSnapshotter := snapshots.New(restored, localstorage.New(t.TempDir()), ids[1], 1)
+	newSnapshotter.TrustValidators(stakesOf(bh))
+
+	// Act
+	err = newSnapshotter.Bootstrap(snapshot)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !restoredList.IsAllowed("validator") {
+		t.Error("Expected the validator set update to be restored")
+	}
+
+	if err := restoredList.Apply(tx); err != allowlist.ErrStaleUpdate {
+		t.Errorf("Expected the replayed update to be stale, got %v", err)
+	}
+}
+
+func Test__Start__ShouldNotTakeSnapshots__WhenIntervalIsZero(t *testing.T) {
+	// Arrange
+	ids := generateIdentities(t, 1)
+	bh := newHistory(t)
+	snapshotter := snapshots.New(bh, localstorage.New(t.TempDir()), ids[0], 0)
+
+	// Act
+	snapshotter.Start()
+	defer snapshotter.Stop()
+
+	if err := bh.Append(&blocks.Block{Index: 1, Hash: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Assert
+	if _, err := snapshotter.Latest(); !errors.Is(err, snapshots.ErrSnapshotNotFound) {
+		t.Errorf("Expected no snapshot to be taken, got %v", err)
+	}
+}
+
+func generateIdentities(t *testing.T, count int) []identity.PrivateIdentity {
+	ids := make([]identity.PrivateIdentity, count)
+	for i := range ids {
+		id, err := identity.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	return ids
+}
+
+func stakesOf(bh *history.BlockHistory) map[string]uint64 {
+	stakes := make(map[string]uint64)
+	for kv := range bh.AllStakes() {
+		stakes[kv.Key] = kv.Value
+	}
+
+	return stakes
+}
+
+func newHistory(t *testing.T) *history.BlockHistory {
+	store := blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0)
+	return history.New(store)
+}
+
+func buildHistory(t *testing.T, ids []identity.PrivateIdentity) *history.BlockHistory {
+	bh := newHistory(t)
+
+	for i := 1; i <= 3; i++ {
+		txs := make([]transactions.Transaction, 0, len(ids))
+		for _, id := range ids {
+			txs = append(txs, &transactions.TransactionShape{SourceTag: id.GetTag()})
+		}
+
+		bh.Append(&blocks.Block{
+			Index:       uint64(i),
+			Hash:        []byte{byte(i)},
+			Transations: txs,
+		})
+	}
+
+	return bh
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"log"
 
+	"github.com/titosilva/drmchain-pos/blocks/blocksdi"
+	"github.com/titosilva/drmchain-pos/blocks/history"
 	"github.com/titosilva/drmchain-pos/cmd/drmclient/internal/cmdserver"
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/network/discovery"
 	"github.com/titosilva/drmchain-pos/network/networkdi"
 )
 
@@ -34,7 +38,13 @@ func main() {
 	log.Println("Command server started")
 
 	log.Println("Starting network services")
+	diCtx = blocksdi.AddBlocksServices(diCtx)
 	diCtx = networkdi.AddNetworkServices(diCtx)
+
+	// The allowlist follows the validator set transactions of the chain from before the network opens,
+	// and is restored with the history from snapshots
+	history.GetFromDI(diCtx).TrackAllowlist(allowlist.GetFromDI(diCtx))
+
 	nw := di.GetService[network.Network](diCtx)
 	if err = nw.Open(); err != nil {
 		log.Println("failed to initialize network services", err)
@@ -42,6 +52,14 @@ func main() {
 	}
 	defer nw.Close()
 
+	log.Println("Starting peer discovery")
+	disc := discovery.GetFromDI(diCtx)
+	if err = disc.Start(); err != nil {
+		log.Println("failed to start peer discovery", err)
+		return
+	}
+	defer disc.Stop()
+
 	currentConns := nw.GetConnections().Current()
 	for conn := range currentConns.All() {
 		log.Println(conn.GetPeer().Addr)
@@ -2,11 +2,15 @@ package consensusdi
 
 import (
 	"github.com/titosilva/drmchain-pos/consensus/internal/services"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
 	"github.com/titosilva/drmchain-pos/internal/di"
 )
 
 func AddConsensusServices(diCtx *di.DIContext) *di.DIContext {
 	di.AddSingleton(diCtx, services.CommitmentFactoryFactory)
+	di.AddSingleton(diCtx, services.RegistryFactory)
+	di.AddSingleton(diCtx, storageproofs.ProverFactory)
+	di.AddSingleton(diCtx, services.ProofValidatorFactory)
 
 	return diCtx
 }
@@ -3,160 +3,139 @@ package consensusnetwork
 import (
 	"context"
 	"log"
-	"time"
+	"sync"
 
 	"github.com/titosilva/drmchain-pos/consensus"
 	"github.com/titosilva/drmchain-pos/consensus/messages"
 	"github.com/titosilva/drmchain-pos/internal/patterns/longtask"
-	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
-	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cbag"
-	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/clru"
-	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
-	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
 	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
-	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
 	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/gossiprouter"
+	"github.com/titosilva/drmchain-pos/network/reputation"
 )
 
-type MessageRegistry = clru.Cache[string, time.Time]
-
-func newRegistry() *MessageRegistry {
-	return clru.New[string, time.Time](256)
-}
+const ConsensusTopic = "consensus"
 
 type ConsensusHost struct {
-	net            *network.Network
-	listenTask     *longtask.LongTask[any]
-	recentMessages *MessageRegistry
-	recentByTunnel *cmap.CMap[tunnel.DuplexTunnel, *MessageRegistry]
-	tunnelSubs     *cbag.CBag[*observable.Subscription[[]byte]]
+	router     *gossiprouter.Router
+	reputation *reputation.Reputation
+	allowlist  *allowlist.Allowlist
+	listenTask *longtask.LongTask[any]
 
 	currentContext *consensus.ConsensusContext
+	contextMux     *sync.Mutex
 }
 
-func NewHost() *ConsensusHost {
-	return &ConsensusHost{}
+func NewHost(router *gossiprouter.Router, reputation *reputation.Reputation, allowlist *allowlist.Allowlist) *ConsensusHost {
+	ch := &ConsensusHost{
+		router:     router,
+		reputation: reputation,
+		allowlist:  allowlist,
+		contextMux: &sync.Mutex{},
+	}
+
+	router.SetValidator(ConsensusTopic, ch.validate)
+	return ch
 }
 
-func (ch *ConsensusHost) ObserveMessages() {
-	connectionsSub := ch.net.GetConnections().Subscribe()
+func (ch *ConsensusHost) SetContext(ctx *consensus.ConsensusContext) {
+	ch.contextMux.Lock()
+	defer ch.contextMux.Unlock()
 
-	for conn := range ch.net.GetConnections().Current().All() {
-		ch.handleConnection(conn)
-	}
+	ch.currentContext = ctx
+}
+
+func (ch *ConsensusHost) ObserveMessages() {
+	ch.router.Start()
+	messagesSub := ch.router.Subscribe(ConsensusTopic)
 
 	task := longtask.Run(func(cancellation context.Context) any {
 		log.Println("Starting message observer.")
 
 		for {
 			select {
-			case conn := <-connectionsSub.Channel():
-				ch.handleConnection(conn)
-			case <-connectionsSub.WaitClose():
-				log.Println("Network closed. Stopping message observer.")
-				ch.StopObservingMessages()
+			case delivery := <-messagesSub.Channel():
+				log.Println("Received consensus message ", cryptutil.HashToString(delivery.Payload))
+			case <-messagesSub.WaitClose():
+				log.Println("Gossip closed. Stopping message observer.")
 				return true
 			case <-cancellation.Done():
 				log.Println("Message observer cancelled. Stopping message observer.")
-				ch.StopObservingMessages()
 				return false
 			}
 		}
 	}).Finally(func() {
-		connectionsSub.Unsubscribe()
+		messagesSub.Unsubscribe()
 	})
 
 	ch.listenTask = task
 	go ch.listenTask.Await()
 }
 
-func (ch *ConsensusHost) handleConnection(conn network.Connection) {
-	tunnel := conn.GetTunnel()
-	ch.recentByTunnel.Set(tunnel, newRegistry())
-
-	task := longtask.Run(func(cancellation context.Context) any {
-		ch.listenTunnel(tunnel)
-		return true
-	}).Finally(func() {
-		ch.recentByTunnel.Delete(tunnel)
-	})
-
-	go task.Await()
-}
-
 func (ch *ConsensusHost) StopObservingMessages() {
 	log.Println("Stopping message observer.")
 	ch.listenTask.Cancel()
-
-	for sub := range ch.tunnelSubs.All() {
-		sub.Unsubscribe()
-	}
 }
 
-func (ch *ConsensusHost) listenTunnel(tunnel tunnel.DuplexTunnel) {
-	tunnelSub := tunnel.Subscribe()
-	defer tunnelSub.Unsubscribe()
-
-	ch.tunnelSubs.Add(tunnelSub)
-	defer ch.tunnelSubs.Remove(tunnelSub)
-	log.Println("Listening tunnel")
+// validate spreads the messages of the current context. Messages for another context are dropped
+// without blaming the peer, which may just be ahead or behind, but invalid signed messages are reported.
+// Signed messages from tags not in the allowlist are dropped too, as the peer may have relayed them in good faith.
+func (ch *ConsensusHost) validate(fromTag string, payload []byte) bool {
+	var msg messages.ConsensusShell
+	if err := encodings.Decode(payload, &msg); err != nil {
+		ch.report(fromTag, reputation.MisbehaviorUndecodable)
+		return false
+	}
 
-	for {
-		select {
-		case data := <-tunnelSub.Channel():
-			if len(data) == 0 {
-				continue
-			}
+	if !ch.isCurrent(msg.Context) {
+		return false
+	}
 
-			var reg *MessageRegistry
-			var found bool
-			if reg, found = ch.recentByTunnel.Get(tunnel); !found {
-				reg = newRegistry()
-				ch.recentByTunnel.Set(tunnel, reg)
-			}
+	// Reveals are checked against their commitment by the receiver, so only signed messages are checked here
+	var signer string
+	var valid bool
+	switch msg.Type {
+	case messages.ShellTypeCommitment:
+		var commitment messages.CommitmentMessage
+		valid = encodings.Decode(msg.Content, &commitment) == nil && commitment.IsValidSignature()
+		signer = commitment.Tag
+	case messages.ShellTypeProposal:
+		var proposal messages.ProposalMessage
+		valid = encodings.Decode(msg.Content, &proposal) == nil && proposal.IsValidSignature()
+		signer = proposal.Tag
+	case messages.ShellTypeVote:
+		var vote messages.VoteMessage
+		valid = encodings.Decode(msg.Content, &vote) == nil && vote.IsValidSignature()
+		signer = vote.Tag
+	default:
+		return true
+	}
 
-			reg.Put(cryptutil.HashToString(data), time.Now())
-			log.Println("Received consensus message ", cryptutil.HashToString(data))
-			go ch.handleMessage(data)
-		case <-tunnelSub.WaitClose():
-			return
-		}
+	if !valid {
+		ch.report(fromTag, reputation.MisbehaviorInvalidConsensus)
+		return false
 	}
+
+	return ch.allowlist.IsAllowed(signer)
 }
 
-func (ch *ConsensusHost) handleMessage(data []byte) {
-	if _, seen := ch.recentMessages.Get(cryptutil.HashToString(data)); seen {
-		return
-	}
+func (ch *ConsensusHost) isCurrent(ctx consensus.ConsensusContext) bool {
+	ch.contextMux.Lock()
+	defer ch.contextMux.Unlock()
 
-	var msg messages.ConsensusShell
-	if err := encodings.Decode(data, &msg); err != nil {
-		return
-	}
+	return ch.currentContext != nil && ch.currentContext.IsSame(ctx)
+}
 
-	if !ch.currentContext.IsSame(msg.Context) {
-		return
+func (ch *ConsensusHost) report(tag string, misbehavior reputation.Misbehavior) {
+	if err := ch.reputation.Report(tag, misbehavior); err != nil {
+		log.Println("Error saving ban of ", tag, ": ", err)
 	}
-
-	go ch.PropagateMessage(msg)
 }
 
 func (ch *ConsensusHost) PropagateMessage(msg messages.ConsensusShell) {
 	msgHash := cryptutil.HashToString(msg.GetRaw())
 	log.Println("Publishing consensus message with hash ", msgHash)
 
-	for sub := range ch.net.GetConnections().Current().All() {
-		tunnel := sub.GetTunnel()
-		reg, found := ch.recentByTunnel.Get(tunnel)
-		if !found {
-			reg = newRegistry()
-			ch.recentByTunnel.Set(tunnel, reg)
-		}
-
-		if _, seen := reg.Get(msgHash); seen {
-			continue
-		}
-
-		go tunnel.Send(msg.GetRaw())
-	}
+	ch.router.Publish(ConsensusTopic, msg.GetRaw())
 }
@@ -3,7 +3,7 @@ package consensus
 import "slices"
 
 type ConsensusContext struct {
-	BlockIndex uint64
+	BlockIndex int64
 	Phase      string
 	PrevHash   []byte
 }
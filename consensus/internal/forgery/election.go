@@ -6,16 +6,32 @@ import (
 	"sort"
 )
 
+// Weigher returns the weight of a participant in the election given its committed stakes.
+type Weigher func(tag string, stakes uint64) uint64
+
 func Elect(forgery *BlockForgery) {
+	ElectWeighted(forgery, func(_ string, stakes uint64) uint64 {
+		return stakes
+	})
+}
+
+// ElectWeighted elects a forger among the revealed participants, with chances proportional to their weight.
+func ElectWeighted(forgery *BlockForgery, weigh Weigher) {
 	// Elect a forger
 	distributedRandom := make([]byte, CommitedLength)
+	// Ties are broken by tag, so every node sorts the same participations alike whatever order they arrived in
 	ps := slices.Clone(forgery.Participations)
 	sort.Slice(ps, func(i, j int) bool {
-		return ps[i].Commitment.Stakes < ps[j].Commitment.Stakes
+		if ps[i].Commitment.Stakes != ps[j].Commitment.Stakes {
+			return ps[i].Commitment.Stakes < ps[j].Commitment.Stakes
+		}
+
+		return ps[i].Commitment.Tag < ps[j].Commitment.Tag
 	})
 
 	totalCoins := uint64(0)
-	for _, p := range ps {
+	weights := make([]uint64, len(ps))
+	for i, p := range ps {
 		if p.Revealing == nil {
 			continue
 		}
@@ -24,16 +40,22 @@ func Elect(forgery *BlockForgery) {
 			continue
 		}
 
-		for i := 0; i < CommitedLength; i++ {
-			distributedRandom[i] = distributedRandom[i] ^ p.Revealing.Commited[i]
-			totalCoins += p.Commitment.Stakes
+		for j := 0; j < CommitedLength; j++ {
+			distributedRandom[j] = distributedRandom[j] ^ p.Revealing.Commited[j]
 		}
+
+		weights[i] = weigh(p.Commitment.Tag, uint64(p.Commitment.Stakes))
+		totalCoins += weights[i]
+	}
+
+	if totalCoins == 0 {
+		return
 	}
 
 	currentCoin := uint64(0)
 	coinIndex := reduce(distributedRandom, totalCoins)
-	for _, p := range ps {
-		currentCoin += p.Commitment.Stakes
+	for i, p := range ps {
+		currentCoin += weights[i]
 
 		if coinIndex < currentCoin {
 			forgery.ElectedTag = p.Commitment.Tag
@@ -45,6 +67,6 @@ func Elect(forgery *BlockForgery) {
 func reduce(large []byte, mod uint64) uint64 {
 	b := big.NewInt(0)
 	b.SetBytes(large)
-	b.Mod(b, big.NewInt(int64(mod)))
+	b.Mod(b, new(big.Int).SetUint64(mod))
 	return b.Uint64()
 }
@@ -1,7 +1,80 @@
 package forgery_test
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/consensus/internal/forgery"
+	"github.com/titosilva/drmchain-pos/consensus/messages"
+)
 
 func Test__Elect__ShouldChooseCorrectForger__BasedOnCommitments(t *testing.T) {
 
 }
+
+func Test__ElectWeighted__ShouldNeverChoose__ParticipantsWithoutWeight(t *testing.T) {
+	for i := range 20 {
+		// Arrange
+		f := forgery.NewBlockForgery()
+		f.AddParticipation(revealed("weighted", 10, byte(i)))
+		f.AddParticipation(revealed("unweighted", 1000, byte(i*7)))
+		f.AddParticipation(forgery.NewParticipation(&messages.CommitmentMessage{Tag: "unrevealed", Stakes: 1000}))
+
+		// Act
+		forgery.ElectWeighted(f, func(tag string, stakes uint64) uint64 {
+			if tag == "unweighted" {
+				return 0
+			}
+
+			return stakes
+		})
+
+		// Assert
+		if f.ElectedTag != "weighted" {
+			t.Fatalf("Expected weighted participant to be elected, got %q", f.ElectedTag)
+		}
+	}
+}
+
+func Test__Elect__ShouldChooseNobody__WhenNoParticipantRevealed(t *testing.T) {
+	// Arrange
+	f := forgery.NewBlockForgery()
+	f.AddParticipation(forgery.NewParticipation(&messages.CommitmentMessage{Tag: "unrevealed", Stakes: 1000}))
+
+	// Act
+	forgery.Elect(f)
+
+	// Assert
+	if f.ElectedTag != "" {
+		t.Errorf("Expected no forger, got %q", f.ElectedTag)
+	}
+}
+
+func revealed(tag string, stakes int64, seed byte) forgery.Participation {
+	p := forgery.NewParticipation(&messages.CommitmentMessage{Tag: tag, Stakes: stakes})
+	p.Revealing = &messages.RevealingMessage{
+		Commited: []byte{seed, seed + 1, seed + 2, seed + 3, seed + 4, seed + 5, seed + 6, seed + 7},
+	}
+
+	return p
+}
+
+func Test__ElectWeighted__ShouldChooseSameForger__WhenParticipationsArriveInAnotherOrder(t *testing.T) {
+	for i := range 20 {
+		// Arrange
+		inOrder, reversed := forgery.NewBlockForgery(), forgery.NewBlockForgery()
+		tags := []string{"a", "b", "c", "d", "e"}
+		for j, tag := range tags {
+			inOrder.AddParticipation(revealed(tag, 10, byte(i*5+j)))
+			reversed.AddParticipation(revealed(tags[len(tags)-1-j], 10, byte(i*5+len(tags)-1-j)))
+		}
+
+		// Act
+		forgery.Elect(inOrder)
+		forgery.Elect(reversed)
+
+		// Assert
+		if inOrder.ElectedTag != reversed.ElectedTag {
+			t.Fatalf("Expected the same forger regardless of order, got %q and %q", inOrder.ElectedTag, reversed.ElectedTag)
+		}
+	}
+}
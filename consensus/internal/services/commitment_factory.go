@@ -44,8 +44,8 @@ func (cf *CommitmentFactory) CreateCommitment() (*messages.CommitmentMessage, er
 	commitment := messages.CommitmentMessage{
 		Tag:        cf.id.GetTag(),
 		Commitment: cryptutil.Hash(value),
-		Stakes:     stakes, // A user could want to commit less than this
-		BlockIndex: cf.bh.GetLastBlock().Index + 1,
+		Stakes:     int64(stakes), // A user could want to commit less than this
+		BlockIndex: int64(cf.bh.GetLastBlock().Index + 1),
 		PrevHash:   cf.bh.GetLastBlock().Hash,
 	}
 
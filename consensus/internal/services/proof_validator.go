@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/internal/di"
+)
+
+var ErrInvalidStorageProofs = errors.New("block contains invalid storage proofs")
+
+// ProofValidator checks the storage proofs of proposed blocks against the registry of the block history.
+// The history applies the blocks it appends to the registry, whose outcomes weigh in the next elections.
+type ProofValidator struct {
+	registry *storageproofs.Registry
+}
+
+// RegistryFactory returns the registry of the block history, so the election weighs the outcomes of the chain.
+func RegistryFactory(diCtx *di.DIContext) *storageproofs.Registry {
+	return history.GetFromDI(diCtx).GetStorageRegistry()
+}
+
+func ProofValidatorFactory(diCtx *di.DIContext) *ProofValidator {
+	return NewProofValidator(storageproofs.GetRegistryFromDI(diCtx))
+}
+
+func GetProofValidatorFromDI(diCtx *di.DIContext) *ProofValidator {
+	return di.GetService[ProofValidator](diCtx)
+}
+
+func NewProofValidator(registry *storageproofs.Registry) *ProofValidator {
+	return &ProofValidator{
+		registry: registry,
+	}
+}
+
+// ValidateBlock verifies every storage proof in the proposed block against the challenges derived from prevHash.
+// It records nothing, so a block may be validated any number of times: outcomes are recorded once it is appended.
+func (pv *ProofValidator) ValidateBlock(block *blocks.Block, prevHash []byte) error {
+	var errs []error
+	for i := range block.StorageProofs {
+		if err := pv.registry.Check(&block.StorageProofs[i], block.Index, prevHash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(append([]error{ErrInvalidStorageProofs}, errs...)...)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package services_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/blockstore"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/consensus/internal/forgery"
+	"github.com/titosilva/drmchain-pos/consensus/internal/services"
+	"github.com/titosilva/drmchain-pos/consensus/messages"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func Test__ElectWeighted__ShouldChooseSameForger__WhenNodesHaveDifferentLocalHistories(t *testing.T) {
+	// Arrange
+	holder, other := generateIdentity(t), generateIdentity(t)
+	holderHistory, otherHistory := newHistory(t), newHistory(t)
+	holderRegistry, otherRegistry := holderHistory.GetStorageRegistry(), otherHistory.GetStorageRegistry()
+	holderValidator := services.NewProofValidator(holderRegistry)
+	prover := storageproofs.NewProver(holder, holderRegistry, localstorage.New(t.TempDir()))
+
+	commitment, err := prover.Hold("committed", randomBytes(t, 8*4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the holder knows of this content, which never reaches the chain
+	if _, err := prover.Hold("uncommitted", randomBytes(t, 4096)); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := storageproofs.NewCommitmentTransaction(holder, commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &blocks.Block{Index: 1, Hash: []byte("first"), ForgerTag: holder.GetTag(), Transations: []transactions.Transaction{tx}}
+
+	// Act
+	appendBlock(t, first, holderHistory, otherHistory)
+
+	proofs, err := prover.ProveFor(2, first.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := &blocks.Block{Index: 2, Hash: []byte("second"), ForgerTag: holder.GetTag(), StorageProofs: proofs}
+
+	// The holder validates the proposal more than once before it is finalized, the other node never does
+	for range 3 {
+		if err := holderValidator.ValidateBlock(second, first.Hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendBlock(t, second, holderHistory, otherHistory)
+	appendBlock(t, &blocks.Block{Index: 3, Hash: []byte("third"), ForgerTag: holder.GetTag()}, holderHistory, otherHistory)
+
+	// Assert
+	for _, tag := range []string{holder.GetTag(), other.GetTag()} {
+		if holderRegistry.Weigh(tag, 100) != otherRegistry.Weigh(tag, 100) {
+			t.Fatalf("Expected both nodes to weigh %s alike, got %d and %d", tag, holderRegistry.Weigh(tag, 100), otherRegistry.Weigh(tag, 100))
+		}
+	}
+
+	if outcomes := otherRegistry.GetOutcomes(holder.GetTag()); outcomes != (storageproofs.Outcomes{Passed: 1, Failed: 1}) {
+		t.Errorf("Expected one passed and one failed challenge, got %+v", outcomes)
+	}
+
+	for i := range 20 {
+		holderForgery, otherForgery := forgery.NewBlockForgery(), forgery.NewBlockForgery()
+		for j, tag := range []string{holder.GetTag(), other.GetTag()} {
+			holderForgery.AddParticipation(revealed(tag, 100, byte(i*2+j)))
+			otherForgery.AddParticipation(revealed(tag, 100, byte(i*2+j)))
+		}
+
+		forgery.ElectWeighted(holderForgery, holderRegistry.Weigh)
+		forgery.ElectWeighted(otherForgery, otherRegistry.Weigh)
+
+		if holderForgery.ElectedTag != otherForgery.ElectedTag {
+			t.Fatalf("Expected both nodes to elect the same forger, got %q and %q", holderForgery.ElectedTag, otherForgery.ElectedTag)
+		}
+	}
+}
+
+func Test__ValidateBlock__ShouldReject__WhenProofIsForContentNotAssignedToProver(t *testing.T) {
+	// Arrange
+	holder, impostor := generateIdentity(t), generateIdentity(t)
+	bh := newHistory(t)
+	registry := bh.GetStorageRegistry()
+	validator := services.NewProofValidator(registry)
+
+	data := randomBytes(t, 4096)
+	holderProver := storageproofs.NewProver(holder, registry, localstorage.New(t.TempDir()))
+	commitment, err := holderProver.Hold("content", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := storageproofs.NewCommitmentTransaction(holder, commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &blocks.Block{Index: 1, Hash: []byte("first"), ForgerTag: holder.GetTag(), Transations: []transactions.Transaction{tx}}
+	appendBlock(t, first, bh)
+
+	chunks, _ := storageproofs.Split(data, storageproofs.DefaultChunkSize)
+	proof, err := storageproofs.Prove(impostor, storageproofs.NewChallenge(commitment, impostor.GetTag(), 2, first.Hash), chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	err = validator.ValidateBlock(&blocks.Block{Index: 2, ForgerTag: impostor.GetTag(), StorageProofs: []storageproofs.Proof{*proof}}, first.Hash)
+
+	// Assert
+	if err == nil {
+		t.Error("Expected proof of unassigned content to be rejected")
+	}
+}
+
+// appendBlock appends a copy of the block to each history, which applies it to its storage registry
+func appendBlock(t *testing.T, block *blocks.Block, histories ...*history.BlockHistory) {
+	for _, bh := range histories {
+		appended := *block
+		if err := bh.Append(&appended); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newHistory(t *testing.T) *history.BlockHistory {
+	return history.New(blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0))
+}
+
+func revealed(tag string, stakes int64, seed byte) forgery.Participation {
+	p := forgery.NewParticipation(&messages.CommitmentMessage{Tag: tag, Stakes: stakes})
+	p.Revealing = &messages.RevealingMessage{
+		Commited: []byte{seed, seed + 1, seed + 2, seed + 3, seed + 4, seed + 5, seed + 6, seed + 7},
+	}
+
+	return p
+}
+
+func generateIdentity(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	bs := make([]byte, size)
+	if _, err := rand.Read(bs); err != nil {
+		t.Fatal(err)
+	}
+
+	return bs
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/titosilva/drmchain-pos/consensus/internal/forgery"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
 )
 
@@ -19,7 +20,8 @@ func NewProposingState(ctx *MachineContext) *ProposingState {
 
 // Run implements ConsensusMachineState.
 func (p *ProposingState) Run() ConsensusMachineState {
-	forgery.Elect(p.Context.Forgery)
+	registry := storageproofs.GetRegistryFromDI(p.Context.DiCtx)
+	forgery.ElectWeighted(p.Context.Forgery, registry.Weigh)
 
 	idProvider := identityprovider.GetFromDI(p.Context.DiCtx)
 	id, err := idProvider.GetIdentity()
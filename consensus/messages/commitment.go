@@ -1,6 +1,8 @@
 package messages
 
 import (
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
 	"github.com/titosilva/drmchain-pos/network/encodings"
 )
 
@@ -9,8 +11,8 @@ type CommitmentMessage struct {
 	Signature []byte
 
 	Commitment []byte
-	Stakes     uint64
-	BlockIndex uint64
+	Stakes     int64
+	BlockIndex int64
 	PrevHash   []byte
 }
 
@@ -25,3 +27,17 @@ type RevealingMessage struct {
 	CommitmentHash string
 	Commited       []byte
 }
+
+// IsValidSignature tells whether the commitment is signed by Tag.
+// The signature covers the commitment serialized without it.
+func (cm CommitmentMessage) IsValidSignature() bool {
+	id, err := identity.FromTag(cm.Tag)
+	if err != nil {
+		return false
+	}
+
+	unsigned := cm
+	unsigned.Signature = nil
+
+	return signatures.Verify(id, unsigned.Serialize(), cm.Signature)
+}
@@ -5,6 +5,14 @@ import (
 	"github.com/titosilva/drmchain-pos/network/encodings"
 )
 
+// Types of shells, by the message in their content
+const (
+	ShellTypeCommitment = "commitment" // CommitmentMessage
+	ShellTypeRevealing  = "revealing"  // RevealingMessage
+	ShellTypeProposal   = "proposal"   // ProposalMessage
+	ShellTypeVote       = "vote"       // VoteMessage
+)
+
 type ConsensusShell struct {
 	Type    string
 	Context consensus.ConsensusContext
@@ -0,0 +1,55 @@
+package messages
+
+import (
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+// ProposalMessage is the block proposed by the elected forger, Tag,
+// with the answers to the storage challenges of the forger and the transactions of the block.
+type ProposalMessage struct {
+	Tag       string
+	Signature []byte
+
+	BlockIndex    int64
+	PrevHash      []byte
+	Timestamp     int64
+	StorageProofs []storageproofs.Proof
+	Transactions  []transactions.TransactionShape
+}
+
+func (pm ProposalMessage) Serialize() []byte {
+	bs, _ := encodings.Encode(pm)
+	return bs
+}
+
+// BlockHash is the hash of the proposed block, which votes refer to.
+// It covers the proposal serialized without its signature.
+func (pm ProposalMessage) BlockHash() []byte {
+	unsigned := pm
+	unsigned.Signature = nil
+
+	return cryptutil.Hash(unsigned.Serialize())
+}
+
+// IsValidSignature tells whether the proposal is signed by Tag.
+// The signature covers the proposal serialized without it.
+func (pm ProposalMessage) IsValidSignature() bool {
+	unsigned := pm
+	unsigned.Signature = nil
+
+	return isSignedBy(pm.Tag, unsigned.Serialize(), pm.Signature)
+}
+
+func isSignedBy(tag string, data []byte, signature []byte) bool {
+	id, err := identity.FromTag(tag)
+	if err != nil {
+		return false
+	}
+
+	return signatures.Verify(id, data, signature)
+}
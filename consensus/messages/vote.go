@@ -0,0 +1,26 @@
+package messages
+
+import "github.com/titosilva/drmchain-pos/network/encodings"
+
+// VoteMessage is the vote of Tag for the block with BlockHash at BlockIndex.
+type VoteMessage struct {
+	Tag       string
+	Signature []byte
+
+	BlockIndex int64
+	BlockHash  []byte
+}
+
+func (vm VoteMessage) Serialize() []byte {
+	bs, _ := encodings.Encode(vm)
+	return bs
+}
+
+// IsValidSignature tells whether the vote is signed by Tag.
+// The signature covers the vote serialized without it.
+func (vm VoteMessage) IsValidSignature() bool {
+	unsigned := vm
+	unsigned.Signature = nil
+
+	return isSignedBy(vm.Tag, unsigned.Serialize(), vm.Signature)
+}
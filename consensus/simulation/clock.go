@@ -0,0 +1,78 @@
+package simulation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network/memnet"
+)
+
+// Clock is the virtual clock of a simulation. Time only passes when the simulation advances it,
+// so phase timeouts and the delays of the in-memory links take no real time and runs can be repeated.
+type Clock struct {
+	now    time.Time
+	timers []timer
+	mux    *sync.Mutex
+}
+
+type timer struct {
+	at time.Time
+	f  func()
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{
+		now:    start,
+		timers: make([]timer, 0),
+		mux:    &sync.Mutex{},
+	}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+// AfterFunc runs f once the clock is advanced by d.
+func (c *Clock) AfterFunc(d time.Duration, f func()) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.timers = append(c.timers, timer{at: c.now.Add(d), f: f})
+}
+
+// Advance moves the clock forward by d, running the functions due by then in order of their deadlines,
+// each with the clock set to its deadline. Functions due at the same time run in the order they were added.
+func (c *Clock) Advance(d time.Duration) {
+	c.mux.Lock()
+	target := c.now.Add(d)
+	c.mux.Unlock()
+
+	for {
+		c.mux.Lock()
+		next := -1
+		for i, t := range c.timers {
+			if !t.at.After(target) && (next < 0 || t.at.Before(c.timers[next].at)) {
+				next = i
+			}
+		}
+
+		if next < 0 {
+			c.now = target
+			c.mux.Unlock()
+			return
+		}
+
+		due := c.timers[next]
+		c.timers = append(c.timers[:next], c.timers[next+1:]...)
+		c.now = due.at
+		c.mux.Unlock()
+
+		due.f()
+	}
+}
+
+// Static impl check
+var _ memnet.Clock = (*Clock)(nil)
@@ -0,0 +1,33 @@
+package simulation_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/consensus/simulation"
+)
+
+func Test__Advance__ShouldRunDueFunctionsInOrderOfDeadline__AtTheirDeadlines(t *testing.T) {
+	// Arrange
+	start := time.Unix(0, 0)
+	clock := simulation.NewClock(start)
+	ran := make([]time.Duration, 0)
+	record := func() { ran = append(ran, clock.Now().Sub(start)) }
+
+	clock.AfterFunc(3*time.Second, record)
+	clock.AfterFunc(time.Second, record)
+	clock.AfterFunc(time.Minute, record)
+
+	// Act
+	clock.Advance(5 * time.Second)
+
+	// Assert
+	if expected := []time.Duration{time.Second, 3 * time.Second}; !slices.Equal(ran, expected) {
+		t.Fatalf("Expected functions to run at %v, got %v", expected, ran)
+	}
+
+	if now := clock.Now().Sub(start); now != 5*time.Second {
+		t.Fatalf("Expected the clock at 5s, got %s", now)
+	}
+}
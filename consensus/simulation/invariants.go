@@ -0,0 +1,93 @@
+package simulation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrConflictingBlocks   = errors.New("conflicting blocks were finalized")
+	ErrFinalizationTooSlow = errors.New("no block was finalized within the bound")
+)
+
+// Finalization of a block by a node, at a virtual time since the start of the run.
+type Finalization struct {
+	Node   int
+	Honest bool
+	Index  uint64
+	Hash   []byte
+	Forger string
+	At     time.Duration
+}
+
+// Report of a run. Its invariants hold for the round modeled by the simulation, not for consensus/internal/states.
+type Report struct {
+	Finalizations []Finalization
+	Forgers       []string      // Elected in each round by the first running honest node, empty if nobody was
+	Duration      time.Duration // Virtual length of the run
+}
+
+// CheckSafety fails with ErrConflictingBlocks if honest nodes finalized different blocks at the same index.
+func (r *Report) CheckSafety() error {
+	byIndex := make(map[uint64]Finalization)
+	for _, f := range r.Finalizations {
+		if !f.Honest {
+			continue
+		}
+
+		first, found := byIndex[f.Index]
+		if !found {
+			byIndex[f.Index] = f
+			continue
+		}
+
+		if !bytes.Equal(first.Hash, f.Hash) {
+			return fmt.Errorf("%w: nodes %d and %d at index %d", ErrConflictingBlocks, first.Node, f.Node, f.Index)
+		}
+	}
+
+	return nil
+}
+
+// CheckLiveness fails with ErrFinalizationTooSlow if honest nodes went longer than bound without finalizing
+// a new index, from the start to the end of the run. Nodes left behind by the others are not waited for.
+func (r *Report) CheckLiveness(bound time.Duration) error {
+	firsts := make(map[uint64]time.Duration)
+	for _, f := range r.Finalizations {
+		if at, found := firsts[f.Index]; f.Honest && (!found || f.At < at) {
+			firsts[f.Index] = f.At
+		}
+	}
+
+	times := make([]time.Duration, 0, len(firsts)+1)
+	for _, at := range firsts {
+		times = append(times, at)
+	}
+	times = append(times, r.Duration)
+	slices.Sort(times)
+
+	last := time.Duration(0)
+	for _, at := range times {
+		if at-last > bound {
+			return fmt.Errorf("%w: nothing finalized from %s to %s", ErrFinalizationTooSlow, last, at)
+		}
+		last = at
+	}
+
+	return nil
+}
+
+// FinalizedBy returns the hashes of the blocks finalized by the node, by index.
+func (r *Report) FinalizedBy(node int) map[uint64][]byte {
+	hashes := make(map[uint64][]byte)
+	for _, f := range r.Finalizations {
+		if f.Node == node {
+			hashes[f.Index] = f.Hash
+		}
+	}
+
+	return hashes
+}
@@ -0,0 +1,574 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksdi"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/consensus"
+	"github.com/titosilva/drmchain-pos/consensus/consensusdi"
+	"github.com/titosilva/drmchain-pos/consensus/consensusnetwork"
+	"github.com/titosilva/drmchain-pos/consensus/internal/forgery"
+	"github.com/titosilva/drmchain-pos/consensus/internal/services"
+	"github.com/titosilva/drmchain-pos/consensus/messages"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/gossiprouter"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+// Phases of a round, in order
+const (
+	PhaseCommitment  = "commitment"
+	PhaseRevealing   = "revealing"
+	PhasePropagating = "propagating"
+	PhaseVoting      = "voting"
+)
+
+// node is a full set of services from its own DI container, on the in-memory network,
+// taking part in the rounds driven by the simulation.
+type node struct {
+	index   int
+	spec    NodeSpec
+	sim     *Simulation
+	id      identity.PrivateIdentity
+	tag     string
+	address network.Address
+	diCtx   *di.DIContext
+
+	net       *network.Network
+	config    *networkconfig.NetworkConfig
+	router    *gossiprouter.Router
+	allowlist *allowlist.Allowlist
+	host      *consensusnetwork.ConsensusHost
+	history   *history.BlockHistory
+	messages  *observable.Subscription[gossiprouter.Delivery]
+	random    *rand.Rand // Commit values, so runs with the same seed elect the same forgers
+
+	registry  *storageproofs.Registry
+	prover    *storageproofs.Prover
+	validator *services.ProofValidator
+	pending   []transactions.TransactionShape // Included in the next block the node forges
+
+	crashed  atomic.Bool
+	received atomic.Int64
+
+	round *round
+	mux   *sync.Mutex
+}
+
+// round is what a node knows of the current round
+type round struct {
+	context    consensus.ConsensusContext
+	forgery    *forgery.BlockForgery
+	value      []byte
+	commitment *messages.CommitmentMessage
+	proposals  map[string]messages.ProposalMessage // By block hash
+	votes      map[string][]byte                   // Block hash by voter, only the first vote counts
+}
+
+func newNode(sim *Simulation, index int, spec NodeSpec, storageDir string) (*node, error) {
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = blocksdi.AddBlocksServices(diCtx)
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	diCtx = consensusdi.AddConsensusServices(diCtx)
+	diCtx = sim.mem.AddTransport(diCtx)
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+
+	id, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	// The ports are never bound, the in-memory network only uses them to tell nodes apart
+	address := network.Address{Host: "127.0.0.1", Port: 3000 + 2*index}
+	config := networkconfig.GetFromDI(diCtx)
+	config.HandshakeHost = address.String()
+	config.GossipHost = network.Address{Host: address.Host, Port: address.Port + 1}.String()
+
+	seed := sim.scenario.Seed + uint64(index)
+
+	return &node{
+		index:   index,
+		spec:    spec,
+		sim:     sim,
+		id:      id,
+		tag:     id.GetTag(),
+		address: address,
+		diCtx:   diCtx,
+		config:  config,
+		random:  rand.New(rand.NewPCG(seed, seed)),
+		pending: make([]transactions.TransactionShape, 0),
+
+		mux: &sync.Mutex{},
+	}, nil
+}
+
+// wire creates the services of the node from its config, which may depend on the tags of the other nodes
+func (n *node) wire() {
+	n.router = gossiprouter.GetFromDI(n.diCtx)
+	n.net = di.GetService[network.Network](n.diCtx)
+	n.allowlist = allowlist.GetFromDI(n.diCtx)
+	n.host = consensusnetwork.NewHost(n.router, reputation.GetFromDI(n.diCtx), n.allowlist)
+	n.history = history.GetFromDI(n.diCtx)
+
+	n.registry = storageproofs.GetRegistryFromDI(n.diCtx)
+	n.prover = storageproofs.GetProverFromDI(n.diCtx)
+	n.validator = services.GetProofValidatorFromDI(n.diCtx)
+}
+
+// open starts the network of the node from the genesis state shared by every node.
+// A node with Content holds it from then on, and commits to it in the next block it forges.
+// The allowlist of the node follows the validator set transactions of the blocks appended from then on.
+func (n *node) open(genesis history.State) error {
+	n.history.TrackAllowlist(n.allowlist)
+	n.history.Restore(genesis)
+
+	if len(n.spec.Content) > 0 {
+		commitment, err := n.prover.Hold(fmt.Sprintf("content-%d", n.index), n.spec.Content)
+		if err != nil {
+			return err
+		}
+
+		tx, err := storageproofs.NewCommitmentTransaction(n.id, commitment)
+		if err != nil {
+			return err
+		}
+
+		n.pending = append(n.pending, *tx)
+	}
+
+	if err := n.net.Open(); err != nil {
+		return err
+	}
+
+	n.router.Start()
+	n.messages = n.router.Subscribe(consensusnetwork.ConsensusTopic)
+	go n.receive()
+
+	return nil
+}
+
+func (n *node) crash() {
+	// Nodes that were never wired have nothing to close
+	if n.crashed.Swap(true) || n.net == nil {
+		return
+	}
+
+	if n.messages != nil {
+		n.messages.Unsubscribe()
+	}
+	n.net.Close()
+}
+
+func (n *node) receive() {
+	for {
+		select {
+		case delivery := <-n.messages.Channel():
+			n.received.Add(1)
+			n.handle(delivery.Payload)
+		case <-n.messages.WaitClose():
+			return
+		}
+	}
+}
+
+func (n *node) handle(payload []byte) {
+	var shell messages.ConsensusShell
+	if err := encodings.Decode(payload, &shell); err != nil {
+		return
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	// The host dropped messages of other contexts already, but the context may have changed since
+	if n.round == nil || !n.round.context.IsSame(shell.Context) {
+		return
+	}
+
+	switch shell.Type {
+	case messages.ShellTypeCommitment:
+		var commitment messages.CommitmentMessage
+		if encodings.Decode(shell.Content, &commitment) == nil {
+			n.addCommitment(&commitment)
+		}
+	case messages.ShellTypeRevealing:
+		var revealing messages.RevealingMessage
+		if encodings.Decode(shell.Content, &revealing) == nil {
+			n.addRevealing(&revealing)
+		}
+	case messages.ShellTypeProposal:
+		var proposal messages.ProposalMessage
+		if encodings.Decode(shell.Content, &proposal) == nil {
+			n.addProposal(proposal)
+		}
+	case messages.ShellTypeVote:
+		var vote messages.VoteMessage
+		if encodings.Decode(shell.Content, &vote) == nil {
+			n.addVote(vote)
+		}
+	}
+}
+
+// enter switches the node to the phase, so it accepts the messages of the phase from then on.
+// Entering the commitment phase starts a round on top of the last block of the node.
+func (n *node) enter(phase string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if phase == PhaseCommitment {
+		last := n.history.GetLastBlock()
+		n.round = &round{
+			context:   consensus.ConsensusContext{BlockIndex: int64(last.Index + 1), PrevHash: last.Hash},
+			forgery:   forgery.NewBlockForgery(),
+			proposals: make(map[string]messages.ProposalMessage),
+			votes:     make(map[string][]byte),
+		}
+	}
+
+	if phase == PhasePropagating {
+		forgery.ElectWeighted(n.round.forgery, n.weigh)
+	}
+
+	context := n.round.context
+	context.Phase = phase
+	n.round.context = context
+	n.host.SetContext(&context)
+}
+
+// act sends what the node has to send as the phase starts.
+func (n *node) act(phase string) {
+	switch phase {
+	case PhaseCommitment:
+		n.commit()
+	case PhaseRevealing:
+		n.mux.Lock()
+		r := n.round
+		n.mux.Unlock()
+
+		if n.spec.Behavior == DelayedRevealer {
+			n.sim.clock.AfterFunc(n.spec.RevealDelay, func() { n.reveal(r) })
+			return
+		}
+
+		n.reveal(r)
+	case PhasePropagating:
+		n.propose()
+	case PhaseVoting:
+		n.vote()
+	}
+}
+
+func (n *node) commit() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.round.value = make([]byte, forgery.CommitedLength)
+	for i := range n.round.value {
+		n.round.value[i] = byte(n.random.Uint32())
+	}
+
+	commitment := &messages.CommitmentMessage{
+		Tag:        n.tag,
+		Commitment: cryptutil.Hash(n.round.value),
+		Stakes:     int64(n.history.GetStakes(n.tag)),
+		BlockIndex: n.round.context.BlockIndex,
+		PrevHash:   n.round.context.PrevHash,
+	}
+
+	signature, err := signatures.Sign(n.id, commitment.Serialize())
+	if err != nil {
+		log.Println("Error signing commitment of node ", n.index, ": ", err)
+		return
+	}
+	commitment.Signature = signature
+
+	n.round.commitment = commitment
+	n.addCommitment(commitment)
+	n.publish(messages.ShellTypeCommitment, commitment.Serialize())
+}
+
+// reveal publishes the value committed in the round with the context of its revealing phase,
+// even if the node moved on since, as a late reveal would be.
+func (n *node) reveal(r *round) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if r.commitment == nil {
+		return
+	}
+
+	revealing := &messages.RevealingMessage{
+		CommitmentHash: cryptutil.HashToString(r.commitment.Serialize()),
+		Commited:       r.value,
+	}
+
+	if r == n.round {
+		n.addRevealing(revealing)
+	}
+
+	context := r.context
+	context.Phase = PhaseRevealing
+	n.publishWithContext(context, messages.ShellTypeRevealing, encode(revealing))
+}
+
+func (n *node) propose() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.round.forgery.ElectedTag != n.tag {
+		return
+	}
+
+	proposal, err := n.signProposal(n.sim.clock.Now().Unix())
+	if err != nil {
+		log.Println("Error signing proposal of node ", n.index, ": ", err)
+		return
+	}
+
+	if n.spec.Behavior != EquivocatingForger {
+		n.addProposal(proposal)
+		n.publish(messages.ShellTypeProposal, proposal.Serialize())
+		return
+	}
+
+	other, err := n.signProposal(proposal.Timestamp + 1)
+	if err != nil {
+		log.Println("Error signing proposal of node ", n.index, ": ", err)
+		return
+	}
+
+	n.addProposal(proposal)
+	n.addProposal(other)
+	n.split(messages.ShellTypeProposal, proposal.Serialize(), other.Serialize())
+}
+
+// signProposal proposes a block with the answers to the storage challenges of the node and its pending transactions
+func (n *node) signProposal(timestamp int64) (messages.ProposalMessage, error) {
+	proofs, err := n.prover.ProveFor(uint64(n.round.context.BlockIndex), n.round.context.PrevHash)
+	if err != nil {
+		return messages.ProposalMessage{}, err
+	}
+
+	proposal := messages.ProposalMessage{
+		Tag:           n.tag,
+		BlockIndex:    n.round.context.BlockIndex,
+		PrevHash:      n.round.context.PrevHash,
+		Timestamp:     timestamp,
+		StorageProofs: proofs,
+		Transactions:  n.pending,
+	}
+
+	signature, err := signatures.Sign(n.id, proposal.Serialize())
+	proposal.Signature = signature
+	return proposal, err
+}
+
+// vote votes for the proposal of the elected forger, unless the forger proposed more than one block.
+// Equivocating forgers vote for every block they proposed.
+func (n *node) vote() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.spec.Behavior != EquivocatingForger && len(n.round.proposals) != 1 {
+		return
+	}
+
+	for _, proposal := range n.round.proposals {
+		vote := messages.VoteMessage{
+			Tag:        n.tag,
+			BlockIndex: n.round.context.BlockIndex,
+			BlockHash:  proposal.BlockHash(),
+		}
+
+		signature, err := signatures.Sign(n.id, vote.Serialize())
+		if err != nil {
+			log.Println("Error signing vote of node ", n.index, ": ", err)
+			return
+		}
+		vote.Signature = signature
+
+		n.addVote(vote)
+		n.publish(messages.ShellTypeVote, vote.Serialize())
+	}
+}
+
+// finalize appends the block voted for by more than two thirds of the stake, if there is one.
+func (n *node) finalize() (*blocks.Block, bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	tally := make(map[string]uint64)
+	for voter, hash := range n.round.votes {
+		tally[hex.EncodeToString(hash)] += n.history.GetStakes(voter)
+	}
+
+	for hash, stake := range tally {
+		proposal, found := n.round.proposals[hash]
+		if !found || 3*stake <= 2*n.sim.totalStake {
+			continue
+		}
+
+		// The history applies the block to the storage registry, so the next election weighs its outcomes
+		block := blockOf(proposal)
+		if err := n.history.Append(block); err != nil {
+			log.Println("Error appending block of node ", n.index, ": ", err)
+			return nil, false
+		}
+
+		if block.ForgerTag == n.tag {
+			n.pending = make([]transactions.TransactionShape, 0)
+		}
+
+		return block, true
+	}
+
+	return nil, false
+}
+
+func (n *node) electedTag() string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.round.forgery.ElectedTag
+}
+
+func (n *node) addCommitment(commitment *messages.CommitmentMessage) {
+	if commitment.BlockIndex != n.round.context.BlockIndex || !bytes.Equal(commitment.PrevHash, n.round.context.PrevHash) {
+		return
+	}
+
+	for _, p := range n.round.forgery.Participations {
+		if p.Commitment.Tag == commitment.Tag {
+			return
+		}
+	}
+
+	n.round.forgery.AddParticipation(forgery.NewParticipation(commitment))
+}
+
+// addRevealing reveals the participation whose commitment the value opens
+func (n *node) addRevealing(revealing *messages.RevealingMessage) {
+	if n.round.context.Phase != PhaseRevealing {
+		return
+	}
+
+	for _, p := range n.round.forgery.Participations {
+		if cryptutil.HashToString(p.Commitment.Serialize()) == revealing.CommitmentHash &&
+			bytes.Equal(p.Commitment.Commitment, cryptutil.Hash(revealing.Commited)) {
+			n.round.forgery.Reveal(revealing)
+			return
+		}
+	}
+}
+
+// addProposal keeps the proposal of the elected forger for the round, if its storage proofs are valid
+func (n *node) addProposal(proposal messages.ProposalMessage) {
+	if proposal.Tag != n.round.forgery.ElectedTag || proposal.BlockIndex != n.round.context.BlockIndex ||
+		!bytes.Equal(proposal.PrevHash, n.round.context.PrevHash) {
+		return
+	}
+
+	if err := n.validator.ValidateBlock(blockOf(proposal), proposal.PrevHash); err != nil {
+		log.Println("Node ", n.index, " dropped proposal of ", proposal.Tag, ": ", err)
+		return
+	}
+
+	n.round.proposals[hex.EncodeToString(proposal.BlockHash())] = proposal
+}
+
+func (n *node) addVote(vote messages.VoteMessage) {
+	if vote.BlockIndex != n.round.context.BlockIndex || n.history.GetStakes(vote.Tag) == 0 {
+		return
+	}
+
+	if _, found := n.round.votes[vote.Tag]; !found {
+		n.round.votes[vote.Tag] = vote.BlockHash
+	}
+}
+
+// weigh trusts the committed stakes only up to the stakes of the tag in the chain,
+// and scales them by the outcomes of its storage challenges
+func (n *node) weigh(tag string, stakes uint64) uint64 {
+	return n.registry.Weigh(tag, min(stakes, n.history.GetStakes(tag)))
+}
+
+func (n *node) publish(shellType string, content []byte) {
+	n.publishWithContext(n.round.context, shellType, content)
+}
+
+func (n *node) publishWithContext(context consensus.ConsensusContext, shellType string, content []byte) {
+	if n.crashed.Load() {
+		return
+	}
+
+	n.host.PropagateMessage(messages.ConsensusShell{Type: shellType, Context: context, Content: content})
+}
+
+// split sends one content to half of the connections of the node, and the other content to the rest,
+// bypassing the router so that each half first sees a different message.
+func (n *node) split(shellType string, content []byte, other []byte) {
+	i := 0
+	for conn := range n.net.GetConnections().Current().All() {
+		chosen := content
+		if i%2 == 1 {
+			chosen = other
+		}
+		i++
+
+		shell := messages.ConsensusShell{Type: shellType, Context: n.round.context, Content: chosen}
+		msg := gossiprouter.GossipMessage{
+			Topic:   consensusnetwork.ConsensusTopic,
+			Ttl:     n.config.GossipTTL,
+			Payload: shell.GetRaw(),
+		}
+
+		conn.GetTunnel().Send(gossiprouter.GossipTopic, encode(msg))
+	}
+}
+
+func blockOf(proposal messages.ProposalMessage) *blocks.Block {
+	txs := make([]transactions.Transaction, 0, len(proposal.Transactions))
+	for i := range proposal.Transactions {
+		txs = append(txs, &proposal.Transactions[i])
+	}
+
+	return &blocks.Block{
+		ForgerTag:       proposal.Tag,
+		ForgerSignature: proposal.Signature,
+		Index:           uint64(proposal.BlockIndex),
+		Timestamp:       proposal.Timestamp,
+		Hash:            proposal.BlockHash(),
+		Transations:     txs,
+		StorageProofs:   proposal.StorageProofs,
+	}
+}
+
+func encode(v any) []byte {
+	data, _ := encodings.Encode(v)
+	return data
+}
@@ -0,0 +1,134 @@
+package simulation
+
+import "time"
+
+// Behavior of a node in a scenario.
+type Behavior int
+
+const (
+	Honest Behavior = iota
+
+	// DelayedRevealer reveals its commitment RevealDelay after the revealing phase starts.
+	// Reveals that miss the phase are dropped by the other nodes.
+	DelayedRevealer
+
+	// EquivocatingForger proposes two different blocks when elected, each to half of its connections,
+	// and votes for both.
+	EquivocatingForger
+)
+
+type NodeSpec struct {
+	Stake       uint64
+	Behavior    Behavior
+	RevealDelay time.Duration
+
+	// Content held by the node. It commits to it in the first block it forges, and answers its challenges from then on.
+	Content []byte
+
+	// Admin nodes sign the validator set transactions of a Permissioned scenario.
+	Admin bool
+}
+
+// Timeouts of the phases of a round, which pass on the virtual clock.
+type Timeouts struct {
+	Commitment  time.Duration
+	Revealing   time.Duration
+	Propagating time.Duration
+	Voting      time.Duration
+}
+
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Commitment:  6 * time.Second,
+		Revealing:   6 * time.Second,
+		Propagating: 24 * time.Second,
+		Voting:      24 * time.Second,
+	}
+}
+
+// Round is the virtual length of a round with these timeouts.
+func (t Timeouts) Round() time.Duration {
+	return t.Commitment + t.Revealing + t.Propagating + t.Voting
+}
+
+// Event is a fault scripted for the start of a round.
+type Event struct {
+	Round int
+	apply func(*Simulation)
+}
+
+// CrashAt closes the network of the nodes at the start of the round. Crashed nodes do not come back.
+func CrashAt(round int, nodes ...int) Event {
+	return Event{Round: round, apply: func(s *Simulation) {
+		for _, i := range nodes {
+			s.nodes[i].crash()
+		}
+	}}
+}
+
+// PartitionAt splits the nodes in groups that cannot reach each other at the start of the round.
+// Nodes left out form a group of their own.
+func PartitionAt(round int, groups ...[]int) Event {
+	return Event{Round: round, apply: func(s *Simulation) {
+		tagGroups := make([][]string, 0, len(groups))
+		for _, group := range groups {
+			tags := make([]string, 0, len(group))
+			for _, i := range group {
+				tags = append(tags, s.nodes[i].tag)
+			}
+			tagGroups = append(tagGroups, tags)
+		}
+
+		s.mem.Partition(tagGroups...)
+	}}
+}
+
+// HealAt removes the partitions at the start of the round.
+func HealAt(round int) Event {
+	return Event{Round: round, apply: func(s *Simulation) {
+		s.mem.Heal()
+	}}
+}
+
+// Scenario of a simulation. Zero fields take the defaults below.
+type Scenario struct {
+	Nodes  []NodeSpec
+	Rounds int
+	Seed   uint64
+	Events []Event
+
+	Timeouts Timeouts // DefaultTimeouts
+
+	// Every node starts in the allowlist of the others, which then follows the validator set transactions
+	// of the Admin nodes in the finalized blocks.
+	Permissioned bool
+
+	// Bound of the liveness invariant, on the virtual clock. Two rounds by default.
+	MaxFinalizationTime time.Duration
+
+	// Each node connects to the Degree nodes after it, wrapping around. 3 by default.
+	Degree int
+
+	// Real time without deliveries after which sent messages are taken to have spread. 20ms by default.
+	Settle time.Duration
+}
+
+func (s Scenario) withDefaults() Scenario {
+	if s.Timeouts == (Timeouts{}) {
+		s.Timeouts = DefaultTimeouts()
+	}
+
+	if s.MaxFinalizationTime == 0 {
+		s.MaxFinalizationTime = 2 * s.Timeouts.Round()
+	}
+
+	if s.Degree == 0 {
+		s.Degree = 3
+	}
+
+	if s.Settle == 0 {
+		s.Settle = 20 * time.Millisecond
+	}
+
+	return s
+}
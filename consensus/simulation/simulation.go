@@ -0,0 +1,299 @@
+// Package simulation is a model checker for the PoS protocol: it runs a model of the round over many nodes
+// in a single process and checks its liveness and safety. Each node has its own DI container on an in-memory network.
+// Phase timeouts and link delays pass on a virtual clock, and faults are scripted per round: crashes, partitions,
+// delayed reveals and equivocating forgers.
+//
+// It is not a test of the production round loop. The machine in consensus/internal/states cannot run a full round yet,
+// so the simulation drives its own round with the same pieces: signed commitments, reveals, the election weighted
+// by stakes and storage proofs, the block proposed by the forger with its proofs, and blocks finalized by votes
+// of more than two thirds of the stake. Passing invariants say the protocol holds up, not that the states do.
+//
+// Only the protocol time is virtual. Between phases the simulation still waits in real time for the nodes
+// to handle what they received, as their services run on goroutines of their own.
+package simulation
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
+	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/network/memnet"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+var ErrNoNodes = errors.New("scenario has no nodes")
+
+// Simulation of a scenario. Runs with the same scenario elect the same forgers,
+// as long as the messages of each phase reach the same nodes.
+type Simulation struct {
+	scenario   Scenario
+	mem        *memnet.Network
+	clock      *Clock
+	start      time.Time
+	nodes      []*node
+	totalStake uint64
+	storageDir string
+}
+
+// New opens the nodes of the scenario, from a genesis block shared by all of them with their stakes, and connects them.
+func New(scenario Scenario) (*Simulation, error) {
+	if len(scenario.Nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	storageDir, err := os.MkdirTemp("", "simulation")
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Unix(0, 0)
+	clock := NewClock(start)
+	s := &Simulation{
+		scenario:   scenario.withDefaults(),
+		mem:        memnet.NewWithClock(scenario.Seed, clock),
+		clock:      clock,
+		start:      start,
+		nodes:      make([]*node, 0, len(scenario.Nodes)),
+		storageDir: storageDir,
+	}
+
+	genesis := history.State{
+		Index:     0,
+		BlockHash: cryptutil.Hash([]byte("genesis")),
+		Stakes:    make(map[string]uint64),
+	}
+
+	for i, spec := range scenario.Nodes {
+		nodeDir, err := os.MkdirTemp(storageDir, "node")
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		n, err := newNode(s, i, spec, nodeDir)
+		if err != nil {
+			s.Close()
+			return nil, errorutil.WithInner("failed to create node", err)
+		}
+
+		s.nodes = append(s.nodes, n)
+		s.totalStake += spec.Stake
+		genesis.Stakes[n.tag] = spec.Stake
+	}
+
+	s.configureAllowlists()
+	for _, n := range s.nodes {
+		n.wire()
+	}
+
+	for _, n := range s.nodes {
+		if err := n.open(genesis); err != nil {
+			s.Close()
+			return nil, errorutil.WithInner("failed to open node", err)
+		}
+	}
+
+	if err := s.connect(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// configureAllowlists allows every node of a Permissioned scenario, with the Admin nodes as validator set admins
+func (s *Simulation) configureAllowlists() {
+	if !s.scenario.Permissioned {
+		return
+	}
+
+	tags, admins := make([]string, 0, len(s.nodes)), make([]string, 0)
+	for _, n := range s.nodes {
+		tags = append(tags, n.tag)
+		if n.spec.Admin {
+			admins = append(admins, n.tag)
+		}
+	}
+
+	for _, n := range s.nodes {
+		n.config.Permissioned = true
+		n.config.Allowlist = tags
+		n.config.ValidatorSetAdmins = admins
+	}
+}
+
+// Tag returns the tag of the node with the given index in the scenario.
+func (s *Simulation) Tag(node int) string {
+	return s.nodes[node].tag
+}
+
+// Identity returns the identity of the node with the given index in the scenario, to sign its transactions.
+func (s *Simulation) Identity(node int) identity.PrivateIdentity {
+	return s.nodes[node].id
+}
+
+// Submit adds the transaction to the pending ones of the node, included in the next block it forges.
+func (s *Simulation) Submit(node int, tx transactions.TransactionShape) {
+	n := s.nodes[node]
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.pending = append(n.pending, tx)
+}
+
+// IsAllowed checks if tag is in the allowlist of the node with the given index.
+func (s *Simulation) IsAllowed(node int, tag string) bool {
+	return s.nodes[node].allowlist.IsAllowed(tag)
+}
+
+// Outcomes returns the outcomes of the storage challenges of tag, as recorded by the node with the given index.
+func (s *Simulation) Outcomes(node int, tag string) storageproofs.Outcomes {
+	return s.nodes[node].registry.GetOutcomes(tag)
+}
+
+// Run runs the rounds of the scenario, then checks the invariants on what was finalized:
+// no conflicting blocks, and no longer than MaxFinalizationTime without a new block.
+func (s *Simulation) Run() (*Report, error) {
+	report := &Report{
+		Finalizations: make([]Finalization, 0),
+		Forgers:       make([]string, 0, s.scenario.Rounds),
+	}
+
+	for round := 1; round <= s.scenario.Rounds; round++ {
+		for _, event := range s.scenario.Events {
+			if event.Round == round {
+				event.apply(s)
+			}
+		}
+
+		s.runRound(report)
+	}
+
+	report.Duration = s.clock.Now().Sub(s.start)
+	return report, errors.Join(report.CheckSafety(), report.CheckLiveness(s.scenario.MaxFinalizationTime))
+}
+
+// Close closes the nodes and removes their storage.
+func (s *Simulation) Close() error {
+	for _, n := range s.nodes {
+		n.crash()
+	}
+
+	return os.RemoveAll(s.storageDir)
+}
+
+// runRound takes the running nodes through the phases. Every node enters a phase before any acts on it,
+// so no message is dropped for arriving early. The phase ends once its timeout passes on the clock.
+func (s *Simulation) runRound(report *Report) {
+	timeouts := s.scenario.Timeouts
+	phases := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{PhaseCommitment, timeouts.Commitment},
+		{PhaseRevealing, timeouts.Revealing},
+		{PhasePropagating, timeouts.Propagating},
+		{PhaseVoting, timeouts.Voting},
+	}
+
+	running := s.running()
+	for _, phase := range phases {
+		for _, n := range running {
+			n.enter(phase.name)
+		}
+
+		for _, n := range running {
+			n.act(phase.name)
+		}
+
+		s.settle()
+		s.clock.Advance(phase.timeout)
+		s.settle()
+	}
+
+	report.Forgers = append(report.Forgers, s.electedTag(running))
+	for _, n := range running {
+		if block, finalized := n.finalize(); finalized {
+			report.Finalizations = append(report.Finalizations, Finalization{
+				Node:   n.index,
+				Honest: n.spec.Behavior != EquivocatingForger,
+				Index:  block.Index,
+				Hash:   block.Hash,
+				Forger: block.ForgerTag,
+				At:     s.clock.Now().Sub(s.start),
+			})
+		}
+	}
+}
+
+// connect connects each node to the Degree nodes after it, once per pair
+func (s *Simulation) connect() error {
+	connected := make(map[[2]int]bool)
+	for i, n := range s.nodes {
+		for j := 1; j <= s.scenario.Degree; j++ {
+			peer := s.nodes[(i+j)%len(s.nodes)]
+			pair := [2]int{min(i, peer.index), max(i, peer.index)}
+			if peer == n || connected[pair] {
+				continue
+			}
+			connected[pair] = true
+
+			if err := n.net.GetConnections().ConnectTo(peer.id, peer.address); err != nil {
+				return errorutil.WithInner("failed to connect nodes", err)
+			}
+		}
+	}
+
+	s.settle()
+	return nil
+}
+
+// settle waits until no node received a message for the Settle time, so that what was sent reached whoever it could.
+// It waits in real time, while the virtual clock stands still.
+func (s *Simulation) settle() {
+	last := s.received()
+	for {
+		time.Sleep(s.scenario.Settle)
+
+		current := s.received()
+		if current == last {
+			return
+		}
+		last = current
+	}
+}
+
+func (s *Simulation) received() int64 {
+	total := int64(0)
+	for _, n := range s.nodes {
+		total += n.received.Load()
+	}
+
+	return total
+}
+
+func (s *Simulation) running() []*node {
+	running := make([]*node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		if !n.crashed.Load() {
+			running = append(running, n)
+		}
+	}
+
+	return running
+}
+
+func (s *Simulation) electedTag(running []*node) string {
+	for _, n := range running {
+		if n.spec.Behavior != EquivocatingForger {
+			return n.electedTag()
+		}
+	}
+
+	return ""
+}
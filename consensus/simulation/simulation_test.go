@@ -0,0 +1,283 @@
+package simulation_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/consensus/simulation"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+)
+
+// run runs the scenario, closing the simulation when the test ends
+func run(t *testing.T, scenario simulation.Scenario) (*simulation.Simulation, *simulation.Report, error) {
+	sim, err := simulation.New(scenario)
+	if err != nil {
+		t.Fatalf("Error creating simulation: %s", err)
+	}
+	t.Cleanup(func() { sim.Close() })
+
+	report, err := sim.Run()
+	return sim, report, err
+}
+
+func stakes(values ...uint64) []simulation.NodeSpec {
+	specs := make([]simulation.NodeSpec, 0, len(values))
+	for _, stake := range values {
+		specs = append(specs, simulation.NodeSpec{Stake: stake})
+	}
+
+	return specs
+}
+
+func Test__Run__ShouldFinalizeEveryRoundOnEveryNode__WhenAllNodesAreHonest(t *testing.T) {
+	// Arrange
+	scenario := simulation.Scenario{Nodes: stakes(10, 20, 30, 40), Rounds: 4, Seed: 1}
+
+	// Act
+	_, report, err := run(t, scenario)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	for node := range scenario.Nodes {
+		if finalized := report.FinalizedBy(node); len(finalized) != scenario.Rounds {
+			t.Errorf("Expected node %d to finalize %d blocks, got %d", node, scenario.Rounds, len(finalized))
+		}
+	}
+}
+
+func Test__Run__ShouldKeepFinalizing__WhenNodesWithLessThanAThirdOfStakeCrash(t *testing.T) {
+	// Arrange
+	scenario := simulation.Scenario{
+		Nodes:  stakes(10, 10, 10, 10, 10),
+		Rounds: 4,
+		Seed:   2,
+		Events: []simulation.Event{simulation.CrashAt(2, 0)},
+	}
+
+	// Act
+	_, report, err := run(t, scenario)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	if finalized := report.FinalizedBy(1); len(finalized) != scenario.Rounds {
+		t.Fatalf("Expected %d blocks despite the crash, got %d", scenario.Rounds, len(finalized))
+	}
+}
+
+func Test__Run__ShouldStallWithoutConflicts__WhenNoPartitionHoldsTwoThirdsOfStake(t *testing.T) {
+	// Arrange
+	scenario := simulation.Scenario{
+		Nodes:  stakes(10, 10, 10, 10),
+		Rounds: 5,
+		Seed:   3,
+		Events: []simulation.Event{
+			simulation.PartitionAt(2, []int{0, 1}, []int{2, 3}),
+			simulation.HealAt(5),
+		},
+	}
+
+	// Act
+	_, report, err := run(t, scenario)
+
+	// Assert
+	if !errors.Is(err, simulation.ErrFinalizationTooSlow) {
+		t.Fatalf("Expected liveness to be broken by the partition, got %v", err)
+	}
+
+	if errors.Is(err, simulation.ErrConflictingBlocks) {
+		t.Fatalf("Expected no conflicting blocks, got %s", err)
+	}
+
+	for node := range scenario.Nodes {
+		if finalized := report.FinalizedBy(node); len(finalized) != 2 {
+			t.Errorf("Expected node %d to finalize only before the partition and after healing, got %d blocks", node, len(finalized))
+		}
+	}
+}
+
+func Test__Run__ShouldKeepFinalizingOnTheMajoritySide__WhenNetworkIsPartitioned(t *testing.T) {
+	// Arrange
+	scenario := simulation.Scenario{
+		Nodes:  stakes(10, 10, 10, 10),
+		Rounds: 3,
+		Seed:   4,
+		Events: []simulation.Event{simulation.PartitionAt(2, []int{0, 1, 2}, []int{3})},
+	}
+
+	// Act
+	_, report, err := run(t, scenario)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	if finalized := report.FinalizedBy(0); len(finalized) != scenario.Rounds {
+		t.Errorf("Expected the majority to finalize %d blocks, got %d", scenario.Rounds, len(finalized))
+	}
+
+	if finalized := report.FinalizedBy(3); len(finalized) != 1 {
+		t.Errorf("Expected the minority to finalize only before the partition, got %d blocks", len(finalized))
+	}
+}
+
+func Test__Run__ShouldNeverElectDelayedRevealer__WhenItRevealsAfterThePhase(t *testing.T) {
+	// Arrange
+	timeouts := simulation.DefaultTimeouts()
+	nodes := stakes(1000, 10, 10, 10)
+	nodes[0].Behavior = simulation.DelayedRevealer
+	nodes[0].RevealDelay = timeouts.Revealing + time.Second
+	scenario := simulation.Scenario{Nodes: nodes, Rounds: 3, Seed: 5, Timeouts: timeouts}
+
+	// Act
+	sim, report, err := run(t, scenario)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	for round, forger := range report.Forgers {
+		if forger == "" || forger == sim.Tag(0) {
+			t.Errorf("Expected an honest forger in round %d, got %q", round+1, forger)
+		}
+	}
+}
+
+func Test__Run__ShouldNotFinalizeConflictingBlocks__WhenForgerEquivocates(t *testing.T) {
+	// Arrange
+	nodes := stakes(20, 15, 15, 15)
+	nodes[0].Behavior = simulation.EquivocatingForger
+	scenario := simulation.Scenario{Nodes: nodes, Rounds: 6, Seed: 6, MaxFinalizationTime: time.Hour}
+
+	// Act
+	sim, report, err := run(t, scenario)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	elected := false
+	for _, forger := range report.Forgers {
+		elected = elected || forger == sim.Tag(0)
+	}
+
+	if !elected {
+		t.Fatal("Expected the equivocating forger to be elected at least once")
+	}
+
+	for _, f := range report.Finalizations {
+		if f.Honest && f.Forger == sim.Tag(0) {
+			t.Fatalf("Expected no block of the equivocating forger to be finalized, node %d finalized index %d", f.Node, f.Index)
+		}
+	}
+}
+
+func Test__Run__ShouldRecordStorageOutcomesAlikeOnEveryNode__WhenAForgerHoldsContent(t *testing.T) {
+	// Arrange
+	content := make([]byte, 8*4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	scenario := simulation.Scenario{Nodes: stakes(10, 10, 10, 70), Rounds: 5, Seed: 7}
+	scenario.Nodes[3].Content = content
+
+	// Act
+	sim, report, err := run(t, scenario)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	holder := sim.Tag(3)
+	outcomes := sim.Outcomes(3, holder)
+	if outcomes.Passed == 0 || outcomes.Failed != 0 {
+		t.Fatalf("Expected the holder to pass every challenge of the %v forgers, got %+v", report.Forgers, outcomes)
+	}
+
+	for node := range scenario.Nodes {
+		if other := sim.Outcomes(node, holder); other != outcomes {
+			t.Errorf("Expected node %d to record %+v, got %+v", node, outcomes, other)
+		}
+	}
+}
+
+func Test__Run__ShouldRemoveValidatorFromEveryAllowlist__WhenAdminUpdateIsFinalized(t *testing.T) {
+	// Arrange
+	scenario := simulation.Scenario{Nodes: stakes(10, 10, 10, 70), Rounds: 5, Seed: 7, Permissioned: true}
+	scenario.Nodes[3].Admin = true
+
+	sim, err := simulation.New(scenario)
+	if err != nil {
+		t.Fatalf("Error creating simulation: %s", err)
+	}
+	t.Cleanup(func() { sim.Close() })
+
+	removed := sim.Tag(0)
+	tx, err := allowlist.NewValidatorSetTransaction(sim.Identity(3), 1, []string{}, []string{removed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Submit(3, *tx)
+
+	// Act
+	report, err := sim.Run()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the invariants to hold, got %s", err)
+	}
+
+	for node := 1; node < len(scenario.Nodes); node++ {
+		if sim.IsAllowed(node, removed) {
+			t.Errorf("Expected node %d to follow the update finalized with the %v forgers", node, report.Forgers)
+		}
+
+		if !sim.IsAllowed(node, sim.Tag(1)) {
+			t.Errorf("Expected node %d to keep allowing the other validators", node)
+		}
+	}
+}
+
+func Test__CheckSafety__ShouldFail__WhenHonestNodesFinalizeDifferentBlocksAtSameIndex(t *testing.T) {
+	// Arrange
+	report := &simulation.Report{Finalizations: []simulation.Finalization{
+		{Node: 0, Honest: true, Index: 1, Hash: []byte{1}},
+		{Node: 1, Honest: false, Index: 1, Hash: []byte{2}},
+		{Node: 2, Honest: true, Index: 1, Hash: []byte{3}},
+	}}
+
+	// Act
+	err := report.CheckSafety()
+
+	// Assert
+	if !errors.Is(err, simulation.ErrConflictingBlocks) {
+		t.Fatalf("Expected conflicting blocks, got %v", err)
+	}
+}
+
+func Test__CheckLiveness__ShouldFail__WhenRunEndsLongAfterTheLastBlock(t *testing.T) {
+	// Arrange
+	report := &simulation.Report{
+		Finalizations: []simulation.Finalization{{Node: 0, Honest: true, Index: 1, At: time.Minute}},
+		Duration:      time.Hour,
+	}
+
+	// Act
+	err := report.CheckLiveness(10 * time.Minute)
+
+	// Assert
+	if !errors.Is(err, simulation.ErrFinalizationTooSlow) {
+		t.Fatalf("Expected liveness to fail, got %v", err)
+	}
+}
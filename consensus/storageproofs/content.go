@@ -0,0 +1,139 @@
+package storageproofs
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrEmptyContent  = errors.New("content is empty")
+	ErrInvalidChunk  = errors.New("invalid chunk size")
+	ErrIndexOutRange = errors.New("chunk index out of range")
+)
+
+const DefaultChunkSize = 4096
+
+// ContentCommitment is the public information needed to verify possession of a content.
+// Root is the merkle root of the content chunks.
+type ContentCommitment struct {
+	ContentId string
+	Root      []byte
+	Chunks    int64
+}
+
+// Split divides the content into chunks of at most chunkSize bytes.
+func Split(data []byte, chunkSize int) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyContent
+	}
+
+	if chunkSize <= 0 {
+		return nil, ErrInvalidChunk
+	}
+
+	chunks := make([][]byte, 0, (len(data)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(data); start += chunkSize {
+		end := min(start+chunkSize, len(data))
+		chunks = append(chunks, data[start:end])
+	}
+
+	return chunks, nil
+}
+
+// Commit computes the commitment of the given chunks.
+func Commit(contentId string, chunks [][]byte) ContentCommitment {
+	return ContentCommitment{
+		ContentId: contentId,
+		Root:      merkleRoot(leavesOf(chunks)),
+		Chunks:    int64(len(chunks)),
+	}
+}
+
+// The tree is built bottom-up over the chunk hashes.
+// An odd node at the end of a level is paired with itself.
+func leavesOf(chunks [][]byte) [][]byte {
+	leaves := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		leaves[i] = hashLeaf(chunk)
+	}
+
+	return leaves
+}
+
+func merkleRoot(level [][]byte) []byte {
+	if len(level) == 0 {
+		return nil
+	}
+
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+
+	return level[0]
+}
+
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+
+		next = append(next, hashNode(level[i], right))
+	}
+
+	return next
+}
+
+// siblingsOf returns the sibling hashes from the leaf at index up to the root.
+func siblingsOf(leaves [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrIndexOutRange
+	}
+
+	siblings := make([][]byte, 0)
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+
+		siblings = append(siblings, level[sibling])
+		level = nextLevel(level)
+		index /= 2
+	}
+
+	return siblings, nil
+}
+
+func rootFromPath(chunk []byte, index int64, siblings [][]byte) []byte {
+	current := hashLeaf(chunk)
+	for _, sibling := range siblings {
+		if index%2 == 0 {
+			current = hashNode(current, sibling)
+		} else {
+			current = hashNode(sibling, current)
+		}
+
+		index /= 2
+	}
+
+	return current
+}
+
+func hashLeaf(chunk []byte) []byte {
+	sha := sha256.New()
+	sha.Write([]byte{0})
+	sha.Write(chunk)
+	return sha.Sum(nil)
+}
+
+func hashNode(left, right []byte) []byte {
+	sha := sha256.New()
+	sha.Write([]byte{1})
+	sha.Write(left)
+	sha.Write(right)
+	return sha.Sum(nil)
+}
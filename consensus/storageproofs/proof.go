@@ -0,0 +1,153 @@
+package storageproofs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"slices"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+)
+
+var (
+	ErrChallengeMismatch = errors.New("proof does not answer the challenge")
+	ErrWrongChunk        = errors.New("chunk does not match the content root")
+	ErrProofSignature    = errors.New("invalid proof signature")
+)
+
+// Number of chunks asked in each challenge
+const ChallengeSize = 4
+
+// Challenge asks a validator to prove it holds some chunks of a content.
+// It is derived from the previous block hash, so it cannot be precomputed and every node derives the same one.
+type Challenge struct {
+	ContentId  string
+	ProverTag  string
+	BlockIndex int64
+	Indexes    []int64
+}
+
+type ChunkProof struct {
+	Index    int64
+	Chunk    []byte
+	Siblings [][]byte
+}
+
+// Proof is the answer of a validator to a challenge. Proofs are included in blocks.
+type Proof struct {
+	ContentId  string
+	ProverTag  string
+	BlockIndex int64
+	Chunks     []ChunkProof
+	Signature  []byte
+}
+
+// NewChallenge derives the challenge of prover for the given content at a block.
+func NewChallenge(commitment ContentCommitment, proverTag string, blockIndex uint64, prevHash []byte) Challenge {
+	seed := sha256.New()
+	seed.Write(prevHash)
+	seed.Write(binary.BigEndian.AppendUint64(nil, blockIndex))
+	seed.Write([]byte(proverTag))
+	seed.Write([]byte(commitment.ContentId))
+	seedBs := seed.Sum(nil)
+
+	count := min(int64(ChallengeSize), commitment.Chunks)
+	indexes := make([]int64, 0, count)
+	for counter := uint64(0); int64(len(indexes)) < count; counter++ {
+		sha := sha256.New()
+		sha.Write(seedBs)
+		sha.Write(binary.BigEndian.AppendUint64(nil, counter))
+		index := int64(binary.BigEndian.Uint64(sha.Sum(nil)) % uint64(commitment.Chunks))
+
+		if !slices.Contains(indexes, index) {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return Challenge{
+		ContentId:  commitment.ContentId,
+		ProverTag:  proverTag,
+		BlockIndex: int64(blockIndex),
+		Indexes:    indexes,
+	}
+}
+
+// Prove answers the challenge using the content chunks, signing the proof with the prover identity.
+func Prove(prover identity.PrivateIdentity, challenge Challenge, chunks [][]byte) (*Proof, error) {
+	leaves := leavesOf(chunks)
+
+	proof := &Proof{
+		ContentId:  challenge.ContentId,
+		ProverTag:  prover.GetTag(),
+		BlockIndex: challenge.BlockIndex,
+		Chunks:     make([]ChunkProof, 0, len(challenge.Indexes)),
+	}
+
+	for _, index := range challenge.Indexes {
+		siblings, err := siblingsOf(leaves, int(index))
+		if err != nil {
+			return nil, err
+		}
+
+		proof.Chunks = append(proof.Chunks, ChunkProof{
+			Index:    index,
+			Chunk:    chunks[index],
+			Siblings: siblings,
+		})
+	}
+
+	signature, err := signatures.Sign(prover, proof.GetSignedData())
+	if err != nil {
+		return nil, err
+	}
+
+	proof.Signature = signature
+	return proof, nil
+}
+
+// Verify checks that the proof answers the challenge and that every chunk belongs to the committed content.
+func Verify(commitment ContentCommitment, challenge Challenge, proof *Proof) error {
+	if proof.ContentId != challenge.ContentId ||
+		proof.ProverTag != challenge.ProverTag ||
+		proof.BlockIndex != challenge.BlockIndex ||
+		len(proof.Chunks) != len(challenge.Indexes) {
+		return ErrChallengeMismatch
+	}
+
+	proverId, err := identity.FromTag(proof.ProverTag)
+	if err != nil {
+		return err
+	}
+
+	if !signatures.Verify(proverId, proof.GetSignedData(), proof.Signature) {
+		return ErrProofSignature
+	}
+
+	for i, chunkProof := range proof.Chunks {
+		if chunkProof.Index != challenge.Indexes[i] {
+			return ErrChallengeMismatch
+		}
+
+		root := rootFromPath(chunkProof.Chunk, chunkProof.Index, chunkProof.Siblings)
+		if !slices.Equal(root, commitment.Root) {
+			return ErrWrongChunk
+		}
+	}
+
+	return nil
+}
+
+// GetSignedData returns the proof without its signature, encoded.
+func (p *Proof) GetSignedData() []byte {
+	unsigned := *p
+	unsigned.Signature = nil
+
+	bs, err := encodings.Encode(unsigned)
+	if err != nil {
+		panic("failed to encode storage proof")
+	}
+
+	return bs
+}
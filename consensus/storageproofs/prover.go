@@ -0,0 +1,101 @@
+package storageproofs
+
+import (
+	"fmt"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/storage"
+)
+
+// Prover keeps the chunks of the contents this node holds and answers its challenges.
+type Prover struct {
+	id       identity.PrivateIdentity
+	registry *Registry
+	storage  storage.BlobStorage
+}
+
+func ProverFactory(diCtx *di.DIContext) *Prover {
+	idProv := identityprovider.GetFromDI(diCtx)
+
+	id, err := idProv.GetIdentity()
+	if err != nil {
+		return nil
+	}
+
+	return NewProver(id, GetRegistryFromDI(diCtx), storage.GetFromDI(diCtx))
+}
+
+func GetProverFromDI(diCtx *di.DIContext) *Prover {
+	return di.GetService[Prover](diCtx)
+}
+
+func NewProver(id identity.PrivateIdentity, registry *Registry, store storage.BlobStorage) *Prover {
+	return &Prover{
+		id:       id,
+		registry: registry,
+		storage:  store,
+	}
+}
+
+// Hold stores the content chunks and returns their commitment. The content is only challenged once the transaction
+// from NewCommitmentTransaction is in a block, and the block is applied to the registry.
+func (p *Prover) Hold(contentId string, data []byte) (ContentCommitment, error) {
+	chunks, err := Split(data, DefaultChunkSize)
+	if err != nil {
+		return ContentCommitment{}, err
+	}
+
+	for i, chunk := range chunks {
+		if err := p.storage.Store(chunkKey(contentId, int64(i)), chunk); err != nil {
+			return ContentCommitment{}, errorutil.WithInner("failed to store content chunk", err)
+		}
+	}
+
+	return Commit(contentId, chunks), nil
+}
+
+// ProveFor answers every challenge of this node for the block with the given index,
+// from the contents assigned to it in the registry.
+func (p *Prover) ProveFor(blockIndex uint64, prevHash []byte) ([]Proof, error) {
+	challenges := p.registry.Challenges(p.id.GetTag(), blockIndex, prevHash)
+
+	proofs := make([]Proof, 0, len(challenges))
+	for _, challenge := range challenges {
+		commitment, _ := p.registry.GetContent(challenge.ContentId)
+
+		chunks, err := p.loadChunks(commitment)
+		if err != nil {
+			return nil, err
+		}
+
+		proof, err := Prove(p.id, challenge, chunks)
+		if err != nil {
+			return nil, err
+		}
+
+		proofs = append(proofs, *proof)
+	}
+
+	return proofs, nil
+}
+
+func (p *Prover) loadChunks(commitment ContentCommitment) ([][]byte, error) {
+	chunks := make([][]byte, commitment.Chunks)
+	for i := range chunks {
+		chunk, err := p.storage.Retrieve(chunkKey(commitment.ContentId, int64(i)))
+		if err != nil {
+			return nil, errorutil.WithInner("failed to load content chunk", err)
+		}
+
+		chunks[i] = chunk
+	}
+
+	return chunks, nil
+}
+
+func chunkKey(contentId string, index int64) string {
+	return fmt.Sprintf("storageproofs/%s/%d", contentId, index)
+}
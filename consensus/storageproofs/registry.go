@@ -0,0 +1,279 @@
+package storageproofs
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+var (
+	ErrUnknownContent  = errors.New("content is not registered")
+	ErrNotAssigned     = errors.New("content is not assigned to the prover")
+	ErrContentConflict = errors.New("content is registered with another commitment")
+	ErrBlockApplied    = errors.New("block was already applied to the registry")
+)
+
+// Validators that pass every challenge weigh up to this percentage more than the ones holding no content,
+// so holding content pays off instead of only risking penalties.
+const StorageBonusPercent = 50
+
+type Outcomes struct {
+	Passed uint64
+	Failed uint64
+}
+
+// RegistryState is a copy of the registry, sorted so every node encodes it alike, to be carried in snapshots.
+type RegistryState struct {
+	Contents    []ContentCommitment
+	Assignments []Assignment
+	Outcomes    []OutcomeEntry
+}
+
+type Assignment struct {
+	Tag        string
+	ContentIds []string
+}
+
+type OutcomeEntry struct {
+	Tag    string
+	Passed int64
+	Failed int64
+}
+
+// Registry keeps the committed contents, which validators must hold each content,
+// and how each validator performed in its challenges.
+// It only changes with the blocks given to ApplyBlock, so every node following the chain holds the same registry.
+// The block history owns the registry of a node, and applies every block it appends.
+type Registry struct {
+	contents     *cmap.CMap[string, ContentCommitment]
+	assignments  *cmap.CMap[string, []string]
+	outcomes     *cmap.CMap[string, Outcomes]
+	appliedUntil uint64
+	mux          *sync.Mutex
+}
+
+func GetRegistryFromDI(diCtx *di.DIContext) *Registry {
+	return di.GetService[Registry](diCtx)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		contents:    cmap.New[string, ContentCommitment](),
+		assignments: cmap.New[string, []string](),
+		outcomes:    cmap.New[string, Outcomes](),
+		mux:         &sync.Mutex{},
+	}
+}
+
+func (r *Registry) GetContent(contentId string) (ContentCommitment, bool) {
+	return r.contents.Get(contentId)
+}
+
+// GetAssigned returns the commitments of the contents the validator must hold.
+func (r *Registry) GetAssigned(tag string) []ContentCommitment {
+	assigned, _ := r.assignments.Get(tag)
+
+	commitments := make([]ContentCommitment, 0, len(assigned))
+	for _, contentId := range assigned {
+		if commitment, found := r.contents.Get(contentId); found {
+			commitments = append(commitments, commitment)
+		}
+	}
+
+	return commitments
+}
+
+// Challenges returns the challenges the validator must answer for the given block.
+func (r *Registry) Challenges(tag string, blockIndex uint64, prevHash []byte) []Challenge {
+	assigned := r.GetAssigned(tag)
+
+	challenges := make([]Challenge, 0, len(assigned))
+	for _, commitment := range assigned {
+		challenges = append(challenges, NewChallenge(commitment, tag, blockIndex, prevHash))
+	}
+
+	return challenges
+}
+
+func (r *Registry) GetOutcomes(tag string) Outcomes {
+	outcomes, _ := r.outcomes.Get(tag)
+	return outcomes
+}
+
+// Weigh scales the stakes of a validator by its ratio of passed challenges, and adds a bonus of up to
+// StorageBonusPercent that grows with the challenges passed. Validators without assigned content keep their stakes,
+// so they weigh less than the ones proving their content, and more than the ones failing to.
+func (r *Registry) Weigh(tag string, stakes uint64) uint64 {
+	if len(r.GetAssigned(tag)) == 0 {
+		return stakes
+	}
+
+	outcomes := r.GetOutcomes(tag)
+	total := outcomes.Passed + outcomes.Failed + 1
+	scaled := stakes * (outcomes.Passed + 1) / total
+	bonus := stakes * outcomes.Passed * StorageBonusPercent / (100 * total)
+	return scaled + bonus
+}
+
+// ApplyBlock updates the registry with a block appended to the chain, from its index, the hash of the block before it,
+// its forger, transactions and storage proofs. The proofs are checked against the registry before the block:
+// each prover passes or fails once per content, and the forger fails the challenges it did not answer.
+// Then the storage commitment transactions of the block are applied.
+// Fails with ErrBlockApplied for blocks at or before the last one applied, so outcomes are never recorded twice.
+func (r *Registry) ApplyBlock(index uint64, prevHash []byte, forgerTag string, txs []transactions.Transaction, proofs []Proof) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if index <= r.appliedUntil {
+		return ErrBlockApplied
+	}
+	r.appliedUntil = index
+
+	answered := make(map[string]bool)
+	for i := range proofs {
+		proof := &proofs[i]
+		key := proof.ProverTag + "/" + proof.ContentId
+		if answered[key] {
+			continue
+		}
+
+		answered[key] = true
+		r.record(proof.ProverTag, r.Check(proof, index, prevHash) == nil)
+	}
+
+	for _, commitment := range r.GetAssigned(forgerTag) {
+		if !answered[forgerTag+"/"+commitment.ContentId] {
+			r.record(forgerTag, false)
+		}
+	}
+
+	for _, tx := range txs {
+		commitment, err := ParseCommitmentTransaction(tx)
+		if err != nil || !tx.IsValidSignature() {
+			continue
+		}
+
+		if err := r.commit(commitment, tx.GetSourceTag()); err != nil {
+			log.Println("ignoring storage commitment transaction ", tx.GetHash(), ": ", err)
+		}
+	}
+
+	return nil
+}
+
+// Check verifies a proof for the block with the given index against the challenge derived from prevHash.
+// The content must be registered and assigned to the prover.
+func (r *Registry) Check(proof *Proof, blockIndex uint64, prevHash []byte) error {
+	commitment, found := r.contents.Get(proof.ContentId)
+	if !found {
+		return ErrUnknownContent
+	}
+
+	assigned, _ := r.assignments.Get(proof.ProverTag)
+	if !slices.Contains(assigned, proof.ContentId) {
+		return ErrNotAssigned
+	}
+
+	challenge := NewChallenge(commitment, proof.ProverTag, blockIndex, prevHash)
+	return Verify(commitment, challenge, proof)
+}
+
+// State returns a copy of the registry, sorted by content id and tag.
+func (r *Registry) State() RegistryState {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	state := RegistryState{
+		Contents:    make([]ContentCommitment, 0),
+		Assignments: make([]Assignment, 0),
+		Outcomes:    make([]OutcomeEntry, 0),
+	}
+
+	for kv := range r.contents.All() {
+		state.Contents = append(state.Contents, kv.Value)
+	}
+	slices.SortFunc(state.Contents, func(a, b ContentCommitment) int { return strings.Compare(a.ContentId, b.ContentId) })
+
+	for kv := range r.assignments.All() {
+		state.Assignments = append(state.Assignments, Assignment{Tag: kv.Key, ContentIds: slices.Sorted(slices.Values(kv.Value))})
+	}
+	slices.SortFunc(state.Assignments, func(a, b Assignment) int { return strings.Compare(a.Tag, b.Tag) })
+
+	for kv := range r.outcomes.All() {
+		state.Outcomes = append(state.Outcomes, OutcomeEntry{Tag: kv.Key, Passed: int64(kv.Value.Passed), Failed: int64(kv.Value.Failed)})
+	}
+	slices.SortFunc(state.Outcomes, func(a, b OutcomeEntry) int { return strings.Compare(a.Tag, b.Tag) })
+
+	return state
+}
+
+// Restore replaces the registry with the state, as derived from the blocks up to appliedUntil.
+func (r *Registry) Restore(state RegistryState, appliedUntil uint64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	clearMap(r.contents)
+	for _, commitment := range state.Contents {
+		r.contents.Set(commitment.ContentId, commitment)
+	}
+
+	clearMap(r.assignments)
+	for _, assignment := range state.Assignments {
+		r.assignments.Set(assignment.Tag, slices.Clone(assignment.ContentIds))
+	}
+
+	clearMap(r.outcomes)
+	for _, entry := range state.Outcomes {
+		r.outcomes.Set(entry.Tag, Outcomes{Passed: uint64(entry.Passed), Failed: uint64(entry.Failed)})
+	}
+
+	r.appliedUntil = appliedUntil
+}
+
+// clearMap removes every entry of m, which is read without the registry lock and so is never replaced
+func clearMap[V any](m *cmap.CMap[string, V]) {
+	for kv := range m.All() {
+		m.Delete(kv.Key)
+	}
+}
+
+// commit registers the content, if it is new, and makes the validator with the given tag responsible for holding it.
+// Must be called with mux locked.
+func (r *Registry) commit(commitment ContentCommitment, tag string) error {
+	if commitment.Chunks <= 0 {
+		return ErrEmptyContent
+	}
+
+	registered, found := r.contents.Get(commitment.ContentId)
+	if found && (!bytes.Equal(registered.Root, commitment.Root) || registered.Chunks != commitment.Chunks) {
+		return ErrContentConflict
+	}
+
+	r.contents.Set(commitment.ContentId, commitment)
+
+	assigned, _ := r.assignments.Get(tag)
+	if !slices.Contains(assigned, commitment.ContentId) {
+		r.assignments.Set(tag, append(slices.Clone(assigned), commitment.ContentId))
+	}
+
+	return nil
+}
+
+// record stores the outcome of a challenge answered (or not) by the validator. Must be called with mux locked.
+func (r *Registry) record(tag string, passed bool) {
+	outcomes, _ := r.outcomes.Get(tag)
+	if passed {
+		outcomes.Passed++
+	} else {
+		outcomes.Failed++
+	}
+
+	r.outcomes.Set(tag, outcomes)
+}
@@ -0,0 +1,329 @@
+package storageproofs_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/consensus/storageproofs"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func Test__Verify__ShouldAccept__WhenProverHoldsContent(t *testing.T) {
+	for _, size := range []int{1, 100, 4096, 4097, 5 * 4096, 13*4096 + 7} {
+		// Arrange
+		id := generateIdentity(t)
+		registry := storageproofs.NewRegistry()
+		prover := storageproofs.NewProver(id, registry, localstorage.New(t.TempDir()))
+
+		commitment, err := prover.Hold("content", randomBytes(t, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyCommitment(t, registry, 1, id, commitment)
+
+		// Act
+		proofs, err := prover.ProveFor(10, []byte("previous"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Assert
+		if len(proofs) != 1 {
+			t.Fatalf("Expected 1 proof, got %d", len(proofs))
+		}
+
+		challenge := storageproofs.NewChallenge(commitment, id.GetTag(), 10, []byte("previous"))
+		if err := storageproofs.Verify(commitment, challenge, &proofs[0]); err != nil {
+			t.Errorf("Expected proof for content of %d bytes to be valid, got %v", size, err)
+		}
+	}
+}
+
+func Test__Verify__ShouldReject__WhenChunkIsTampered(t *testing.T) {
+	// Arrange
+	id := generateIdentity(t)
+	chunks, _ := storageproofs.Split(randomBytes(t, 10*1024), 1024)
+	commitment := storageproofs.Commit("content", chunks)
+	challenge := storageproofs.NewChallenge(commitment, id.GetTag(), 1, []byte("previous"))
+
+	tampered := make([][]byte, len(chunks))
+	copy(tampered, chunks)
+	tampered[challenge.Indexes[0]] = randomBytes(t, 1024)
+
+	// Act
+	proof, err := storageproofs.Prove(id, challenge, tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if err := storageproofs.Verify(commitment, challenge, proof); !errors.Is(err, storageproofs.ErrWrongChunk) {
+		t.Errorf("Expected ErrWrongChunk, got %v", err)
+	}
+}
+
+func Test__Verify__ShouldReject__WhenProofAnswersAnotherChallenge(t *testing.T) {
+	// Arrange
+	id := generateIdentity(t)
+	chunks, _ := storageproofs.Split(randomBytes(t, 64*1024), 1024)
+	commitment := storageproofs.Commit("content", chunks)
+
+	oldChallenge := storageproofs.NewChallenge(commitment, id.GetTag(), 1, []byte("old"))
+	challenge := storageproofs.NewChallenge(commitment, id.GetTag(), 2, []byte("new"))
+
+	// Act
+	proof, err := storageproofs.Prove(id, oldChallenge, chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if err := storageproofs.Verify(commitment, challenge, proof); !errors.Is(err, storageproofs.ErrChallengeMismatch) {
+		t.Errorf("Expected ErrChallengeMismatch, got %v", err)
+	}
+}
+
+func Test__Verify__ShouldReject__WhenSignedByAnotherProver(t *testing.T) {
+	// Arrange
+	id := generateIdentity(t)
+	other := generateIdentity(t)
+	chunks, _ := storageproofs.Split(randomBytes(t, 8*1024), 1024)
+	commitment := storageproofs.Commit("content", chunks)
+	challenge := storageproofs.NewChallenge(commitment, id.GetTag(), 1, []byte("previous"))
+
+	// Act
+	proof, err := storageproofs.Prove(other, challenge, chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if err := storageproofs.Verify(commitment, challenge, proof); !errors.Is(err, storageproofs.ErrChallengeMismatch) {
+		t.Errorf("Expected ErrChallengeMismatch, got %v", err)
+	}
+}
+
+func Test__NewChallenge__ShouldBeDeterministic__AndDistinct(t *testing.T) {
+	// Arrange
+	commitment := storageproofs.ContentCommitment{ContentId: "content", Chunks: 100}
+
+	// Act
+	first := storageproofs.NewChallenge(commitment, "prover", 5, []byte("previous"))
+	second := storageproofs.NewChallenge(commitment, "prover", 5, []byte("previous"))
+	small := storageproofs.NewChallenge(storageproofs.ContentCommitment{ContentId: "content", Chunks: 2}, "prover", 5, nil)
+
+	// Assert
+	if len(first.Indexes) != storageproofs.ChallengeSize {
+		t.Fatalf("Expected %d indexes, got %d", storageproofs.ChallengeSize, len(first.Indexes))
+	}
+
+	seen := make(map[int64]bool)
+	for i, index := range first.Indexes {
+		if index != second.Indexes[i] {
+			t.Errorf("Expected challenges to be equal, got %v and %v", first.Indexes, second.Indexes)
+		}
+
+		if seen[index] {
+			t.Errorf("Expected distinct indexes, got %v", first.Indexes)
+		}
+
+		seen[index] = true
+	}
+
+	if len(small.Indexes) != 2 {
+		t.Errorf("Expected 2 indexes for a content with 2 chunks, got %v", small.Indexes)
+	}
+}
+
+func Test__ProveFor__ShouldAnswerNothing__WhenCommitmentIsNotInABlock(t *testing.T) {
+	// Arrange
+	id := generateIdentity(t)
+	prover := storageproofs.NewProver(id, storageproofs.NewRegistry(), localstorage.New(t.TempDir()))
+
+	if _, err := prover.Hold("content", randomBytes(t, 4096)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	proofs, err := prover.ProveFor(10, []byte("previous"))
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(proofs) != 0 {
+		t.Errorf("Expected no proofs, got %d", len(proofs))
+	}
+}
+
+func Test__Weigh__ShouldReduceStakes__WhenChallengesFail(t *testing.T) {
+	// Arrange
+	honest := generateIdentity(t)
+	dishonest := generateIdentity(t)
+	registry := storageproofs.NewRegistry()
+	honestProver := storageproofs.NewProver(honest, registry, localstorage.New(t.TempDir()))
+
+	commitment, err := honestProver.Hold("content", randomBytes(t, 8*4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyCommitment(t, registry, 1, honest, commitment)
+	applyCommitment(t, registry, 2, dishonest, commitment)
+
+	// Act
+	for index := uint64(3); index < 9; index += 2 {
+		proofs, err := honestProver.ProveFor(index, []byte("previous"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := registry.ApplyBlock(index, []byte("previous"), honest.GetTag(), nil, proofs); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := registry.ApplyBlock(index+1, []byte("previous"), dishonest.GetTag(), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Assert
+	// Three passed challenges out of three: full stakes, plus three quarters of the bonus
+	if weight := registry.Weigh(honest.GetTag(), 100); weight != 137 {
+		t.Errorf("Expected honest weight to be 137, got %d", weight)
+	}
+
+	if weight := registry.Weigh(dishonest.GetTag(), 100); weight != 25 {
+		t.Errorf("Expected dishonest weight to be 25, got %d", weight)
+	}
+
+	if weight := registry.Weigh("unassigned", 100); weight != 100 {
+		t.Errorf("Expected unassigned weight to be 100, got %d", weight)
+	}
+}
+
+func Test__Restore__ShouldWeighAlike__WhenRestoredFromState(t *testing.T) {
+	// Arrange
+	id := generateIdentity(t)
+	registry := storageproofs.NewRegistry()
+	prover := storageproofs.NewProver(id, registry, localstorage.New(t.TempDir()))
+
+	commitment, err := prover.Hold("content", randomBytes(t, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyCommitment(t, registry, 1, id, commitment)
+
+	proofs, err := prover.ProveFor(2, []byte("previous"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.ApplyBlock(2, []byte("previous"), id.GetTag(), nil, proofs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	restored := storageproofs.NewRegistry()
+	restored.Restore(registry.State(), 2)
+
+	// Assert
+	if restored.Weigh(id.GetTag(), 100) != registry.Weigh(id.GetTag(), 100) {
+		t.Errorf("Expected the restored registry to weigh %d, got %d", registry.Weigh(id.GetTag(), 100), restored.Weigh(id.GetTag(), 100))
+	}
+
+	if len(restored.GetAssigned(id.GetTag())) != 1 {
+		t.Errorf("Expected the assignment to be restored, got %v", restored.GetAssigned(id.GetTag()))
+	}
+
+	if err := restored.ApplyBlock(2, []byte("previous"), id.GetTag(), nil, proofs); !errors.Is(err, storageproofs.ErrBlockApplied) {
+		t.Errorf("Expected the blocks before the state to count as applied, got %v", err)
+	}
+}
+
+func Test__ApplyBlock__ShouldRecordOutcomesOnce__WhenBlockIsAppliedTwice(t *testing.T) {
+	// Arrange
+	id := generateIdentity(t)
+	registry := storageproofs.NewRegistry()
+	prover := storageproofs.NewProver(id, registry, localstorage.New(t.TempDir()))
+
+	commitment, err := prover.Hold("content", randomBytes(t, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyCommitment(t, registry, 1, id, commitment)
+
+	proofs, err := prover.ProveFor(2, []byte("previous"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	first := registry.ApplyBlock(2, []byte("previous"), id.GetTag(), nil, append(proofs, proofs...))
+	second := registry.ApplyBlock(2, []byte("previous"), id.GetTag(), nil, proofs)
+
+	// Assert
+	if first != nil {
+		t.Fatalf("Expected block to be applied, got %v", first)
+	}
+
+	if !errors.Is(second, storageproofs.ErrBlockApplied) {
+		t.Errorf("Expected ErrBlockApplied, got %v", second)
+	}
+
+	if outcomes := registry.GetOutcomes(id.GetTag()); outcomes != (storageproofs.Outcomes{Passed: 1}) {
+		t.Errorf("Expected a single passed challenge, got %+v", outcomes)
+	}
+}
+
+func Test__ApplyBlock__ShouldIgnoreCommitment__WhenContentIsRegisteredWithAnotherRoot(t *testing.T) {
+	// Arrange
+	first := generateIdentity(t)
+	second := generateIdentity(t)
+	registry := storageproofs.NewRegistry()
+
+	chunks, _ := storageproofs.Split(randomBytes(t, 4096), 1024)
+	otherChunks, _ := storageproofs.Split(randomBytes(t, 4096), 1024)
+	applyCommitment(t, registry, 1, first, storageproofs.Commit("content", chunks))
+
+	// Act
+	applyCommitment(t, registry, 2, second, storageproofs.Commit("content", otherChunks))
+
+	// Assert
+	if assigned := registry.GetAssigned(second.GetTag()); len(assigned) != 0 {
+		t.Errorf("Expected no content assigned, got %v", assigned)
+	}
+}
+
+func generateIdentity(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	bs := make([]byte, size)
+	if _, err := rand.Read(bs); err != nil {
+		t.Fatal(err)
+	}
+
+	return bs
+}
+
+// applyCommitment applies a block, with the given index, whose only transaction commits holder to the content
+func applyCommitment(t *testing.T, registry *storageproofs.Registry, index uint64, holder identity.PrivateIdentity, commitment storageproofs.ContentCommitment) {
+	tx, err := storageproofs.NewCommitmentTransaction(holder, commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.ApplyBlock(index, []byte("previous"), holder.GetTag(), []transactions.Transaction{tx}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
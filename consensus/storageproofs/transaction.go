@@ -0,0 +1,51 @@
+package storageproofs
+
+import (
+	"errors"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+const KindStorageCommitment = "storage_commitment"
+
+var ErrNotCommitmentTransaction = errors.New("transaction is not a storage commitment transaction")
+
+// CommitmentUpdate is the content of a storage commitment transaction.
+// Once in a block, the source of the transaction must hold the content and answer its challenges.
+type CommitmentUpdate struct {
+	Kind       string // Always KindStorageCommitment, so other transactions are not mistaken for commitments
+	Commitment ContentCommitment
+}
+
+// NewCommitmentTransaction creates the transaction by which holder commits to hold the content, signed by holder.
+func NewCommitmentTransaction(holder identity.PrivateIdentity, commitment ContentCommitment) (*transactions.TransactionShape, error) {
+	content, err := encodings.Encode(CommitmentUpdate{Kind: KindStorageCommitment, Commitment: commitment})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signatures.Sign(holder, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transactions.TransactionShape{
+		SourceTag: holder.GetTag(),
+		Signature: signature,
+		Content:   content,
+	}, nil
+}
+
+// ParseCommitmentTransaction decodes the commitment of a storage commitment transaction.
+// Fails with ErrNotCommitmentTransaction for any other transaction.
+func ParseCommitmentTransaction(tx transactions.Transaction) (ContentCommitment, error) {
+	var update CommitmentUpdate
+	if err := encodings.Decode(tx.GetContent(), &update); err != nil || update.Kind != KindStorageCommitment {
+		return ContentCommitment{}, ErrNotCommitmentTransaction
+	}
+
+	return update.Commitment, nil
+}
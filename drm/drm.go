@@ -0,0 +1,142 @@
+// Package drm defines the transactions of the content registry and of its licenses.
+// DRM transactions are regular transactions whose content is an encoded Payload.
+package drm
+
+import (
+	"errors"
+
+	"github.com/titosilva/drmchain-pos/drm/escrow"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+var ErrNotDRMTransaction = errors.New("transaction is not a DRM transaction")
+
+type Kind string
+
+const (
+	KindRegisterContent Kind = "register_content"
+	KindIssueLicense    Kind = "issue_license"
+	KindTransferLicense Kind = "transfer_license"
+	KindRevokeLicense   Kind = "revoke_license"
+	KindUseLicense      Kind = "use_license"
+)
+
+// Payload is the content of a DRM transaction. Body is the encoding of the structure matching Kind.
+type Payload struct {
+	Kind Kind
+	Body []byte
+}
+
+// RegisterContent registers a content item owned by the source of the transaction.
+type RegisterContent struct {
+	ContentId   string
+	ContentHash []byte
+	Metadata    []byte
+}
+
+// Terms limit how a license can be used. Zero values mean no limit.
+type Terms struct {
+	ExpiresAtHeight int64 // Last block index in which the license is valid
+	ExpiresAt       int64 // Last block timestamp, in unix seconds, in which the license is valid
+	MaxUses         int64
+}
+
+// IssueLicense grants a license for a content. Only the content owner can issue licenses.
+// The license id is the hash of the issuing transaction.
+// WrappedKey is the content key wrapped for the holder with the escrow package, and may be empty.
+type IssueLicense struct {
+	ContentId  string
+	HolderTag  string
+	WrappedKey []byte
+	Terms      Terms
+}
+
+// TransferLicense moves a license to another holder. Only the current holder can transfer it.
+// WrappedKey replaces the key of the license, wrapped for the new holder.
+type TransferLicense struct {
+	LicenseId    string
+	NewHolderTag string
+	WrappedKey   []byte
+}
+
+// RevokeLicense invalidates a license. Only the content owner can revoke it.
+type RevokeLicense struct {
+	LicenseId string
+}
+
+// UseLicense records the consumption of a license by its holder, e.g. one play.
+type UseLicense struct {
+	LicenseId string
+	Uses      int64
+}
+
+// NewTransaction creates a DRM transaction of the given kind, signed by id.
+func NewTransaction(id identity.PrivateIdentity, kind Kind, body any) (*transactions.TransactionShape, error) {
+	bodyBs, err := encodings.Encode(body)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := encodings.Encode(Payload{Kind: kind, Body: bodyBs})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signatures.Sign(id, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transactions.TransactionShape{
+		SourceTag: id.GetTag(),
+		Signature: signature,
+		Content:   content,
+	}, nil
+}
+
+// NewLicenseIssue creates a license for the holder, escrowing the content key for it.
+func NewLicenseIssue(owner identity.PrivateIdentity, contentId string, holderTag string, contentKey []byte, terms Terms) (*transactions.TransactionShape, error) {
+	wrapped, err := escrow.Wrap(holderTag, contentId, contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTransaction(owner, KindIssueLicense, IssueLicense{
+		ContentId:  contentId,
+		HolderTag:  holderTag,
+		WrappedKey: wrapped,
+		Terms:      terms,
+	})
+}
+
+// NewLicenseTransfer moves a license to the new holder, escrowing the content key for it.
+func NewLicenseTransfer(holder identity.PrivateIdentity, licenseId string, contentId string, newHolderTag string, contentKey []byte) (*transactions.TransactionShape, error) {
+	wrapped, err := escrow.Wrap(newHolderTag, contentId, contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTransaction(holder, KindTransferLicense, TransferLicense{
+		LicenseId:    licenseId,
+		NewHolderTag: newHolderTag,
+		WrappedKey:   wrapped,
+	})
+}
+
+// ParsePayload decodes the payload of a DRM transaction.
+// Fails with ErrNotDRMTransaction for any other transaction.
+func ParsePayload(tx transactions.Transaction) (Payload, error) {
+	payload, err := encodings.DecodeAs[Payload](tx.GetContent())
+	if err != nil || payload.Kind == "" {
+		return Payload{}, ErrNotDRMTransaction
+	}
+
+	return payload, nil
+}
+
+func DecodeBody[T any](payload Payload) (T, error) {
+	return encodings.DecodeAs[T](payload.Body)
+}
@@ -0,0 +1,39 @@
+package drmmiddlewares
+
+import (
+	"time"
+
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/drm/drmstate"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+// UsageValidatorMiddleware rejects license usages that the next block would not accept:
+// usages by someone other than the holder, of expired or revoked licenses, or beyond the usage limit.
+type UsageValidatorMiddleware struct {
+	history *history.BlockHistory
+}
+
+func NewUsageValidatorMiddleware(bh *history.BlockHistory) *UsageValidatorMiddleware {
+	return &UsageValidatorMiddleware{
+		history: bh,
+	}
+}
+
+// Next implements transactions.TransactionMiddleware.
+func (u *UsageValidatorMiddleware) Next(tran transactions.Transaction) (transactions.Transaction, error) {
+	next := drmstate.BlockContext{
+		Height:    u.history.GetLastIndex() + 1,
+		Timestamp: time.Now().Unix(),
+	}
+
+	if err := u.history.GetDRMState().CheckUse(tran, next); err != nil {
+		return nil, err
+	}
+
+	return tran, nil
+}
+
+// UsageValidatorMiddleware is an implementation of the TransactionMiddleware interface.
+// Static impl check
+var _ transactions.TransactionMiddleware = &UsageValidatorMiddleware{}
@@ -0,0 +1,446 @@
+package drmstate
+
+import (
+	"errors"
+	"iter"
+	"sort"
+	"sync"
+
+	"github.com/titosilva/drmchain-pos/drm"
+	"github.com/titosilva/drmchain-pos/drm/escrow"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+var (
+	ErrContentExists   = errors.New("content is already registered")
+	ErrUnknownContent  = errors.New("content is not registered")
+	ErrUnknownLicense  = errors.New("license does not exist")
+	ErrLicenseExists   = errors.New("license already exists")
+	ErrLicenseRevoked  = errors.New("license was revoked")
+	ErrNotContentOwner = errors.New("source is not the content owner")
+	ErrNotHolder       = errors.New("source does not hold the license")
+	ErrUnknownKind     = errors.New("unknown DRM transaction kind")
+	ErrLicenseExpired  = errors.New("license has expired")
+	ErrUsageExceeded   = errors.New("license usage limit exceeded")
+	ErrInvalidUsage    = errors.New("invalid license usage")
+	ErrInvalidSigner   = errors.New("transaction is not signed by its source")
+)
+
+// Used in Entitlement.RemainingUses when the license has no usage limit
+const UnlimitedUses = -1
+
+type Content struct {
+	ContentId   string
+	OwnerTag    string
+	ContentHash []byte
+	Metadata    []byte
+}
+
+type License struct {
+	LicenseId  string
+	ContentId  string
+	HolderTag  string
+	IssuerTag  string
+	Revoked    bool
+	WrappedKey []byte
+	Terms      drm.Terms
+	Used       int64
+}
+
+// BlockContext is the position in the chain at which transactions are applied.
+type BlockContext struct {
+	Height    uint64
+	Timestamp int64
+}
+
+// Entitlement is what the holder of a license can still do with it.
+type Entitlement struct {
+	LicenseId       string
+	ContentId       string
+	ExpiresAtHeight int64
+	ExpiresAt       int64
+	RemainingUses   int64
+}
+
+// IsExpiredAt tells if the license terms no longer allow it to be used at the given block.
+func (l License) IsExpiredAt(ctx BlockContext) bool {
+	if l.Terms.ExpiresAtHeight != 0 && int64(ctx.Height) > l.Terms.ExpiresAtHeight {
+		return true
+	}
+
+	return l.Terms.ExpiresAt != 0 && ctx.Timestamp > l.Terms.ExpiresAt
+}
+
+func (l License) GetRemainingUses() int64 {
+	if l.Terms.MaxUses == 0 {
+		return UnlimitedUses
+	}
+
+	return max(l.Terms.MaxUses-l.Used, 0)
+}
+
+func (l License) isValidAt(ctx BlockContext) bool {
+	return !l.Revoked && !l.IsExpiredAt(ctx)
+}
+
+// UnwrapKey recovers the content key escrowed in the license. Only the holder can do it.
+func (l License) UnwrapKey(self identity.PrivateIdentity) ([]byte, error) {
+	if l.HolderTag != self.GetTag() || len(l.WrappedKey) == 0 {
+		return nil, escrow.ErrCannotUnwrap
+	}
+
+	return escrow.Unwrap(self, l.ContentId, l.WrappedKey)
+}
+
+// State is the content registry and the licenses derived from the DRM transactions in the chain.
+type State struct {
+	contents *cmap.CMap[string, Content]
+	licenses *cmap.CMap[string, License]
+	current  BlockContext
+	mux      *sync.Mutex
+}
+
+func New() *State {
+	return &State{
+		contents: cmap.New[string, Content](),
+		licenses: cmap.New[string, License](),
+		mux:      &sync.Mutex{},
+	}
+}
+
+// Advance sets the block in which the next transactions are applied.
+func (s *State) Advance(ctx BlockContext) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.current = ctx
+}
+
+func (s *State) GetCurrentContext() BlockContext {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.current
+}
+
+// Apply updates the state with the transaction, in the block set by Advance.
+// Transactions that are not DRM transactions are ignored, invalid ones fail without changing the state.
+// The source tag is what authorizes each operation, so transactions not signed by it are invalid.
+func (s *State) Apply(tx transactions.Transaction) error {
+	payload, err := drm.ParsePayload(tx)
+	if err != nil {
+		return nil
+	}
+
+	if !tx.IsValidSignature() {
+		return ErrInvalidSigner
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch payload.Kind {
+	case drm.KindRegisterContent:
+		body, err := drm.DecodeBody[drm.RegisterContent](payload)
+		if err != nil {
+			return err
+		}
+
+		return s.register(tx.GetSourceTag(), body)
+	case drm.KindIssueLicense:
+		body, err := drm.DecodeBody[drm.IssueLicense](payload)
+		if err != nil {
+			return err
+		}
+
+		return s.issue(tx.GetSourceTag(), tx.GetHash(), body)
+	case drm.KindTransferLicense:
+		body, err := drm.DecodeBody[drm.TransferLicense](payload)
+		if err != nil {
+			return err
+		}
+
+		return s.transfer(tx.GetSourceTag(), body)
+	case drm.KindRevokeLicense:
+		body, err := drm.DecodeBody[drm.RevokeLicense](payload)
+		if err != nil {
+			return err
+		}
+
+		return s.revoke(tx.GetSourceTag(), body)
+	case drm.KindUseLicense:
+		body, err := drm.DecodeBody[drm.UseLicense](payload)
+		if err != nil {
+			return err
+		}
+
+		return s.use(tx.GetSourceTag(), body)
+	default:
+		return ErrUnknownKind
+	}
+}
+
+func (s *State) register(sourceTag string, body drm.RegisterContent) error {
+	if _, found := s.contents.Get(body.ContentId); found {
+		return ErrContentExists
+	}
+
+	s.contents.Set(body.ContentId, Content{
+		ContentId:   body.ContentId,
+		OwnerTag:    sourceTag,
+		ContentHash: body.ContentHash,
+		Metadata:    body.Metadata,
+	})
+
+	return nil
+}
+
+func (s *State) issue(sourceTag string, licenseId string, body drm.IssueLicense) error {
+	content, found := s.contents.Get(body.ContentId)
+	if !found {
+		return ErrUnknownContent
+	}
+
+	if content.OwnerTag != sourceTag {
+		return ErrNotContentOwner
+	}
+
+	if _, found := s.licenses.Get(licenseId); found {
+		return ErrLicenseExists
+	}
+
+	s.licenses.Set(licenseId, License{
+		LicenseId:  licenseId,
+		ContentId:  body.ContentId,
+		HolderTag:  body.HolderTag,
+		IssuerTag:  sourceTag,
+		WrappedKey: body.WrappedKey,
+		Terms:      body.Terms,
+	})
+
+	return nil
+}
+
+func (s *State) transfer(sourceTag string, body drm.TransferLicense) error {
+	license, err := s.heldLicense(sourceTag, body.LicenseId, s.current)
+	if err != nil {
+		return err
+	}
+
+	license.HolderTag = body.NewHolderTag
+	license.WrappedKey = body.WrappedKey
+	s.licenses.Set(license.LicenseId, license)
+	return nil
+}
+
+func (s *State) revoke(sourceTag string, body drm.RevokeLicense) error {
+	license, err := s.activeLicense(body.LicenseId)
+	if err != nil {
+		return err
+	}
+
+	content, _ := s.contents.Get(license.ContentId)
+	if content.OwnerTag != sourceTag {
+		return ErrNotContentOwner
+	}
+
+	license.Revoked = true
+	s.licenses.Set(license.LicenseId, license)
+	return nil
+}
+
+func (s *State) use(sourceTag string, body drm.UseLicense) error {
+	license, err := s.checkUse(sourceTag, body, s.current)
+	if err != nil {
+		return err
+	}
+
+	license.Used += body.Uses
+	s.licenses.Set(license.LicenseId, license)
+	return nil
+}
+
+// CheckUse validates a usage transaction against the state as if it was applied in the given block.
+// The state is not changed.
+func (s *State) CheckUse(tx transactions.Transaction, ctx BlockContext) error {
+	payload, err := drm.ParsePayload(tx)
+	if err != nil || payload.Kind != drm.KindUseLicense {
+		return nil
+	}
+
+	body, err := drm.DecodeBody[drm.UseLicense](payload)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	_, err = s.checkUse(tx.GetSourceTag(), body, ctx)
+	return err
+}
+
+func (s *State) checkUse(sourceTag string, body drm.UseLicense, ctx BlockContext) (License, error) {
+	if body.Uses <= 0 {
+		return License{}, ErrInvalidUsage
+	}
+
+	license, err := s.heldLicense(sourceTag, body.LicenseId, ctx)
+	if err != nil {
+		return License{}, err
+	}
+
+	if license.Terms.MaxUses != 0 && license.Used+body.Uses > license.Terms.MaxUses {
+		return License{}, ErrUsageExceeded
+	}
+
+	return license, nil
+}
+
+// heldLicense returns the license if it is active, not expired and held by sourceTag.
+func (s *State) heldLicense(sourceTag string, licenseId string, ctx BlockContext) (License, error) {
+	license, err := s.activeLicense(licenseId)
+	if err != nil {
+		return License{}, err
+	}
+
+	if license.HolderTag != sourceTag {
+		return License{}, ErrNotHolder
+	}
+
+	if license.IsExpiredAt(ctx) {
+		return License{}, ErrLicenseExpired
+	}
+
+	return license, nil
+}
+
+func (s *State) activeLicense(licenseId string) (License, error) {
+	license, found := s.licenses.Get(licenseId)
+	if !found {
+		return License{}, ErrUnknownLicense
+	}
+
+	if license.Revoked {
+		return License{}, ErrLicenseRevoked
+	}
+
+	return license, nil
+}
+
+func (s *State) GetContent(contentId string) (Content, bool) {
+	return s.contents.Get(contentId)
+}
+
+func (s *State) GetLicense(licenseId string) (License, bool) {
+	return s.licenses.Get(licenseId)
+}
+
+// HoldersOf returns the tags holding a valid license for the content, sorted.
+// Revoked and expired licenses are not valid.
+func (s *State) HoldersOf(contentId string) []string {
+	seen := make(map[string]bool)
+	holders := make([]string, 0)
+	current := s.GetCurrentContext()
+
+	for kv := range s.licenses.All() {
+		license := kv.Value
+		if license.ContentId != contentId || !license.isValidAt(current) || seen[license.HolderTag] {
+			continue
+		}
+
+		seen[license.HolderTag] = true
+		holders = append(holders, license.HolderTag)
+	}
+
+	sort.Strings(holders)
+	return holders
+}
+
+// LicensesOf returns the valid licenses held by the tag.
+func (s *State) LicensesOf(holderTag string) []License {
+	licenses := make([]License, 0)
+	current := s.GetCurrentContext()
+
+	for kv := range s.licenses.All() {
+		if kv.Value.HolderTag == holderTag && kv.Value.isValidAt(current) {
+			licenses = append(licenses, kv.Value)
+		}
+	}
+
+	sort.Slice(licenses, func(i, j int) bool { return licenses[i].LicenseId < licenses[j].LicenseId })
+	return licenses
+}
+
+// Entitlements returns what the tag can still do with each of its valid licenses.
+// Licenses without remaining uses are left out.
+func (s *State) Entitlements(holderTag string) []Entitlement {
+	entitlements := make([]Entitlement, 0)
+
+	for _, license := range s.LicensesOf(holderTag) {
+		remaining := license.GetRemainingUses()
+		if remaining == 0 {
+			continue
+		}
+
+		entitlements = append(entitlements, Entitlement{
+			LicenseId:       license.LicenseId,
+			ContentId:       license.ContentId,
+			ExpiresAtHeight: license.Terms.ExpiresAtHeight,
+			ExpiresAt:       license.Terms.ExpiresAt,
+			RemainingUses:   remaining,
+		})
+	}
+
+	return entitlements
+}
+
+func (s *State) HasLicense(contentId string, holderTag string) bool {
+	for _, license := range s.LicensesOf(holderTag) {
+		if license.ContentId == contentId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *State) AllContents() iter.Seq[Content] {
+	return func(yield func(Content) bool) {
+		for kv := range s.contents.All() {
+			if !yield(kv.Value) {
+				return
+			}
+		}
+	}
+}
+
+func (s *State) AllLicenses() iter.Seq[License] {
+	return func(yield func(License) bool) {
+		for kv := range s.licenses.All() {
+			if !yield(kv.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Restore replaces the state with the given contents and licenses, as of the given block.
+func (s *State) Restore(ctx BlockContext, contents []Content, licenses []License) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.current = ctx
+
+	s.contents = cmap.New[string, Content]()
+	for _, content := range contents {
+		s.contents.Set(content.ContentId, content)
+	}
+
+	s.licenses = cmap.New[string, License]()
+	for _, license := range licenses {
+		s.licenses.Set(license.LicenseId, license)
+	}
+}
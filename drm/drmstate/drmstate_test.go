@@ -0,0 +1,270 @@
+package drmstate_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/blocks/blocksconfig"
+	"github.com/titosilva/drmchain-pos/blocks/blockstore"
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/drm"
+	"github.com/titosilva/drmchain-pos/drm/drmmiddlewares"
+	"github.com/titosilva/drmchain-pos/drm/drmstate"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func Test__HoldersOf__ShouldReturnLicensees__WhenBlocksAreAppended(t *testing.T) {
+	// Arrange
+	owner, alice, bob := generateIdentity(t), generateIdentity(t), generateIdentity(t)
+	bh := history.New(blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0))
+
+	register := newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie", ContentHash: []byte{1}})
+	issueAlice := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag()})
+	issueBob := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: bob.GetTag()})
+
+	// Act
+	appendBlock(t, bh, 1, register, issueAlice)
+	appendBlock(t, bh, 2, issueBob)
+
+	// Assert
+	holders := bh.GetDRMState().HoldersOf("movie")
+	expected := []string{alice.GetTag(), bob.GetTag()}
+	slices.Sort(expected)
+
+	if !slices.Equal(holders, expected) {
+		t.Errorf("Expected holders %v, got %v", expected, holders)
+	}
+}
+
+func Test__Apply__ShouldMoveLicense__WhenHolderTransfers(t *testing.T) {
+	// Arrange
+	owner, alice, bob := generateIdentity(t), generateIdentity(t), generateIdentity(t)
+	state := drmstate.New()
+	apply(t, state, newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"}))
+
+	issue := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag()})
+	apply(t, state, issue)
+
+	// Act
+	err := state.Apply(newTransaction(t, alice, drm.KindTransferLicense, drm.TransferLicense{LicenseId: issue.GetHash(), NewHolderTag: bob.GetTag()}))
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.HasLicense("movie", alice.GetTag()) {
+		t.Error("Expected alice to no longer hold a license")
+	}
+
+	if !state.HasLicense("movie", bob.GetTag()) {
+		t.Error("Expected bob to hold a license")
+	}
+}
+
+func Test__Apply__ShouldRemoveHolder__WhenOwnerRevokes(t *testing.T) {
+	// Arrange
+	owner, alice := generateIdentity(t), generateIdentity(t)
+	state := drmstate.New()
+	apply(t, state, newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"}))
+
+	issue := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag()})
+	apply(t, state, issue)
+
+	// Act
+	err := state.Apply(newTransaction(t, owner, drm.KindRevokeLicense, drm.RevokeLicense{LicenseId: issue.GetHash()}))
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.HoldersOf("movie")) != 0 {
+		t.Errorf("Expected no holders, got %v", state.HoldersOf("movie"))
+	}
+
+	transfer := newTransaction(t, alice, drm.KindTransferLicense, drm.TransferLicense{LicenseId: issue.GetHash(), NewHolderTag: owner.GetTag()})
+	if err := state.Apply(transfer); !errors.Is(err, drmstate.ErrLicenseRevoked) {
+		t.Errorf("Expected ErrLicenseRevoked, got %v", err)
+	}
+}
+
+func Test__Apply__ShouldFail__WhenSourceIsNotAllowed(t *testing.T) {
+	// Arrange
+	owner, mallory := generateIdentity(t), generateIdentity(t)
+	state := drmstate.New()
+	apply(t, state, newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"}))
+
+	issue := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: owner.GetTag()})
+	apply(t, state, issue)
+
+	cases := []struct {
+		tx       transactions.Transaction
+		expected error
+	}{
+		{newTransaction(t, mallory, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"}), drmstate.ErrContentExists},
+		{newTransaction(t, mallory, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: mallory.GetTag()}), drmstate.ErrNotContentOwner},
+		{newTransaction(t, mallory, drm.KindIssueLicense, drm.IssueLicense{ContentId: "other", HolderTag: mallory.GetTag()}), drmstate.ErrUnknownContent},
+		{newTransaction(t, mallory, drm.KindTransferLicense, drm.TransferLicense{LicenseId: issue.GetHash(), NewHolderTag: mallory.GetTag()}), drmstate.ErrNotHolder},
+		{newTransaction(t, mallory, drm.KindRevokeLicense, drm.RevokeLicense{LicenseId: issue.GetHash()}), drmstate.ErrNotContentOwner},
+		{newTransaction(t, mallory, drm.KindRevokeLicense, drm.RevokeLicense{LicenseId: "unknown"}), drmstate.ErrUnknownLicense},
+	}
+
+	for _, c := range cases {
+		// Act
+		err := state.Apply(c.tx)
+
+		// Assert
+		if !errors.Is(err, c.expected) {
+			t.Errorf("Expected %v, got %v", c.expected, err)
+		}
+	}
+
+	if !slices.Equal(state.HoldersOf("movie"), []string{owner.GetTag()}) {
+		t.Errorf("Expected state to be unchanged, got holders %v", state.HoldersOf("movie"))
+	}
+}
+
+func Test__Apply__ShouldFail__WhenSignatureIsForged(t *testing.T) {
+	// Arrange
+	owner, mallory := generateIdentity(t), generateIdentity(t)
+	state := drmstate.New()
+	apply(t, state, newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"}))
+
+	// Mallory signs the issuance but claims it comes from the owner
+	signed := newTransaction(t, mallory, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: mallory.GetTag()})
+	forged := &transactions.TransactionShape{SourceTag: owner.GetTag(), Signature: signed.GetSignature(), Content: signed.GetContent()}
+
+	// Act
+	err := state.Apply(forged)
+
+	// Assert
+	if !errors.Is(err, drmstate.ErrInvalidSigner) {
+		t.Errorf("Expected %v, got %v", drmstate.ErrInvalidSigner, err)
+	}
+
+	if state.HasLicense("movie", mallory.GetTag()) {
+		t.Error("Expected the forged license not to be issued")
+	}
+}
+
+func Test__Apply__ShouldIgnore__NonDRMTransactions(t *testing.T) {
+	// Arrange
+	state := drmstate.New()
+	tx := &transactions.TransactionShape{SourceTag: "tag", Content: []byte("Hello, world!")}
+
+	// Act
+	err := state.Apply(tx)
+
+	// Assert
+	if err != nil {
+		t.Errorf("Expected non DRM transaction to be ignored, got %v", err)
+	}
+}
+
+func Test__Apply__ShouldRejectUsage__WhenLicenseExpiredOrExhausted(t *testing.T) {
+	// Arrange
+	owner, alice := generateIdentity(t), generateIdentity(t)
+	bh := history.New(blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0))
+
+	register := newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"})
+	byHeight := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag(), Terms: drm.Terms{ExpiresAtHeight: 2}})
+	byTime := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag(), Terms: drm.Terms{ExpiresAt: 1000}})
+	byUses := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag(), Terms: drm.Terms{MaxUses: 2}})
+	appendBlock(t, bh, 1, register, byHeight, byTime, byUses)
+
+	use := func(license transactions.Transaction, uses int64) transactions.Transaction {
+		return newTransaction(t, alice, drm.KindUseLicense, drm.UseLicense{LicenseId: license.GetHash(), Uses: uses})
+	}
+
+	state := bh.GetDRMState()
+	cases := []struct {
+		tx       transactions.Transaction
+		ctx      drmstate.BlockContext
+		expected error
+	}{
+		{use(byHeight, 1), drmstate.BlockContext{Height: 2}, nil},
+		{use(byHeight, 1), drmstate.BlockContext{Height: 3}, drmstate.ErrLicenseExpired},
+		{use(byTime, 1), drmstate.BlockContext{Height: 2, Timestamp: 1000}, nil},
+		{use(byTime, 1), drmstate.BlockContext{Height: 2, Timestamp: 1001}, drmstate.ErrLicenseExpired},
+		{use(byUses, 2), drmstate.BlockContext{Height: 2}, nil},
+		{use(byUses, 3), drmstate.BlockContext{Height: 2}, drmstate.ErrUsageExceeded},
+		{use(byUses, 0), drmstate.BlockContext{Height: 2}, drmstate.ErrInvalidUsage},
+	}
+
+	for _, c := range cases {
+		// Act
+		err := state.CheckUse(c.tx, c.ctx)
+
+		// Assert
+		if !errors.Is(err, c.expected) {
+			t.Errorf("Expected %v, got %v", c.expected, err)
+		}
+	}
+}
+
+func Test__Entitlements__ShouldReturnRemainingUses__AfterUsageIsAppended(t *testing.T) {
+	// Arrange
+	owner, alice := generateIdentity(t), generateIdentity(t)
+	bh := history.New(blockstore.New(localstorage.New(t.TempDir()), blocksconfig.PruningArchive, 0))
+
+	register := newTransaction(t, owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"})
+	limited := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag(), Terms: drm.Terms{MaxUses: 3}})
+	expiring := newTransaction(t, owner, drm.KindIssueLicense, drm.IssueLicense{ContentId: "movie", HolderTag: alice.GetTag(), Terms: drm.Terms{ExpiresAtHeight: 1}})
+	appendBlock(t, bh, 1, register, limited, expiring)
+
+	// Act
+	appendBlock(t, bh, 2,
+		newTransaction(t, alice, drm.KindUseLicense, drm.UseLicense{LicenseId: limited.GetHash(), Uses: 1}),
+		newTransaction(t, alice, drm.KindUseLicense, drm.UseLicense{LicenseId: limited.GetHash(), Uses: 1}),
+	)
+
+	// Assert
+	entitlements := bh.GetDRMState().Entitlements(alice.GetTag())
+	if len(entitlements) != 1 {
+		t.Fatalf("Expected 1 entitlement, got %v", entitlements)
+	}
+
+	if entitlements[0].LicenseId != limited.GetHash() || entitlements[0].RemainingUses != 1 {
+		t.Errorf("Expected 1 remaining use of the limited license, got %v", entitlements[0])
+	}
+
+	validator := drmmiddlewares.NewUsageValidatorMiddleware(bh)
+	if _, err := validator.Next(newTransaction(t, alice, drm.KindUseLicense, drm.UseLicense{LicenseId: limited.GetHash(), Uses: 2})); !errors.Is(err, drmstate.ErrUsageExceeded) {
+		t.Errorf("Expected middleware to reject usage beyond the limit, got %v", err)
+	}
+}
+
+func generateIdentity(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func newTransaction(t *testing.T, id identity.PrivateIdentity, kind drm.Kind, body any) transactions.Transaction {
+	tx, err := drm.NewTransaction(id, kind, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func apply(t *testing.T, state *drmstate.State, tx transactions.Transaction) {
+	if err := state.Apply(tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendBlock(t *testing.T, bh *history.BlockHistory, index uint64, txs ...transactions.Transaction) {
+	if err := bh.Append(&blocks.Block{Index: index, Hash: []byte{byte(index)}, Transations: txs}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+// Package escrow wraps content keys so that only the holder of a license can recover them.
+//
+// A fresh ephemeral key is agreed with the holder identity key (ECIES-like): the shared secret
+// derives an AES-GCM key that encrypts the content key. The content id and the holder tag are
+// authenticated, so a wrapped key cannot be moved to another license.
+package escrow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/keyexchange"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"golang.org/x/crypto/hkdf"
+)
+
+var ErrCannotUnwrap = errors.New("content key cannot be unwrapped with this identity")
+
+const keyInfo = "drmchain license key"
+
+// WrappedKey is the content key encrypted for a single license holder.
+type WrappedKey struct {
+	EphemeralKey []byte
+	Nonce        []byte
+	Ciphertext   []byte
+}
+
+// Wrap encrypts the content key for the identity with the given tag.
+func Wrap(holderTag string, contentId string, contentKey []byte) ([]byte, error) {
+	holder, err := identity.FromTag(holderTag)
+	if err != nil {
+		return nil, err
+	}
+
+	ephKey, err := keyexchange.GenerateEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := keyexchange.DeriveFromPublicIdentity(holder, ephKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephPub := keyexchange.KeyToBytes(ephKey.PublicKey())
+	aead, err := newAEAD(secret, ephPub)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return encodings.Encode(WrappedKey{
+		EphemeralKey: ephPub,
+		Nonce:        nonce,
+		Ciphertext:   aead.Seal(nil, nonce, contentKey, additionalData(holderTag, contentId)),
+	})
+}
+
+// Unwrap recovers the content key wrapped for self.
+// Fails with ErrCannotUnwrap if the key was wrapped for someone else or for another content.
+func Unwrap(self identity.PrivateIdentity, contentId string, wrapped []byte) ([]byte, error) {
+	wrappedKey, err := encodings.DecodeAs[WrappedKey](wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	ephKey, err := keyexchange.BytesToKey(wrappedKey.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := keyexchange.DeriveFromPrivateIdentity(self, ephKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(secret, wrappedKey.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrappedKey.Nonce) != aead.NonceSize() {
+		return nil, ErrCannotUnwrap
+	}
+
+	contentKey, err := aead.Open(nil, wrappedKey.Nonce, wrappedKey.Ciphertext, additionalData(self.GetTag(), contentId))
+	if err != nil {
+		return nil, ErrCannotUnwrap
+	}
+
+	return contentKey, nil
+}
+
+func newAEAD(secret []byte, salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(keyInfo)), key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func additionalData(holderTag string, contentId string) []byte {
+	bs, _ := encodings.Encode(struct {
+		HolderTag string
+		ContentId string
+	}{holderTag, contentId})
+
+	return bs
+}
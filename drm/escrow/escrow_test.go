@@ -0,0 +1,128 @@
+package escrow_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/drm"
+	"github.com/titosilva/drmchain-pos/drm/drmstate"
+	"github.com/titosilva/drmchain-pos/drm/escrow"
+	"github.com/titosilva/drmchain-pos/identity"
+)
+
+var contentKey = []byte("0123456789abcdef0123456789abcdef")
+
+func Test__Unwrap__ShouldReturnContentKey__WhenCalledByHolder(t *testing.T) {
+	// Arrange
+	holder := generateIdentity(t)
+	wrapped, err := escrow.Wrap(holder.GetTag(), "movie", contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	key, err := escrow.Unwrap(holder, "movie", wrapped)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(key, contentKey) {
+		t.Error("Expected unwrapped key to be the content key")
+	}
+}
+
+func Test__Unwrap__ShouldFail__WhenCalledByThirdParty(t *testing.T) {
+	// Arrange
+	holder, thirdParty := generateIdentity(t), generateIdentity(t)
+	wrapped, err := escrow.Wrap(holder.GetTag(), "movie", contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	key, err := escrow.Unwrap(thirdParty, "movie", wrapped)
+
+	// Assert
+	if !errors.Is(err, escrow.ErrCannotUnwrap) {
+		t.Errorf("Expected ErrCannotUnwrap, got %v", err)
+	}
+
+	if key != nil {
+		t.Error("Expected no key to be returned")
+	}
+}
+
+func Test__Unwrap__ShouldFail__WhenKeyIsMovedToAnotherContent(t *testing.T) {
+	// Arrange
+	holder := generateIdentity(t)
+	wrapped, err := escrow.Wrap(holder.GetTag(), "movie", contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	_, err = escrow.Unwrap(holder, "other movie", wrapped)
+
+	// Assert
+	if !errors.Is(err, escrow.ErrCannotUnwrap) {
+		t.Errorf("Expected ErrCannotUnwrap, got %v", err)
+	}
+}
+
+func Test__UnwrapKey__ShouldFollowLicense__WhenLicenseIsTransferred(t *testing.T) {
+	// Arrange
+	owner, alice, bob := generateIdentity(t), generateIdentity(t), generateIdentity(t)
+	state := drmstate.New()
+
+	register, _ := drm.NewTransaction(owner, drm.KindRegisterContent, drm.RegisterContent{ContentId: "movie"})
+	issue, err := drm.NewLicenseIssue(owner, "movie", alice.GetTag(), contentKey, drm.Terms{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state.Apply(register)
+	state.Apply(issue)
+
+	license, _ := state.GetLicense(issue.GetHash())
+	aliceKey, err := license.UnwrapKey(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := drm.NewLicenseTransfer(alice, issue.GetHash(), "movie", bob.GetTag(), aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := state.Apply(transfer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	license, _ = state.GetLicense(issue.GetHash())
+	bobKey, err := license.UnwrapKey(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bobKey, contentKey) {
+		t.Error("Expected new holder to recover the content key")
+	}
+
+	if _, err := license.UnwrapKey(alice); !errors.Is(err, escrow.ErrCannotUnwrap) {
+		t.Errorf("Expected previous holder to fail with ErrCannotUnwrap, got %v", err)
+	}
+}
+
+func generateIdentity(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
@@ -4,4 +4,7 @@ go 1.23.3
 
 require golang.org/x/crypto v0.32.0
 
-require github.com/titosilva/pdpr-go v0.1.0 // indirect
+require (
+	github.com/titosilva/pdpr-go v0.1.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+)
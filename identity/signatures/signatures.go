@@ -5,14 +5,22 @@ import (
 	"crypto/rand"
 
 	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
 )
 
+// Verify checks a signature created by Sign.
 func Verify(id identity.PublicIdentity, data []byte, signature []byte) bool {
 	pubKey := id.GetPublicKey()
-	return ecdsa.VerifyASN1(pubKey, data, signature)
+	return ecdsa.VerifyASN1(pubKey, cryptutil.Hash(data), signature)
 }
 
+// Sign signs the SHA-256 of data. ECDSA only uses as many bytes of what it signs as the curve order has,
+// so signing data directly would leave everything after them unauthenticated: signed peer records,
+// transactions and blocks longer than that could be changed past their first bytes without notice.
+//
+// Signatures made before data was hashed do not verify anymore, nor do the new ones with older nodes.
+// Nodes must be upgraded together, and stored chains and peer records re-signed or synced again from scratch.
 func Sign(id identity.PrivateIdentity, data []byte) ([]byte, error) {
 	privKey := id.GetPrivateKey()
-	return ecdsa.SignASN1(rand.Reader, privKey, data)
+	return ecdsa.SignASN1(rand.Reader, privKey, cryptutil.Hash(data))
 }
@@ -60,3 +60,54 @@ func Test__Verify__ShouldReturnFalse__WhenSignatureWasCreatedWithDifferentKey(t
 		t.Fail()
 	}
 }
+
+func Test__Verify__ShouldReturnFalse__WhenDataChangesAfterTheFirstBytes(t *testing.T) {
+	// Arrange
+	id, err := identity.Generate()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	data := []byte("a prefix longer than the curve order of thirty two bytes, then the data")
+	signature, err := signatures.Sign(id, data)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+
+	// Act
+	accepted := signatures.Verify(id, tampered, signature)
+
+	// Assert
+	if accepted {
+		t.Fail()
+	}
+}
+
+func Test__Verify__ShouldReturnFalse__WhenDataChangesPastTheCurveOrderSize(t *testing.T) {
+	// Arrange
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 128)
+	signature, err := signatures.Sign(id, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-1] = 1
+
+	// Act
+	accepted := signatures.Verify(id, data, signature)
+
+	// Assert
+	if accepted {
+		t.Error("Expected the signature to cover the whole data")
+	}
+}
@@ -0,0 +1,294 @@
+package tunnel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+)
+
+// TopicTunnel carries frames for several protocols over a single tunnel.
+// Each frame is headed by its topic, and dispatched to the handlers subscribed to it.
+type TopicTunnel interface {
+	Send(topic string, data []byte) error
+	// Handle subscribes to the frames received on the topic
+	Handle(topic string) *observable.Subscription[[]byte]
+	// SubscribeErrors notifies the frames the peer sent back because it had no handler for them
+	SubscribeErrors() *observable.Subscription[TopicError]
+	GetMetrics() map[string]TopicMetrics
+	WaitClose() <-chan struct{}
+	Close() error
+}
+
+// TopicError is a frame sent back by the peer, naming its topic and why it was refused.
+type TopicError struct {
+	Topic   string
+	Message string
+}
+
+// TopicMetrics counts the frames of a topic.
+// Unhandled frames were received without a handler, and rejected frames were sent back by the peer.
+type TopicMetrics struct {
+	SentFrames     int64
+	SentBytes      int64
+	ReceivedFrames int64
+	ReceivedBytes  int64
+	Unhandled      int64
+	Rejected       int64
+}
+
+const (
+	frameKindData  byte = 0
+	frameKindError byte = 1
+
+	frameHeaderSize = 2
+	MaxTopicLength  = 255
+
+	// Past this many topics, frames of new topics are counted together under OtherTopics,
+	// so a peer sending random topics cannot grow the metrics without bound
+	maxTrackedTopics = 64
+	OtherTopics      = "*"
+)
+
+var (
+	ErrTopicTooLong   = errors.New("topic is longer than MaxTopicLength")
+	ErrMalformedFrame = errors.New("malformed topic frame")
+	ErrNoHandler      = errors.New("no handler for topic")
+)
+
+type topicCounters struct {
+	sentFrames     atomic.Int64
+	sentBytes      atomic.Int64
+	receivedFrames atomic.Int64
+	receivedBytes  atomic.Int64
+	unhandled      atomic.Int64
+	rejected       atomic.Int64
+}
+
+// Mux implements TopicTunnel over any DuplexTunnel.
+// A frame is a kind byte, the length of the topic in a byte, the topic and the data.
+// Error frames are never answered, so two peers cannot bounce frames back and forth.
+type Mux struct {
+	inner    DuplexTunnel
+	innerSub *observable.Subscription[[]byte]
+
+	handlers map[string]*observable.Observable[[]byte]
+	counters map[string]*topicCounters
+	closed   bool
+	mux      *sync.Mutex
+
+	errors *observable.Observable[TopicError]
+}
+
+// NewMux starts dispatching the frames received by the inner tunnel, until it is closed.
+func NewMux(inner DuplexTunnel) *Mux {
+	m := &Mux{
+		inner:    inner,
+		innerSub: inner.Subscribe(),
+		handlers: make(map[string]*observable.Observable[[]byte]),
+		counters: make(map[string]*topicCounters),
+		mux:      &sync.Mutex{},
+		errors:   observable.New[TopicError](),
+	}
+
+	go m.dispatchLoop()
+	return m
+}
+
+// Send implements TopicTunnel.
+func (m *Mux) Send(topic string, data []byte) error {
+	frame, err := encodeFrame(frameKindData, topic, data)
+	if err != nil {
+		return err
+	}
+
+	counters := m.getCounters(topic)
+	counters.sentFrames.Add(1)
+	counters.sentBytes.Add(int64(len(data)))
+
+	return m.inner.Send(frame)
+}
+
+// Handle implements TopicTunnel.
+// Handling a topic of a closed tunnel returns a closed subscription.
+func (m *Mux) Handle(topic string) *observable.Subscription[[]byte] {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.closed {
+		obs := observable.New[[]byte]()
+		sub := obs.Subscribe()
+		obs.Close()
+		return sub
+	}
+
+	handler, found := m.handlers[topic]
+	if !found {
+		handler = observable.New[[]byte]()
+		m.handlers[topic] = handler
+	}
+
+	return handler.Subscribe()
+}
+
+// SubscribeErrors implements TopicTunnel.
+func (m *Mux) SubscribeErrors() *observable.Subscription[TopicError] {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.closed {
+		obs := observable.New[TopicError]()
+		sub := obs.Subscribe()
+		obs.Close()
+		return sub
+	}
+
+	return m.errors.Subscribe()
+}
+
+// GetMetrics implements TopicTunnel.
+func (m *Mux) GetMetrics() map[string]TopicMetrics {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	metrics := make(map[string]TopicMetrics, len(m.counters))
+	for topic, counters := range m.counters {
+		metrics[topic] = TopicMetrics{
+			SentFrames:     counters.sentFrames.Load(),
+			SentBytes:      counters.sentBytes.Load(),
+			ReceivedFrames: counters.receivedFrames.Load(),
+			ReceivedBytes:  counters.receivedBytes.Load(),
+			Unhandled:      counters.unhandled.Load(),
+			Rejected:       counters.rejected.Load(),
+		}
+	}
+
+	return metrics
+}
+
+// WaitClose implements TopicTunnel.
+func (m *Mux) WaitClose() <-chan struct{} {
+	return m.inner.WaitClose()
+}
+
+// Close implements TopicTunnel.
+func (m *Mux) Close() error {
+	return m.inner.Close()
+}
+
+func (m *Mux) dispatchLoop() {
+	defer m.closeHandlers()
+	defer m.innerSub.Unsubscribe()
+
+	for {
+		select {
+		case data := <-m.innerSub.Channel():
+			m.dispatch(data)
+		case <-m.innerSub.WaitClose():
+			return
+		case <-m.inner.WaitClose():
+			return
+		}
+	}
+}
+
+func (m *Mux) dispatch(data []byte) {
+	kind, topic, payload, err := decodeFrame(data)
+	if err != nil {
+		return
+	}
+
+	counters := m.getCounters(topic)
+
+	if kind == frameKindError {
+		counters.rejected.Add(1)
+		m.errors.Notify(TopicError{Topic: topic, Message: string(payload)})
+		return
+	}
+
+	counters.receivedFrames.Add(1)
+	counters.receivedBytes.Add(int64(len(payload)))
+
+	m.mux.Lock()
+	handler, found := m.handlers[topic]
+	m.mux.Unlock()
+
+	if !found || handler.SubscriberCount() == 0 {
+		counters.unhandled.Add(1)
+		m.refuse(topic)
+		return
+	}
+
+	handler.Notify(payload)
+}
+
+func (m *Mux) refuse(topic string) {
+	frame, err := encodeFrame(frameKindError, topic, []byte(ErrNoHandler.Error()))
+	if err != nil {
+		return
+	}
+
+	go m.inner.Send(frame)
+}
+
+func (m *Mux) getCounters(topic string) *topicCounters {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	counters, found := m.counters[topic]
+	if !found && len(m.counters) >= maxTrackedTopics {
+		topic = OtherTopics
+		counters, found = m.counters[topic]
+	}
+
+	if !found {
+		counters = &topicCounters{}
+		m.counters[topic] = counters
+	}
+
+	return counters
+}
+
+func (m *Mux) closeHandlers() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.closed = true
+	for _, handler := range m.handlers {
+		handler.Close()
+	}
+
+	m.errors.Close()
+}
+
+func encodeFrame(kind byte, topic string, data []byte) ([]byte, error) {
+	if len(topic) > MaxTopicLength {
+		return nil, ErrTopicTooLong
+	}
+
+	frame := make([]byte, 0, frameHeaderSize+len(topic)+len(data))
+	frame = append(frame, kind, byte(len(topic)))
+	frame = append(frame, topic...)
+	frame = append(frame, data...)
+
+	return frame, nil
+}
+
+func decodeFrame(frame []byte) (byte, string, []byte, error) {
+	if len(frame) < frameHeaderSize {
+		return 0, "", nil, ErrMalformedFrame
+	}
+
+	kind := frame[0]
+	topicLength := int(frame[1])
+	if (kind != frameKindData && kind != frameKindError) || len(frame) < frameHeaderSize+topicLength {
+		return 0, "", nil, ErrMalformedFrame
+	}
+
+	topic := string(frame[frameHeaderSize : frameHeaderSize+topicLength])
+	return kind, topic, frame[frameHeaderSize+topicLength:], nil
+}
+
+// Static impl check
+var _ TopicTunnel = (*Mux)(nil)
@@ -0,0 +1,149 @@
+package tunnel_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+)
+
+// pipeEnd is one end of an in-memory tunnel: what it sends is received by the other end.
+type pipeEnd struct {
+	received *observable.Observable[[]byte]
+	peer     *pipeEnd
+	done     chan struct{}
+	once     *sync.Once
+}
+
+func newPipe() (*pipeEnd, *pipeEnd) {
+	done := make(chan struct{})
+	once := &sync.Once{}
+	a := &pipeEnd{received: observable.New[[]byte](), done: done, once: once}
+	b := &pipeEnd{received: observable.New[[]byte](), done: done, once: once}
+	a.peer, b.peer = b, a
+
+	return a, b
+}
+
+func (p *pipeEnd) Send(data []byte) error {
+	p.peer.received.Notify(data)
+	return nil
+}
+
+func (p *pipeEnd) Subscribe() *observable.Subscription[[]byte] {
+	return p.received.Subscribe()
+}
+
+func (p *pipeEnd) WaitClose() <-chan struct{} {
+	return p.done
+}
+
+func (p *pipeEnd) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+func Test__Mux__ShouldDispatchFramesByTopic__WhenHandlersAreRegistered(t *testing.T) {
+	// Arrange
+	a, b := newPipe()
+	muxA, muxB := tunnel.NewMux(a), tunnel.NewMux(b)
+	defer muxA.Close()
+
+	first := muxB.Handle("first")
+	defer first.Unsubscribe()
+	second := muxB.Handle("second")
+	defer second.Unsubscribe()
+
+	// Act
+	muxA.Send("second", []byte("to second"))
+	muxA.Send("first", []byte("to first"))
+
+	// Assert
+	if data, ok := first.WaitNextWithTimeoutMs(500); !ok || string(data) != "to first" {
+		t.Fatalf("expected frame on first topic, got %q", data)
+	}
+
+	if data, ok := second.WaitNextWithTimeoutMs(500); !ok || string(data) != "to second" {
+		t.Fatalf("expected frame on second topic, got %q", data)
+	}
+}
+
+func Test__Mux__ShouldSendFrameBackWithError__WhenTopicHasNoHandler(t *testing.T) {
+	// Arrange
+	a, b := newPipe()
+	muxA, muxB := tunnel.NewMux(a), tunnel.NewMux(b)
+	defer muxA.Close()
+
+	errs := muxA.SubscribeErrors()
+	defer errs.Unsubscribe()
+
+	// Act
+	muxA.Send("unknown", []byte("data"))
+
+	// Assert
+	topicErr, ok := errs.WaitNextWithTimeoutMs(500)
+	if !ok {
+		t.Fatal("expected the frame to be sent back")
+	}
+
+	if topicErr.Topic != "unknown" || topicErr.Message != tunnel.ErrNoHandler.Error() {
+		t.Fatalf("unexpected error frame: %+v", topicErr)
+	}
+
+	if muxA.GetMetrics()["unknown"].Rejected != 1 {
+		t.Fatalf("expected one rejected frame, got %+v", muxA.GetMetrics()["unknown"])
+	}
+
+	if muxB.GetMetrics()["unknown"].Unhandled != 1 {
+		t.Fatalf("expected one unhandled frame, got %+v", muxB.GetMetrics()["unknown"])
+	}
+}
+
+func Test__Mux__ShouldCountFramesAndBytesPerTopic__WhenSending(t *testing.T) {
+	// Arrange
+	a, b := newPipe()
+	muxA, muxB := tunnel.NewMux(a), tunnel.NewMux(b)
+	defer muxA.Close()
+
+	sub := muxB.Handle("topic")
+	defer sub.Unsubscribe()
+
+	// Act
+	muxA.Send("topic", []byte("abc"))
+	muxA.Send("topic", []byte("de"))
+	sub.WaitNextWithTimeoutMs(500)
+	sub.WaitNextWithTimeoutMs(500)
+
+	// Assert
+	sent := muxA.GetMetrics()["topic"]
+	if sent.SentFrames != 2 || sent.SentBytes != 5 {
+		t.Fatalf("unexpected sent metrics: %+v", sent)
+	}
+
+	received := muxB.GetMetrics()["topic"]
+	if received.ReceivedFrames != 2 || received.ReceivedBytes != 5 || received.Unhandled != 0 {
+		t.Fatalf("unexpected received metrics: %+v", received)
+	}
+}
+
+func Test__Mux__ShouldCloseHandlers__WhenTunnelCloses(t *testing.T) {
+	// Arrange
+	a, _ := newPipe()
+	mux := tunnel.NewMux(a)
+	sub := mux.Handle("topic")
+
+	// Act
+	mux.Close()
+
+	// Assert
+	if _, ok := sub.WaitNextWithTimeoutMs(500); ok {
+		t.Fatal("expected no frame from a closed tunnel")
+	}
+
+	select {
+	case <-sub.WaitClose():
+	default:
+		t.Fatal("expected the handler to be closed")
+	}
+}
@@ -45,7 +45,7 @@ func (mc *Observable[T]) Subscribe() *Subscription[T] {
 		observable:    mc,
 		channel:       make(chan T, mc.bufferSize),
 		closed:        false,
-		closedChannel: make(chan struct{}, mc.bufferSize),
+		closedChannel: make(chan struct{}),
 		closedMux:     new(sync.Mutex),
 	}
 
@@ -53,6 +53,8 @@ func (mc *Observable[T]) Subscribe() *Subscription[T] {
 	return s
 }
 
+// Notify sends t to every subscription. It waits while the buffer of a subscription is full,
+// until it is read or the subscription is closed.
 func (mc *Observable[T]) Notify(t T) {
 	mc.closedMux.Lock()
 	defer mc.closedMux.Unlock()
@@ -60,11 +62,20 @@ func (mc *Observable[T]) Notify(t T) {
 		return
 	}
 
+	// A subscription closed meanwhile is skipped, even if its buffer is full
 	for s := range mc.subscribers.All() {
-		s.channel <- t
+		select {
+		case s.channel <- t:
+		case <-s.closedChannel:
+		}
 	}
 }
 
+// SubscriberCount returns how many subscriptions are open.
+func (mc *Observable[T]) SubscriberCount() int {
+	return mc.subscribers.Count()
+}
+
 func (mc *Observable[T]) unsubscribe(s *Subscription[T]) {
 	mc.subscribers.Remove(s)
 }
@@ -99,14 +110,26 @@ func (s *Subscription[T]) WaitNextWIthTimeout(timeout time.Duration) (T, bool) {
 
 func (s *Subscription[T]) WaitNextWithCancellation(cancellation context.Context, cancel context.CancelFunc) (T, bool) {
 	defer cancel()
+
+	// Messages received before closing are still delivered
 	select {
 	case t := <-s.channel:
 		return t, true
+	default:
+	}
+
+	select {
+	case t := <-s.channel:
+		return t, true
+	case <-s.closedChannel:
+		return *new(T), false
 	case <-cancellation.Done():
 		return *new(T), false
 	}
 }
 
+// Channel returns the channel of the notifications. It is never closed, so receivers must also
+// select on WaitClose to know when the subscription ends.
 func (s *Subscription[T]) Channel() <-chan T {
 	return s.channel
 }
@@ -119,6 +142,8 @@ func (s *Subscription[T]) WaitClose() <-chan struct{} {
 	return s.closedChannel
 }
 
+// Close ends the subscription, closing the channel returned by WaitClose.
+// Notifications already in the buffer can still be read.
 func (s *Subscription[T]) Close() {
 	s.closedMux.Lock()
 	defer s.closedMux.Unlock()
@@ -126,8 +151,7 @@ func (s *Subscription[T]) Close() {
 		return
 	}
 
-	close(s.channel)
-	s.closedChannel <- struct{}{}
+	// The channel itself is not closed, as Notify may be sending to it
 	close(s.closedChannel)
 	s.closed = true
 }
@@ -0,0 +1,117 @@
+package observable_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+)
+
+func Test__Notify__ShouldNotBlock__WhenSubscriptionIsClosedWithAFullBuffer(t *testing.T) {
+	// Arrange
+	obs := observable.New[int]()
+	sub := obs.Subscribe()
+
+	notified := make(chan struct{})
+	go func() {
+		for i := range 1000 {
+			obs.Notify(i)
+		}
+		close(notified)
+	}()
+
+	// Act
+	sub.Close()
+
+	// Assert
+	<-notified
+}
+
+func Test__Notify__ShouldDeliver__WhileOtherSubscriptionsCome(t *testing.T) {
+	// Arrange
+	obs := observable.New[int]()
+	sub := obs.Subscribe()
+	defer sub.Close()
+
+	wg := &sync.WaitGroup{}
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other := obs.Subscribe()
+			other.Unsubscribe()
+		}()
+	}
+
+	// Act
+	obs.Notify(42)
+	wg.Wait()
+
+	// Assert
+	if v, ok := sub.WaitNextWithTimeoutMs(1000); !ok || v != 42 {
+		t.Fatalf("Expected 42, got %d", v)
+	}
+}
+
+func Test__WaitNext__ShouldReturnPendingMessages__WhenSubscriptionIsClosed(t *testing.T) {
+	// Arrange
+	obs := observable.New[int]()
+	sub := obs.Subscribe()
+	obs.Notify(1)
+
+	// Act
+	sub.Close()
+	first, firstOk := sub.WaitNextWithTimeoutMs(1000)
+	_, secondOk := sub.WaitNextWithTimeoutMs(1000)
+
+	// Assert
+	if !firstOk || first != 1 {
+		t.Fatalf("Expected the pending message, got %d", first)
+	}
+
+	if secondOk {
+		t.Fatal("Expected no more messages after closing")
+	}
+}
+
+func Test__Close__ShouldSignalWaitClose__WithoutClosingTheChannel(t *testing.T) {
+	// Arrange
+	obs := observable.New[int]()
+	sub := obs.Subscribe()
+
+	// Act
+	sub.Close()
+
+	// Assert
+	select {
+	case <-sub.WaitClose():
+	default:
+		t.Fatal("Expected WaitClose to be closed")
+	}
+
+	select {
+	case _, ok := <-sub.Channel():
+		t.Fatalf("Expected the channel to stay open and empty, got a receive with ok %v", ok)
+	default:
+	}
+}
+
+func Test__SubscriberCount__ShouldCountOpenSubscriptions(t *testing.T) {
+	// Arrange
+	obs := observable.New[int]()
+	first := obs.Subscribe()
+	obs.Subscribe()
+
+	// Act
+	first.Unsubscribe()
+
+	// Assert
+	if count := obs.SubscriberCount(); count != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", count)
+	}
+
+	obs.Close()
+	if count := obs.SubscriberCount(); count != 0 {
+		t.Errorf("Expected no subscribers after closing, got %d", count)
+	}
+}
@@ -1,17 +1,19 @@
 package uuid
 
 import (
-	"os/exec"
-	"strings"
+	"crypto/rand"
+	"fmt"
 )
 
-func NewUuid() string { // Only works on Linux! TODO: add support for windows (or don't haha)
-	uuid, err := exec.Command("uuidgen").Output()
-
-	if err != nil {
+// NewUuid returns a random (version 4) uuid, or an empty string if the system randomness fails.
+func NewUuid() string {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
 		return ""
 	}
 
-	str := string(uuid)
-	return strings.Replace(str, "\n", "", -1)
+	bs[6] = (bs[6] & 0x0f) | 0x40 // version 4
+	bs[8] = (bs[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bs[0:4], bs[4:6], bs[6:8], bs[8:10], bs[10:])
 }
@@ -0,0 +1,195 @@
+package allowlist
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+// Allowlist holds the tags allowed in a permissioned network. It starts with the Allowlist of the config,
+// and changes live with the validator set transactions of ValidatorSetAdmins.
+// When the network is not Permissioned, every tag is allowed.
+type Allowlist struct {
+	permissioned bool
+	admins       []string
+	initial      []string
+
+	tags     map[string]bool
+	sequence int64 // Of the last validator set update applied
+	mux      *sync.Mutex
+
+	removed *observable.Observable[string]
+}
+
+// State is what the validator set transactions of the chain made of the allowlist.
+// A zero Sequence means no update was applied, and the allowlist is the one of the config.
+type State struct {
+	Tags     []string
+	Sequence int64
+}
+
+func Factory(diCtx *di.DIContext) *Allowlist {
+	config := networkconfig.GetFromDI(diCtx)
+	return New(config.Permissioned, config.Allowlist, config.ValidatorSetAdmins)
+}
+
+func GetFromDI(diCtx *di.DIContext) *Allowlist {
+	return di.GetService[Allowlist](diCtx)
+}
+
+func New(permissioned bool, tags []string, admins []string) *Allowlist {
+	a := &Allowlist{
+		permissioned: permissioned,
+		admins:       admins,
+		initial:      slices.Clone(tags),
+		tags:         make(map[string]bool, len(tags)),
+		mux:          &sync.Mutex{},
+		removed:      observable.New[string](),
+	}
+
+	for _, tag := range tags {
+		a.tags[tag] = true
+	}
+
+	return a
+}
+
+func (a *Allowlist) IsPermissioned() bool {
+	return a.permissioned
+}
+
+func (a *Allowlist) IsAllowed(tag string) bool {
+	if !a.permissioned {
+		return true
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.tags[tag]
+}
+
+func (a *Allowlist) Add(tags ...string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, tag := range tags {
+		a.tags[tag] = true
+	}
+}
+
+// Remove disallows the tags, and notifies the subscribers of SubscribeRemovals of the ones that were allowed.
+func (a *Allowlist) Remove(tags ...string) {
+	removed := make([]string, 0, len(tags))
+
+	a.mux.Lock()
+	for _, tag := range tags {
+		if a.tags[tag] {
+			delete(a.tags, tag)
+			removed = append(removed, tag)
+		}
+	}
+	a.mux.Unlock()
+
+	if !a.permissioned {
+		return
+	}
+
+	for _, tag := range removed {
+		a.removed.Notify(tag)
+	}
+}
+
+// SubscribeRemovals notifies the tags removed from now on, so their peers can be disconnected.
+func (a *Allowlist) SubscribeRemovals() *observable.Subscription[string] {
+	return a.removed.Subscribe()
+}
+
+// Apply updates the allowlist with a validator set transaction.
+// Fails with ErrNotValidatorSetTransaction for other transactions, ErrNotAdmin for updates not signed by an admin,
+// and ErrStaleUpdate for updates whose sequence is not greater than the one of the last update applied.
+func (a *Allowlist) Apply(tx transactions.Transaction) error {
+	update, err := ParseValidatorSetUpdate(tx)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(a.admins, tx.GetSourceTag()) || !tx.IsValidSignature() {
+		return ErrNotAdmin
+	}
+
+	a.mux.Lock()
+	if update.Sequence <= a.sequence {
+		a.mux.Unlock()
+		return ErrStaleUpdate
+	}
+	a.sequence = update.Sequence
+	a.mux.Unlock()
+
+	a.Add(update.Add...)
+	a.Remove(update.Remove...)
+	return nil
+}
+
+// ApplyBlock applies the validator set transactions of the block, ignoring the invalid ones.
+func (a *Allowlist) ApplyBlock(block *blocks.Block) {
+	for _, tx := range block.Transations {
+		err := a.Apply(tx)
+		if err == ErrNotAdmin || err == ErrStaleUpdate {
+			log.Println("ignoring validator set transaction from ", tx.GetSourceTag(), ": ", err)
+		}
+	}
+}
+
+// State returns the allowed tags, sorted, with the sequence of the last update applied.
+func (a *Allowlist) State() State {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	state := State{Tags: make([]string, 0, len(a.tags)), Sequence: a.sequence}
+	for tag := range a.tags {
+		state.Tags = append(state.Tags, tag)
+	}
+	sort.Strings(state.Tags)
+
+	return state
+}
+
+// Restore replaces the allowed tags and the sequence with the given state, as of a block of the chain.
+// The tags that are no longer allowed are notified as removals.
+func (a *Allowlist) Restore(state State) {
+	tags := state.Tags
+	if state.Sequence == 0 {
+		tags = a.initial
+	}
+
+	a.mux.Lock()
+	removed := make([]string, 0)
+	for tag := range a.tags {
+		if !slices.Contains(tags, tag) {
+			removed = append(removed, tag)
+		}
+	}
+
+	a.tags = make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		a.tags[tag] = true
+	}
+	a.sequence = state.Sequence
+	a.mux.Unlock()
+
+	if !a.permissioned {
+		return
+	}
+
+	for _, tag := range removed {
+		a.removed.Notify(tag)
+	}
+}
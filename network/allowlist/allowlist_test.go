@@ -0,0 +1,182 @@
+package allowlist_test
+
+import (
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/blocks"
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+func generate(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func Test__IsAllowed__ShouldAllowEveryTag__WhenNotPermissioned(t *testing.T) {
+	// Arrange
+	list := allowlist.New(false, []string{}, []string{})
+
+	// Act
+	allowed := list.IsAllowed("anyone")
+
+	// Assert
+	if !allowed {
+		t.Fatal("expected every tag to be allowed")
+	}
+}
+
+func Test__Apply__ShouldUpdateTagsAndNotifyRemovals__WhenSignedByAdmin(t *testing.T) {
+	// Arrange
+	admin := generate(t)
+	list := allowlist.New(true, []string{"old"}, []string{admin.GetTag()})
+	removals := list.SubscribeRemovals()
+	defer removals.Unsubscribe()
+
+	tx, err := allowlist.NewValidatorSetTransaction(admin, 1, []string{"new"}, []string{"old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	err = list.Apply(tx)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("expected the update to be applied, got %v", err)
+	}
+
+	if !list.IsAllowed("new") || list.IsAllowed("old") {
+		t.Fatal("expected new to be allowed and old to be removed")
+	}
+
+	if tag, ok := removals.WaitNextWithTimeoutMs(500); !ok || tag != "old" {
+		t.Fatalf("expected the removal of old to be notified, got %q", tag)
+	}
+}
+
+func Test__Apply__ShouldRefuseUpdate__WhenNotSignedByAdmin(t *testing.T) {
+	// Arrange
+	admin, other := generate(t), generate(t)
+	list := allowlist.New(true, []string{}, []string{admin.GetTag()})
+
+	tx, err := allowlist.NewValidatorSetTransaction(other, 1, []string{other.GetTag()}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	err = list.Apply(tx)
+
+	// Assert
+	if err != allowlist.ErrNotAdmin {
+		t.Fatalf("expected ErrNotAdmin, got %v", err)
+	}
+
+	if list.IsAllowed(other.GetTag()) {
+		t.Fatal("expected the update not to be applied")
+	}
+}
+
+func Test__Apply__ShouldRefuseReplayedUpdate__WhenANewerOneWasApplied(t *testing.T) {
+	// Arrange
+	admin := generate(t)
+	list := allowlist.New(true, []string{}, []string{admin.GetTag()})
+
+	add, err := allowlist.NewValidatorSetTransaction(admin, 1, []string{"validator"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remove, err := allowlist.NewValidatorSetTransaction(admin, 2, []string{}, []string{"validator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tx := range []*transactions.TransactionShape{add, remove} {
+		if err := list.Apply(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	err = list.Apply(add)
+
+	// Assert
+	if err != allowlist.ErrStaleUpdate {
+		t.Fatalf("expected ErrStaleUpdate, got %v", err)
+	}
+
+	if list.IsAllowed("validator") {
+		t.Fatal("expected the replayed update not to be applied")
+	}
+}
+
+func Test__ApplyBlock__ShouldApplyUpdates__WhenBlockHasThem(t *testing.T) {
+	// Arrange
+	admin := generate(t)
+	list := allowlist.New(true, []string{}, []string{admin.GetTag()})
+
+	tx, err := allowlist.NewValidatorSetTransaction(admin, 1, []string{"validator"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unrelated := &transactions.TransactionShape{SourceTag: admin.GetTag(), Content: []byte("not an update")}
+
+	// Act
+	list.ApplyBlock(&blocks.Block{Index: 1, Transations: []transactions.Transaction{unrelated, tx}})
+
+	// Assert
+	if !list.IsAllowed("validator") {
+		t.Fatal("expected the update in the block to be applied")
+	}
+}
+
+func Test__Restore__ShouldKeepSequence__WhenRestoredFromState(t *testing.T) {
+	// Arrange
+	admin := generate(t)
+	list := allowlist.New(true, []string{"initial"}, []string{admin.GetTag()})
+
+	add, err := allowlist.NewValidatorSetTransaction(admin, 1, []string{"validator"}, []string{"initial"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := list.Apply(add); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := allowlist.New(true, []string{"initial"}, []string{admin.GetTag()})
+
+	// Act
+	restored.Restore(list.State())
+
+	// Assert
+	if !restored.IsAllowed("validator") || restored.IsAllowed("initial") {
+		t.Fatal("expected the restored allowlist to have the applied update")
+	}
+
+	if err := restored.Apply(add); err != allowlist.ErrStaleUpdate {
+		t.Fatalf("expected the replayed update to be stale, got %v", err)
+	}
+}
+
+func Test__Restore__ShouldUseConfigTags__WhenNoUpdateWasApplied(t *testing.T) {
+	// Arrange
+	list := allowlist.New(true, []string{"initial"}, []string{})
+	list.Add("other")
+
+	// Act
+	list.Restore(allowlist.State{})
+
+	// Assert
+	if !list.IsAllowed("initial") || list.IsAllowed("other") {
+		t.Fatal("expected the allowlist of the config")
+	}
+}
@@ -0,0 +1,59 @@
+package allowlist
+
+import (
+	"errors"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/transactions"
+)
+
+const KindValidatorSet = "validator_set"
+
+var (
+	ErrNotValidatorSetTransaction = errors.New("transaction is not a validator set transaction")
+	ErrNotAdmin                   = errors.New("validator set transaction is not from an admin")
+	ErrStaleUpdate                = errors.New("validator set transaction is not newer than the last one applied")
+)
+
+// ValidatorSetUpdate is the content of a validator set transaction. It is applied by the allowlist
+// of every node, so the allowed tags follow the chain.
+// Sequence orders the updates of all admins: an update is only applied if its sequence is greater than
+// the one of the last update applied, so an old update cannot be replayed over a newer one.
+type ValidatorSetUpdate struct {
+	Kind     string // Always KindValidatorSet, so other transactions are not mistaken for updates
+	Sequence int64
+	Add      []string
+	Remove   []string
+}
+
+// NewValidatorSetTransaction creates the update of the validator set with the given sequence, signed by admin.
+func NewValidatorSetTransaction(admin identity.PrivateIdentity, sequence int64, add []string, remove []string) (*transactions.TransactionShape, error) {
+	content, err := encodings.Encode(ValidatorSetUpdate{Kind: KindValidatorSet, Sequence: sequence, Add: add, Remove: remove})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signatures.Sign(admin, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transactions.TransactionShape{
+		SourceTag: admin.GetTag(),
+		Signature: signature,
+		Content:   content,
+	}, nil
+}
+
+// ParseValidatorSetUpdate decodes the update of a validator set transaction.
+// Fails with ErrNotValidatorSetTransaction for any other transaction.
+func ParseValidatorSetUpdate(tx transactions.Transaction) (ValidatorSetUpdate, error) {
+	var update ValidatorSetUpdate
+	if err := encodings.Decode(tx.GetContent(), &update); err != nil || update.Kind != KindValidatorSet {
+		return ValidatorSetUpdate{}, ErrNotValidatorSetTransaction
+	}
+
+	return update, nil
+}
@@ -0,0 +1,211 @@
+package discovery
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/storage"
+)
+
+const (
+	addressBookKey = "addressbook"
+
+	// Records from the future are accepted up to this skew between clocks
+	maxClockSkew = 5 * time.Minute
+
+	// When full, the record signed longest ago is dropped to make room, unless we connected to its peer
+	MaxAddressBookSize = 1024
+)
+
+// AddressBook keeps the latest record of each known peer, persisted in a BlobStorage.
+// Changes are kept in memory until Save, so a flood of records does not write on every exchange.
+type AddressBook struct {
+	records   *cmap.CMap[string, network.PeerRecord]
+	connected *cmap.CMap[string, bool] // Peers we connected to in this run, whose records are never dropped for newcomers
+	storage   storage.BlobStorage
+	lifetime  time.Duration
+	dirty     bool
+	mux       *sync.Mutex // Orders the changes with the saves
+}
+
+type addressBookData struct {
+	Records []network.PeerRecord
+}
+
+func NewAddressBook(storage storage.BlobStorage, lifetime time.Duration) *AddressBook {
+	return &AddressBook{
+		records:   cmap.New[string, network.PeerRecord](),
+		connected: cmap.New[string, bool](),
+		storage:   storage,
+		lifetime:  lifetime,
+		mux:       &sync.Mutex{},
+	}
+}
+
+// Load reads the records saved by an earlier run. An address book never saved loads empty.
+func (a *AddressBook) Load() error {
+	exists, err := a.storage.Exists(addressBookKey)
+	if err != nil || !exists {
+		return err
+	}
+
+	bs, err := a.storage.Retrieve(addressBookKey)
+	if err != nil {
+		return err
+	}
+
+	var data addressBookData
+	if err := encodings.Decode(bs, &data); err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, record := range data.Records {
+		if a.isValid(record) {
+			a.records.Set(record.Tag, record)
+		}
+	}
+
+	return nil
+}
+
+// Add keeps the records that are valid and newer than the known ones, and returns how many were kept.
+// When the book is full and holds only records of connected peers, new peers are not kept.
+func (a *AddressBook) Add(records ...network.PeerRecord) int {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	added := 0
+	for _, record := range records {
+		if !a.isValid(record) {
+			continue
+		}
+
+		known, found := a.records.Get(record.Tag)
+		if found && known.Timestamp >= record.Timestamp {
+			continue
+		}
+
+		if !found && a.records.Count() >= MaxAddressBookSize && !a.dropOldest() {
+			continue
+		}
+
+		a.records.Set(record.Tag, record)
+		added++
+	}
+
+	if added > 0 {
+		a.dirty = true
+	}
+
+	return added
+}
+
+// MarkConnected keeps the record of the peer when the book is full, since we know it is reachable.
+func (a *AddressBook) MarkConnected(tag string) {
+	a.connected.Set(tag, true)
+}
+
+// Save writes the records, if they changed since the last save.
+func (a *AddressBook) Save() error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if !a.dirty {
+		return nil
+	}
+
+	if err := a.save(); err != nil {
+		return err
+	}
+
+	a.dirty = false
+	return nil
+}
+
+func (a *AddressBook) Get(tag string) (network.PeerRecord, bool) {
+	record, found := a.records.Get(tag)
+	if !found || a.isExpired(record) {
+		return network.PeerRecord{}, false
+	}
+
+	return record, true
+}
+
+// All returns the records not expired, in random order.
+func (a *AddressBook) All() []network.PeerRecord {
+	records := make([]network.PeerRecord, 0)
+	for entry := range a.records.All() {
+		if !a.isExpired(entry.Value) {
+			records = append(records, entry.Value)
+		}
+	}
+
+	rand.Shuffle(len(records), func(i, j int) {
+		records[i], records[j] = records[j], records[i]
+	})
+
+	return records
+}
+
+// Sample returns up to n random records.
+func (a *AddressBook) Sample(n int) []network.PeerRecord {
+	records := a.All()
+	return records[:min(n, len(records))]
+}
+
+func (a *AddressBook) isValid(record network.PeerRecord) bool {
+	if a.isExpired(record) || time.Unix(record.Timestamp, 0).After(time.Now().Add(maxClockSkew)) {
+		return false
+	}
+
+	return record.Verify() == nil
+}
+
+func (a *AddressBook) isExpired(record network.PeerRecord) bool {
+	return time.Since(time.Unix(record.Timestamp, 0)) > a.lifetime
+}
+
+// dropOldest drops the oldest record of a peer we did not connect to, and tells if there was one.
+// It must be called with mux held.
+func (a *AddressBook) dropOldest() bool {
+	var oldest *network.PeerRecord
+	for entry := range a.records.All() {
+		if _, connected := a.connected.Get(entry.Key); connected {
+			continue
+		}
+
+		if oldest == nil || entry.Value.Timestamp < oldest.Timestamp {
+			record := entry.Value
+			oldest = &record
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	a.records.Delete(oldest.Tag)
+	return true
+}
+
+// save must be called with mux held
+func (a *AddressBook) save() error {
+	data := addressBookData{Records: make([]network.PeerRecord, 0)}
+	for entry := range a.records.All() {
+		data.Records = append(data.Records, entry.Value)
+	}
+
+	bs, err := encodings.Encode(data)
+	if err != nil {
+		return err
+	}
+
+	return a.storage.Store(addressBookKey, bs)
+}
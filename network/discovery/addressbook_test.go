@@ -0,0 +1,171 @@
+package discovery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/discovery"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+)
+
+func Test__AddressBook__ShouldRejectRecord__WhenSignatureIsForged(t *testing.T) {
+	// Arrange
+	book := discovery.NewAddressBook(localstorage.New(t.TempDir()), time.Hour)
+	record := newRecord(t, "localhost:3000")
+	record.Addr = "localhost:3001"
+
+	// Act
+	added := book.Add(record)
+
+	// Assert
+	if added != 0 {
+		t.Fatal("Expected record with a forged address to be rejected")
+	}
+}
+
+func Test__AddressBook__ShouldKeepNewestRecord__WhenPeerIsAddedTwice(t *testing.T) {
+	// Arrange
+	book := discovery.NewAddressBook(localstorage.New(t.TempDir()), time.Hour)
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	older, _ := network.NewPeerRecord(id, "localhost:3000")
+	older.Timestamp -= 10
+	older = resign(t, id, older)
+	newer, _ := network.NewPeerRecord(id, "localhost:3001")
+
+	// Act
+	book.Add(newer)
+	book.Add(older)
+
+	// Assert
+	record, found := book.Get(id.GetTag())
+	if !found || record.Addr != "localhost:3001" {
+		t.Fatalf("Expected the newest record, got %v", record)
+	}
+}
+
+func Test__AddressBook__ShouldRejectRecord__WhenExpired(t *testing.T) {
+	// Arrange
+	book := discovery.NewAddressBook(localstorage.New(t.TempDir()), time.Hour)
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, _ := network.NewPeerRecord(id, "localhost:3000")
+	record.Timestamp -= int64((2 * time.Hour).Seconds())
+	record = resign(t, id, record)
+
+	// Act
+	added := book.Add(record)
+
+	// Assert
+	if added != 0 {
+		t.Fatal("Expected expired record to be rejected")
+	}
+}
+
+func Test__AddressBook__ShouldLoadRecords__WhenSavedByAnotherInstance(t *testing.T) {
+	// Arrange
+	storage := localstorage.New(t.TempDir())
+	record := newRecord(t, "localhost:3000")
+	saved := discovery.NewAddressBook(storage, time.Hour)
+	saved.Add(record)
+	if err := saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	book := discovery.NewAddressBook(storage, time.Hour)
+
+	// Act
+	err := book.Load()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := book.Get(record.Tag); !found {
+		t.Fatal("Expected record to be loaded")
+	}
+}
+
+func Test__AddressBook__ShouldKeepConnectedPeers__WhenFullOfNewcomers(t *testing.T) {
+	// Arrange
+	book := discovery.NewAddressBook(localstorage.New(t.TempDir()), time.Hour)
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected, _ := network.NewPeerRecord(id, "localhost:3000")
+	connected.Timestamp -= 10
+	connected = resign(t, id, connected)
+	book.Add(connected)
+	book.MarkConnected(connected.Tag)
+
+	for i := 1; i < discovery.MaxAddressBookSize; i++ {
+		book.Add(newRecord(t, "localhost:3001"))
+	}
+
+	// Act
+	book.Add(newRecord(t, "localhost:3002"))
+
+	// Assert
+	if _, found := book.Get(connected.Tag); !found {
+		t.Error("Expected the record of the connected peer to be kept")
+	}
+
+	if count := len(book.All()); count != discovery.MaxAddressBookSize {
+		t.Errorf("Expected the book to stay at %d records, got %d", discovery.MaxAddressBookSize, count)
+	}
+}
+
+func Test__AddressBook__ShouldNotWrite__UntilSaved(t *testing.T) {
+	// Arrange
+	storage := localstorage.New(t.TempDir())
+	book := discovery.NewAddressBook(storage, time.Hour)
+	record := newRecord(t, "localhost:3000")
+
+	// Act
+	book.Add(record)
+
+	// Assert
+	loaded := discovery.NewAddressBook(storage, time.Hour)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := loaded.Get(record.Tag); found {
+		t.Error("Expected the record not to be written before Save")
+	}
+}
+
+func newRecord(t *testing.T, addr string) network.PeerRecord {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := network.NewPeerRecord(id, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return record
+}
+
+// resign signs the record again after its timestamp was changed
+func resign(t *testing.T, id identity.PrivateIdentity, record network.PeerRecord) network.PeerRecord {
+	signed, err := network.SignPeerRecord(id, record.Addr, time.Unix(record.Timestamp, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
@@ -0,0 +1,29 @@
+package discovery
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/network"
+)
+
+var ErrInvalidBootstrap = errors.New("bootstrap peer must be in the tag@host:port format")
+
+// parseBootstrapPeer reads a peer from the configuration. The record is not signed, so it is never shared.
+func parseBootstrapPeer(peer string) (network.PeerRecord, error) {
+	tag, addr, found := strings.Cut(peer, "@")
+	if !found || tag == "" {
+		return network.PeerRecord{}, ErrInvalidBootstrap
+	}
+
+	if _, err := identity.FromTag(tag); err != nil {
+		return network.PeerRecord{}, err
+	}
+
+	if _, err := network.ParseAddress(addr); err != nil {
+		return network.PeerRecord{}, err
+	}
+
+	return network.PeerRecord{Tag: tag, Addr: addr}, nil
+}
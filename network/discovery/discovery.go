@@ -0,0 +1,299 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/patterns/longtask"
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cbag"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/clru"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/storage"
+)
+
+const (
+	// Records sent in each exchange, besides our own
+	maxExchangedRecords = 32
+
+	// A peer that could not be connected to is not tried again before this cooldown
+	failedPeerCooldown  = time.Minute
+	failedPeersCapacity = 1024
+)
+
+// Discovery finds peers and keeps TargetOutboundPeers outbound connections open.
+// It starts from the bootstrap peers in the configuration, and learns about other peers
+// from the signed records its connections share, which are kept in the address book.
+type Discovery struct {
+	net           *network.Network
+	self          identity.PrivateIdentity
+	book          *AddressBook
+	configuration *networkconfig.NetworkConfig
+	bootstrap     []network.PeerRecord
+	failedPeers   *clru.Cache[string, time.Time]
+	maintaining   *atomic.Bool // Set while connecting to peers, so connections are not attempted twice
+
+	listenTask *longtask.LongTask[any]
+	tunnelSubs *cbag.CBag[*observable.Subscription[[]byte]]
+}
+
+func Factory(diCtx *di.DIContext) *Discovery {
+	net := di.GetService[network.Network](diCtx)
+	config := networkconfig.GetFromDI(diCtx)
+	blobStorage := storage.GetFromDI(diCtx)
+	self, _ := identityprovider.GetFromDI(diCtx).GetIdentity()
+
+	bootstrap := make([]network.PeerRecord, 0, len(config.BootstrapPeers))
+	for _, peer := range config.BootstrapPeers {
+		record, err := parseBootstrapPeer(peer)
+		if err != nil {
+			log.Println("ignoring bootstrap peer ", peer, ": ", err)
+			continue
+		}
+
+		bootstrap = append(bootstrap, record)
+	}
+
+	return &Discovery{
+		net:           net,
+		self:          self,
+		book:          NewAddressBook(blobStorage, config.PeerRecordLifetime),
+		configuration: config,
+		bootstrap:     bootstrap,
+		failedPeers:   clru.New[string, time.Time](failedPeersCapacity),
+		maintaining:   &atomic.Bool{},
+		tunnelSubs:    cbag.New[*observable.Subscription[[]byte]](),
+	}
+}
+
+func GetFromDI(diCtx *di.DIContext) *Discovery {
+	return di.GetService[Discovery](diCtx)
+}
+
+func (d *Discovery) GetAddressBook() *AddressBook {
+	return d.book
+}
+
+// Start loads the address book, exchanges peers with every connection and keeps the outbound connections at target.
+// The network must be open.
+func (d *Discovery) Start() error {
+	if err := d.book.Load(); err != nil {
+		return err
+	}
+
+	connectionsSub := d.net.GetConnections().Subscribe()
+
+	for conn := range d.net.GetConnections().Current().All() {
+		d.handleConnection(conn)
+	}
+
+	task := longtask.Run(func(cancellation context.Context) any {
+		log.Println("Starting peer discovery.")
+
+		ticker := time.NewTicker(d.configuration.DiscoveryInterval)
+		defer ticker.Stop()
+
+		d.startMaintainingOutbound()
+		for {
+			select {
+			case conn := <-connectionsSub.Channel():
+				d.handleConnection(conn)
+			case <-ticker.C:
+				d.exchangeWithRandomPeer()
+				d.startMaintainingOutbound()
+				d.saveAddressBook()
+			case <-connectionsSub.WaitClose():
+				log.Println("Network closed. Stopping peer discovery.")
+				d.Stop()
+				return true
+			case <-cancellation.Done():
+				log.Println("Peer discovery cancelled. Stopping peer discovery.")
+				return false
+			}
+		}
+	}).Finally(func() {
+		connectionsSub.Unsubscribe()
+	})
+
+	d.listenTask = task
+	go d.listenTask.Await()
+	return nil
+}
+
+func (d *Discovery) Stop() {
+	log.Println("Stopping peer discovery.")
+	if d.listenTask != nil {
+		d.listenTask.Cancel()
+	}
+
+	for sub := range d.tunnelSubs.All() {
+		sub.Unsubscribe()
+	}
+
+	d.saveAddressBook()
+}
+
+func (d *Discovery) saveAddressBook() {
+	if err := d.book.Save(); err != nil {
+		log.Println("failed to save address book: ", err)
+	}
+}
+
+func (d *Discovery) handleConnection(conn network.Connection) {
+	d.book.MarkConnected(conn.GetPeer().Id.GetTag())
+
+	tunnel := conn.GetTunnel()
+	go d.listenTunnel(tunnel)
+	d.sendRecords(tunnel, true)
+}
+
+func (d *Discovery) listenTunnel(tunnel tunnel.TopicTunnel) {
+	tunnelSub := tunnel.Handle(PeerExchangeTopic)
+	defer tunnelSub.Unsubscribe()
+
+	d.tunnelSubs.Add(tunnelSub)
+	defer d.tunnelSubs.Remove(tunnelSub)
+
+	for {
+		select {
+		case data := <-tunnelSub.Channel():
+			d.handleMessage(tunnel, data)
+		case <-tunnelSub.WaitClose():
+			return
+		}
+	}
+}
+
+func (d *Discovery) handleMessage(tunnel tunnel.TopicTunnel, data []byte) {
+	var msg PeerExchangeMessage
+	if err := encodings.Decode(data, &msg); err != nil {
+		return
+	}
+
+	// Honest peers send a sample of their book and their own record, anything past that is not verified
+	if len(msg.Records) > maxExchangedRecords+1 {
+		msg.Records = msg.Records[:maxExchangedRecords+1]
+	}
+
+	records := make([]network.PeerRecord, 0, len(msg.Records))
+	for _, record := range msg.Records {
+		if record.Tag != d.self.GetTag() {
+			records = append(records, record)
+		}
+	}
+
+	d.book.Add(records...)
+
+	if msg.Request {
+		d.sendRecords(tunnel, false)
+	}
+}
+
+// sendRecords sends our own record, freshly signed with the address peers can reach us at, and a sample of the address book.
+func (d *Discovery) sendRecords(tunnel tunnel.TopicTunnel, request bool) {
+	selfRecord, err := network.NewPeerRecord(d.self, d.net.GetConnections().AdvertisedAddress())
+	if err != nil {
+		log.Println("failed to sign peer record: ", err)
+		return
+	}
+
+	msg := PeerExchangeMessage{
+		Request: request,
+		Records: append(d.book.Sample(maxExchangedRecords), selfRecord),
+	}
+
+	data, err := encodings.Encode(msg)
+	if err != nil {
+		log.Println("failed to encode peer exchange: ", err)
+		return
+	}
+
+	go tunnel.Send(PeerExchangeTopic, data)
+}
+
+func (d *Discovery) exchangeWithRandomPeer() {
+	conns := make([]network.Connection, 0)
+	for conn := range d.net.GetConnections().Current().All() {
+		conns = append(conns, conn)
+	}
+
+	if len(conns) == 0 {
+		return
+	}
+
+	d.sendRecords(conns[rand.IntN(len(conns))].GetTunnel(), true)
+}
+
+// startMaintainingOutbound runs maintainOutbound in the background, unless it is still running.
+// Connecting can take as long as the handshake timeouts, which would hold up the exchanges.
+func (d *Discovery) startMaintainingOutbound() {
+	if !d.maintaining.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer d.maintaining.Store(false)
+		d.maintainOutbound()
+	}()
+}
+
+// maintainOutbound connects to known peers, bootstrap peers first, until the target is reached.
+// Peers that fail are skipped for a while.
+func (d *Discovery) maintainOutbound() {
+	outbound := d.net.GetConnections().Outbound().Count()
+	if outbound >= d.configuration.TargetOutboundPeers {
+		return
+	}
+
+	connected := map[string]bool{d.self.GetTag(): true}
+	for conn := range d.net.GetConnections().Current().All() {
+		connected[conn.GetPeer().Id.GetTag()] = true
+	}
+
+	candidates := append(append([]network.PeerRecord{}, d.bootstrap...), d.book.All()...)
+	for _, candidate := range candidates {
+		if outbound >= d.configuration.TargetOutboundPeers {
+			return
+		}
+
+		if connected[candidate.Tag] {
+			continue
+		}
+
+		if failedAt, failed := d.failedPeers.Get(candidate.Tag); failed && time.Since(failedAt) < failedPeerCooldown {
+			continue
+		}
+
+		if err := d.connect(candidate); err != nil {
+			log.Println("failed to connect to discovered peer ", candidate.Addr, ": ", err)
+			d.failedPeers.Put(candidate.Tag, time.Now())
+			continue
+		}
+
+		connected[candidate.Tag] = true
+		outbound++
+	}
+}
+
+func (d *Discovery) connect(record network.PeerRecord) error {
+	id, err := record.GetIdentity()
+	if err != nil {
+		return err
+	}
+
+	addr, err := record.GetAddress()
+	if err != nil {
+		return err
+	}
+
+	return d.net.GetConnections().ConnectTo(id, addr)
+}
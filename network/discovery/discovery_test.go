@@ -0,0 +1,85 @@
+package discovery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/discovery"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+)
+
+func Test__Discovery__ShouldConnectPeers__WhenTheyOnlyKnowTheBootstrapPeer(t *testing.T) {
+	// Arrange
+	nwA, _ := openNode(t, "localhost:2511", "localhost:2512")
+	bootstrap := nwA.GetSelf().GetTag() + "@localhost:2511"
+
+	nwB, discB := openNode(t, "localhost:2513", "localhost:2514", bootstrap)
+	nwC, discC := openNode(t, "localhost:2515", "localhost:2516", bootstrap)
+
+	// Act
+	connected := false
+	for start := time.Now(); time.Since(start) < 10*time.Second && !connected; time.Sleep(50 * time.Millisecond) {
+		connected = isConnected(nwB, nwC.GetSelf().GetTag()) && isConnected(nwC, nwB.GetSelf().GetTag())
+	}
+
+	// Assert
+	if !connected {
+		t.Fatal("Expected the peers to find each other through the bootstrap peer")
+	}
+
+	if _, found := discB.GetAddressBook().Get(nwC.GetSelf().GetTag()); !found {
+		t.Error("Expected the record of C in the address book of B")
+	}
+
+	if _, found := discC.GetAddressBook().Get(nwB.GetSelf().GetTag()); !found {
+		t.Error("Expected the record of B in the address book of C")
+	}
+}
+
+func isConnected(nw *network.Network, tag string) bool {
+	for conn := range nw.GetConnections().Current().All() {
+		if conn.GetPeer().Id.GetTag() == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// openNode opens a network with its own storage, so each node has its own identity
+func openNode(t *testing.T, handshakeHost string, gossipHost string, bootstrap ...string) (*network.Network, *discovery.Discovery) {
+	storageDir := t.TempDir()
+
+	diCtx := defaultdi.ConfigureDefaultDI()
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+	diCtx = networkdi.AddNetworkServices(diCtx)
+
+	config := networkconfig.GetFromDI(diCtx)
+	config.HandshakeHost = handshakeHost
+	config.GossipHost = gossipHost
+	config.BootstrapPeers = bootstrap
+	config.TargetOutboundPeers = 2
+	config.DiscoveryInterval = 100 * time.Millisecond
+
+	nw := di.GetService[network.Network](diCtx)
+	if err := nw.Open(); err != nil {
+		t.Fatalf("Error opening network on %s: %s", handshakeHost, err)
+	}
+	t.Cleanup(func() { nw.Close() })
+
+	disc := discovery.GetFromDI(diCtx)
+	if err := disc.Start(); err != nil {
+		t.Fatalf("Error starting discovery on %s: %s", handshakeHost, err)
+	}
+	t.Cleanup(disc.Stop)
+
+	return nw, disc
+}
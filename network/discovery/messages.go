@@ -0,0 +1,13 @@
+package discovery
+
+import "github.com/titosilva/drmchain-pos/network"
+
+// PeerExchangeTopic is the tunnel topic of peer exchanges.
+const PeerExchangeTopic = "peer-exchange"
+
+// PeerExchangeMessage shares peer records through a tunnel.
+// A request asks the receiver to answer with records it knows.
+type PeerExchangeMessage struct {
+	Request bool
+	Records []network.PeerRecord
+}
@@ -0,0 +1,26 @@
+package gossiprouter
+
+import (
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
+)
+
+// GossipTopic is the tunnel topic of gossip messages, which carry the gossip topic in turn.
+const GossipTopic = "gossip"
+
+// GossipMessage carries a payload published on a topic.
+// Each hop decrements Ttl, and the message is not forwarded once it reaches zero.
+type GossipMessage struct {
+	Topic   string
+	Ttl     int
+	Payload []byte
+}
+
+// MessageId identifies a payload within a topic, so the same bytes on different topics are different messages.
+func MessageId(topic string, payload []byte) string {
+	data := make([]byte, 0, len(topic)+1+len(payload))
+	data = append(data, topic...)
+	data = append(data, 0)
+	data = append(data, payload...)
+
+	return cryptutil.HashToString(data)
+}
@@ -0,0 +1,258 @@
+package gossiprouter
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/patterns/longtask"
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cbag"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+)
+
+// Validator tells whether a payload received on a topic, from the peer with the given tag,
+// is worth delivering and forwarding. Validators report the peers that send invalid payloads.
+type Validator func(fromTag string, payload []byte) bool
+
+// Delivery is a payload received on a topic, with the tag of the peer it came from.
+type Delivery struct {
+	FromTag string
+	Payload []byte
+}
+
+// Router spreads messages epidemically: each new message is sent to GossipFanout random connections,
+// which forward it the same way until GossipTTL hops are spent.
+// Messages seen within GossipSeenWindow are dropped, so they do not loop between peers.
+type Router struct {
+	net           *network.Network
+	configuration *networkconfig.NetworkConfig
+	reputation    *reputation.Reputation
+	seen          *SeenCache
+
+	topics     map[string]*observable.Observable[Delivery]
+	validators map[string]Validator
+	topicsMux  *sync.Mutex
+
+	startMux   *sync.Mutex
+	listenTask *longtask.LongTask[any]
+	tunnelSubs *cbag.CBag[*observable.Subscription[[]byte]]
+}
+
+func Factory(diCtx *di.DIContext) *Router {
+	net := di.GetService[network.Network](diCtx)
+	config := networkconfig.GetFromDI(diCtx)
+
+	return &Router{
+		net:           net,
+		configuration: config,
+		reputation:    reputation.GetFromDI(diCtx),
+		seen:          NewSeenCache(config.GossipSeenWindow),
+		topics:        make(map[string]*observable.Observable[Delivery]),
+		validators:    make(map[string]Validator),
+		topicsMux:     &sync.Mutex{},
+		startMux:      &sync.Mutex{},
+		tunnelSubs:    cbag.New[*observable.Subscription[[]byte]](),
+	}
+}
+
+func GetFromDI(diCtx *di.DIContext) *Router {
+	return di.GetService[Router](diCtx)
+}
+
+// Start listens to every connection of the network. Starting a running router does nothing.
+func (r *Router) Start() {
+	r.startMux.Lock()
+	defer r.startMux.Unlock()
+	if r.listenTask != nil {
+		return
+	}
+
+	connectionsSub := r.net.GetConnections().Subscribe()
+
+	for conn := range r.net.GetConnections().Current().All() {
+		r.listenConnection(conn)
+	}
+
+	task := longtask.Run(func(cancellation context.Context) any {
+		log.Println("Starting gossip router.")
+
+		for {
+			select {
+			case conn := <-connectionsSub.Channel():
+				r.listenConnection(conn)
+			case <-connectionsSub.WaitClose():
+				log.Println("Network closed. Stopping gossip router.")
+				r.Stop()
+				return true
+			case <-cancellation.Done():
+				log.Println("Gossip router cancelled. Stopping gossip router.")
+				return false
+			}
+		}
+	}).Finally(func() {
+		connectionsSub.Unsubscribe()
+	})
+
+	r.listenTask = task
+	go r.listenTask.Await()
+}
+
+func (r *Router) Stop() {
+	r.startMux.Lock()
+	defer r.startMux.Unlock()
+	if r.listenTask == nil {
+		return
+	}
+
+	log.Println("Stopping gossip router.")
+	r.listenTask.Cancel()
+	r.listenTask = nil
+
+	for sub := range r.tunnelSubs.All() {
+		sub.Unsubscribe()
+	}
+}
+
+// Subscribe receives the payloads published on the topic by other nodes.
+func (r *Router) Subscribe(topic string) *observable.Subscription[Delivery] {
+	return r.getTopic(topic).Subscribe()
+}
+
+// SetValidator makes the router drop payloads on the topic that the validator rejects.
+// Rejected payloads are neither delivered nor forwarded.
+func (r *Router) SetValidator(topic string, validator Validator) {
+	r.topicsMux.Lock()
+	defer r.topicsMux.Unlock()
+
+	r.validators[topic] = validator
+}
+
+// Publish sends the payload to GossipFanout random connections.
+// Publishing a payload already seen on the topic does nothing.
+func (r *Router) Publish(topic string, payload []byte) {
+	if !r.seen.MarkSeen(MessageId(topic, payload)) {
+		return
+	}
+
+	r.send(GossipMessage{
+		Topic:   topic,
+		Ttl:     r.configuration.GossipTTL,
+		Payload: payload,
+	}, nil)
+}
+
+func (r *Router) getTopic(topic string) *observable.Observable[Delivery] {
+	r.topicsMux.Lock()
+	defer r.topicsMux.Unlock()
+
+	obs, found := r.topics[topic]
+	if !found {
+		obs = observable.New[Delivery]()
+		r.topics[topic] = obs
+	}
+
+	return obs
+}
+
+func (r *Router) getValidator(topic string) (Validator, bool) {
+	r.topicsMux.Lock()
+	defer r.topicsMux.Unlock()
+
+	validator, found := r.validators[topic]
+	return validator, found
+}
+
+// listenConnection handles the gossip topic of the connection before returning, so no message sent
+// once the router started is refused by the connection for lack of a handler.
+func (r *Router) listenConnection(conn network.Connection) {
+	tunnelSub := conn.GetTunnel().Handle(GossipTopic)
+	r.tunnelSubs.Add(tunnelSub)
+
+	go r.receive(conn, tunnelSub)
+}
+
+func (r *Router) receive(conn network.Connection, tunnelSub *observable.Subscription[[]byte]) {
+	defer tunnelSub.Unsubscribe()
+	defer r.tunnelSubs.Remove(tunnelSub)
+
+	for {
+		select {
+		case data := <-tunnelSub.Channel():
+			r.handleMessage(conn, data)
+		case <-tunnelSub.WaitClose():
+			return
+		}
+	}
+}
+
+func (r *Router) handleMessage(source network.Connection, data []byte) {
+	fromTag := source.GetPeer().Id.GetTag()
+
+	var msg GossipMessage
+	if err := encodings.Decode(data, &msg); err != nil {
+		if err := r.reputation.Report(fromTag, reputation.MisbehaviorUndecodable); err != nil {
+			log.Println("failed to save ban of ", fromTag, ": ", err)
+		}
+		return
+	}
+
+	// The id is computed here rather than sent, so a peer cannot get a payload dropped by reusing its id
+	id := MessageId(msg.Topic, msg.Payload)
+	if r.seen.Contains(id) {
+		return
+	}
+
+	// Only valid payloads are marked seen, so a rejected copy does not drop a later one that is accepted
+	if validator, found := r.getValidator(msg.Topic); found && !validator(fromTag, msg.Payload) {
+		return
+	}
+
+	if !r.seen.MarkSeen(id) {
+		return
+	}
+
+	r.getTopic(msg.Topic).Notify(Delivery{FromTag: fromTag, Payload: msg.Payload})
+
+	// Peers cannot make a message travel further than our own messages do
+	msg.Ttl = min(msg.Ttl, r.configuration.GossipTTL) - 1
+	if msg.Ttl <= 0 {
+		return
+	}
+
+	r.send(msg, source.GetTunnel())
+}
+
+// send encodes the message once and sends it to GossipFanout random connections other than the source.
+func (r *Router) send(msg GossipMessage, source tunnel.TopicTunnel) {
+	data, err := encodings.Encode(msg)
+	if err != nil {
+		log.Println("failed to encode gossip message: ", err)
+		return
+	}
+
+	tunnels := make([]tunnel.TopicTunnel, 0)
+	for conn := range r.net.GetConnections().Current().All() {
+		if conn.GetTunnel() != source {
+			tunnels = append(tunnels, conn.GetTunnel())
+		}
+	}
+
+	rand.Shuffle(len(tunnels), func(i, j int) {
+		tunnels[i], tunnels[j] = tunnels[j], tunnels[i]
+	})
+
+	if len(tunnels) > r.configuration.GossipFanout {
+		tunnels = tunnels[:r.configuration.GossipFanout]
+	}
+
+	for _, tunnel := range tunnels {
+		go tunnel.Send(GossipTopic, data)
+	}
+}
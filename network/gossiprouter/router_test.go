@@ -0,0 +1,205 @@
+package gossiprouter_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/gossiprouter"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/networkdi"
+)
+
+const testTopic = "test"
+
+func Test__Publish__ShouldReachEveryNodeOnce__WhenNodesAreChained(t *testing.T) {
+	// Arrange
+	routers := openChain(t, 6, [][2]string{
+		{"localhost:2523", "localhost:2524"},
+		{"localhost:2525", "localhost:2526"},
+		{"localhost:2527", "localhost:2528"},
+	})
+
+	sub2 := routers[1].Subscribe(testTopic)
+	defer sub2.Unsubscribe()
+	sub3 := routers[2].Subscribe(testTopic)
+	defer sub3.Unsubscribe()
+
+	// Act
+	routers[0].Publish(testTopic, []byte("hello"))
+
+	// Assert
+	for i, sub := range []*observable.Subscription[gossiprouter.Delivery]{sub2, sub3} {
+		delivery, ok := sub.WaitNextWithTimeoutMs(1000)
+		if !ok {
+			t.Fatalf("node %d did not receive the message", i+2)
+		}
+
+		if string(delivery.Payload) != "hello" {
+			t.Fatalf("node %d received %q", i+2, delivery.Payload)
+		}
+	}
+
+	if _, ok := sub2.WaitNextWithTimeoutMs(500); ok {
+		t.Fatal("expected node 2 to receive the message only once")
+	}
+}
+
+func Test__Publish__ShouldStopForwarding__WhenTtlIsSpent(t *testing.T) {
+	// Arrange
+	routers := openChain(t, 1, [][2]string{
+		{"localhost:2529", "localhost:2530"},
+		{"localhost:2531", "localhost:2532"},
+		{"localhost:2533", "localhost:2534"},
+	})
+
+	sub2 := routers[1].Subscribe(testTopic)
+	defer sub2.Unsubscribe()
+	sub3 := routers[2].Subscribe(testTopic)
+	defer sub3.Unsubscribe()
+
+	// Act
+	routers[0].Publish(testTopic, []byte("hello"))
+
+	// Assert
+	if _, ok := sub2.WaitNextWithTimeoutMs(1000); !ok {
+		t.Fatal("expected the neighbour to receive the message")
+	}
+
+	if _, ok := sub3.WaitNextWithTimeoutMs(500); ok {
+		t.Fatal("expected the message not to be forwarded beyond its ttl")
+	}
+}
+
+func Test__Publish__ShouldDeliver__WhenAnEarlierCopyWasRejected(t *testing.T) {
+	// Arrange
+	routers := openChain(t, 6, [][2]string{
+		{"localhost:2551", "localhost:2552"},
+		{"localhost:2553", "localhost:2554"},
+		{"localhost:2555", "localhost:2556"},
+	})
+
+	accept := &atomic.Bool{}
+	routers[1].SetValidator(testTopic, func(string, []byte) bool { return accept.Load() })
+
+	sub2 := routers[1].Subscribe(testTopic)
+	defer sub2.Unsubscribe()
+
+	routers[0].Publish(testTopic, []byte("hello"))
+	if _, ok := sub2.WaitNextWithTimeoutMs(500); ok {
+		t.Fatal("expected the rejected copy not to be delivered")
+	}
+
+	// Act
+	accept.Store(true)
+	routers[2].Publish(testTopic, []byte("hello"))
+
+	// Assert
+	if _, ok := sub2.WaitNextWithTimeoutMs(1000); !ok {
+		t.Fatal("expected the copy accepted later to be delivered")
+	}
+}
+
+func Test__Publish__ShouldStopForwarding__WhenPeerSendsTtlAboveGossipTTL(t *testing.T) {
+	// Arrange
+	routers := openChainWithTtls(t, []int{6, 1, 1}, [][2]string{
+		{"localhost:2557", "localhost:2558"},
+		{"localhost:2559", "localhost:2560"},
+		{"localhost:2561", "localhost:2562"},
+	})
+
+	sub2 := routers[1].Subscribe(testTopic)
+	defer sub2.Unsubscribe()
+	sub3 := routers[2].Subscribe(testTopic)
+	defer sub3.Unsubscribe()
+
+	// Act
+	routers[0].Publish(testTopic, []byte("hello"))
+
+	// Assert
+	if _, ok := sub2.WaitNextWithTimeoutMs(1000); !ok {
+		t.Fatal("expected the neighbour to receive the message")
+	}
+
+	if _, ok := sub3.WaitNextWithTimeoutMs(500); ok {
+		t.Fatal("expected the neighbour to clamp the ttl to its own")
+	}
+}
+
+func Test__Publish__ShouldReachNeighbour__WhenPublishedRightAfterStart(t *testing.T) {
+	// Arrange
+	routers := openChain(t, 6, [][2]string{
+		{"localhost:2563", "localhost:2564"},
+		{"localhost:2565", "localhost:2566"},
+	})
+
+	sub2 := routers[1].Subscribe(testTopic)
+	defer sub2.Unsubscribe()
+
+	// Act
+	for i := range 20 {
+		routers[0].Publish(testTopic, []byte{byte(i)})
+	}
+
+	// Assert
+	for i := range 20 {
+		if _, ok := sub2.WaitNextWithTimeoutMs(1000); !ok {
+			t.Fatalf("expected 20 messages, got %d", i)
+		}
+	}
+}
+
+// openChain opens a network per pair of hosts, connecting each to the next, and starts their routers.
+func openChain(t *testing.T, ttl int, hosts [][2]string) []*gossiprouter.Router {
+	ttls := make([]int, len(hosts))
+	for i := range ttls {
+		ttls[i] = ttl
+	}
+
+	return openChainWithTtls(t, ttls, hosts)
+}
+
+// openChainWithTtls is openChain with the GossipTTL of each node.
+func openChainWithTtls(t *testing.T, ttls []int, hosts [][2]string) []*gossiprouter.Router {
+	routers := make([]*gossiprouter.Router, 0, len(hosts))
+	nets := make([]*network.Network, 0, len(hosts))
+
+	for i, pair := range hosts {
+		diCtx := defaultdi.ConfigureDefaultDI()
+		diCtx = networkdi.AddNetworkServices(diCtx)
+
+		config := networkconfig.GetFromDI(diCtx)
+		config.HandshakeHost = pair[0]
+		config.GossipHost = pair[1]
+		config.GossipTTL = ttls[i]
+
+		net := di.GetService[network.Network](diCtx)
+		if err := net.Open(); err != nil {
+			t.Fatalf("failed to open network on %s: %s", pair[0], err)
+		}
+		t.Cleanup(func() { net.Close() })
+
+		if len(nets) > 0 {
+			addr, err := network.ParseAddress(pair[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := nets[len(nets)-1].GetConnections().ConnectTo(net.GetSelf(), addr); err != nil {
+				t.Fatalf("failed to connect to %s: %s", pair[0], err)
+			}
+		}
+
+		router := gossiprouter.GetFromDI(diCtx)
+		router.Start()
+		t.Cleanup(router.Stop)
+
+		nets = append(nets, net)
+		routers = append(routers, router)
+	}
+
+	return routers
+}
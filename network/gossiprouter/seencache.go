@@ -0,0 +1,69 @@
+package gossiprouter
+
+import (
+	"sync"
+	"time"
+)
+
+// SeenCache remembers message ids for a time window, however many messages arrive in it.
+type SeenCache struct {
+	seen      map[string]time.Time
+	window    time.Duration
+	lastSweep time.Time
+	mux       *sync.Mutex
+}
+
+func NewSeenCache(window time.Duration) *SeenCache {
+	return &SeenCache{
+		seen:      make(map[string]time.Time),
+		window:    window,
+		lastSweep: time.Now(),
+		mux:       &sync.Mutex{},
+	}
+}
+
+// MarkSeen records the id and reports whether it was not seen within the window.
+func (sc *SeenCache) MarkSeen(id string) bool {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+
+	now := time.Now()
+	sc.sweep(now)
+
+	if seenAt, seen := sc.seen[id]; seen && now.Sub(seenAt) < sc.window {
+		return false
+	}
+
+	sc.seen[id] = now
+	return true
+}
+
+func (sc *SeenCache) Contains(id string) bool {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+
+	seenAt, seen := sc.seen[id]
+	return seen && time.Since(seenAt) < sc.window
+}
+
+func (sc *SeenCache) Count() int {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+
+	return len(sc.seen)
+}
+
+// sweep drops expired ids, at most twice per window so marking stays cheap.
+func (sc *SeenCache) sweep(now time.Time) {
+	if now.Sub(sc.lastSweep) < sc.window/2 {
+		return
+	}
+
+	for id, seenAt := range sc.seen {
+		if now.Sub(seenAt) >= sc.window {
+			delete(sc.seen, id)
+		}
+	}
+
+	sc.lastSweep = now
+}
@@ -0,0 +1,68 @@
+package gossiprouter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network/gossiprouter"
+)
+
+func Test__MarkSeen__ShouldReturnFalse__WhenIdWasSeenWithinWindow(t *testing.T) {
+	// Arrange
+	cache := gossiprouter.NewSeenCache(time.Minute)
+	cache.MarkSeen("id")
+
+	// Act
+	isNew := cache.MarkSeen("id")
+
+	// Assert
+	if isNew {
+		t.Fatal("expected id to be already seen")
+	}
+}
+
+func Test__MarkSeen__ShouldReturnTrue__WhenWindowHasPassed(t *testing.T) {
+	// Arrange
+	cache := gossiprouter.NewSeenCache(20 * time.Millisecond)
+	cache.MarkSeen("id")
+	time.Sleep(30 * time.Millisecond)
+
+	// Act
+	isNew := cache.MarkSeen("id")
+
+	// Assert
+	if !isNew {
+		t.Fatal("expected id to be forgotten after the window")
+	}
+}
+
+func Test__MarkSeen__ShouldDropExpiredIds__WhenSweeping(t *testing.T) {
+	// Arrange
+	cache := gossiprouter.NewSeenCache(20 * time.Millisecond)
+	for _, id := range []string{"a", "b", "c"} {
+		cache.MarkSeen(id)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// Act
+	cache.MarkSeen("d")
+
+	// Assert
+	if cache.Count() != 1 {
+		t.Fatalf("expected only the new id to be kept, got %d", cache.Count())
+	}
+}
+
+func Test__MessageId__ShouldDiffer__WhenTopicsDiffer(t *testing.T) {
+	// Arrange
+	payload := []byte("payload")
+
+	// Act
+	first := gossiprouter.MessageId("first", payload)
+	second := gossiprouter.MessageId("second", payload)
+
+	// Assert
+	if first == second {
+		t.Fatal("expected ids on different topics to differ")
+	}
+}
@@ -0,0 +1,63 @@
+// Package ciphersuites identifies the AEAD ciphers that can seal session traffic.
+// The initiator offers the suites it supports in the handshake and the responder picks one,
+// so new suites can be added without breaking older peers.
+package ciphersuites
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"slices"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var ErrUnsupportedSuite = errors.New("unsupported cipher suite")
+
+type Suite int
+
+const (
+	AES256GCM        Suite = 1
+	ChaCha20Poly1305 Suite = 2
+)
+
+// Supported lists the suites of this implementation, the preferred first
+var Supported = []Suite{AES256GCM, ChaCha20Poly1305}
+
+// Both suites use 256-bit keys and 96-bit nonces
+const (
+	KeySize   = 32
+	NonceSize = 12
+)
+
+// Negotiate picks the first of our supported suites that was offered by the peer.
+func Negotiate(offered []Suite) (Suite, error) {
+	for _, suite := range Supported {
+		if slices.Contains(offered, suite) {
+			return suite, nil
+		}
+	}
+
+	return 0, ErrUnsupportedSuite
+}
+
+func (s Suite) IsSupported() bool {
+	return slices.Contains(Supported, s)
+}
+
+// NewAEAD creates the cipher of the suite with a key of KeySize bytes.
+func (s Suite) NewAEAD(key []byte) (cipher.AEAD, error) {
+	switch s {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return cipher.NewGCM(block)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, ErrUnsupportedSuite
+	}
+}
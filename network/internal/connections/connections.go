@@ -1,6 +1,12 @@
 package connections
 
 import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
 	"github.com/titosilva/drmchain-pos/identity"
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/structures"
@@ -8,41 +14,64 @@ import (
 	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
 	"github.com/titosilva/drmchain-pos/internal/structures/kv"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
 	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
 )
 
 type Gossiper interface {
 	Listen(addr string, onConnect func(network.Connection)) error
 	ConnectTo(session *sessions.Session) (network.Connection, error)
+	Resume(peer network.Peer) (network.Connection, error) // Connects with a ticket from an earlier session
 	Close() error
 }
 
 type Handshaker interface {
 	Listen(addr string) error // TODO: pass onSession func, similar to Gossiper.Listen
 	ConnectTo(peer network.Peer) (*sessions.Session, error)
+	FindPeer(tag string) (network.Peer, error) // Finds the address of a peer by its tag, in the DHT
+	Punch(tag string) (network.Peer, error)    // Opens the NATs between us and a peer, through a peer we both know
+	AdvertisedAddress() string                 // The handshake address given to peers, which may differ from the bind address behind a NAT
 	Close() error
 }
 
 type ConnectionsImpl struct {
-	connections           *cmap.CMap[identity.PublicIdentity, network.Connection]
-	handshake             Handshaker
-	gossip                Gossiper
-	connectionsObservable *observable.Observable[network.Connection]
-	configuration         *networkconfig.NetworkConfig
+	connections              *cmap.CMap[identity.PublicIdentity, network.Connection]
+	outbound                 *cmap.CMap[string, network.Peer] // Peers we connected to, by tag. They are reconnected when dropped.
+	mux                      *sync.Mutex
+	handshake                Handshaker
+	gossip                   Gossiper
+	connectionsObservable    *observable.Observable[network.Connection]
+	disconnectionsObservable *observable.Observable[network.Connection]
+	configuration            *networkconfig.NetworkConfig
+	reputation               *reputation.Reputation
+	allowlist                *allowlist.Allowlist
+	cancellation             context.Context
+	cancel                   context.CancelFunc
 }
 
 func Factory(diCtx *di.DIContext) network.ConfigurableConnections {
 	handshakeHost := di.GetInterfaceService[Handshaker](diCtx)
 	gossipHost := di.GetInterfaceService[Gossiper](diCtx)
 	config := di.GetService[networkconfig.NetworkConfig](diCtx)
+	reputation := reputation.GetFromDI(diCtx)
+
+	cancellation, cancel := context.WithCancel(context.Background())
 
 	return &ConnectionsImpl{
-		connections:           cmap.New[identity.PublicIdentity, network.Connection](),
-		handshake:             handshakeHost,
-		gossip:                gossipHost,
-		connectionsObservable: observable.New[network.Connection](),
-		configuration:         config,
+		connections:              cmap.New[identity.PublicIdentity, network.Connection](),
+		outbound:                 cmap.New[string, network.Peer](),
+		mux:                      &sync.Mutex{},
+		handshake:                handshakeHost,
+		gossip:                   gossipHost,
+		connectionsObservable:    observable.New[network.Connection](),
+		disconnectionsObservable: observable.New[network.Connection](),
+		configuration:            config,
+		reputation:               reputation,
+		allowlist:                allowlist.GetFromDI(diCtx),
+		cancellation:             cancellation,
+		cancel:                   cancel,
 	}
 }
 
@@ -52,6 +81,16 @@ func GetFromDI(diCtx *di.DIContext) *ConnectionsImpl {
 
 // Init implements network.Connections.
 func (c *ConnectionsImpl) Init() error {
+	if err := c.reputation.Load(); err != nil {
+		return err
+	}
+
+	bansSub := c.reputation.SubscribeBans()
+	go c.enforceBans(bansSub)
+
+	removalsSub := c.allowlist.SubscribeRemovals()
+	go c.enforceBans(removalsSub)
+
 	err := c.handshake.Listen(c.configuration.HandshakeHost)
 
 	if err != nil {
@@ -70,18 +109,68 @@ func (c *ConnectionsImpl) Init() error {
 }
 
 // ConnectTo implements network.Connections.
+// Once connected, the peer is reconnected whenever the connection drops.
 func (c *ConnectionsImpl) ConnectTo(id identity.PublicIdentity, addr network.Address) error {
+	if c.reputation.IsBanned(id.GetTag()) {
+		return network.ErrPeerBanned
+	}
+
+	if !c.allowlist.IsAllowed(id.GetTag()) {
+		return network.ErrPeerNotAllowed
+	}
+
 	peer := network.Peer{
 		Id:   id,
 		Addr: addr.AsUdp().String(),
 	}
 
-	session, err := c.handshake.ConnectTo(peer)
+	if err := c.connect(peer); err != nil {
+		return err
+	}
 
+	c.outbound.Set(id.GetTag(), peer)
+	return nil
+}
+
+// ConnectToTag implements network.Connections.
+func (c *ConnectionsImpl) ConnectToTag(tag string) error {
+	peer, err := c.handshake.FindPeer(tag)
 	if err != nil {
 		return err
 	}
 
+	addr, err := network.ParseAddress(peer.Addr)
+	if err != nil {
+		return err
+	}
+
+	return c.ConnectTo(peer.Id, addr)
+}
+
+// connect does the handshake with the peer, then connects to it with the session.
+// A handshake that could not reach the peer is tried again once the NATs between us are punched,
+// but the error of the first handshake is the one returned if that fails too.
+func (c *ConnectionsImpl) connect(peer network.Peer) error {
+	session, err := c.handshake.ConnectTo(peer)
+	if err != nil {
+		if !errors.Is(err, network.ErrPeerUnreachable) {
+			return err
+		}
+
+		// The peer may be behind a NAT, which only lets the handshake through once both sides punched it
+		punched, punchErr := c.handshake.Punch(peer.Id.GetTag())
+		if punchErr != nil {
+			log.Println("failed to punch towards ", peer.Addr, ": ", punchErr)
+			return err
+		}
+
+		var retryErr error
+		if session, retryErr = c.handshake.ConnectTo(punched); retryErr != nil {
+			log.Println("failed to handshake with punched peer ", punched.Addr, ": ", retryErr)
+			return err
+		}
+	}
+
 	conn, err := c.gossip.ConnectTo(session)
 	if err != nil {
 		return err
@@ -91,35 +180,159 @@ func (c *ConnectionsImpl) ConnectTo(id identity.PublicIdentity, addr network.Add
 }
 
 // RegisterConnection implements network.Connections.
+// Connections of banned or unlisted peers are closed instead.
 func (c *ConnectionsImpl) RegisterConnection(conn network.Connection) error {
+	if c.reputation.IsBanned(conn.GetPeer().Id.GetTag()) {
+		conn.GetTunnel().Close()
+		return network.ErrPeerBanned
+	}
+
+	if !c.allowlist.IsAllowed(conn.GetPeer().Id.GetTag()) {
+		conn.GetTunnel().Close()
+		return network.ErrPeerNotAllowed
+	}
+
+	c.mux.Lock()
 	c.connections.Set(conn.GetPeer().Id, conn)
+	c.mux.Unlock()
+
 	c.connectionsObservable.Notify(conn)
+	go c.watch(conn)
 	return nil
 }
 
+// watch removes the connection when its tunnel is closed, and reconnects if the peer is outbound.
+func (c *ConnectionsImpl) watch(conn network.Connection) {
+	select {
+	case <-conn.GetTunnel().WaitClose():
+	case <-c.cancellation.Done():
+		return
+	}
+
+	c.mux.Lock()
+	current, found := c.connections.Get(conn.GetPeer().Id)
+	removed := found && current == conn
+	if removed {
+		c.connections.Delete(conn.GetPeer().Id)
+	}
+	c.mux.Unlock()
+
+	if !removed {
+		return
+	}
+
+	c.disconnectionsObservable.Notify(conn)
+
+	if peer, found := c.outbound.Get(conn.GetPeer().Id.GetTag()); found {
+		c.reconnect(peer)
+	}
+}
+
+// enforceBans disconnects the peers banned, or removed from the allowlist, while the network is open,
+// and stops reconnecting to them.
+func (c *ConnectionsImpl) enforceBans(bansSub *observable.Subscription[string]) {
+	defer bansSub.Unsubscribe()
+
+	for {
+		select {
+		case tag := <-bansSub.Channel():
+			log.Println("disconnecting refused peer ", tag)
+			c.outbound.Delete(tag)
+
+			for conn := range c.Current().All() {
+				if conn.GetPeer().Id.GetTag() == tag {
+					conn.GetTunnel().Close()
+				}
+			}
+		case <-c.cancellation.Done():
+			return
+		}
+	}
+}
+
+// resume connects to the peer with a ticket from the dropped connection, and redoes the handshake without one.
+func (c *ConnectionsImpl) resume(peer network.Peer) error {
+	conn, err := c.gossip.Resume(peer)
+	if err == nil {
+		return c.RegisterConnection(conn)
+	}
+
+	log.Println("failed to resume session with ", peer.Addr, ", connecting again: ", err)
+	return c.connect(peer)
+}
+
+// reconnect connects to the peer again until it succeeds, with exponential backoff.
+// It gives up once the peer is no longer outbound, or is refused for being banned or not allowed.
+func (c *ConnectionsImpl) reconnect(peer network.Peer) {
+	backoff := c.configuration.ReconnectMinBackoff
+
+	for {
+		select {
+		case <-c.cancellation.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if _, found := c.outbound.Get(peer.Id.GetTag()); !found {
+			return
+		}
+
+		err := c.resume(peer)
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, network.ErrPeerBanned) || errors.Is(err, network.ErrPeerNotAllowed) {
+			log.Println("stopped reconnecting to refused peer ", peer.Addr, ": ", err)
+			c.outbound.Delete(peer.Id.GetTag())
+			return
+		}
+
+		log.Println("failed to reconnect to ", peer.Addr, ": ", err)
+		backoff = min(2*backoff, c.configuration.ReconnectMaxBackoff)
+	}
+}
+
 // Subscribe implements network.Connections.
 func (c *ConnectionsImpl) Subscribe() *observable.Subscription[network.Connection] {
 	return c.connectionsObservable.Subscribe()
 }
 
+// AdvertisedAddress implements network.Connections.
+func (c *ConnectionsImpl) AdvertisedAddress() string {
+	return c.handshake.AdvertisedAddress()
+}
+
+// SubscribeDisconnections implements network.Connections.
+func (c *ConnectionsImpl) SubscribeDisconnections() *observable.Subscription[network.Connection] {
+	return c.disconnectionsObservable.Subscribe()
+}
+
 // Current implements network.Connections.
 func (c *ConnectionsImpl) Current() structures.Enumerable[network.Connection] {
 	return structures.Map(kv.GetValue, c.connections)
 }
 
+// Outbound implements network.Connections.
+func (c *ConnectionsImpl) Outbound() structures.Enumerable[network.Connection] {
+	outbound := c.connections.Where(func(entry kv.KeyValue[identity.PublicIdentity, network.Connection]) bool {
+		_, found := c.outbound.Get(entry.Key.GetTag())
+		return found
+	})
+
+	return structures.Map(kv.GetValue, structures.Seq(outbound))
+}
+
 // Finish implements network.Connections.
+// Reconnections are cancelled first, so none starts while the hosts close.
 func (c *ConnectionsImpl) Finish() error {
-	err := c.handshake.Close()
+	c.cancel()
 
-	if err != nil {
-		return err
-	}
-
-	c.gossip.Close()
-	c.handshake.Close()
+	err := errors.Join(c.gossip.Close(), c.handshake.Close())
 	c.connectionsObservable.Close()
+	c.disconnectionsObservable.Close()
 
-	return nil
+	return err
 }
 
 // Static interface impl check
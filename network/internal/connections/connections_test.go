@@ -0,0 +1,327 @@
+package connections_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+)
+
+var errClose = errors.New("close failed")
+
+func Test__Finish__ShouldCloseEachHostOnce__WhenHandshakeFailsToClose(t *testing.T) {
+	// Arrange
+	handshaker := &fakeHandshaker{closeErr: errClose}
+	gossiper := &fakeGossiper{}
+	conns, _ := newConnections(t, handshaker, gossiper)
+	sub := conns.Subscribe()
+
+	// Act
+	err := conns.Finish()
+
+	// Assert
+	if !errors.Is(err, errClose) {
+		t.Errorf("Expected the close error of the handshake host, got %v", err)
+	}
+
+	if closes := handshaker.closes.Load(); closes != 1 {
+		t.Errorf("Expected the handshake host to be closed once, got %d", closes)
+	}
+
+	if closes := gossiper.closes.Load(); closes != 1 {
+		t.Errorf("Expected the gossip host to be closed once, got %d", closes)
+	}
+
+	select {
+	case <-sub.WaitClose():
+	default:
+		t.Error("Expected the connections to be closed")
+	}
+}
+
+func Test__Reconnect__ShouldStop__WhenPeerIsRefusedAsBanned(t *testing.T) {
+	// Arrange
+	handshaker := &fakeHandshaker{}
+	conns, diCtx := newConnections(t, handshaker, &fakeGossiper{})
+	t.Cleanup(func() { conns.Finish() })
+
+	peer := generateIdentity(t)
+	if err := conns.ConnectTo(peer, network.Address{Host: "localhost", Port: 2701}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without Init, the ban is only noticed when the reconnected peer is registered
+	if err := reputation.GetFromDI(diCtx).Ban(peer.GetTag(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	dropConnections(conns)
+	time.Sleep(100 * time.Millisecond)
+
+	// Assert
+	if connects := handshaker.connects.Load(); connects != 2 {
+		t.Errorf("Expected a single attempt to reconnect to the banned peer, got %d", connects-1)
+	}
+}
+
+func Test__Reconnect__ShouldStop__WhenPeerIsNoLongerOutbound(t *testing.T) {
+	// Arrange
+	handshaker := &fakeHandshaker{}
+	conns, diCtx := newConnections(t, handshaker, &fakeGossiper{})
+	if err := conns.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conns.Finish() })
+
+	peer := generateIdentity(t)
+	if err := conns.ConnectTo(peer, network.Address{Host: "localhost", Port: 2701}); err != nil {
+		t.Fatal(err)
+	}
+
+	handshaker.connectErr = func(int32) error { return errHandshake }
+	dropConnections(conns)
+	waitFor(t, func() bool { return handshaker.connects.Load() > 2 })
+
+	// Act
+	if err := reputation.GetFromDI(diCtx).Ban(peer.GetTag(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	connects := handshaker.connects.Load()
+	time.Sleep(100 * time.Millisecond)
+
+	// Assert
+	if attempts := handshaker.connects.Load() - connects; attempts > 1 {
+		t.Errorf("Expected reconnecting to stop once the peer was removed, got %d more attempts", attempts)
+	}
+}
+
+func Test__ConnectTo__ShouldNotPunch__WhenPeerRefusesHandshake(t *testing.T) {
+	// Arrange
+	handshaker := &fakeHandshaker{connectErr: func(int32) error { return errHandshake }}
+	conns, _ := newConnections(t, handshaker, &fakeGossiper{})
+	t.Cleanup(func() { conns.Finish() })
+
+	// Act
+	err := conns.ConnectTo(generateIdentity(t), network.Address{Host: "localhost", Port: 2701})
+
+	// Assert
+	if !errors.Is(err, errHandshake) {
+		t.Errorf("Expected the handshake error, got %v", err)
+	}
+
+	if punches := handshaker.punches.Load(); punches != 0 {
+		t.Errorf("Expected no punch for a refused handshake, got %d", punches)
+	}
+}
+
+func Test__ConnectTo__ShouldReturnFirstHandshakeError__WhenHandshakeFailsAgainAfterPunching(t *testing.T) {
+	// Arrange
+	handshaker := &fakeHandshaker{connectErr: func(attempt int32) error {
+		if attempt == 1 {
+			return errTimeout
+		}
+
+		return errHandshake
+	}}
+	conns, _ := newConnections(t, handshaker, &fakeGossiper{})
+	t.Cleanup(func() { conns.Finish() })
+
+	// Act
+	err := conns.ConnectTo(generateIdentity(t), network.Address{Host: "localhost", Port: 2701})
+
+	// Assert
+	if !errors.Is(err, errTimeout) || errors.Is(err, errHandshake) {
+		t.Errorf("Expected the error of the first handshake, got %v", err)
+	}
+
+	if punches := handshaker.punches.Load(); punches != 1 {
+		t.Errorf("Expected a single punch for an unreachable peer, got %d", punches)
+	}
+
+	if connects := handshaker.connects.Load(); connects != 2 {
+		t.Errorf("Expected the handshake to be tried again after punching, got %d attempts", connects)
+	}
+}
+
+// newConnections creates the connections with the given hosts, over a storage of their own.
+// Dropped peers are reconnected within a few milliseconds.
+func newConnections(t *testing.T, handshaker connections.Handshaker, gossiper connections.Gossiper) (network.ConfigurableConnections, *di.DIContext) {
+	storageDir := t.TempDir()
+
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) connections.Handshaker {
+		return handshaker
+	})
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) connections.Gossiper {
+		return gossiper
+	})
+
+	config := networkconfig.GetFromDI(diCtx)
+	config.ReconnectMinBackoff = time.Millisecond
+	config.ReconnectMaxBackoff = 5 * time.Millisecond
+
+	return di.GetInterfaceService[network.ConfigurableConnections](diCtx), diCtx
+}
+
+// dropConnections closes the tunnels of the current connections, as if the peers went away
+func dropConnections(conns network.Connections) {
+	for conn := range conns.Current().All() {
+		conn.GetTunnel().Close()
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func generateIdentity(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+var errHandshake = errors.New("handshake failed")
+
+var errTimeout = errorutil.WithInner("handshake timed out", network.ErrPeerUnreachable)
+
+type fakeHandshaker struct {
+	closeErr   error
+	connectErr func(attempt int32) error // Handshakes succeed when nil
+	punchErr   error
+	closes     atomic.Int32
+	connects   atomic.Int32
+	punches    atomic.Int32
+}
+
+func (h *fakeHandshaker) Listen(addr string) error {
+	return nil
+}
+
+func (h *fakeHandshaker) ConnectTo(peer network.Peer) (*sessions.Session, error) {
+	attempt := h.connects.Add(1)
+	if h.connectErr != nil {
+		if err := h.connectErr(attempt); err != nil {
+			return nil, err
+		}
+	}
+
+	return sessions.NewSession("session", nil, ciphersuites.ChaCha20Poly1305, peer), nil
+}
+
+func (h *fakeHandshaker) FindPeer(tag string) (network.Peer, error) {
+	return network.Peer{}, errors.New("not implemented")
+}
+
+func (h *fakeHandshaker) Punch(tag string) (network.Peer, error) {
+	h.punches.Add(1)
+	if h.punchErr != nil {
+		return network.Peer{}, h.punchErr
+	}
+
+	return network.Peer{Addr: "punched"}, nil
+}
+
+func (h *fakeHandshaker) AdvertisedAddress() string {
+	return "advertised"
+}
+
+func (h *fakeHandshaker) Close() error {
+	h.closes.Add(1)
+	return h.closeErr
+}
+
+type fakeGossiper struct {
+	closes atomic.Int32
+}
+
+func (g *fakeGossiper) Listen(addr string, onConnect func(network.Connection)) error {
+	return nil
+}
+
+func (g *fakeGossiper) ConnectTo(session *sessions.Session) (network.Connection, error) {
+	return &fakeConnection{peer: session.Peer, tunnel: &fakeTunnel{closed: make(chan struct{})}}, nil
+}
+
+func (g *fakeGossiper) Resume(peer network.Peer) (network.Connection, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (g *fakeGossiper) Close() error {
+	g.closes.Add(1)
+	return nil
+}
+
+type fakeConnection struct {
+	peer   network.Peer
+	tunnel *fakeTunnel
+}
+
+func (c *fakeConnection) GetPeer() network.Peer {
+	return c.peer
+}
+
+func (c *fakeConnection) GetTunnel() tunnel.TopicTunnel {
+	return c.tunnel
+}
+
+type fakeTunnel struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *fakeTunnel) Send(topic string, data []byte) error {
+	return errors.New("not implemented")
+}
+
+func (t *fakeTunnel) Handle(topic string) *observable.Subscription[[]byte] {
+	return observable.New[[]byte]().Subscribe()
+}
+
+func (t *fakeTunnel) SubscribeErrors() *observable.Subscription[tunnel.TopicError] {
+	return observable.New[tunnel.TopicError]().Subscribe()
+}
+
+func (t *fakeTunnel) GetMetrics() map[string]tunnel.TopicMetrics {
+	return nil
+}
+
+func (t *fakeTunnel) WaitClose() <-chan struct{} {
+	return t.closed
+}
+
+func (t *fakeTunnel) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
@@ -0,0 +1,167 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network"
+)
+
+const (
+	// Contacts kept per bucket, and returned by each FIND_NODE
+	BucketSize = 16
+
+	// Queries sent in parallel during a lookup
+	Alpha = 3
+
+	idBits = sha256.Size * 8
+)
+
+// NodeId places a peer in the keyspace: the SHA-256 of its identity tag.
+type NodeId [sha256.Size]byte
+
+func IdFromTag(tag string) NodeId {
+	return sha256.Sum256([]byte(tag))
+}
+
+// Distance is the XOR of two ids, compared as a big-endian number.
+func Distance(a NodeId, b NodeId) NodeId {
+	var d NodeId
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+
+	return d
+}
+
+// Closer tells whether a is closer to target than b.
+func Closer(target NodeId, a NodeId, b NodeId) bool {
+	da, db := Distance(target, a), Distance(target, b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// SortByDistance sorts the records from the closest to the target to the farthest.
+func SortByDistance(target NodeId, records []network.PeerRecord) {
+	slices.SortFunc(records, func(a, b network.PeerRecord) int {
+		da, db := Distance(target, IdFromTag(a.Tag)), Distance(target, IdFromTag(b.Tag))
+		return bytes.Compare(da[:], db[:])
+	})
+}
+
+type contact struct {
+	record   network.PeerRecord
+	lastSeen time.Time
+}
+
+// RoutingTable keeps contacts in one bucket per bit of distance from self.
+// Buckets keep the contacts seen first: long-lived peers tend to stay alive, and cannot be flushed
+// by an attacker flooding new identities. Contacts are removed when they stop answering.
+type RoutingTable struct {
+	self    NodeId
+	buckets [idBits][]contact
+	mux     *sync.Mutex
+}
+
+func NewRoutingTable(selfTag string) *RoutingTable {
+	return &RoutingTable{
+		self: IdFromTag(selfTag),
+		mux:  &sync.Mutex{},
+	}
+}
+
+// Add inserts or refreshes a contact. It returns false if the bucket of the contact is full.
+// The record must have been verified by the caller.
+func (rt *RoutingTable) Add(record network.PeerRecord) bool {
+	id := IdFromTag(record.Tag)
+	if id == rt.self {
+		return false
+	}
+
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+
+	index := rt.bucketIndex(id)
+	bucket := rt.buckets[index]
+	for i, c := range bucket {
+		if c.record.Tag == record.Tag {
+			if record.Timestamp >= c.record.Timestamp {
+				bucket[i].record = record
+			}
+			bucket[i].lastSeen = time.Now()
+			return true
+		}
+	}
+
+	if len(bucket) >= BucketSize {
+		return false
+	}
+
+	rt.buckets[index] = append(bucket, contact{record: record, lastSeen: time.Now()})
+	return true
+}
+
+func (rt *RoutingTable) Remove(tag string) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+
+	index := rt.bucketIndex(IdFromTag(tag))
+	rt.buckets[index] = slices.DeleteFunc(rt.buckets[index], func(c contact) bool {
+		return c.record.Tag == tag
+	})
+}
+
+func (rt *RoutingTable) Get(tag string) (network.PeerRecord, bool) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+
+	for _, c := range rt.buckets[rt.bucketIndex(IdFromTag(tag))] {
+		if c.record.Tag == tag {
+			return c.record, true
+		}
+	}
+
+	return network.PeerRecord{}, false
+}
+
+// Closest returns up to n contacts, sorted from the closest to the target.
+func (rt *RoutingTable) Closest(target NodeId, n int) []network.PeerRecord {
+	rt.mux.Lock()
+	records := make([]network.PeerRecord, 0)
+	for _, bucket := range rt.buckets {
+		for _, c := range bucket {
+			records = append(records, c.record)
+		}
+	}
+	rt.mux.Unlock()
+
+	SortByDistance(target, records)
+	return records[:min(n, len(records))]
+}
+
+func (rt *RoutingTable) Count() int {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+
+	count := 0
+	for _, bucket := range rt.buckets {
+		count += len(bucket)
+	}
+
+	return count
+}
+
+// bucketIndex is the number of leading bits the id shares with self
+func (rt *RoutingTable) bucketIndex(id NodeId) int {
+	d := Distance(rt.self, id)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+
+	return idBits - 1
+}
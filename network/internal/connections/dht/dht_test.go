@@ -0,0 +1,96 @@
+package dht_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/dht"
+)
+
+func Test__Closest__ShouldSortByXorDistance(t *testing.T) {
+	// Arrange
+	table := dht.NewRoutingTable("self")
+	for i := range 50 {
+		table.Add(network.PeerRecord{Tag: "peer" + strconv.Itoa(i)})
+	}
+	target := dht.IdFromTag("target")
+
+	// Act
+	closest := table.Closest(target, 10)
+
+	// Assert
+	if len(closest) != 10 {
+		t.Fatalf("Expected 10 contacts, got %d", len(closest))
+	}
+
+	for i := 1; i < len(closest); i++ {
+		if dht.Closer(target, dht.IdFromTag(closest[i].Tag), dht.IdFromTag(closest[i-1].Tag)) {
+			t.Fatalf("Expected contact %d to be farther than contact %d", i, i-1)
+		}
+	}
+
+	for _, other := range table.Closest(target, 50)[10:] {
+		if dht.Closer(target, dht.IdFromTag(other.Tag), dht.IdFromTag(closest[9].Tag)) {
+			t.Fatalf("Expected %s to be among the closest", other.Tag)
+		}
+	}
+}
+
+func Test__Add__ShouldKeepOldContacts__WhenBucketIsFull(t *testing.T) {
+	// Arrange
+	table := dht.NewRoutingTable("self")
+	self := dht.IdFromTag("self")
+
+	// Half of the keyspace is in the farthest bucket, which holds the ids whose first bit differs from self
+	farthest := make([]string, 0)
+	for i := 0; len(farthest) <= dht.BucketSize; i++ {
+		tag := "peer" + strconv.Itoa(i)
+		if id := dht.IdFromTag(tag); (id[0]^self[0])&0x80 != 0 {
+			farthest = append(farthest, tag)
+		}
+	}
+
+	for _, tag := range farthest[:dht.BucketSize] {
+		table.Add(network.PeerRecord{Tag: tag})
+	}
+
+	// Act
+	added := table.Add(network.PeerRecord{Tag: farthest[dht.BucketSize]})
+
+	// Assert
+	if added {
+		t.Fatal("Expected contact not to be added to a full bucket")
+	}
+
+	if _, found := table.Get(farthest[0]); !found {
+		t.Fatal("Expected the oldest contact to be kept")
+	}
+}
+
+func Test__Add__ShouldIgnoreSelf(t *testing.T) {
+	// Arrange
+	table := dht.NewRoutingTable("self")
+
+	// Act
+	added := table.Add(network.PeerRecord{Tag: "self"})
+
+	// Assert
+	if added || table.Count() != 0 {
+		t.Fatal("Expected self not to be added")
+	}
+}
+
+func Test__Remove__ShouldForgetContact(t *testing.T) {
+	// Arrange
+	table := dht.NewRoutingTable("self")
+	table.Add(network.PeerRecord{Tag: "peer"})
+
+	// Act
+	table.Remove("peer")
+
+	// Assert
+	if _, found := table.Get("peer"); found {
+		t.Fatal("Expected contact to be removed")
+	}
+}
@@ -2,6 +2,7 @@ package gossip
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"log"
 	"net"
@@ -11,12 +12,22 @@ import (
 	"github.com/titosilva/drmchain-pos/internal/di"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
 	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/internal/utils/uuid"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
 	"github.com/titosilva/drmchain-pos/network/internal/connections"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/gossiptunnel"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/framing"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/messages"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
 )
 
+const resumeNonceSize = 32
+
+var ErrNoTicket = errors.New("no ticket to resume a session with the peer")
+
 type GossipHost struct {
 	address string
 
@@ -25,8 +36,10 @@ type GossipHost struct {
 	cancel       context.CancelFunc
 	selfId       identity.PrivateIdentity
 
-	sessions  *sessions.Memory
-	onConnect func(network.Connection)
+	sessions      *sessions.Memory
+	configuration *networkconfig.NetworkConfig
+	reputation    *reputation.Reputation
+	onConnect     func(network.Connection)
 }
 
 func Factory(diCtx *di.DIContext) connections.Gossiper {
@@ -37,10 +50,12 @@ func Factory(diCtx *di.DIContext) connections.Gossiper {
 	identity, _ := idProvider.GetIdentity()
 
 	return &GossipHost{
-		selfId:       identity,
-		cancellation: &cancellation,
-		cancel:       cancel,
-		sessions:     sessions,
+		selfId:        identity,
+		cancellation:  &cancellation,
+		cancel:        cancel,
+		sessions:      sessions,
+		configuration: networkconfig.GetFromDI(diCtx),
+		reputation:    reputation.GetFromDI(diCtx),
 	}
 }
 
@@ -104,44 +119,78 @@ func (g *GossipHost) acceptConnections() {
 
 func (g *GossipHost) handleConnection(conn net.Conn) {
 	log.Printf("new connection accepted from %s Reading message shell\n", conn.RemoteAddr().String())
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
+	tunnel, session, err := g.acceptTunnel(conn)
 	if err != nil {
-		log.Println("failed to read connection sessionId", err)
+		log.Println("rejected connection from ", conn.RemoteAddr().String(), ": ", err)
+		conn.Close()
 		return
 	}
 
-	sessionId := string(buf[:n])
-
-	session := g.sessions.GetSession(sessionId)
-	if session == nil {
-		log.Println("session ", sessionId, " not found")
+	if err := tunnel.AnswerInit(); err != nil {
+		log.Println("failed to receive init message", err)
+		tunnel.Close()
 		return
 	}
 
-	if session.WasConnected() {
-		log.Println("session ", sessionId, " already connected")
-		return
-	}
+	tunnel.Start()
+	log.Println("connected to ", session.Peer.Addr, " with session ", session.Id, " and tag ", session.Peer.Id.GetTag())
+}
 
-	tunnel, err := gossiptunnel.New(conn, session, g.selfId)
+// acceptTunnel reads the init of the connection and creates the tunnel of its session, reporting the connection.
+// The caller must close conn if it fails.
+func (g *GossipHost) acceptTunnel(conn net.Conn) (*gossiptunnel.GossipTunnel, *sessions.Session, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := framing.NewReader(conn).Next()
 	if err != nil {
-		log.Println("failed to create tunnel", err)
-		return
+		return nil, nil, errorutil.WithInner("failed to read connection init", err)
 	}
-	log.Println("new tunnel created for session ", session.Id)
 
-	connection := NewGossipConnection(session.Peer, tunnel)
-	g.onConnect(connection)
-	err = tunnel.AnswerInit()
+	var init messages.InitMessage
+	if err := encodings.Decode(frame, &init); err != nil {
+		return nil, nil, errorutil.WithInner("failed to decode connection init", err)
+	}
+
+	var session *sessions.Session
+	switch {
+	case init.TicketId != "":
+		session, err = g.resumeSession(conn, init)
+		if err != nil {
+			return nil, nil, errorutil.WithInner("failed to resume session", err)
+		}
+	case init.SessionId != "":
+		session = g.sessions.GetSession(init.SessionId)
+		if session == nil {
+			return nil, nil, errors.New("session " + init.SessionId + " not found")
+		}
+
+		if session.WasConnected() {
+			return nil, nil, errors.New("session " + init.SessionId + " already connected")
+		}
+		session.MarkConnected()
+	default:
+		return nil, nil, errors.New("connection init has neither a ticket nor a session")
+	}
+
+	tunnel, err := g.newTunnel(conn, session)
 	if err != nil {
-		log.Println("failed to receive init message", err)
-		return
+		return nil, nil, errorutil.WithInner("failed to create tunnel", err)
 	}
 
-	tunnel.Start()
-	log.Println("connected to ", session.Peer.Addr, " with session ", session.Id, " and tag ", session.Peer.Id.GetTag())
+	// The ticket id travels in clear, so the ticket is used only once the peer proves it holds the resumed keys
+	if init.TicketId != "" {
+		if err := tunnel.AwaitProof(); err != nil {
+			return nil, nil, errorutil.WithInner("peer did not prove the keys of the resumed session", err)
+		}
+
+		if g.sessions.TakeIssuedTicket(init.TicketId) == nil {
+			return nil, nil, errors.New("ticket " + init.TicketId + " was already used")
+		}
+		session.MarkConnected()
+	}
+	log.Println("new tunnel created for session ", session.Id)
+
+	g.onConnect(NewGossipConnection(session.Peer, tunnel))
+	return tunnel, session, nil
 }
 
 func (g *GossipHost) ConnectTo(session *sessions.Session) (network.Connection, error) {
@@ -153,8 +202,9 @@ func (g *GossipHost) ConnectTo(session *sessions.Session) (network.Connection, e
 
 	log.Println("TCP connected to ", peer.Addr)
 
-	tunnel, err := gossiptunnel.New(conn, session, g.selfId)
+	tunnel, err := g.newTunnel(conn, session)
 	if err != nil {
+		conn.Close()
 		return nil, errorutil.WithInner("failed to create tunnel", err)
 	}
 
@@ -162,6 +212,7 @@ func (g *GossipHost) ConnectTo(session *sessions.Session) (network.Connection, e
 	g.onConnect(connection)
 	err = tunnel.SendInit()
 	if err != nil {
+		tunnel.Close()
 		return nil, errorutil.WithInner("failed to send init message", err)
 	}
 
@@ -170,6 +221,124 @@ func (g *GossipHost) ConnectTo(session *sessions.Session) (network.Connection, e
 	return connection, nil
 }
 
+// resumeSession creates a new session from the ticket in the init and answers it.
+// The ticket is not used yet: the caller takes it once the peer proves it holds the keys of the session.
+func (g *GossipHost) resumeSession(conn net.Conn, init messages.InitMessage) (*sessions.Session, error) {
+	ticket := g.sessions.GetIssuedTicket(init.TicketId)
+	if ticket == nil {
+		return nil, errors.New("ticket " + init.TicketId + " not found")
+	}
+
+	nonce := make([]byte, resumeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sessionId := uuid.NewUuid()
+	if sessionId == "" {
+		return nil, errors.New("failed to generate an id for the resumed session")
+	}
+
+	session := ticket.Resume(sessionId, init.Nonce, nonce)
+	answer, err := encodings.Encode(messages.ResumeMessage{SessionId: session.Id, Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := framing.Write(conn, answer); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Resume connects to the peer with a ticket it issued in an earlier session, skipping the handshake.
+// It returns ErrNoTicket if there is no ticket from the peer. Each ticket is used once, even if resuming fails.
+func (g *GossipHost) Resume(peer network.Peer) (network.Connection, error) {
+	ticket := g.sessions.TakeReceivedTicket(peer.Id.GetTag())
+	if ticket == nil {
+		return nil, ErrNoTicket
+	}
+
+	nonce := make([]byte, resumeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	init, err := encodings.Encode(messages.InitMessage{TicketId: ticket.Id, Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("tcp", ticket.Peer.Addr)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to dial peer", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := framing.Write(conn, init); err != nil {
+		conn.Close()
+		return nil, errorutil.WithInner("failed to send resumption init", err)
+	}
+
+	frame, err := framing.NewReader(conn).Next()
+	if err != nil {
+		conn.Close()
+		return nil, errorutil.WithInner("failed to read resumption answer", err)
+	}
+
+	var answer messages.ResumeMessage
+	if err := encodings.Decode(frame, &answer); err != nil {
+		conn.Close()
+		return nil, errorutil.WithInner("failed to decode resumption answer", err)
+	}
+
+	if answer.SessionId == "" {
+		conn.Close()
+		return nil, errors.New("resumption answer has no session id")
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	session := ticket.Resume(answer.SessionId, nonce, answer.Nonce)
+	tunnel, err := g.newTunnel(conn, session)
+	if err != nil {
+		conn.Close()
+		return nil, errorutil.WithInner("failed to create tunnel", err)
+	}
+
+	if err := tunnel.AnswerInit(); err != nil {
+		tunnel.Close()
+		return nil, errorutil.WithInner("failed to prove the keys of the resumed session", err)
+	}
+
+	if err := tunnel.AwaitInit(); err != nil {
+		tunnel.Close()
+		return nil, errorutil.WithInner("failed to resume session", err)
+	}
+
+	connection := NewGossipConnection(ticket.Peer, tunnel)
+	g.onConnect(connection)
+
+	tunnel.Start()
+	log.Println("resumed session with ", ticket.Peer.Addr, " as session ", session.Id)
+	return connection, nil
+}
+
+func (g *GossipHost) newTunnel(conn net.Conn, session *sessions.Session) (*gossiptunnel.GossipTunnel, error) {
+	tunnel, err := gossiptunnel.New(conn, session, g.selfId)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel.SetKeepAlive(g.configuration.KeepAliveInterval, g.configuration.IdleTimeout)
+	tunnel.SetRekeyInterval(g.configuration.RekeyInterval)
+	tunnel.EnableResumption(g.sessions, g.configuration.TicketLifetime)
+	tunnel.ReportTo(g.reputation)
+	return tunnel, nil
+}
+
 func (g *GossipHost) Close() error {
 	g.cancel()
 	g.tcpServer.Close()
@@ -8,13 +8,15 @@ import (
 
 type GossipConnection struct {
 	peer   network.Peer
-	tunnel *gossiptunnel.GossipTunnel
+	tunnel *tunnel.Mux
 }
 
-func NewGossipConnection(peer network.Peer, tunnel *gossiptunnel.GossipTunnel) *GossipConnection {
+// NewGossipConnection multiplexes topics over the tunnel. It must be called before the tunnel is started,
+// so no frame is received before the multiplexer listens.
+func NewGossipConnection(peer network.Peer, gossipTunnel *gossiptunnel.GossipTunnel) *GossipConnection {
 	return &GossipConnection{
 		peer:   peer,
-		tunnel: tunnel,
+		tunnel: tunnel.NewMux(gossipTunnel),
 	}
 }
 
@@ -24,7 +26,7 @@ func (c *GossipConnection) GetPeer() network.Peer {
 }
 
 // GetTunnel implements network.Connection.
-func (c *GossipConnection) GetTunnel() tunnel.DuplexTunnel {
+func (c *GossipConnection) GetTunnel() tunnel.TopicTunnel {
 	return c.tunnel
 }
 
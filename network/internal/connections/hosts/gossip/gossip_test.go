@@ -2,15 +2,25 @@ package gossip_test
 
 import (
 	"crypto/rand"
+	"errors"
+	"io"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/framing"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/messages"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
 	"github.com/titosilva/drmchain-pos/network/networkdi"
 )
 
+const testTopic = "test"
+
 func Test__TwoGossipHostsConnecting(t *testing.T) {
 	keySeed := make([]byte, 32)
 	if _, err := rand.Read(keySeed); err != nil {
@@ -41,8 +51,8 @@ func Test__TwoGossipHostsConnecting(t *testing.T) {
 	}
 	defer g2.Close()
 
-	session1 := g1.GetSessions().GenerateSession(g2.GetPeer(), keySeed)
-	session2 := sessions.NewSession(session1.Id, session1.KeySeed, g1.GetPeer())
+	session1 := g1.GetSessions().GenerateSession(g2.GetPeer(), keySeed, ciphersuites.ChaCha20Poly1305)
+	session2 := sessions.NewSession(session1.Id, session1.KeySeed, session1.CipherSuite, g1.GetPeer())
 	g2.GetSessions().RegisterSession(session2)
 
 	conn, err := g1.ConnectTo(session1)
@@ -57,9 +67,9 @@ func Test__TwoGossipHostsConnecting(t *testing.T) {
 	tun1 := conn.GetTunnel()
 	tun2 := c2conns[0].GetTunnel()
 
-	sub2 := tun2.Subscribe()
+	sub2 := tun2.Handle(testTopic)
 	defer sub2.Close()
-	tun1.Send([]byte("Hello, world!"))
+	tun1.Send(testTopic, []byte("Hello, world!"))
 	msg, ok := sub2.WaitNextWithTimeoutMs(5000)
 	if !ok {
 		t.Fatalf("Timeout waiting for message")
@@ -69,7 +79,7 @@ func Test__TwoGossipHostsConnecting(t *testing.T) {
 		t.Fatalf("Expected message 'Hello, world!', got '%s'", string(msg))
 	}
 
-	tun1.Send([]byte("Goodbye, world! 2"))
+	tun1.Send(testTopic, []byte("Goodbye, world! 2"))
 	msg, ok = sub2.WaitNextWithTimeoutMs(5000)
 	if !ok {
 		t.Fatalf("Timeout waiting for message")
@@ -79,8 +89,8 @@ func Test__TwoGossipHostsConnecting(t *testing.T) {
 		t.Fatalf("Expected message 'Goodbye, world! 2', got '%s'", string(msg))
 	}
 
-	sub1 := tun1.Subscribe()
-	tun2.Send([]byte("Hello, world! 3"))
+	sub1 := tun1.Handle(testTopic)
+	tun2.Send(testTopic, []byte("Hello, world! 3"))
 	msg, ok = sub1.WaitNextWithTimeoutMs(5000)
 	if !ok {
 		t.Fatalf("Timeout waiting for message")
@@ -101,3 +111,195 @@ func Test__TwoGossipHostsConnecting(t *testing.T) {
 	g1.Close()
 	g2.Close()
 }
+
+func Test__Resume__ShouldConnectWithTicket__WhenPeerIssuedOne(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+
+	g1 := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+	g2 := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+
+	if err := g1.Listen("localhost:55003", func(c network.Connection) {}); err != nil {
+		t.Fatalf("Error listening on g1: %s", err)
+	}
+	defer g1.Close()
+
+	c2conns := make(chan network.Connection, 2)
+	if err := g2.Listen("localhost:55004", func(c network.Connection) { c2conns <- c }); err != nil {
+		t.Fatalf("Error listening on g2: %s", err)
+	}
+	defer g2.Close()
+
+	session1 := g1.GetSessions().GenerateSession(g2.GetPeer(), make([]byte, 32), ciphersuites.AES256GCM)
+	session2 := sessions.NewSession(session1.Id, session1.KeySeed, session1.CipherSuite, g1.GetPeer())
+	g2.GetSessions().RegisterSession(session2)
+
+	first, err := g1.ConnectTo(session1)
+	if err != nil {
+		t.Fatalf("Error connecting g1 to g2: %s", err)
+	}
+	defer first.GetTunnel().Close()
+	<-c2conns
+
+	// Act
+	var resumed network.Connection
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		resumed, err = g1.Resume(g2.GetPeer())
+		if !errors.Is(err, gossip.ErrNoTicket) {
+			break
+		}
+	}
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Error resuming session: %s", err)
+	}
+	defer resumed.GetTunnel().Close()
+
+	conn2 := <-c2conns
+	sub2 := conn2.GetTunnel().Handle(testTopic)
+	defer sub2.Close()
+
+	resumed.GetTunnel().Send(testTopic, []byte("Resumed"))
+	msg, ok := sub2.WaitNextWithTimeoutMs(5000)
+	if !ok || string(msg) != "Resumed" {
+		t.Fatalf("Expected message 'Resumed', got '%s'", string(msg))
+	}
+}
+
+func Test__Listen__ShouldNotResume__WhenInitiatorDoesNotProveTheKeys(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+
+	g1 := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+	g2 := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+
+	if err := g1.Listen("localhost:55006", func(c network.Connection) {}); err != nil {
+		t.Fatalf("Error listening on g1: %s", err)
+	}
+	defer g1.Close()
+
+	c2conns := make(chan network.Connection, 2)
+	if err := g2.Listen("localhost:55007", func(c network.Connection) { c2conns <- c }); err != nil {
+		t.Fatalf("Error listening on g2: %s", err)
+	}
+	defer g2.Close()
+
+	session1 := g1.GetSessions().GenerateSession(g2.GetPeer(), make([]byte, 32), ciphersuites.AES256GCM)
+	session2 := sessions.NewSession(session1.Id, session1.KeySeed, session1.CipherSuite, g1.GetPeer())
+	g2.GetSessions().RegisterSession(session2)
+
+	first, err := g1.ConnectTo(session1)
+	if err != nil {
+		t.Fatalf("Error connecting g1 to g2: %s", err)
+	}
+	defer first.GetTunnel().Close()
+	<-c2conns
+
+	// The attacker saw the ticket id in clear, but does not have the keys of the session
+	var ticket *sessions.Ticket
+	for start := time.Now(); ticket == nil && time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		ticket = g1.GetSessions().TakeReceivedTicket(g2.GetPeer().Id.GetTag())
+	}
+	if ticket == nil {
+		t.Fatal("Expected g2 to issue a ticket")
+	}
+
+	conn, err := net.Dial("tcp", "localhost:55007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	init, err := encodings.Encode(messages.InitMessage{TicketId: ticket.Id, Nonce: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := framing.Write(conn, init); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := framing.NewReader(conn).Next(); err != nil {
+		t.Fatal("Expected a resumption answer: ", err)
+	}
+
+	if err := framing.Write(conn, []byte("not sealed")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the host to close the connection, got %v", err)
+	}
+
+	select {
+	case <-c2conns:
+		t.Fatal("Expected no connection to be reported")
+	default:
+	}
+
+	// The ticket was not used, so its holder can still resume
+	g1.GetSessions().StoreReceivedTicket(ticket)
+	resumed, err := g1.Resume(g2.GetPeer())
+	if err != nil {
+		t.Fatalf("Error resuming session: %s", err)
+	}
+	resumed.GetTunnel().Close()
+}
+
+func Test__Resume__ShouldFail__WhenThereIsNoTicket(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+
+	g1 := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+	g2 := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+
+	// Act
+	_, err := g1.Resume(g2.GetPeer())
+
+	// Assert
+	if !errors.Is(err, gossip.ErrNoTicket) {
+		t.Fatalf("Expected ErrNoTicket, got %v", err)
+	}
+}
+
+func Test__Listen__ShouldCloseConnection__WhenInitHasNeitherTicketNorSession(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+
+	g := gossip.GetFromDI(diCtx).(*gossip.GossipHost)
+	if err := g.Listen("localhost:55005", func(network.Connection) {}); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer g.Close()
+
+	conn, err := net.Dial("tcp", "localhost:55005")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	init, err := encodings.Encode(messages.InitMessage{Nonce: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := framing.Write(conn, init); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the host to close the connection, got %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 package gossiptunnel
 
 import (
-	"context"
+	"crypto/ecdh"
 	"crypto/sha256"
 	"errors"
 	"io"
@@ -11,41 +11,120 @@ import (
 	"time"
 
 	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/keyexchange"
 	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
-	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cqueue"
 	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/internal/utils/uuid"
 
 	"github.com/titosilva/drmchain-pos/network/encodings"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/gossiptunnel/internal/gossipseal"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/framing"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/messages"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+	"github.com/titosilva/drmchain-pos/network/reputation"
 	"golang.org/x/crypto/hkdf"
 )
 
+const (
+	// Messages sent and not yet acknowledged. Send blocks while the window is full.
+	DefaultWindowSize = 64
+
+	// Unacknowledged messages are sent again after this timeout, doubled at each retransmission
+	defaultRetransmitTimeout = time.Second
+	maxRetransmitTimeout     = 8 * time.Second
+
+	// A ping is sent when nothing was sent for the keep-alive interval.
+	// The tunnel is closed when nothing is received for the idle timeout.
+	DefaultKeepAliveInterval = 5 * time.Second
+	DefaultIdleTimeout       = 15 * time.Second
+
+	// The side that sent the init negotiates new keys with the peer every rekey interval,
+	// or sooner once this many seals were exchanged since the last rekey, long before a sealer runs out of sequences
+	DefaultRekeyInterval = 10 * time.Minute
+	DefaultRekeyMessages = 1 << 20
+
+	timersCheckInterval = 100 * time.Millisecond
+)
+
+var ErrInitTimeout = errors.New("timed out waiting for the peer to answer the init")
+
+type pendingMessage struct {
+	seq  int
+	data []byte
+}
+
+// GossipTunnel carries messages over a TCP connection, sealed with the keys of a session.
+// Each side seals what it sends with selfSealer and opens what it receives with peerSealer.
+//
+// Data messages are pipelined: up to windowSize messages can be in flight, and the peer
+// acknowledges them cumulatively. Messages not acknowledged in time are sent again (go-back-N).
+// Idle tunnels are kept alive with pings, and a tunnel whose peer goes silent is closed.
+//
+// Keys are renegotiated periodically with an ephemeral key exchange over control messages.
+// Each direction switches keys right after a marker message, so no seal is ever ambiguous.
 type GossipTunnel struct {
 	selfSealer           *gossipseal.GossipSealer
 	peerSealer           *gossipseal.GossipSealer
 	defaultAnswerTimeout time.Duration
 
 	session *sessions.Session
+	selfTag string
+	peerTag string
 	conn    net.Conn
-	connMux *sync.RWMutex
+	frames  *framing.Reader
+
+	// sendMux orders the seals with the writes. It is taken before stateMux.
+	sendMux  *sync.Mutex
+	stateMux *sync.Mutex
+
+	window            chan struct{} // Holds a token for each message in flight
+	pending           []pendingMessage
+	nextMessageSeq    int
+	lastProgress      time.Time
+	retransmitTimeout time.Duration
+
+	keepAliveInterval time.Duration
+	idleTimeout       time.Duration
+	lastSent          time.Time
+	lastReceived      time.Time
+
+	// Only touched by listenConnectionLoop, except for ackSeq, which is read by ackLoop
+	expectedMessageSeq int
+	ackSeq             int
+	ackSignal          chan struct{}
+
+	// Only the initiator starts rekeys, so both sides never start one at the same time
+	isInitiator     bool
+	rekeyInterval   time.Duration
+	rekeyMessages   int
+	sealsSinceRekey int // Sealed and opened, in both directions
+	lastRekey       time.Time
+	keySeed         []byte
+	rekeyKey        *ecdh.PrivateKey // Ephemeral key of the rekey we started, until the peer answers
+	pendingPeerSeed []byte           // Keys the peer seals with after its rekey-done
+	epoch           int
+
+	// Resumption tickets are issued and stored in tickets, if set
+	tickets        *sessions.Memory
+	ticketLifetime time.Duration
+
+	// Misbehavior of the peer is reported to reputation, if set
+	reputation *reputation.Reputation
 
-	messagesToSend     *cqueue.CQueue[[]byte]
 	receivedObservable *observable.Observable[[]byte]
-	controlObservable  *observable.Observable[[]byte]
-	closedObservable   *observable.Observable[struct{}]
+	closeMux           *sync.Mutex
+	done               chan struct{}
 	isClosed           bool
 }
 
 // New creates a new GossipTunnel.
 func New(conn net.Conn, session *sessions.Session, selfId identity.PrivateIdentity) (*GossipTunnel, error) {
-	selfSealer, err := createSealer(session, selfId.GetTag())
+	selfSealer, err := createSealer(session, session.KeySeed, selfId.GetTag())
 	if err != nil {
 		return nil, err
 	}
 
-	peerSealer, err := createSealer(session, session.Peer.Id.GetTag())
+	peerSealer, err := createSealer(session, session.KeySeed, session.Peer.Id.GetTag())
 	if err != nil {
 		return nil, err
 	}
@@ -54,33 +133,122 @@ func New(conn net.Conn, session *sessions.Session, selfId identity.PrivateIdenti
 		selfSealer:           selfSealer,
 		peerSealer:           peerSealer,
 		defaultAnswerTimeout: 5 * time.Second,
-		connMux:              &sync.RWMutex{},
-		isClosed:             false,
 
-		session:            session,
-		conn:               conn,
-		messagesToSend:     cqueue.New[[]byte](),
+		session:  session,
+		selfTag:  selfId.GetTag(),
+		peerTag:  session.Peer.Id.GetTag(),
+		conn:     conn,
+		frames:   framing.NewReader(conn),
+		sendMux:  &sync.Mutex{},
+		stateMux: &sync.Mutex{},
+
+		window:            make(chan struct{}, DefaultWindowSize),
+		pending:           make([]pendingMessage, 0),
+		retransmitTimeout: defaultRetransmitTimeout,
+
+		keepAliveInterval: DefaultKeepAliveInterval,
+		idleTimeout:       DefaultIdleTimeout,
+
+		ackSeq:    -1,
+		ackSignal: make(chan struct{}, 1),
+
+		rekeyInterval: DefaultRekeyInterval,
+		rekeyMessages: DefaultRekeyMessages,
+		keySeed:       session.KeySeed,
+
 		receivedObservable: observable.New[[]byte](),
-		controlObservable:  observable.New[[]byte](),
-		closedObservable:   observable.New[struct{}](),
+		closeMux:           &sync.Mutex{},
+		done:               make(chan struct{}),
+		isClosed:           false,
 	}
 
 	return gt, nil
 }
 
+// SetWindowSize changes how many messages can be in flight. It must be called before anything is sent.
+func (g *GossipTunnel) SetWindowSize(size int) {
+	g.window = make(chan struct{}, size)
+}
+
+// SetKeepAlive changes the keep-alive interval and the idle timeout. It must be called before Start.
+func (g *GossipTunnel) SetKeepAlive(interval time.Duration, idleTimeout time.Duration) {
+	g.keepAliveInterval = interval
+	g.idleTimeout = idleTimeout
+}
+
+// SetRekeyInterval changes how often the keys are renegotiated. It must be called before Start.
+func (g *GossipTunnel) SetRekeyInterval(interval time.Duration) {
+	g.rekeyInterval = interval
+}
+
+// SetRekeyMessages changes how many seals start a rekey. It must be called before Start.
+func (g *GossipTunnel) SetRekeyMessages(count int) {
+	g.rekeyMessages = count
+}
+
+// EnableResumption makes the tunnel issue tickets to resume its session, or store the ones the peer issues.
+// It must be called before Start.
+func (g *GossipTunnel) EnableResumption(tickets *sessions.Memory, lifetime time.Duration) {
+	g.tickets = tickets
+	g.ticketLifetime = lifetime
+}
+
+// ReportTo makes the tunnel report the misbehavior of the peer. It must be called before Start.
+func (g *GossipTunnel) ReportTo(reputation *reputation.Reputation) {
+	g.reputation = reputation
+}
+
+// GetEpoch returns how many rekeys were completed.
+func (g *GossipTunnel) GetEpoch() int {
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+	return g.epoch
+}
+
 func (g *GossipTunnel) SendInit() error {
-	g.conn.SetWriteDeadline(time.Now().Add(g.defaultAnswerTimeout * time.Second))
-	err := g.write([]byte(g.session.Id))
+	init, err := encodings.Encode(messages.InitMessage{SessionId: g.session.Id})
+	if err != nil {
+		return err
+	}
+
+	g.conn.SetWriteDeadline(time.Now().Add(g.defaultAnswerTimeout))
+	err = g.write(init)
+	g.conn.SetWriteDeadline(time.Time{})
 	if err != nil {
 		return err
 	}
 
-	g.conn.SetReadDeadline(time.Now().Add(g.defaultAnswerTimeout * time.Second))
+	return g.AwaitInit()
+}
+
+// AwaitInit waits for the peer to answer the init, proving that it holds the keys of the session.
+// It is called directly when the init was sent by someone else, as when resuming a session.
+func (g *GossipTunnel) AwaitInit() error {
+	if err := g.AwaitProof(); err != nil {
+		return err
+	}
+
+	g.isInitiator = true
+	g.session.MarkConnected()
+	log.Println("connection established with session ", g.session.Id)
+
+	return nil
+}
+
+// AwaitProof waits for the peer to seal the session id, proving that it holds the keys of the session.
+// Unlike AwaitInit, the tunnel does not take the initiator role.
+func (g *GossipTunnel) AwaitProof() error {
+	g.conn.SetReadDeadline(time.Now().Add(g.defaultAnswerTimeout))
 	bs, err := g.read()
+	g.conn.SetReadDeadline(time.Time{})
 	if err != nil {
 		return err
 	}
 
+	if bs == nil {
+		return ErrInitTimeout
+	}
+
 	var shell messages.MessageSeal
 	if err = encodings.Decode(bs, &shell); err != nil {
 		return err
@@ -99,13 +267,10 @@ func (g *GossipTunnel) SendInit() error {
 		return errors.New("wrong session id")
 	}
 
-	g.peerSealer.Update()
-	g.session.MarkConnected()
-	log.Println("connection established with session ", g.session.Id)
-
-	return nil
+	return g.peerSealer.Update()
 }
 
+// AnswerInit seals the session id for the peer, proving that we hold the keys of the session.
 func (g *GossipTunnel) AnswerInit() error {
 	// TODO: this should also challenge the peer
 	sealed, err := g.selfSealer.Seal([]byte(g.session.Id), messages.SealTypeData)
@@ -118,48 +283,50 @@ func (g *GossipTunnel) AnswerInit() error {
 		return err
 	}
 
-	g.conn.SetWriteDeadline(time.Now().Add(g.defaultAnswerTimeout * time.Second))
-	g.write(data)
+	g.conn.SetWriteDeadline(time.Now().Add(g.defaultAnswerTimeout))
+	err = g.write(data)
+	g.conn.SetWriteDeadline(time.Time{})
 
-	return nil
+	return err
 }
 
 func (g *GossipTunnel) Start() {
-	go g.listenConnectionLoop()
-	go g.sendMessagesLoop()
-}
+	g.stateMux.Lock()
+	g.lastSent = time.Now()
+	g.lastReceived = time.Now()
+	g.lastRekey = time.Now()
+	g.stateMux.Unlock()
 
-func createSealer(session *sessions.Session, senderId string) (*gossipseal.GossipSealer, error) {
-	keyGen := hkdf.Expand(sha256.New, session.KeySeed, []byte(senderId))
+	go g.listenConnectionLoop()
+	go g.ackLoop()
+	go g.timersLoop()
 
-	sealer, err := gossipseal.New(session.Id, keyGen)
-	if err != nil {
-		return nil, err
+	if !g.isInitiator && g.tickets != nil {
+		g.issueTicket()
 	}
+}
 
-	return sealer, nil
+func createSealer(session *sessions.Session, keySeed []byte, senderId string) (*gossipseal.GossipSealer, error) {
+	return gossipseal.New(session.Id, session.CipherSuite, keySeed, senderId)
 }
 
 // Close implements tunnel.WritableTunnel.
 func (g *GossipTunnel) Close() error {
-	g.connMux.Lock()
-	defer g.connMux.Unlock()
+	g.closeMux.Lock()
 	if g.isClosed {
+		g.closeMux.Unlock()
 		return nil
 	}
 
-	if err := g.conn.Close(); err != nil {
-		return err
-	}
-
-	g.closedObservable.Notify(struct{}{})
+	g.isClosed = true
+	close(g.done)
+	g.closeMux.Unlock()
 
+	// Closing the connection also unblocks pending reads and writes
+	err := g.conn.Close()
 	g.receivedObservable.Close()
-	g.controlObservable.Close()
-	g.closedObservable.Close()
 
-	g.isClosed = true
-	return nil
+	return err
 }
 
 // Notify implements tunnel.WritableTunnel.
@@ -174,22 +341,57 @@ func (g *GossipTunnel) Subscribe() *observable.Subscription[[]byte] {
 }
 
 // Send implements tunnel.WritableTunnel.
+// It blocks while the window is full, until the peer acknowledges older messages.
 func (g *GossipTunnel) Send(data []byte) error {
-	g.messagesToSend.Enqueue(data)
+	select {
+	case g.window <- struct{}{}:
+	case <-g.done:
+		return &tunnel.ErrorTunnelClosed{}
+	}
+
+	g.sendMux.Lock()
+	defer g.sendMux.Unlock()
+
+	g.stateMux.Lock()
+	msg := pendingMessage{seq: g.nextMessageSeq, data: data}
+	g.nextMessageSeq++
+	if len(g.pending) == 0 {
+		g.lastProgress = time.Now()
+	}
+	g.pending = append(g.pending, msg)
+	g.stateMux.Unlock()
+
+	// A message that fails to be sent stays in the window, and is sent again on retransmission
+	if err := g.sendData(msg); err != nil {
+		log.Println("failed to send message: ", err)
+	}
+
 	return nil
 }
 
 // WaitClose implements tunnel.WritableTunnel.
+// The channel is closed with the tunnel, so it can be waited on even after that.
 func (g *GossipTunnel) WaitClose() <-chan struct{} {
-	return g.closedObservable.Subscribe().Channel()
+	return g.done
 }
 
+func (g *GossipTunnel) closed() bool {
+	select {
+	case <-g.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// listenConnectionLoop reads without a deadline. Close closes the connection, which unblocks the read.
 func (g *GossipTunnel) listenConnectionLoop() {
+	g.conn.SetReadDeadline(time.Time{})
+
 	for {
-		g.conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
 		buf, err := g.read()
 		if err != nil {
-			if g.isClosed {
+			if g.closed() {
 				return
 			}
 
@@ -198,8 +400,10 @@ func (g *GossipTunnel) listenConnectionLoop() {
 				return
 			}
 
-			log.Println("failed to read from connection: ", err)
-			continue
+			// Either a broken connection or a bad length prefix, after which the stream cannot be resynchronized
+			log.Println("failed to read from connection. Closing connection: ", err)
+			g.Close()
+			return
 		}
 
 		if buf == nil {
@@ -212,151 +416,433 @@ func (g *GossipTunnel) listenConnectionLoop() {
 			continue
 		}
 
-		sealer := g.peerSealer
-		if sealed.Type == messages.SealTypeControl {
-			sealer = g.selfSealer
-		}
-
-		if sealed.Sequence < sealer.GetCurrentSeq() {
-			log.Println("invalid sequence - unrecoverable. Sending error and closing connection")
-			g.sendError(messages.ErrorTypeWrongSeq, sealed.Sequence)
+		// Seals arrive in order on the stream, so any other sequence means the tunnel was tampered with
+		if sealed.SessionId != g.session.Id || sealed.Sequence != g.peerSealer.GetCurrentSeq() {
+			log.Println("unexpected seal session or sequence. Closing connection")
+			g.report(reputation.MisbehaviorWrongSequence)
 			g.Close()
-			continue
+			return
 		}
 
-		if sealed.SessionId != g.session.Id {
-			log.Println("wrong session id")
-			g.sendError(messages.ErrorTypeWrongSession, sealed.Sequence)
-			continue
+		data, err := g.peerSealer.Unseal(&sealed)
+		if err != nil {
+			log.Println("failed to unseal message. Closing connection: ", err)
+			g.report(reputation.MisbehaviorBadSignature)
+			g.Close()
+			return
 		}
 
-		// TODO: this allows an attacker to flood the connection with messages, because seq is not verified in the MAC yet. Rethink this.
-		if sealed.Sequence > sealer.GetCurrentSeq() {
-			log.Println("invalid sequence - recoverable. Trying to recover")
-
-			if err := g.peerSealer.UpdateToSeq(sealed.Sequence); err != nil {
-				log.Println("error during recovery", err)
-				g.sendError(messages.ErrorTypeInternalError, sealed.Sequence)
-				continue
-			}
-
-			log.Println("recovery successful")
-			continue
+		if err := g.peerSealer.Update(); err != nil {
+			log.Println("peer ran out of sequences. Closing connection: ", err)
+			g.Close()
+			return
 		}
 
-		data, err := sealer.Unseal(&sealed)
-		if err != nil {
-			log.Println("failed to unseal message: ", err)
-			g.sendError(messages.ErrorTypeInvalidSeal, sealed.Sequence)
-			continue
-		}
+		g.stateMux.Lock()
+		g.lastReceived = time.Now()
+		g.sealsSinceRekey++
+		g.stateMux.Unlock()
 
-		sealer.Update()
 		if sealed.Type == messages.SealTypeData {
-			g.sendSuccess(sealed.Sequence)
-			g.receivedObservable.Notify(data)
+			g.handleData(data)
 		} else {
-			g.controlObservable.Notify(data)
+			g.handleControl(data)
 		}
 	}
 }
 
-func (g *GossipTunnel) sendMessagesLoop() {
-	for {
-		if g.isClosed {
-			return
-		}
+// handleData delivers the message if it is the next one expected.
+// Retransmitted duplicates and messages after a gap are dropped, the ack tells the peer where to resume.
+func (g *GossipTunnel) handleData(data []byte) {
+	var msg messages.DataMessage
+	if err := encodings.Decode(data, &msg); err != nil {
+		log.Println("failed to decode data message: ", err)
+		g.report(reputation.MisbehaviorUndecodable)
+		return
+	}
 
-		data, ok := g.messagesToSend.Dequeue()
-		if !ok {
-			continue
-		}
+	if msg.MessageSeq == g.expectedMessageSeq {
+		g.expectedMessageSeq++
+		g.receivedObservable.Notify(msg.Data)
+	}
 
-		sub := g.controlObservable.Subscribe()
-		err := g.immediateSend(data, g.selfSealer, messages.SealTypeData)
+	g.stateMux.Lock()
+	g.ackSeq = g.expectedMessageSeq - 1
+	g.stateMux.Unlock()
 
-		if err != nil {
-			log.Println("failed to send message: ", err)
-			g.messagesToSend.Enqueue(data)
-			sub.Unsubscribe()
-			continue
-		}
+	// Acks are written by ackLoop, so reading never waits on a write
+	select {
+	case g.ackSignal <- struct{}{}:
+	default:
+	}
+}
 
-		control, err := g.waitControlMessageForSeq(sub, g.selfSealer.GetCurrentSeq()-1)
-		if err != nil {
-			log.Println("failed to wait for answer message: ", err)
-			g.messagesToSend.Enqueue(data)
-			sub.Unsubscribe()
-			continue
-		}
+func (g *GossipTunnel) handleControl(data []byte) {
+	var control messages.ControlMessage
+	if err := encodings.Decode(data, &control); err != nil {
+		log.Println("failed to decode control message: ", err)
+		g.report(reputation.MisbehaviorUndecodable)
+		return
+	}
 
-		if !control.Succeed {
-			log.Println("message not accepted. Retrying. Received error ", control.ErrorType)
-			g.messagesToSend.Enqueue(data)
-			sub.Unsubscribe()
-			continue
+	switch control.Kind {
+	case messages.ControlKindAck:
+		g.handleAck(control)
+	case messages.ControlKindRekey:
+		g.answerRekey(control)
+	case messages.ControlKindRekeyAck:
+		g.completeRekey(control)
+	case messages.ControlKindRekeyDone:
+		g.switchPeerKeys()
+	case messages.ControlKindTicket:
+		g.storeTicket(control)
+	case messages.ControlKindPing:
+		// Pings only refresh lastReceived
+	}
+}
+
+func (g *GossipTunnel) handleAck(control messages.ControlMessage) {
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+
+	acked := 0
+	for len(g.pending) > 0 && g.pending[0].seq <= control.MessageSeq {
+		g.pending = g.pending[1:]
+		acked++
+	}
+
+	if acked == 0 {
+		return
+	}
+
+	g.lastProgress = time.Now()
+	g.retransmitTimeout = defaultRetransmitTimeout
+	for range acked {
+		<-g.window
+	}
+}
+
+// ackLoop sends the latest cumulative ack whenever new data is received.
+// Acks for messages received while an ack is being written are coalesced.
+func (g *GossipTunnel) ackLoop() {
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-g.ackSignal:
+			g.stateMux.Lock()
+			ackSeq := g.ackSeq
+			g.stateMux.Unlock()
+
+			if err := g.sendControl(messages.ControlMessage{Kind: messages.ControlKindAck, MessageSeq: ackSeq}); err != nil {
+				log.Println("failed to send ack: ", err)
+			}
 		}
 	}
 }
 
-func (g *GossipTunnel) waitControlMessageForSeq(sub *observable.Subscription[[]byte], seq int) (messages.ControlMessage, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), g.defaultAnswerTimeout)
-	defer cancel()
+// timersLoop retransmits, pings and closes the tunnel when the peer is silent.
+func (g *GossipTunnel) timersLoop() {
+	ticker := time.NewTicker(timersCheckInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-ctx.Done():
-			return messages.ControlMessage{}, errors.New("timeout waiting for response")
-		case msg := <-sub.Channel():
-			var control messages.ControlMessage
-			if err := encodings.Decode(msg, &control); err != nil {
-				continue
+		case <-g.done:
+			return
+		case <-ticker.C:
+			g.stateMux.Lock()
+			sinceSent, sinceReceived := time.Since(g.lastSent), time.Since(g.lastReceived)
+			g.stateMux.Unlock()
+
+			if sinceReceived >= g.idleTimeout {
+				log.Println("peer silent for ", sinceReceived, ". Closing tunnel with session ", g.session.Id)
+				g.Close()
+				return
+			}
+
+			if sinceSent >= g.keepAliveInterval {
+				if err := g.sendControl(messages.ControlMessage{Kind: messages.ControlKindPing}); err != nil {
+					log.Println("failed to send ping: ", err)
+				}
 			}
 
-			if control.MessageSeq == seq {
-				return control, nil
+			g.retransmitIfStale()
+
+			if g.rekeyDue() {
+				g.startRekey()
 			}
 		}
 	}
 }
 
-func (g *GossipTunnel) sendSuccess(messageSeq int) {
-	controlMessage := messages.ControlMessage{
-		Succeed:    true,
-		MessageSeq: messageSeq,
+func (g *GossipTunnel) rekeyDue() bool {
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+
+	return g.isInitiator && g.rekeyKey == nil &&
+		(time.Since(g.lastRekey) >= g.rekeyInterval || g.sealsSinceRekey >= g.rekeyMessages)
+}
+
+// startRekey sends a fresh ephemeral key to the peer. The rekey is completed when the peer answers with its own.
+func (g *GossipTunnel) startRekey() {
+	key, err := keyexchange.GenerateEphemeralKey()
+	if err != nil {
+		log.Println("failed to generate rekey key: ", err)
+		return
+	}
+
+	g.stateMux.Lock()
+	g.rekeyKey = key
+	g.stateMux.Unlock()
+
+	control := messages.ControlMessage{Kind: messages.ControlKindRekey, EphKey: keyexchange.KeyToBytes(key.PublicKey())}
+	if err := g.sendControl(control); err != nil {
+		log.Println("failed to send rekey: ", err)
+	}
+}
+
+// answerRekey derives the next keys from the ephemeral key of the peer, then answers with its own ephemeral key.
+// The peer keeps its keys until its rekey-done.
+func (g *GossipTunnel) answerRekey(control messages.ControlMessage) {
+	key, err := keyexchange.GenerateEphemeralKey()
+	if err != nil {
+		g.failRekey(err)
+		return
+	}
+
+	seed, err := g.nextSeed(key, control.EphKey)
+	if err != nil {
+		g.failRekey(err)
+		return
+	}
+
+	g.stateMux.Lock()
+	g.pendingPeerSeed = seed
+	g.stateMux.Unlock()
+
+	answer := messages.ControlMessage{Kind: messages.ControlKindRekeyAck, EphKey: keyexchange.KeyToBytes(key.PublicKey())}
+
+	// Written by another goroutine, so reading never waits on a write
+	go func() {
+		if err := g.switchSelfKeys(answer, seed); err != nil {
+			g.failRekey(err)
+		}
+	}()
+}
+
+// completeRekey derives the next keys from the answer of the peer, which already seals with them.
+func (g *GossipTunnel) completeRekey(control messages.ControlMessage) {
+	g.stateMux.Lock()
+	key := g.rekeyKey
+	g.stateMux.Unlock()
+
+	if key == nil {
+		g.failRekey(errors.New("rekey answered, but none was started"))
+		return
+	}
+
+	seed, err := g.nextSeed(key, control.EphKey)
+	if err != nil {
+		g.failRekey(err)
+		return
+	}
+
+	peerSealer, err := createSealer(g.session, seed, g.peerTag)
+	if err != nil {
+		g.failRekey(err)
+		return
+	}
+	g.peerSealer = peerSealer
+
+	go func() {
+		if err := g.switchSelfKeys(messages.ControlMessage{Kind: messages.ControlKindRekeyDone}, seed); err != nil {
+			g.failRekey(err)
+			return
+		}
+
+		g.stateMux.Lock()
+		g.rekeyKey = nil
+		g.lastRekey = time.Now()
+		g.sealsSinceRekey = 0
+		g.epoch++
+		g.stateMux.Unlock()
+	}()
+}
+
+// switchPeerKeys opens what follows the rekey-done of the peer with the keys derived in answerRekey.
+func (g *GossipTunnel) switchPeerKeys() {
+	g.stateMux.Lock()
+	seed := g.pendingPeerSeed
+	g.pendingPeerSeed = nil
+	g.stateMux.Unlock()
+
+	if seed == nil {
+		g.failRekey(errors.New("rekey done, but none was answered"))
+		return
+	}
+
+	peerSealer, err := createSealer(g.session, seed, g.peerTag)
+	if err != nil {
+		g.failRekey(err)
+		return
+	}
+	g.peerSealer = peerSealer
+
+	g.stateMux.Lock()
+	g.epoch++
+	g.stateMux.Unlock()
+}
+
+// nextSeed mixes the ephemeral secret into the current key seed, so keys stay secret even if an older seed leaks.
+func (g *GossipTunnel) nextSeed(key *ecdh.PrivateKey, peerKeyBytes []byte) ([]byte, error) {
+	peerKey, err := keyexchange.BytesToKey(peerKeyBytes)
+	if err != nil {
+		return nil, err
 	}
 
-	encoded, err := encodings.Encode(controlMessage)
+	secret, err := key.ECDH(peerKey)
 	if err != nil {
-		log.Println("failed to encode success message: ", err)
+		return nil, err
+	}
+
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+
+	g.keySeed = hkdf.Extract(sha256.New, secret, g.keySeed)
+	return g.keySeed, nil
+}
+
+// switchSelfKeys sends the control message with the current keys, and seals everything after it with the keys from seed.
+func (g *GossipTunnel) switchSelfKeys(control messages.ControlMessage, seed []byte) error {
+	encoded, err := encodings.Encode(control)
+	if err != nil {
+		return err
+	}
+
+	sealer, err := createSealer(g.session, seed, g.selfTag)
+	if err != nil {
+		return err
 	}
 
-	err = g.immediateSend(encoded, g.peerSealer, messages.SealTypeControl)
+	g.sendMux.Lock()
+	defer g.sendMux.Unlock()
+
+	if err := g.immediateSend(encoded, messages.SealTypeControl); err != nil {
+		return err
+	}
+
+	g.selfSealer = sealer
+	return nil
+}
+
+// failRekey closes the tunnel, since the sides may no longer agree on the keys
+func (g *GossipTunnel) failRekey(err error) {
+	log.Println("failed to rekey. Closing tunnel with session ", g.session.Id, ": ", err)
+	g.Close()
+}
+
+// issueTicket lets the peer resume the session later, without a handshake.
+func (g *GossipTunnel) issueTicket() {
+	ticket, err := sessions.NewTicket(g.session, uuid.NewUuid(), g.ticketLifetime)
 	if err != nil {
-		log.Println("failed to send success message: ", err)
+		log.Println("failed to create ticket: ", err)
+		return
+	}
+
+	g.tickets.StoreIssuedTicket(ticket)
+
+	control := messages.ControlMessage{
+		Kind:           messages.ControlKindTicket,
+		TicketId:       ticket.Id,
+		TicketLifetime: int(g.ticketLifetime / time.Second),
+	}
+
+	if err := g.sendControl(control); err != nil {
+		log.Println("failed to send ticket: ", err)
 	}
 }
 
-func (g *GossipTunnel) sendError(errorType string, messageSeq int) {
-	controlMessage := messages.ControlMessage{
-		Succeed:    false,
-		ErrorType:  errorType,
-		MessageSeq: messageSeq,
+func (g *GossipTunnel) storeTicket(control messages.ControlMessage) {
+	if g.tickets == nil {
+		return
 	}
 
-	encoded, err := encodings.Encode(controlMessage)
+	ticket, err := sessions.NewTicket(g.session, control.TicketId, time.Duration(control.TicketLifetime)*time.Second)
 	if err != nil {
-		log.Println("failed to encode success message: ", err)
+		log.Println("failed to create ticket: ", err)
+		return
 	}
 
-	err = g.immediateSend(encoded, g.peerSealer, messages.SealTypeControl)
+	g.tickets.StoreReceivedTicket(ticket)
+}
+
+func (g *GossipTunnel) sendControl(control messages.ControlMessage) error {
+	encoded, err := encodings.Encode(control)
 	if err != nil {
-		log.Println("failed to send error message: ", err)
+		return err
 	}
+
+	g.sendMux.Lock()
+	defer g.sendMux.Unlock()
+
+	return g.immediateSend(encoded, messages.SealTypeControl)
 }
 
-func (g *GossipTunnel) immediateSend(data []byte, sealer *gossipseal.GossipSealer, sealType string) error {
-	sealed, err := sealer.Seal(data, sealType)
+// retransmitIfStale sends the whole window again if no ack was received within the retransmission timeout.
+func (g *GossipTunnel) retransmitIfStale() {
+	g.sendMux.Lock()
+	defer g.sendMux.Unlock()
+
+	g.stateMux.Lock()
+	if len(g.pending) == 0 || time.Since(g.lastProgress) < g.retransmitTimeout {
+		g.stateMux.Unlock()
+		return
+	}
+
+	toSend := append([]pendingMessage(nil), g.pending...)
+	g.lastProgress = time.Now()
+	g.retransmitTimeout = min(2*g.retransmitTimeout, maxRetransmitTimeout)
+	g.stateMux.Unlock()
+
+	log.Println("retransmitting ", len(toSend), " messages with session ", g.session.Id)
+	for _, msg := range toSend {
+		if err := g.sendData(msg); err != nil {
+			log.Println("failed to retransmit message: ", err)
+			return
+		}
+	}
+}
+
+func (g *GossipTunnel) report(misbehavior reputation.Misbehavior) {
+	if g.reputation == nil {
+		return
+	}
+
+	if err := g.reputation.Report(g.peerTag, misbehavior); err != nil {
+		log.Println("failed to save ban of ", g.peerTag, ": ", err)
+	}
+}
+
+// sendData must be called with sendMux held
+func (g *GossipTunnel) sendData(msg pendingMessage) error {
+	encoded, err := encodings.Encode(messages.DataMessage{MessageSeq: msg.seq, Data: msg.data})
+	if err != nil {
+		return err
+	}
+
+	return g.immediateSend(encoded, messages.SealTypeData)
+}
+
+// immediateSend must be called with sendMux held, so seals are written in sequence order
+func (g *GossipTunnel) immediateSend(data []byte, sealType string) error {
+	sealed, err := g.selfSealer.Seal(data, sealType)
 	if err != nil {
+		// Without a rekey in time, the keys are never reused: the tunnel is closed instead
+		if errors.Is(err, gossipseal.ErrSequenceExhausted) {
+			g.Close()
+		}
+
 		return err
 	}
 
@@ -367,7 +853,7 @@ func (g *GossipTunnel) immediateSend(data []byte, sealer *gossipseal.GossipSeale
 
 	err = g.write(encoded)
 	if err != nil {
-		if g.isClosed {
+		if g.closed() {
 			return nil
 		}
 
@@ -378,22 +864,22 @@ func (g *GossipTunnel) immediateSend(data []byte, sealer *gossipseal.GossipSeale
 		return err
 	}
 
+	g.stateMux.Lock()
+	g.lastSent = time.Now()
+	g.sealsSinceRekey++
+	g.stateMux.Unlock()
+
 	return nil
 }
 
 func (g *GossipTunnel) write(data []byte) error {
-	g.connMux.RLock()
-	defer g.connMux.RUnlock()
-	_, err := g.conn.Write(data)
-	return err
+	return framing.Write(g.conn, data)
 }
 
+// read returns the next message, or nil if the read deadline passes before it is complete.
+// The part already read is kept for the next call.
 func (g *GossipTunnel) read() ([]byte, error) {
-	g.connMux.RLock()
-	// TODO: handle messages bigger than 1024 bytes
-	buf := make([]byte, 1024)
-	n, err := g.conn.Read(buf)
-	g.connMux.RUnlock()
+	frame, err := g.frames.Next()
 
 	if err != nil {
 		netErr, ok := err.(net.Error)
@@ -405,7 +891,7 @@ func (g *GossipTunnel) read() ([]byte, error) {
 		return nil, err
 	}
 
-	return buf[:n], nil
+	return frame, nil
 }
 
 // GossipTunnel implements tunnel.Tunnel
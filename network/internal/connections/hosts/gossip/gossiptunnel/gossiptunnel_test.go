@@ -1,8 +1,13 @@
 package gossiptunnel_test
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"log"
 	"net"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -10,6 +15,7 @@ import (
 	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/gossiptunnel"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
 )
@@ -46,11 +52,11 @@ func Test__TwoGossipTunnels__ShouldExchangeMessagesCorrectly(t *testing.T) {
 	defer tun2.Close()
 
 	c := make(chan []byte)
+	sub := tun2.Subscribe()
 	go func(c chan []byte) {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		sub := tun2.Subscribe()
 		for {
 			select {
 			case <-ctx.Done():
@@ -85,6 +91,344 @@ func Test__TwoGossipTunnels__ShouldExchangeMessagesCorrectly(t *testing.T) {
 	tun2.Close()
 }
 
+func Test__TwoGossipTunnels__ShouldKeepMessageBoundaries__WhenSmallAndLargeMessagesInterleave(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	selfId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "localhost:4322")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn1, conn2, err := makeSelfConnections(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tun1, err := gossiptunnel.New(conn1, makeSession(selfId, conn1), selfId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun1.Close()
+
+	tun2, err := gossiptunnel.New(conn2, makeSession(selfId, conn2), selfId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun2.Close()
+
+	sent := [][]byte{
+		[]byte("small"),
+		bytes.Repeat([]byte("block"), 1<<20),
+		[]byte("tiny"),
+		bytes.Repeat([]byte{7}, 3<<20),
+		[]byte("last"),
+	}
+
+	sub := tun2.Subscribe()
+	tun1.Start()
+	tun2.Start()
+
+	// Act
+	for _, msg := range sent {
+		tun1.Send(msg)
+	}
+
+	// Assert
+	timeout := time.After(30 * time.Second)
+	for i, expected := range sent {
+		select {
+		case received := <-sub.Channel():
+			if !bytes.Equal(received, expected) {
+				t.Fatalf("Message %d: expected %d bytes, got %d bytes", i, len(expected), len(received))
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for message %d", i)
+		}
+	}
+}
+
+func Test__Send__ShouldBlock__WhenWindowIsFull(t *testing.T) {
+	// Arrange
+	tun1, tun2 := makeTunnels(t)
+	tun1.SetWindowSize(2)
+	tun1.Start()
+
+	tun1.Send([]byte("first"))
+	tun1.Send([]byte("second"))
+
+	// Act
+	sent := make(chan struct{})
+	go func() {
+		tun1.Send([]byte("third"))
+		close(sent)
+	}()
+
+	// Assert
+	select {
+	case <-sent:
+		t.Fatal("Expected Send to block until the peer acknowledges")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	tun2.Start()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected Send to return once the peer acknowledges")
+	}
+}
+
+func Test__GossipTunnel__ShouldClose__WhenPeerIsSilent(t *testing.T) {
+	// Arrange
+	tun1, _ := makeTunnels(t)
+	tun1.SetKeepAlive(50*time.Millisecond, 300*time.Millisecond)
+
+	// Act
+	tun1.Start()
+
+	// Assert
+	select {
+	case <-tun1.WaitClose():
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the tunnel to close after the idle timeout")
+	}
+}
+
+func Test__GossipTunnel__ShouldWaitOnOneRead__UntilClosed(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	selfId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipe, other := net.Pipe()
+	defer other.Close()
+	conn := &countingConn{Conn: pipe}
+
+	tun, err := gossiptunnel.New(conn, makeSession(selfId, conn), selfId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	tun.Start()
+	time.Sleep(200 * time.Millisecond)
+	reads := conn.reads.Load()
+	tun.Close()
+
+	// Assert
+	if reads != 1 {
+		t.Errorf("Expected the tunnel to block on a single read, got %d reads", reads)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for conn.pending.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected closing the tunnel to unblock the read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// countingConn counts the reads on the connection, and how many of them did not return yet
+type countingConn struct {
+	net.Conn
+	reads   atomic.Int32
+	pending atomic.Int32
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	c.reads.Add(1)
+	c.pending.Add(1)
+	defer c.pending.Add(-1)
+
+	return c.Conn.Read(b)
+}
+
+func Test__GossipTunnel__ShouldStayOpen__WhenIdlePeersPing(t *testing.T) {
+	// Arrange
+	tun1, tun2 := makeTunnels(t)
+	tun1.SetKeepAlive(50*time.Millisecond, 300*time.Millisecond)
+	tun2.SetKeepAlive(50*time.Millisecond, 300*time.Millisecond)
+
+	// Act
+	tun1.Start()
+	tun2.Start()
+
+	// Assert
+	select {
+	case <-tun1.WaitClose():
+		t.Error("Expected the tunnel to be kept alive")
+	case <-tun2.WaitClose():
+		t.Error("Expected the tunnel to be kept alive")
+	case <-time.After(time.Second):
+	}
+}
+
+func Test__TwoGossipTunnels__ShouldKeepExchangingMessages__WhenRekeying(t *testing.T) {
+	// Arrange
+	tun1, tun2 := makeTunnels(t)
+	tun1.SetRekeyInterval(200 * time.Millisecond)
+
+	answered := make(chan error, 1)
+	go func() { answered <- tun2.AnswerInit() }()
+	if err := tun1.AwaitInit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-answered; err != nil {
+		t.Fatal(err)
+	}
+
+	sub1 := tun1.Subscribe()
+	defer sub1.Close()
+	sub2 := tun2.Subscribe()
+	defer sub2.Close()
+
+	tun1.Start()
+	tun2.Start()
+
+	// Act
+	deadline := time.Now().Add(time.Second)
+	for i := 0; time.Now().Before(deadline); i++ {
+		msg := []byte{byte(i)}
+		tun1.Send(msg)
+		tun2.Send(msg)
+
+		// Assert
+		received, ok := sub2.WaitNextWithTimeoutMs(2000)
+		if !ok || !bytes.Equal(received, msg) {
+			t.Fatalf("Expected message %d from tun1, got %v", i, received)
+		}
+
+		received, ok = sub1.WaitNextWithTimeoutMs(2000)
+		if !ok || !bytes.Equal(received, msg) {
+			t.Fatalf("Expected message %d from tun2, got %v", i, received)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if tun1.GetEpoch() < 2 || tun2.GetEpoch() < 2 {
+		t.Fatalf("Expected at least 2 rekeys, got %d and %d", tun1.GetEpoch(), tun2.GetEpoch())
+	}
+}
+
+func Test__TwoGossipTunnels__ShouldRekey__WhenManyMessagesWereSealed(t *testing.T) {
+	// Arrange
+	tun1, tun2 := makeTunnels(t)
+	tun1.SetRekeyMessages(20)
+
+	answered := make(chan error, 1)
+	go func() { answered <- tun2.AnswerInit() }()
+	if err := tun1.AwaitInit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-answered; err != nil {
+		t.Fatal(err)
+	}
+
+	sub2 := tun2.Subscribe()
+	defer sub2.Close()
+
+	tun1.Start()
+	tun2.Start()
+
+	// Act
+	for i := range 100 {
+		msg := []byte{byte(i)}
+		tun1.Send(msg)
+
+		received, ok := sub2.WaitNextWithTimeoutMs(2000)
+		if !ok || !bytes.Equal(received, msg) {
+			t.Fatalf("Expected message %d, got %v", i, received)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Assert
+	if tun1.GetEpoch() < 1 {
+		t.Fatalf("Expected a rekey long before the rekey interval, got epoch %d", tun1.GetEpoch())
+	}
+}
+
+func BenchmarkSend__StopAndWait(b *testing.B) {
+	benchmarkSend(b, 1)
+}
+
+func BenchmarkSend__Windowed(b *testing.B) {
+	benchmarkSend(b, gossiptunnel.DefaultWindowSize)
+}
+
+// benchmarkSend measures the delivery of 1KiB messages over loopback with the given window size.
+func benchmarkSend(b *testing.B, windowSize int) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tun1, tun2 := makeTunnels(b)
+	tun1.SetWindowSize(windowSize)
+
+	sub := tun2.Subscribe()
+	tun1.Start()
+	tun2.Start()
+
+	msg := bytes.Repeat([]byte{1}, 1024)
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+
+	go func() {
+		for range b.N {
+			tun1.Send(msg)
+		}
+	}()
+
+	for range b.N {
+		<-sub.Channel()
+	}
+}
+
+// makeTunnels connects two tunnels over loopback. They are closed when the test ends.
+func makeTunnels(tb testing.TB) (*gossiptunnel.GossipTunnel, *gossiptunnel.GossipTunnel) {
+	diCtx := defaultdi.ConfigureDefaultDI()
+	selfId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn1, conn2, err := makeSelfConnections(ln)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tun1, err := gossiptunnel.New(conn1, makeSession(selfId, conn1), selfId)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { tun1.Close() })
+
+	tun2, err := gossiptunnel.New(conn2, makeSession(selfId, conn2), selfId)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { tun2.Close() })
+
+	return tun1, tun2
+}
+
 func makeSelfConnections(ln net.Listener) (net.Conn, net.Conn, error) {
 	// makes a server and a client connection
 	// with tcp. Must have buffering
@@ -97,7 +441,7 @@ func makeSelfConnections(ln net.Listener) (net.Conn, net.Conn, error) {
 		c <- conn
 	}(c)
 
-	conn, err := net.Dial("tcp", "localhost:4321")
+	conn, err := net.Dial("tcp", ln.Addr().String())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -106,7 +450,7 @@ func makeSelfConnections(ln net.Listener) (net.Conn, net.Conn, error) {
 }
 
 func makeSession(selfId identity.PrivateIdentity, conn net.Conn) *sessions.Session {
-	return sessions.NewSession("session", []byte("keyseed"), network.Peer{
+	return sessions.NewSession("session", []byte("keyseed"), ciphersuites.AES256GCM, network.Peer{
 		Id:   selfId,
 		Addr: conn.RemoteAddr().String(),
 	})
@@ -1,42 +1,52 @@
 package gossipseal
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"io"
 	"log"
+	"math"
 
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/messages"
+	"golang.org/x/crypto/hkdf"
 )
 
+var ErrSequenceExhausted = errors.New("no sequence left to seal with, keys must be renegotiated")
+
+// MaxSequence is the sequence at which a sealer stops sealing. Tunnels renegotiate keys long before reaching it.
+const MaxSequence = math.MaxUint32
+
 var counter int = 0
 
+// GossipSealer seals the messages of one direction of a tunnel.
+// Each sequence number has its own key and nonce, expanded from the key seed with the sender and the sequence,
+// so no key or nonce is ever used twice and the keys never run out before MaxSequence.
 type GossipSealer struct {
-	sessionId    string
-	currentSeq   int
-	keyGenerator io.Reader
-	currentKeys  []byte
-	currentIv    []byte
-	sealerCount  int
+	sessionId   string
+	suite       ciphersuites.Suite
+	keySeed     []byte
+	senderId    string
+	currentSeq  int
+	currentKeys []byte
+	sealerCount int
 }
 
-func New(sessionId string, keyGenerator io.Reader) (*GossipSealer, error) {
-	iv := make([]byte, 32)
-	if _, err := io.ReadFull(keyGenerator, iv); err != nil {
-		return nil, errors.New("failed to generate IV")
+func New(sessionId string, suite ciphersuites.Suite, keySeed []byte, senderId string) (*GossipSealer, error) {
+	if !suite.IsSupported() {
+		return nil, ciphersuites.ErrUnsupportedSuite
 	}
 
 	gs := &GossipSealer{
-		sessionId:    sessionId,
-		currentSeq:   -1,
-		keyGenerator: keyGenerator,
-		currentKeys:  make([]byte, 48),
-		currentIv:    iv,
-		sealerCount:  counter,
+		sessionId:   sessionId,
+		suite:       suite,
+		keySeed:     keySeed,
+		senderId:    senderId,
+		currentSeq:  -1,
+		currentKeys: make([]byte, ciphersuites.KeySize+ciphersuites.NonceSize),
+		sealerCount: counter,
 	}
 	counter++
 
@@ -51,36 +61,45 @@ func (g *GossipSealer) GetCurrentSeq() int {
 	return g.currentSeq
 }
 
+// Seal seals the data with the keys of the current sequence, then moves to the next one.
+// Fails with ErrSequenceExhausted once it reaches MaxSequence.
 func (g *GossipSealer) Seal(data []byte, sealType string) (*messages.MessageSeal, error) {
+	if g.currentSeq >= MaxSequence {
+		return nil, ErrSequenceExhausted
+	}
+
 	log.Print("Sealing with seq ", g.currentSeq, " on sealer ", g.sealerCount)
-	aes, err := aes.NewCipher(g.currentKeys[16:48])
+	aead, err := g.suite.NewAEAD(g.currentKeys[:ciphersuites.KeySize])
 	if err != nil {
-		return nil, errors.New("failed to create AES cipher")
+		return nil, errors.New("failed to create cipher")
 	}
 
-	aesofb := cipher.NewOFB(aes, g.currentIv[:aes.BlockSize()])
-	encrypted := make([]byte, len(data))
-	aesofb.XORKeyStream(encrypted, data)
-
-	mac := hmac.New(sha256.New, g.currentKeys[:16])
-	dataToMac := getDataToMac(encrypted, g.currentSeq)
-	mac.Write(dataToMac)
-	macSum := mac.Sum(nil)
-
 	msg := &messages.MessageSeal{
+		Suite:     g.suite,
 		Type:      sealType,
 		SessionId: g.sessionId,
-		Mac:       macSum,
 		Sequence:  g.currentSeq,
-		Encrypted: encrypted,
 	}
 
-	g.Update()
+	ad, err := additionalData(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Encrypted = aead.Seal(nil, g.currentKeys[ciphersuites.KeySize:], data, ad)
+
+	if err := g.Update(); err != nil {
+		return nil, err
+	}
 
 	return msg, nil
 }
 
 func (g *GossipSealer) Unseal(msg *messages.MessageSeal) ([]byte, error) {
+	if msg.Suite != g.suite {
+		return nil, errors.New("wrong cipher suite")
+	}
+
 	if msg.SessionId != g.sessionId {
 		return nil, errors.New("wrong session ID")
 	}
@@ -89,61 +108,65 @@ func (g *GossipSealer) Unseal(msg *messages.MessageSeal) ([]byte, error) {
 		return nil, errors.New("wrong sequence")
 	}
 
-	mac := hmac.New(sha256.New, g.currentKeys[:16])
-	dataToMac := getDataToMac(msg.Encrypted, msg.Sequence)
-	mac.Write(dataToMac)
-	expectedMac := mac.Sum(nil)
-
-	if !hmac.Equal(msg.Mac, expectedMac) {
-		return nil, errors.New("wrong MAC")
+	aead, err := g.suite.NewAEAD(g.currentKeys[:ciphersuites.KeySize])
+	if err != nil {
+		return nil, errors.New("failed to create cipher")
 	}
 
-	aes, err := aes.NewCipher(g.currentKeys[16:48])
+	ad, err := additionalData(msg)
 	if err != nil {
-		return nil, errors.New("failed to create AES cipher")
+		return nil, err
 	}
 
-	// TODO: which mode to use?
-	aesofb := cipher.NewOFB(aes, g.currentIv[:aes.BlockSize()])
-	decrypted := make([]byte, len(msg.Encrypted))
-	aesofb.XORKeyStream(decrypted, msg.Encrypted)
+	decrypted, err := aead.Open(nil, g.currentKeys[ciphersuites.KeySize:], msg.Encrypted, ad)
+	if err != nil {
+		return nil, errors.New("failed to authenticate seal")
+	}
 
 	return decrypted, nil
 }
 
-func getDataToMac(data []byte, seq int) []byte {
-	dataToMac := make([]byte, len(data))
-	copy(dataToMac, data)
-	intBuff := make([]byte, 4)
-	binary.BigEndian.PutUint32(intBuff, uint32(seq))
-	dataToMac = append(dataToMac, intBuff...)
-	return dataToMac
+// additionalData binds the ciphertext to everything in the seal header
+func additionalData(msg *messages.MessageSeal) ([]byte, error) {
+	return encodings.Encode(struct {
+		Suite     ciphersuites.Suite
+		Type      string
+		SessionId string
+		Sequence  int
+	}{msg.Suite, msg.Type, msg.SessionId, msg.Sequence})
 }
 
+// Update moves to the keys of the next sequence.
 func (g *GossipSealer) Update() error {
-	n, err := g.keyGenerator.Read(g.currentKeys)
+	return g.UpdateToSeq(g.currentSeq + 1)
+}
 
-	if err != nil || n != len(g.currentKeys) {
-		return errors.New("failed to generate next keys")
+// UpdateToSeq moves to the keys of the given sequence, skipping the ones before it.
+func (g *GossipSealer) UpdateToSeq(seq int) error {
+	if seq < g.currentSeq {
+		return errors.New("cannot update to a previous sequence")
 	}
 
-	g.currentSeq++
-	sha256 := sha256.New()
-	sha256.Write(g.currentIv)
-	g.currentIv = sha256.Sum(nil)
+	if seq > MaxSequence {
+		return ErrSequenceExhausted
+	}
+
+	if err := g.deriveKeys(seq); err != nil {
+		return err
+	}
+
+	g.currentSeq = seq
 	log.Println("Updated sealer ", g.sealerCount, " to seq ", g.currentSeq)
 	return nil
 }
 
-func (g *GossipSealer) UpdateToSeq(seq int) error {
-	if seq < g.currentSeq {
-		return errors.New("cannot update to a previous sequence")
-	}
+// deriveKeys expands the key and nonce of the sequence, each in its own HKDF output
+func (g *GossipSealer) deriveKeys(seq int) error {
+	info := binary.BigEndian.AppendUint64([]byte(g.senderId), uint64(seq))
+	keyGenerator := hkdf.Expand(sha256.New, g.keySeed, info)
 
-	for g.currentSeq < seq {
-		if err := g.Update(); err != nil {
-			return err
-		}
+	if _, err := io.ReadFull(keyGenerator, g.currentKeys); err != nil {
+		return errors.New("failed to generate next keys")
 	}
 
 	return nil
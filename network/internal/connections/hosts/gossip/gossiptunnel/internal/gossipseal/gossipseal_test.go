@@ -1,21 +1,26 @@
 package gossipseal_test
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"testing"
 
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/gossiptunnel/internal/gossipseal"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/messages"
 	"golang.org/x/crypto/hkdf"
 )
 
 func Test__Unseal__ShouldUndoSeal(t *testing.T) {
-	tun1, tun2 := generateTunnels(t)
+	for _, suite := range ciphersuites.Supported {
+		tun1, tun2 := generateTunnelsWith(t, suite)
 
-	checkSealUnseal(t, tun1, tun2, "hello")
-	checkSealUnseal(t, tun1, tun2, "world")
-	checkSealUnseal(t, tun1, tun2, "foo")
-	checkSealUnseal(t, tun1, tun2, "bar")
+		checkSealUnseal(t, tun1, tun2, "hello")
+		checkSealUnseal(t, tun1, tun2, "world")
+		checkSealUnseal(t, tun1, tun2, "foo")
+		checkSealUnseal(t, tun1, tun2, "bar")
+	}
 }
 
 func checkSealUnseal(t *testing.T, sealer1, sealer2 *gossipseal.GossipSealer, msg string) {
@@ -53,18 +58,146 @@ func Test__Unseal__ShouldFailIfSealIsCorrupted(t *testing.T) {
 	}
 }
 
+func Test__Unseal__ShouldFail__WhenHeaderIsTampered(t *testing.T) {
+	cases := map[string]func(*messages.MessageSeal){
+		"type":       func(m *messages.MessageSeal) { m.Type = messages.SealTypeControl },
+		"session id": func(m *messages.MessageSeal) { m.SessionId = "other session" },
+		"suite":      func(m *messages.MessageSeal) { m.Suite = ciphersuites.ChaCha20Poly1305 },
+	}
+
+	for field, tamper := range cases {
+		// Arrange
+		sealer1, sealer2 := generateTunnels(t)
+		sealed, err := sealer1.Seal([]byte("hello"), messages.SealTypeData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Act
+		tamper(sealed)
+		_, err = sealer2.Unseal(sealed)
+
+		// Assert
+		if err == nil {
+			t.Errorf("expected error when the %s is tampered", field)
+		}
+	}
+}
+
+func Test__Unseal__ShouldFail__WhenSealIsMovedToAnotherSequence(t *testing.T) {
+	// Arrange
+	sealer1, sealer2 := generateTunnels(t)
+	sealed, err := sealer1.Seal([]byte("hello"), messages.SealTypeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealer2.Update()
+
+	// Act
+	sealed.Sequence++
+	_, err = sealer2.Unseal(sealed)
+
+	// Assert
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func Test__Seal__ShouldUseFreshKeys__WhenSealingMoreThanOneHKDFOutputOfKeys(t *testing.T) {
+	// Arrange
+	sealer1, sealer2 := generateTunnels(t)
+	seen := make(map[string]bool)
+
+	// Act
+	// A single HKDF-SHA256 output holds 8160 bytes, the keys and nonces of only 185 sequences
+	for i := range 1000 {
+		msg := []byte("same message")
+		sealed, err := sealer1.Seal(msg, messages.SealTypeData)
+		if err != nil {
+			t.Fatalf("Expected seal %d to succeed, got %v", i, err)
+		}
+
+		// Assert
+		if seen[string(sealed.Encrypted)] {
+			t.Fatalf("Expected seal %d to differ from the previous ones", i)
+		}
+		seen[string(sealed.Encrypted)] = true
+
+		decrypted, err := sealer2.Unseal(sealed)
+		if err != nil || !bytes.Equal(decrypted, msg) {
+			t.Fatalf("Expected seal %d to be unsealed, got %v", i, err)
+		}
+
+		if err := sealer2.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test__Seal__ShouldFail__WhenSequencesAreExhausted(t *testing.T) {
+	// Arrange
+	sealer, _ := generateTunnels(t)
+	if err := sealer.UpdateToSeq(gossipseal.MaxSequence); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	_, err := sealer.Seal([]byte("hello"), messages.SealTypeData)
+
+	// Assert
+	if !errors.Is(err, gossipseal.ErrSequenceExhausted) {
+		t.Errorf("Expected ErrSequenceExhausted, got %v", err)
+	}
+
+	if err := sealer.Update(); !errors.Is(err, gossipseal.ErrSequenceExhausted) {
+		t.Errorf("Expected ErrSequenceExhausted when updating past the last sequence, got %v", err)
+	}
+}
+
+func Test__Unseal__ShouldFail__WhenSealedBySenderWithAnotherId(t *testing.T) {
+	// Arrange
+	seed := hkdf.Extract(sha256.New, []byte("secret"), []byte("salt"))
+	sender, _ := gossipseal.New("session", ciphersuites.AES256GCM, seed, "sender")
+	other, _ := gossipseal.New("session", ciphersuites.AES256GCM, seed, "other")
+
+	sealed, err := sender.Seal([]byte("hello"), messages.SealTypeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	_, err = other.Unseal(sealed)
+
+	// Assert
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func Test__New__ShouldFail__WhenSuiteIsNotSupported(t *testing.T) {
+	_, err := gossipseal.New("session", ciphersuites.Suite(99), []byte("seed"), "sender")
+
+	if !errors.Is(err, ciphersuites.ErrUnsupportedSuite) {
+		t.Errorf("expected ErrUnsupportedSuite, got %v", err)
+	}
+}
+
 func generateTunnels(t *testing.T) (*gossipseal.GossipSealer, *gossipseal.GossipSealer) {
-	sealer1 := buildSealer(t, "session", []byte("secret"))
-	sealer2 := buildSealer(t, "session", []byte("secret"))
+	return generateTunnelsWith(t, ciphersuites.AES256GCM)
+}
+
+func generateTunnelsWith(t *testing.T, suite ciphersuites.Suite) (*gossipseal.GossipSealer, *gossipseal.GossipSealer) {
+	sealer1 := buildSealer(t, "session", suite, []byte("secret"))
+	sealer2 := buildSealer(t, "session", suite, []byte("secret"))
 
 	return sealer1, sealer2
 }
 
-func buildSealer(t *testing.T, sessionId string, secret []byte) *gossipseal.GossipSealer {
+func buildSealer(t *testing.T, sessionId string, suite ciphersuites.Suite, secret []byte) *gossipseal.GossipSealer {
 	seed := hkdf.Extract(sha256.New, secret, []byte("salt"))
-	keyGen := hkdf.Expand(sha256.New, seed, []byte("test"))
 
-	sealer, err := gossipseal.New(sessionId, keyGen)
+	sealer, err := gossipseal.New(sessionId, suite, seed, "sender")
 	if err != nil {
 		t.Fatal(err)
 	}
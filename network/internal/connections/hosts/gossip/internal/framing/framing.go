@@ -0,0 +1,78 @@
+// Package framing delimits messages on a stream connection with a length prefix.
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Size of the big endian length prefix
+const HeaderSize = 4
+
+// Largest frame accepted, to bound the memory a peer can make us allocate
+const MaxFrameSize = 16 << 20
+
+var ErrFrameTooLarge = errors.New("frame is too large")
+
+// Write writes the data as a single frame.
+// The prefix and the data are written at once, so frames of concurrent writers are not interleaved.
+func Write(w io.Writer, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	frame := make([]byte, HeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[HeaderSize:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Reader reads the frames written with Write.
+type Reader struct {
+	r      io.Reader
+	header [HeaderSize]byte
+	frame  []byte
+	read   int // Bytes of the current frame read so far, prefix included
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next reads the next frame. A frame may arrive split across reads, and many frames in a single read.
+// If the underlying reader fails in the middle of a frame, e.g. on a read deadline,
+// the bytes read so far are kept and the next call continues the same frame.
+func (f *Reader) Next() ([]byte, error) {
+	for f.read < HeaderSize {
+		n, err := f.r.Read(f.header[f.read:])
+		f.read += n
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.frame == nil {
+		size := binary.BigEndian.Uint32(f.header[:])
+		if size > MaxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+
+		f.frame = make([]byte, size)
+	}
+
+	for f.read < HeaderSize+len(f.frame) {
+		n, err := f.r.Read(f.frame[f.read-HeaderSize:])
+		f.read += n
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	frame := f.frame
+	f.frame = nil
+	f.read = 0
+	return frame, nil
+}
@@ -0,0 +1,101 @@
+package framing_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/internal/framing"
+)
+
+func Test__Next__ShouldReturnEachFrame__WhenFramesArriveInOneRead(t *testing.T) {
+	// Arrange
+	var stream bytes.Buffer
+	messages := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{1}, 3000), []byte("world")}
+	for _, msg := range messages {
+		if err := framing.Write(&stream, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := framing.NewReader(&stream)
+
+	for _, expected := range messages {
+		// Act
+		frame, err := reader.Next()
+
+		// Assert
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(frame, expected) {
+			t.Errorf("Expected a frame of %d bytes, got %d bytes", len(expected), len(frame))
+		}
+	}
+}
+
+func Test__Next__ShouldResumeFrame__WhenReadFailsMidFrame(t *testing.T) {
+	// Arrange
+	var stream bytes.Buffer
+	expected := bytes.Repeat([]byte("0123456789"), 100)
+	framing.Write(&stream, expected)
+
+	// Each byte is followed by a timeout
+	reader := framing.NewReader(&timeoutEveryOther{r: iotest.OneByteReader(&stream)})
+
+	// Act
+	var frame []byte
+	var err error
+	for range 10 * len(expected) {
+		if frame, err = reader.Next(); !errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+	}
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(frame, expected) {
+		t.Error("Expected the frame to be read across the timeouts")
+	}
+}
+
+func Test__Next__ShouldFail__WhenFrameIsTooLarge(t *testing.T) {
+	// Arrange
+	header := make([]byte, framing.HeaderSize)
+	binary.BigEndian.PutUint32(header, framing.MaxFrameSize+1)
+
+	// Act
+	_, err := framing.NewReader(bytes.NewReader(header)).Next()
+
+	// Assert
+	if !errors.Is(err, framing.ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v", err)
+	}
+
+	if err := framing.Write(io.Discard, make([]byte, framing.MaxFrameSize+1)); !errors.Is(err, framing.ErrFrameTooLarge) {
+		t.Errorf("Expected Write to fail with ErrFrameTooLarge, got %v", err)
+	}
+}
+
+// timeoutEveryOther fails every other read with a deadline error, like a connection polled with short deadlines
+type timeoutEveryOther struct {
+	r     io.Reader
+	calls int
+}
+
+func (t *timeoutEveryOther) Read(p []byte) (int, error) {
+	t.calls++
+	if t.calls%2 == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	return t.r.Read(p)
+}
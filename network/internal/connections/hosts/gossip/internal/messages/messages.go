@@ -1,28 +1,65 @@
 package messages
 
+import "github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+
 const (
 	SealTypeControl = "control"
 	SealTypeData    = "data"
 )
 
+// MessageSeal carries data encrypted with the AEAD of Suite.
+// The other fields are not encrypted, but are authenticated as associated data.
 type MessageSeal struct {
+	Suite     ciphersuites.Suite
 	Type      string
 	SessionId string
-	Mac       []byte // HMAC of the data together with the sequence number
 	Sequence  int
 	Encrypted []byte
 }
 
-type ControlMessage struct {
-	Succeed    bool
-	ErrorType  string
+// DataMessage is the content of data seals. MessageSeq numbers the messages sent in the tunnel,
+// so that a retransmitted message keeps its number while getting a new seal.
+type DataMessage struct {
 	MessageSeq int
+	Data       []byte
 }
 
 const (
-	ErrorTypeUnreadableSeal = "unreadable_seal"
-	ErrorTypeWrongSession   = "wrong_session"
-	ErrorTypeWrongSeq       = "wrong_sequence"
-	ErrorTypeInvalidSeal    = "invalid_seal"
-	ErrorTypeInternalError  = "internal_error"
+	ControlKindAck       = "ack"
+	ControlKindPing      = "ping"
+	ControlKindRekey     = "rekey"
+	ControlKindRekeyAck  = "rekey-ack"
+	ControlKindRekeyDone = "rekey-done"
+	ControlKindTicket    = "ticket"
 )
+
+// ControlMessage is the content of control seals.
+// An ack acknowledges every data message up to MessageSeq, inclusive.
+// A ping only tells the peer that the tunnel is alive.
+//
+// A rekey carries the ephemeral key of the initiator, and a rekey-ack the one of the responder.
+// Each side seals with the new keys right after sending its rekey-ack or rekey-done,
+// so the peer switches keys right after receiving it.
+//
+// A ticket lets the receiver resume the session later, for TicketLifetime seconds.
+type ControlMessage struct {
+	Kind           string
+	MessageSeq     int
+	EphKey         []byte
+	TicketId       string
+	TicketLifetime int
+}
+
+// InitMessage is the first frame of a gossip connection. It names either an established session,
+// or a ticket to resume, with the nonce of the initiator.
+type InitMessage struct {
+	SessionId string
+	TicketId  string
+	Nonce     []byte
+}
+
+// ResumeMessage answers an InitMessage with a ticket, naming the resumed session.
+type ResumeMessage struct {
+	SessionId string
+	Nonce     []byte
+}
@@ -0,0 +1,66 @@
+package handshake
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
+)
+
+// Cookies are valid for the current and the previous period
+const cookiePeriod = 30 * time.Second
+
+type cookieData struct {
+	Addr   string
+	SrcTag string
+	Nonce  []byte
+	Epoch  int64
+}
+
+// cookieJar makes stateless cookies: the responder keeps no state for a hello until its source
+// proves it can receive packets at the claimed address, by echoing the cookie.
+type cookieJar struct {
+	secret []byte
+}
+
+func newCookieJar() *cookieJar {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	return &cookieJar{secret: secret}
+}
+
+func (c *cookieJar) Make(hello messages.HelloMessage, addr *net.UDPAddr) []byte {
+	return c.makeAt(hello, addr, currentEpoch())
+}
+
+func (c *cookieJar) IsValid(hello messages.HelloMessage, addr *net.UDPAddr) bool {
+	if len(hello.Cookie) == 0 {
+		return false
+	}
+
+	epoch := currentEpoch()
+	return hmac.Equal(hello.Cookie, c.makeAt(hello, addr, epoch)) ||
+		hmac.Equal(hello.Cookie, c.makeAt(hello, addr, epoch-1))
+}
+
+func (c *cookieJar) makeAt(hello messages.HelloMessage, addr *net.UDPAddr, epoch int64) []byte {
+	data, _ := encodings.Encode(cookieData{
+		Addr:   addr.String(),
+		SrcTag: hello.SrcTag,
+		Nonce:  hello.Nonce,
+		Epoch:  epoch,
+	})
+
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func currentEpoch() int64 {
+	return time.Now().Unix() / int64(cookiePeriod.Seconds())
+}
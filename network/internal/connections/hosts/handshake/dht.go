@@ -0,0 +1,250 @@
+package handshake
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/dht"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
+)
+
+// A FIND_NODE not answered within this timeout counts as failed, and the contact is removed
+const queryTimeout = 2 * time.Second
+
+var ErrPeerNotFound = errors.New("peer not found in the DHT")
+
+// FindPeer implements connections.Handshaker.
+// It looks the tag up in the DHT, asking the closest known contacts for closer ones until it is found.
+func (h *HandshakeHost) FindPeer(tag string) (network.Peer, error) {
+	id, err := identity.FromTag(tag)
+	if err != nil {
+		return network.Peer{}, err
+	}
+
+	record, found := h.routes.Get(tag)
+	if !found {
+		for _, closest := range h.lookup(dht.IdFromTag(tag)) {
+			if closest.Tag == tag {
+				record, found = closest, true
+				break
+			}
+		}
+	}
+
+	if !found {
+		return network.Peer{}, ErrPeerNotFound
+	}
+
+	return network.Peer{Id: id, Addr: record.Addr}, nil
+}
+
+// lookup returns the contacts closest to the target, querying Alpha contacts at a time
+// until the closest ones have all been queried.
+func (h *HandshakeHost) lookup(target dht.NodeId) []network.PeerRecord {
+	candidates := h.routes.Closest(target, dht.BucketSize)
+	known := make(map[string]bool)
+	for _, candidate := range candidates {
+		known[candidate.Tag] = true
+	}
+	queried := make(map[string]bool)
+
+	for {
+		batch := make([]network.PeerRecord, 0, dht.Alpha)
+		for _, candidate := range candidates {
+			if len(batch) < dht.Alpha && !queried[candidate.Tag] {
+				batch = append(batch, candidate)
+			}
+		}
+
+		if len(batch) == 0 || (*h.cancellation).Err() != nil {
+			return candidates
+		}
+
+		results := make(chan []network.PeerRecord, len(batch))
+		for _, contact := range batch {
+			queried[contact.Tag] = true
+			go func() {
+				records, err := h.queryRecord(contact, target)
+				if err != nil {
+					h.routes.Remove(contact.Tag)
+				}
+				results <- records
+			}()
+		}
+
+		for range batch {
+			for _, record := range <-results {
+				if !known[record.Tag] && record.Tag != h.selfId.GetTag() {
+					known[record.Tag] = true
+					candidates = append(candidates, record)
+				}
+			}
+		}
+
+		dht.SortByDistance(target, candidates)
+		candidates = candidates[:min(dht.BucketSize, len(candidates))]
+	}
+}
+
+// refresh adds a peer we just completed a handshake with to the routing table,
+// and looks self up through it, which fills the buckets near self.
+func (h *HandshakeHost) refresh(peer HandshakePeerData) {
+	if _, err := h.query(peer, dht.IdFromTag(h.selfId.GetTag())); err != nil {
+		return
+	}
+
+	h.lookup(dht.IdFromTag(h.selfId.GetTag()))
+}
+
+func (h *HandshakeHost) queryRecord(record network.PeerRecord, target dht.NodeId) ([]network.PeerRecord, error) {
+	id, err := record.GetIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", record.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.query(HandshakePeerData{Id: id, Addr: addr}, target)
+}
+
+// query sends a FIND_NODE to the peer, and returns the valid records it answers with.
+// The sender of the answer is added to the routing table.
+func (h *HandshakeHost) query(peer HandshakePeerData, target dht.NodeId) ([]network.PeerRecord, error) {
+	self, err := h.selfRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	subscription, err := h.subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	data := HandshakeData{
+		PeerId:       peer.Id,
+		PeerAddr:     peer.Addr,
+		Subscription: subscription,
+	}
+	defer data.Subscription.Unsubscribe()
+
+	packet, err := h.seal("find_node", messages.FindNodeMessage{Target: target[:], Nonce: nonce, Sender: self}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.write(packet, peer.Addr); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(*h.cancellation, queryTimeout)
+	defer cancel()
+
+	retransmit := time.NewTimer(h.retransmitInterval)
+	defer retransmit.Stop()
+
+	for {
+		select {
+		case udpMsg := <-data.Subscription.Channel():
+			shell, ok := h.accept(udpMsg, data)
+			if !ok || shell.Cmd != "nodes" {
+				continue
+			}
+
+			var nodesMsg messages.NodesMessage
+			if encodings.Decode(shell.Data, &nodesMsg) != nil || !slices.Equal(nodesMsg.Nonce, nonce) {
+				continue
+			}
+
+			if nodesMsg.Sender.Tag == peer.Id.GetTag() && nodesMsg.Sender.Verify() == nil {
+				h.routes.Add(nodesMsg.Sender)
+			}
+
+			records := make([]network.PeerRecord, 0, len(nodesMsg.Records))
+			for _, record := range nodesMsg.Records[:min(dht.BucketSize, len(nodesMsg.Records))] {
+				if record.Verify() == nil {
+					records = append(records, record)
+				}
+			}
+
+			return records, nil
+		case <-retransmit.C:
+			if err := h.write(packet, peer.Addr); err != nil {
+				return nil, err
+			}
+		case <-ctx.Done():
+			return nil, errors.New("timed out while waiting for nodes")
+		}
+	}
+}
+
+// handleFindNode answers with the closest contacts to the target. Answers are larger than requests,
+// so sources are rate limited like hellos, to keep the host from being used to amplify floods.
+// Banned and disallowed senders are ignored, as they are for hellos.
+func (h *HandshakeHost) handleFindNode(shellMsg messages.MessageShell, udpMsg UdpMessage) {
+	if !h.limiter.Allow(udpMsg.Addr.IP.String()) {
+		return
+	}
+
+	var findMsg messages.FindNodeMessage
+	if encodings.Decode(shellMsg.Data, &findMsg) != nil || len(findMsg.Target) != len(dht.NodeId{}) {
+		return
+	}
+
+	if findMsg.Sender.Verify() != nil {
+		return
+	}
+
+	senderId, _ := findMsg.Sender.GetIdentity()
+	if !signatures.Verify(senderId, shellMsg.Data, shellMsg.Signature) {
+		return
+	}
+
+	if h.reputation.IsBanned(findMsg.Sender.Tag) || !h.allowlist.IsAllowed(findMsg.Sender.Tag) {
+		return
+	}
+
+	h.routes.Add(findMsg.Sender)
+
+	self, err := h.selfRecord()
+	if err != nil {
+		log.Println("Failed to sign peer record: ", err)
+		return
+	}
+
+	nodesMsg := messages.NodesMessage{
+		Nonce:   findMsg.Nonce,
+		Sender:  self,
+		Records: h.routes.Closest(dht.NodeId(findMsg.Target), dht.BucketSize),
+	}
+
+	packet, err := h.seal("nodes", nodesMsg, true)
+	if err != nil {
+		log.Println("Failed to encode nodes message: ", err)
+		return
+	}
+
+	if err = h.write(packet, udpMsg.Addr); err != nil {
+		log.Println("Failed to send nodes message: ", err)
+	}
+}
+
+func (h *HandshakeHost) selfRecord() (network.PeerRecord, error) {
+	return network.NewPeerRecord(h.selfId, h.AdvertisedAddress())
+}
@@ -1,13 +1,17 @@
 package handshake
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"log"
 	"net"
 	"slices"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/titosilva/drmchain-pos/identity"
@@ -15,18 +19,34 @@ import (
 	"github.com/titosilva/drmchain-pos/identity/signatures"
 	"github.com/titosilva/drmchain-pos/internal/di"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/clru"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
 	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
 	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
 	"github.com/titosilva/drmchain-pos/network"
 	"golang.org/x/crypto/hkdf"
 
+	"github.com/titosilva/drmchain-pos/network/allowlist"
 	"github.com/titosilva/drmchain-pos/network/encodings"
 	"github.com/titosilva/drmchain-pos/network/internal/connections"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/dht"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
 	config "github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
 )
 
+var ErrHostClosed = errors.New("handshake host closed")
+
+var ErrNotListening = errors.New("handshake host is not listening")
+
+// Largest datagram accepted by the listener: the maximum UDP payload
+const maxDatagramSize = 65535
+
+// Number of nonces remembered to reject replays. Older hellos are rejected anyway, as their cookies expire.
+const nonceCacheCapacity = 8192
+
 type HandshakeHost struct {
 	selfId             identity.PrivateIdentity
 	cancellation       *context.Context
@@ -36,9 +56,37 @@ type HandshakeHost struct {
 	configuration      *config.NetworkConfig
 	defaultTimeoutSecs int
 
+	// DoS protection: hellos must echo a cookie before any state is kept,
+	// each source IP is rate limited and only so many handshakes run at once
+	cookies    *cookieJar
+	limiter    *rateLimiter
+	slots      chan struct{}
+	inProgress *cmap.CMap[string, bool]
+	nonces     *clru.Cache[string, bool]
+
+	// Unanswered messages are sent again after this interval, doubled at each retry
+	retransmitInterval time.Duration
+
+	// Contacts of the DHT, filled by the peers we complete handshakes with and the ones they know
+	routes *dht.RoutingTable
+
+	// Peers that misbehave once authenticated are reported, and banned peers are refused
+	reputation *reputation.Reputation
+
+	// In a permissioned network, hellos from tags not in the allowlist are refused
+	allowlist *allowlist.Allowlist
+
+	// Peers behind a NAT learn their public address from the addresses their peers observed,
+	// and peers we completed a handshake with are remembered at their observed address, to relay punches
+	observations  *addressObservations
+	observedPeers *clru.Cache[string, string]
+
+	listener  PacketListener
 	address   string
-	tcpAddr   string
-	udpServer *net.UDPConn
+	udpServer net.PacketConn
+
+	closeMux *sync.Mutex
+	closed   bool
 }
 
 type HandshakeData struct {
@@ -75,8 +123,20 @@ func Factory(diCtx *di.DIContext) connections.Handshaker {
 		received:           observable.New[UdpMessage](),
 		sessions:           session,
 		configuration:      config,
-		tcpAddr:            config.GossipHost,
 		defaultTimeoutSecs: 5,
+		cookies:            newCookieJar(),
+		limiter:            newRateLimiter(config.HandshakesPerSecond, config.HandshakeBurst),
+		slots:              make(chan struct{}, config.MaxConcurrentHandshakes),
+		inProgress:         cmap.New[string, bool](),
+		nonces:             clru.New[string, bool](nonceCacheCapacity),
+		retransmitInterval: 500 * time.Millisecond,
+		routes:             dht.NewRoutingTable(selfId.GetTag()),
+		reputation:         reputation.GetFromDI(diCtx),
+		allowlist:          allowlist.GetFromDI(diCtx),
+		observations:       newAddressObservations(),
+		observedPeers:      clru.New[string, string](observedPeersCapacity),
+		listener:           di.GetInterfaceService[PacketListener](diCtx),
+		closeMux:           &sync.Mutex{},
 	}
 
 	return h
@@ -92,128 +152,263 @@ func (h *HandshakeHost) ConnectTo(peer network.Peer) (*sessions.Session, error)
 		return nil, errorutil.WithInner("failed to resolve udp address: ", err)
 	}
 
+	subscription, err := h.subscribe()
+	if err != nil {
+		return nil, err
+	}
+
 	data := HandshakeData{
 		PeerId:       peer.Id,
 		PeerAddr:     udpAddr,
-		Subscription: h.received.Subscribe(),
+		Subscription: subscription,
 	}
 	defer data.Subscription.Unsubscribe()
 
 	// Hello -> Peer
-	nonce := generateNonce()
+	nonce := h.generateNonce()
 	msg := messages.HelloMessage{
-		SrcTag:  h.selfId.GetTag(),
-		DstTag:  data.PeerId.GetTag(),
-		SrcAddr: h.address,
-		Nonce:   nonce,
+		SrcTag:       h.selfId.GetTag(),
+		DstTag:       data.PeerId.GetTag(),
+		SrcAddr:      h.AdvertisedAddress(),
+		Nonce:        nonce,
+		CipherSuites: ciphersuites.Supported,
+	}
+
+	// Cookie <- Peer, on first contact
+	shell, err := h.exchange(data, "hello", msg, "", true, "cookie", "challenge")
+	if err != nil {
+		return nil, errorutil.WithInner("failed to receive challenge message: ", err)
 	}
 
-	if err := h.send("hello", msg, data.PeerAddr); err != nil {
-		return nil, errorutil.WithInner("failed to send hello message: ", err)
+	if shell.Cmd == "cookie" {
+		var cookieMsg messages.CookieMessage
+		if err := encodings.Decode(shell.Data, &cookieMsg); err != nil {
+			return nil, errorutil.WithInner("failed to decode cookie message: ", err)
+		}
+
+		if !slices.Equal(cookieMsg.Nonce, nonce) {
+			return nil, errors.New("cookie nonce mismatch")
+		}
+
+		// Hello with cookie -> Peer
+		msg.Cookie = cookieMsg.Cookie
+		if shell, err = h.exchange(data, "hello", msg, "", true, "challenge"); err != nil {
+			return nil, errorutil.WithInner("failed to receive challenge message: ", err)
+		}
 	}
 
 	// Challenge <- Peer
 	var challengeMsg messages.ChallengeMessage
-	if err := h.wait(&challengeMsg, data); err != nil {
-		return nil, errorutil.WithInner("failed to receive challenge message: ", err)
+	if err := encodings.Decode(shell.Data, &challengeMsg); err != nil {
+		h.report(peer.Id.GetTag(), reputation.MisbehaviorUndecodable)
+		return nil, errorutil.WithInner("failed to decode challenge message: ", err)
 	}
 
 	if !slices.Equal(challengeMsg.Nonce, nonce) {
 		return nil, errors.New("first nonce mismatch")
 	}
 
+	// The choice is authenticated by the transcript signatures, so it cannot be downgraded
+	if !slices.Contains(msg.CipherSuites, challengeMsg.CipherSuite) {
+		return nil, ciphersuites.ErrUnsupportedSuite
+	}
+
 	// Answer -> Peer
 	ephKey, err := keyexchange.GenerateEphemeralKey()
 	if err != nil {
 		return nil, errorutil.WithInner("failed to generate ephemeral key: ", err)
 	}
 
-	acceptNonce := generateNonce()
+	acceptNonce := h.generateNonce()
 	ansMsg := messages.AnswerMessage{
 		EphKey:         ephKey.PublicKey().Bytes(),
 		ChallengeNonce: challengeMsg.ChallengeNonce,
 		AcceptNonce:    acceptNonce,
 	}
 
-	if err = h.send("answer", ansMsg, data.PeerAddr); err != nil {
-		return nil, errorutil.WithInner("failed to send answer message: ", err)
+	transcriptHash, err := transcriptHash(msg, challengeMsg, ansMsg)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to compute transcript hash: ", err)
+	}
+
+	signedData, err := initiatorSignedData(transcriptHash)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to encode transcript: ", err)
+	}
+
+	ansMsg.TranscriptSignature, err = signatures.Sign(h.selfId, signedData)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to sign transcript: ", err)
 	}
 
 	// Accepted <- Peer
-	var acceptedMsg messages.AcceptedMessage
-	if err = h.wait(&acceptedMsg, data); err != nil {
+	if shell, err = h.exchange(data, "answer", ansMsg, "", true, "accept"); err != nil {
 		return nil, errorutil.WithInner("failed to receive accepted message: ", err)
 	}
 
+	var acceptedMsg messages.AcceptedMessage
+	if err = encodings.Decode(shell.Data, &acceptedMsg); err != nil {
+		h.report(peer.Id.GetTag(), reputation.MisbehaviorUndecodable)
+		return nil, errorutil.WithInner("failed to decode accepted message: ", err)
+	}
+
 	if !slices.Equal(acceptedMsg.AcceptNonce, acceptNonce) {
 		return nil, errors.New("accept nonce mismatch")
 	}
 
+	peerSignedData, err := responderSignedData(transcriptHash, acceptedMsg)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to encode transcript: ", err)
+	}
+
+	if !signatures.Verify(peer.Id, peerSignedData, acceptedMsg.TranscriptSignature) {
+		h.report(peer.Id.GetTag(), reputation.MisbehaviorBadSignature)
+		return nil, errors.New("failed to verify transcript signature")
+	}
+
+	// The observed address is part of the transcript signed by the responder
+	if challengeMsg.ObservedAddr != "" {
+		h.observations.Add(peer.Id.GetTag(), challengeMsg.ObservedAddr)
+	}
+	h.observedPeers.Put(peer.Id.GetTag(), data.PeerAddr.String())
+
 	secret, err := keyexchange.DeriveFromPublicIdentity(peer.Id, ephKey)
 	if err != nil {
 		return nil, errorutil.WithInner("failed to derive secret: ", err)
 	}
 
-	if !signatures.Verify(peer.Id, secret, acceptedMsg.SecretSignature) {
-		return nil, errors.New("failed to verify secret signature")
+	peerEphKey, err := keyexchange.BytesToKey(challengeMsg.EphKey)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to parse ephemeral key: ", err)
+	}
+
+	ephSecret, err := ephKey.ECDH(peerEphKey)
+	if err != nil {
+		return nil, errorutil.WithInner("failed to derive ephemeral secret: ", err)
 	}
 
-	salt := append(challengeMsg.Nonce, challengeMsg.ChallengeNonce...)
-	keySeed := hkdf.Extract(sha256.New, secret, salt)
+	keySeed := deriveKeySeed(secret, ephSecret, transcriptHash)
 
 	sessionPeer := network.Peer{
 		Id:   data.PeerId,
-		Addr: acceptedMsg.TcpAddr,
+		Addr: reachableAddr(acceptedMsg.TcpAddr, data.PeerAddr),
 	}
-	session := sessions.NewSession(acceptedMsg.SessionId, keySeed, sessionPeer)
+	session := sessions.NewSession(acceptedMsg.SessionId, keySeed, challengeMsg.CipherSuite, sessionPeer)
 	h.sessions.RegisterSession(session)
+	go h.refresh(HandshakePeerData{Id: data.PeerId, Addr: data.PeerAddr})
 
-	// Derive secret
 	return session, nil
 }
 
-func (h *HandshakeHost) receiveHandshake(helloMsg messages.HelloMessage, addr *net.UDPAddr) {
+// receiveHandshake runs the responder side of a handshake. It holds one of the slots until the
+// accept is sent, and keeps answering retransmissions for a while after that.
+func (h *HandshakeHost) receiveHandshake(helloMsg messages.HelloMessage, srcId identity.PublicIdentity, addr *net.UDPAddr, key string) {
+	defer h.inProgress.Delete(key)
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			<-h.slots
+		}
+	}
+	defer release()
+
 	// Hello <- Source
-	srcId, err := identity.FromTag(helloMsg.SrcTag)
+	subscription, err := h.subscribe()
 	if err != nil {
-		log.Println("Failed to parse peer identity: ", err)
+		return
 	}
 
 	data := HandshakeData{
 		PeerId:       srcId,
 		PeerAddr:     addr,
-		Subscription: h.received.Subscribe(),
+		Subscription: subscription,
 	}
 	defer data.Subscription.Unsubscribe()
 
 	log.Println("Received hello message from ", data.PeerId.GetTag(), " at ", data.PeerAddr.String())
 
+	suite, err := ciphersuites.Negotiate(helloMsg.CipherSuites)
+	if err != nil {
+		log.Println("No common cipher suite with ", data.PeerId.GetTag())
+		return
+	}
+
 	// Challenge -> Source
-	challengeNonce := generateNonce()
+	selfEphKey, err := keyexchange.GenerateEphemeralKey()
+	if err != nil {
+		log.Println("Failed to generate ephemeral key: ", err)
+		return
+	}
+
+	challengeNonce := h.generateNonce()
 	challengeMsg := messages.ChallengeMessage{
 		Nonce:          helloMsg.Nonce,
 		ChallengeNonce: challengeNonce,
+		EphKey:         selfEphKey.PublicKey().Bytes(),
+		CipherSuite:    suite,
+		ObservedAddr:   data.PeerAddr.String(),
 	}
 
-	if err = h.send("challenge", challengeMsg, data.PeerAddr); err != nil {
-		log.Println("Failed to send challenge message: ", err)
+	// Answer <- Source
+	// The initiator retransmits, so the challenge is only sent again when the hello is repeated
+	shell, err := h.exchange(data, "challenge", challengeMsg, "hello", false, "answer")
+	if err != nil {
+		log.Println("Failed to receive answer message: ", err)
+		return
 	}
 
-	// Answer <- Source
 	var answerMsg messages.AnswerMessage
-	if err = h.wait(&answerMsg, data); err != nil {
-		log.Println("Failed to receive answer message: ", err)
+	if err = encodings.Decode(shell.Data, &answerMsg); err != nil {
+		log.Println("Failed to decode answer message: ", err)
+		h.report(data.PeerId.GetTag(), reputation.MisbehaviorUndecodable)
+		return
+	}
+
+	if !slices.Equal(answerMsg.ChallengeNonce, challengeNonce) {
+		log.Println("Challenge nonce mismatch from ", data.PeerId.GetTag())
+		return
+	}
+
+	// The initiator proves it owns SrcTag by signing the transcript
+	transcriptHash, err := transcriptHash(helloMsg, challengeMsg, answerMsg)
+	if err != nil {
+		log.Println("Failed to compute transcript hash: ", err)
+		return
+	}
+
+	peerSignedData, err := initiatorSignedData(transcriptHash)
+	if err != nil {
+		log.Println("Failed to encode transcript: ", err)
+		return
+	}
+
+	if !signatures.Verify(data.PeerId, peerSignedData, answerMsg.TranscriptSignature) {
+		log.Println("Invalid transcript signature from ", data.PeerId.GetTag())
+		h.report(data.PeerId.GetTag(), reputation.MisbehaviorBadSignature)
+		return
 	}
 
+	h.observedPeers.Put(data.PeerId.GetTag(), data.PeerAddr.String())
+
 	// Derive secret
 	ephKey, err := keyexchange.BytesToKey(answerMsg.EphKey)
 	if err != nil {
 		log.Println("Failed to parse ephemeral key: ", err)
+		return
 	}
 
 	secret, err := keyexchange.DeriveFromPrivateIdentity(h.selfId, ephKey)
 	if err != nil {
 		log.Println("Failed to derive secret: ", err)
+		return
+	}
+
+	ephSecret, err := selfEphKey.ECDH(ephKey)
+	if err != nil {
+		log.Println("Failed to derive ephemeral secret: ", err)
+		return
 	}
 
 	peer := network.Peer{
@@ -221,37 +416,50 @@ func (h *HandshakeHost) receiveHandshake(helloMsg messages.HelloMessage, addr *n
 		Addr: "", // Will be filled in later
 	}
 
-	extractSalt := append(helloMsg.Nonce, challengeNonce...)
-	keySeed := hkdf.Extract(sha256.New, secret, extractSalt)
-	session := h.sessions.GenerateSession(peer, keySeed)
+	keySeed := deriveKeySeed(secret, ephSecret, transcriptHash)
+	session := h.sessions.GenerateSession(peer, keySeed, suite)
 
 	// Accepted -> Source
-	secretSignature, err := signatures.Sign(h.selfId, secret)
+	acceptedMsg := messages.AcceptedMessage{
+		AcceptNonce: answerMsg.AcceptNonce,
+		SessionId:   session.Id,
+		TcpAddr:     h.advertisedGossipAddress(),
+	}
+
+	signedData, err := responderSignedData(transcriptHash, acceptedMsg)
 	if err != nil {
-		log.Println("Failed to sign secret: ", err)
+		log.Println("Failed to encode transcript: ", err)
+		return
 	}
 
-	acceptedMsg := messages.AcceptedMessage{
-		AcceptNonce:     answerMsg.AcceptNonce,
-		SessionId:       session.Id,
-		SecretSignature: secretSignature,
-		TcpAddr:         h.tcpAddr,
+	acceptedMsg.TranscriptSignature, err = signatures.Sign(h.selfId, signedData)
+	if err != nil {
+		log.Println("Failed to sign transcript: ", err)
+		return
+	}
+
+	acceptedPacket, err := h.seal("accept", acceptedMsg, true)
+	if err != nil {
+		log.Println("Failed to encode accepted message: ", err)
+		return
 	}
 
-	if err = h.send("accept", acceptedMsg, data.PeerAddr); err != nil {
+	if err = h.write(acceptedPacket, data.PeerAddr); err != nil {
 		log.Println("Failed to send accepted message: ", err)
+		return
 	}
 
 	log.Println("Handshake completed with ", data.PeerId.GetTag(), " Session ID: ", session.Id)
+
+	go h.refresh(HandshakePeerData{Id: data.PeerId, Addr: data.PeerAddr})
+
+	// The accept may be lost: answer repeated answers until the initiator has surely given up
+	release()
+	h.linger(data, shell, acceptedPacket)
 }
 
 func (h *HandshakeHost) Listen(address string) error {
-	udpAddr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		return err
-	}
-
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	udpConn, err := h.listener.ListenPacket(address)
 	if err != nil {
 		return err
 	}
@@ -263,121 +471,325 @@ func (h *HandshakeHost) Listen(address string) error {
 }
 
 func (h *HandshakeHost) listenLoop() {
-	buf := make([]byte, 1024)
+	buf := make([]byte, maxDatagramSize)
 	for {
 		select {
 		case <-(*h.cancellation).Done():
 			return
 		default:
-			n, addr, err := h.udpServer.ReadFromUDP(buf)
+			n, packetAddr, err := h.udpServer.ReadFrom(buf)
 			if err != nil {
 				log.Println("Failed to read from udp: ", err)
 				return
 			}
 
+			addr, ok := packetAddr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+
+			// The buffer is reused by the next read, while subscribers may still hold the message
 			udpMsg := UdpMessage{
-				Data: buf[:n],
+				Data: slices.Clone(buf[:n]),
 				Addr: addr,
 			}
 
-			var shellMsg messages.MessageShell
-			if err = encodings.Decode(udpMsg.Data, &shellMsg); err != nil {
+			shellMsg, err := messages.DecodeShell(udpMsg.Data)
+			if err != nil {
 				log.Println("Failed to decode message shell: ", err)
 				continue
 			}
 
-			var helloMsg messages.HelloMessage
-			if encodings.Decode(shellMsg.Data, &helloMsg) == nil {
-				go h.receiveHandshake(helloMsg, addr)
+			if shellMsg.Cmd == "hello" {
+				h.handleHello(shellMsg, udpMsg)
 				continue
 			}
 
+			if shellMsg.Cmd == "find_node" {
+				h.handleFindNode(shellMsg, udpMsg)
+				continue
+			}
+
+			if shellMsg.Cmd == "punch_request" {
+				h.handlePunchRequest(shellMsg, udpMsg)
+				continue
+			}
+
+			if shellMsg.Cmd == "punch" {
+				continue
+			}
+
+			// Intros are also awaited by the side that requested the punch
+			if shellMsg.Cmd == "punch_intro" {
+				h.handlePunchIntro(shellMsg, udpMsg)
+			}
+
 			h.received.Notify(udpMsg)
 		}
 	}
 }
 
+// handleHello decides what to do with a hello, keeping no state unless it carries a valid cookie.
+func (h *HandshakeHost) handleHello(shellMsg messages.MessageShell, udpMsg UdpMessage) {
+	if !h.limiter.Allow(udpMsg.Addr.IP.String()) {
+		return
+	}
+
+	var helloMsg messages.HelloMessage
+	if encodings.Decode(shellMsg.Data, &helloMsg) != nil {
+		return
+	}
+
+	if helloMsg.DstTag != h.selfId.GetTag() {
+		return
+	}
+
+	if h.reputation.IsBanned(helloMsg.SrcTag) || !h.allowlist.IsAllowed(helloMsg.SrcTag) {
+		return
+	}
+
+	if !h.cookies.IsValid(helloMsg, udpMsg.Addr) {
+		h.sendCookie(helloMsg, udpMsg.Addr)
+		return
+	}
+
+	// A retransmitted hello is handled by the handshake already running for it
+	nonce := hex.EncodeToString(helloMsg.Nonce)
+	key := udpMsg.Addr.String() + "|" + nonce
+	if _, found := h.inProgress.Get(key); found {
+		h.received.Notify(udpMsg)
+		return
+	}
+
+	// Only checked once the source proved its address, as verifying signatures is expensive
+	srcId, err := identity.FromTag(helloMsg.SrcTag)
+	if err != nil || !signatures.Verify(srcId, shellMsg.Data, shellMsg.Signature) {
+		return
+	}
+
+	if _, replayed := h.nonces.Get(nonce); replayed {
+		log.Println("Dropping replayed hello from ", udpMsg.Addr.String())
+		return
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		log.Println("Too many handshakes in progress, dropping hello from ", udpMsg.Addr.String())
+		return
+	}
+
+	h.nonces.Put(nonce, true)
+	h.inProgress.Set(key, true)
+	go h.receiveHandshake(helloMsg, srcId, udpMsg.Addr, key)
+}
+
+// report must only be called for messages signed by the peer, so a peer cannot be blamed by someone else.
+func (h *HandshakeHost) report(tag string, misbehavior reputation.Misbehavior) {
+	if err := h.reputation.Report(tag, misbehavior); err != nil {
+		log.Println("failed to save ban of ", tag, ": ", err)
+	}
+}
+
+func (h *HandshakeHost) sendCookie(helloMsg messages.HelloMessage, addr *net.UDPAddr) {
+	cookieMsg := messages.CookieMessage{
+		Nonce:  helloMsg.Nonce,
+		Cookie: h.cookies.Make(helloMsg, addr),
+	}
+
+	packet, err := h.seal("cookie", cookieMsg, false)
+	if err != nil {
+		log.Println("Failed to encode cookie message: ", err)
+		return
+	}
+
+	if err = h.write(packet, addr); err != nil {
+		log.Println("Failed to send cookie message: ", err)
+	}
+}
+
 func (h *HandshakeHost) Close() error {
+	h.closeMux.Lock()
+	defer h.closeMux.Unlock()
+
+	h.closed = true
 	h.cancel()
 	h.received.Close()
 	return nil
 }
 
+// subscribe fails once the host is closed, where subscribing to the closed observable would panic
+func (h *HandshakeHost) subscribe() (*observable.Subscription[UdpMessage], error) {
+	h.closeMux.Lock()
+	defer h.closeMux.Unlock()
+
+	if h.closed {
+		return nil, ErrHostClosed
+	}
+
+	return h.received.Subscribe(), nil
+}
+
 // Helper methods
-func (h *HandshakeHost) send(cmd string, data any, addr *net.UDPAddr) error {
+func (h *HandshakeHost) seal(cmd string, data any, sign bool) ([]byte, error) {
 	dataBytes, err := encodings.Encode(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	signature, err := signatures.Sign(h.selfId, dataBytes)
-	if err != nil {
-		return err
+	shell := messages.MessageShell{
+		Version: messages.ProtocolVersion,
+		Cmd:     cmd,
+		Data:    dataBytes,
 	}
 
-	shell := messages.MessageShell{
-		Cmd:       cmd,
-		Data:      dataBytes,
-		Signature: signature,
+	if sign {
+		shell.Signature, err = signatures.Sign(h.selfId, dataBytes)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	shellData, err := encodings.Encode(shell)
-	if err != nil {
-		return err
+	return encodings.Encode(shell)
+}
+
+func (h *HandshakeHost) write(packet []byte, addr *net.UDPAddr) error {
+	if h.udpServer == nil {
+		return ErrNotListening
+	}
+
+	n, err := h.udpServer.WriteTo(packet, addr)
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return errorutil.WithInner(err.Error(), network.ErrPeerUnreachable)
 	}
 
-	n, err := h.udpServer.WriteToUDP(shellData, addr)
 	if err != nil {
 		return err
 	}
 
-	if n != len(shellData) {
+	if n != len(packet) {
 		return errors.New("failed to send all data")
 	}
 
 	return nil
 }
 
-func (h *HandshakeHost) wait(out any, data HandshakeData) error {
-	sub := data.Subscription
+// exchange sends a message and waits for the reply with one of the expected commands.
+// The message is sent again whenever duplicateCmd is received from the peer and,
+// if retransmit is set, each time the retransmission interval passes without a reply.
+func (h *HandshakeHost) exchange(data HandshakeData, cmd string, msg any, duplicateCmd string, retransmit bool, expected ...string) (messages.MessageShell, error) {
+	packet, err := h.seal(cmd, msg, true)
+	if err != nil {
+		return messages.MessageShell{}, err
+	}
+
+	if err = h.write(packet, data.PeerAddr); err != nil {
+		return messages.MessageShell{}, err
+	}
 
 	ctx, cancel := context.WithTimeout(*h.cancellation, time.Duration(h.defaultTimeoutSecs)*time.Second)
 	defer cancel()
 
-	var shell messages.MessageShell
+	interval := h.retransmitInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	if !retransmit {
+		timer.Stop()
+	}
+
 	for {
 		select {
-		case udpMsg := <-sub.Channel():
-			if udpMsg.Addr.String() != data.PeerAddr.String() {
+		case udpMsg := <-data.Subscription.Channel():
+			shell, ok := h.accept(udpMsg, data)
+			if !ok {
 				continue
 			}
 
-			if encodings.Decode(udpMsg.Data, &shell) != nil {
+			if shell.Cmd == duplicateCmd {
+				if err := h.write(packet, data.PeerAddr); err != nil {
+					return messages.MessageShell{}, err
+				}
+
 				continue
 			}
 
-			if !signatures.Verify(data.PeerId, shell.Data, shell.Signature) {
-				continue
+			if slices.Contains(expected, shell.Cmd) {
+				return shell, nil
+			}
+		case <-timer.C:
+			if err := h.write(packet, data.PeerAddr); err != nil {
+				return messages.MessageShell{}, err
 			}
 
-			if err := encodings.Decode(shell.Data, out); err != nil {
-				log.Println("Received a valid shell and signature but failed to decode message: ", err)
-				continue
+			interval *= 2
+			timer.Reset(interval)
+		case <-ctx.Done():
+			if (*h.cancellation).Err() != nil {
+				return messages.MessageShell{}, ErrHostClosed
 			}
 
-			return nil
-		case <-(*h.cancellation).Done():
+			return messages.MessageShell{}, errorutil.WithInner("timed out while waiting for a message", network.ErrPeerUnreachable)
+		}
+	}
+}
+
+// linger sends the packet again whenever the duplicate message is received, until the handshake timeout passes.
+// Other messages of the same peer may belong to a newer handshake, and are ignored.
+func (h *HandshakeHost) linger(data HandshakeData, duplicate messages.MessageShell, packet []byte) {
+	ctx, cancel := context.WithTimeout(*h.cancellation, time.Duration(h.defaultTimeoutSecs)*time.Second)
+	defer cancel()
+
+	for {
+		select {
+		case udpMsg := <-data.Subscription.Channel():
+			if shell, ok := h.accept(udpMsg, data); ok && shell.Cmd == duplicate.Cmd && bytes.Equal(shell.Data, duplicate.Data) {
+				_ = h.write(packet, data.PeerAddr)
+			}
 		case <-ctx.Done():
-			return errors.New("timed out while waiting for a message")
+			return
 		}
 	}
 }
 
-func generateNonce() []byte {
-	// TODO: check if nonce is unique
+// accept returns the shell of a message coming from the peer of the handshake.
+// Cookies are the only messages that are not signed.
+func (h *HandshakeHost) accept(udpMsg UdpMessage, data HandshakeData) (messages.MessageShell, bool) {
+	if udpMsg.Addr.String() != data.PeerAddr.String() {
+		return messages.MessageShell{}, false
+	}
+
+	shell, err := messages.DecodeShell(udpMsg.Data)
+	if err != nil {
+		return messages.MessageShell{}, false
+	}
+
+	if shell.Cmd != "cookie" && !signatures.Verify(data.PeerId, shell.Data, shell.Signature) {
+		return messages.MessageShell{}, false
+	}
+
+	return shell, true
+}
+
+// deriveKeySeed mixes the ephemeral-static and the ephemeral-ephemeral secrets.
+// The ephemeral-ephemeral secret gives forward secrecy: leaking an identity key later does not reveal the session.
+func deriveKeySeed(secret []byte, ephSecret []byte, transcriptHash []byte) []byte {
+	ikm := append(slices.Clone(secret), ephSecret...)
+	return hkdf.Extract(sha256.New, ikm, transcriptHash)
+}
+
+// generateNonce returns a random nonce not seen recently, either generated here or received in a hello.
+func (h *HandshakeHost) generateNonce() []byte {
 	nonce := make([]byte, 32)
-	_, _ = rand.Read(nonce)
-	return nonce
+	for {
+		_, _ = rand.Read(nonce)
+
+		key := hex.EncodeToString(nonce)
+		if _, found := h.nonces.Get(key); !found {
+			h.nonces.Put(key, true)
+			return nonce
+		}
+	}
 }
 
 // HandshakeHost implements connections.Handshaker
@@ -1,13 +1,37 @@
 package handshake_test
 
 import (
+	"crypto/sha256"
+	"io"
+	"net"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/keyexchange"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/dht"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip/gossiptunnel"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+	"github.com/titosilva/drmchain-pos/network/internal/natsim"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
 	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+	"golang.org/x/crypto/hkdf"
 )
 
 func Test__TwoHosts__Connecting(t *testing.T) {
@@ -45,3 +69,769 @@ func Test__TwoHosts__Connecting(t *testing.T) {
 		t.Error("Error connecting: ", err)
 	}
 }
+
+func Test__ReceiveHandshake__ShouldNotAccept__WhenTranscriptIsNotSignedByInitiator(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	responder := handshake.GetFromDI(diCtx)
+
+	if err := responder.Listen("localhost:52003"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	initiator, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", "localhost:52003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	helloWithCookie(t, conn, initiator, responderId)
+
+	var challenge messages.ChallengeMessage
+	if !receiveRaw(conn, "challenge", &challenge) {
+		t.Fatal("Expected a challenge message")
+	}
+
+	ephKey, _ := keyexchange.GenerateEphemeralKey()
+	badSignature, _ := signatures.Sign(initiator, []byte("not the transcript"))
+
+	// Act
+	sendRaw(t, conn, initiator, "answer", messages.AnswerMessage{
+		EphKey:              ephKey.PublicKey().Bytes(),
+		ChallengeNonce:      challenge.ChallengeNonce,
+		AcceptNonce:         []byte("accept"),
+		TranscriptSignature: badSignature,
+	})
+
+	// Assert
+	var accepted messages.AcceptedMessage
+	if receiveRaw(conn, "accept", &accepted) {
+		t.Error("Expected the handshake not to be accepted")
+	}
+}
+
+func sendRaw(t *testing.T, conn net.Conn, id identity.PrivateIdentity, cmd string, msg any) {
+	data, _ := encodings.Encode(msg)
+	signature, _ := signatures.Sign(id, data)
+	shell, _ := encodings.Encode(messages.MessageShell{Version: messages.ProtocolVersion, Cmd: cmd, Data: data, Signature: signature})
+
+	if _, err := conn.Write(shell); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveRaw waits a second for a message with the given command, skipping any other.
+func receiveRaw(conn net.Conn, cmd string, out any) bool {
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return false
+		}
+
+		var shell messages.MessageShell
+		if encodings.Decode(buf[:n], &shell) != nil || shell.Cmd != cmd {
+			continue
+		}
+
+		return encodings.Decode(shell.Data, out) == nil
+	}
+}
+
+// helloWithCookie sends a hello, then sends it again with the cookie given by the responder.
+func helloWithCookie(t *testing.T, conn net.Conn, initiator identity.PrivateIdentity, responderId identity.PublicIdentity) messages.HelloMessage {
+	hello := messages.HelloMessage{
+		SrcTag:       initiator.GetTag(),
+		DstTag:       responderId.GetTag(),
+		Nonce:        []byte(initiator.GetTag()),
+		CipherSuites: ciphersuites.Supported,
+	}
+	sendRaw(t, conn, initiator, "hello", hello)
+
+	var cookie messages.CookieMessage
+	if !receiveRaw(conn, "cookie", &cookie) {
+		t.Fatal("Expected a cookie message")
+	}
+
+	hello.Cookie = cookie.Cookie
+	sendRaw(t, conn, initiator, "hello", hello)
+	return hello
+}
+
+func Test__ConnectTo__ShouldKeepSessionSecret__WhenIdentityKeyLeaksLater(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	h1 := handshake.GetFromDI(diCtx)
+	h2 := handshake.GetFromDI(diCtx)
+
+	if err := h1.Listen("localhost:52004"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h1.Close()
+
+	if err := h2.Listen("localhost:52005"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h2.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	recorded := relay(t, "localhost:52006", "localhost:52005")
+
+	// Act
+	session, err := h1.ConnectTo(network.Peer{Id: responderId, Addr: "localhost:52006"})
+	if err != nil {
+		t.Fatal("Error connecting: ", err)
+	}
+
+	// A frame of the session recorded by the attacker, together with the handshake.
+	// Both hosts share the identity of the DI context, so the responder is also the sender
+	sealed := recordSeal(t, session, responderId)
+
+	// Assert
+	if err := openRecorded(session, responderId, session.KeySeed, sealed); err != nil {
+		t.Fatal("Expected the session keys to open the frame: ", err)
+	}
+
+	// Later, the attacker gets the responder identity key. With the public transcript,
+	// it can only compute the ephemeral-static secret, not the ephemeral-ephemeral one
+	var hello messages.HelloMessage
+	var challenge messages.ChallengeMessage
+	var answer messages.AnswerMessage
+	for _, packet := range recorded() {
+		var shell messages.MessageShell
+		if encodings.Decode(packet, &shell) != nil {
+			continue
+		}
+
+		switch shell.Cmd {
+		case "hello":
+			encodings.Decode(shell.Data, &hello)
+		case "challenge":
+			encodings.Decode(shell.Data, &challenge)
+		case "answer":
+			encodings.Decode(shell.Data, &answer)
+		}
+	}
+
+	if answer.EphKey == nil || challenge.EphKey == nil {
+		t.Fatal("Expected the handshake to be recorded")
+	}
+
+	initiatorEphKey, err := keyexchange.BytesToKey(answer.EphKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leakedSecret, err := keyexchange.DeriveFromPrivateIdentity(responderId, initiatorEphKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer.TranscriptSignature = nil
+	transcript, _ := encodings.Encode(struct {
+		Hello     messages.HelloMessage
+		Challenge messages.ChallengeMessage
+		Answer    messages.AnswerMessage
+	}{hello, challenge, answer})
+	transcriptHash := cryptutil.Hash(transcript)
+
+	// Every way to combine what the attacker knows into a seed, including the public ephemeral keys
+	candidates := [][]byte{
+		leakedSecret,
+		hkdf.Extract(sha256.New, leakedSecret, transcriptHash),
+		hkdf.Extract(sha256.New, leakedSecret, nil),
+		hkdf.Extract(sha256.New, append(slices.Clone(leakedSecret), challenge.EphKey...), transcriptHash),
+		hkdf.Extract(sha256.New, append(slices.Clone(leakedSecret), answer.EphKey...), transcriptHash),
+	}
+
+	for i, seed := range candidates {
+		if err := openRecorded(session, responderId, seed, sealed); err == nil {
+			t.Errorf("Expected the frame to stay sealed, but seed candidate %d opened it", i)
+		}
+	}
+}
+
+// recordSeal seals a frame with a gossip tunnel of the session, as the session traffic is, and returns it as sent on the wire
+func recordSeal(t *testing.T, session *sessions.Session, selfId identity.PrivateIdentity) []byte {
+	conn, wire := net.Pipe()
+	tun, err := gossiptunnel.New(conn, session, selfId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		tun.AnswerInit()
+		conn.Close()
+	}()
+
+	recorded, err := io.ReadAll(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return recorded
+}
+
+// openRecorded replays the recorded frame to a gossip tunnel of the session keyed with the seed.
+// The tunnel opens the frame as it opens any frame of its peer, so it fails unless the seed is the one of the session.
+func openRecorded(session *sessions.Session, selfId identity.PrivateIdentity, seed []byte, recorded []byte) error {
+	conn, wire := net.Pipe()
+	defer conn.Close()
+	defer wire.Close()
+
+	guess := sessions.NewSession(session.Id, seed, session.CipherSuite, session.Peer)
+	tun, err := gossiptunnel.New(conn, guess, selfId)
+	if err != nil {
+		return err
+	}
+
+	go wire.Write(recorded)
+	return tun.AwaitInit()
+}
+
+func Test__Listen__ShouldRateLimitHellos__WhenSourceFloods(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	configuration := networkconfig.GetFromDI(diCtx)
+	configuration.HandshakesPerSecond = 1
+	configuration.HandshakeBurst = 3
+
+	responder := handshake.GetFromDI(diCtx)
+	if err := responder.Listen("localhost:52007"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	initiator, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", "localhost:52007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Act
+	for range 20 {
+		sendRaw(t, conn, initiator, "hello", messages.HelloMessage{SrcTag: initiator.GetTag(), DstTag: responderId.GetTag(), Nonce: []byte("nonce")})
+	}
+
+	// Assert
+	cookies := 0
+	var cookie messages.CookieMessage
+	for receiveRaw(conn, "cookie", &cookie) {
+		cookies++
+	}
+
+	if cookies < 1 || cookies > 4 {
+		t.Errorf("Expected only the burst of hellos to be answered, got %d cookies", cookies)
+	}
+}
+
+func Test__ReceiveHandshake__ShouldDropHello__WhenTooManyHandshakesAreInProgress(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	networkconfig.GetFromDI(diCtx).MaxConcurrentHandshakes = 1
+
+	responder := handshake.GetFromDI(diCtx)
+	if err := responder.Listen("localhost:52008"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	first, second := generateIdentity(t), generateIdentity(t)
+	firstConn, secondConn := dial(t, "localhost:52008"), dial(t, "localhost:52008")
+
+	helloWithCookie(t, firstConn, first, responderId)
+	var challenge messages.ChallengeMessage
+	if !receiveRaw(firstConn, "challenge", &challenge) {
+		t.Fatal("Expected the first handshake to be challenged")
+	}
+
+	// Act
+	helloWithCookie(t, secondConn, second, responderId)
+
+	// Assert
+	if receiveRaw(secondConn, "challenge", &challenge) {
+		t.Error("Expected the second handshake not to be challenged while the first is in progress")
+	}
+}
+
+func Test__ConnectTo__ShouldSucceed__WhenPacketsAreLost(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	h1 := handshake.GetFromDI(diCtx)
+	h2 := handshake.GetFromDI(diCtx)
+
+	if err := h1.Listen("localhost:52009"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h1.Close()
+
+	if err := h2.Listen("localhost:52010"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h2.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	// The first copy of every packet is dropped, in both directions
+	seen := make(map[string]bool)
+	lossyRelay(t, "localhost:52011", "localhost:52010", func(packet []byte) bool {
+		first := !seen[string(packet)]
+		seen[string(packet)] = true
+		return first
+	})
+
+	// Act
+	_, err = h1.ConnectTo(network.Peer{Id: responderId, Addr: "localhost:52011"})
+
+	// Assert
+	if err != nil {
+		t.Error("Expected the handshake to survive packet loss, got ", err)
+	}
+}
+
+func Test__Listen__ShouldIgnoreHello__WhenAddressedToAnotherIdentity(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	responder := handshake.GetFromDI(diCtx)
+
+	if err := responder.Listen("localhost:52012"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	initiator, other := generateIdentity(t), generateIdentity(t)
+	conn := dial(t, "localhost:52012")
+
+	// Act
+	sendRaw(t, conn, initiator, "hello", messages.HelloMessage{SrcTag: initiator.GetTag(), DstTag: other.GetTag(), Nonce: []byte("nonce")})
+
+	// Assert
+	var cookie messages.CookieMessage
+	if receiveRaw(conn, "cookie", &cookie) {
+		t.Error("Expected a hello to another identity to be ignored")
+	}
+}
+
+func Test__ReceiveHandshake__ShouldNotChallenge__WhenHelloIsReplayed(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	responder := handshake.GetFromDI(diCtx)
+
+	if err := responder.Listen("localhost:52013"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	initiator := generateIdentity(t)
+	conn := dial(t, "localhost:52013")
+
+	hello := helloWithCookie(t, conn, initiator, responderId)
+	var challenge messages.ChallengeMessage
+	if !receiveRaw(conn, "challenge", &challenge) {
+		t.Fatal("Expected a challenge message")
+	}
+
+	// An invalid answer ends the handshake
+	sendRaw(t, conn, initiator, "answer", messages.AnswerMessage{ChallengeNonce: challenge.ChallengeNonce})
+	time.Sleep(100 * time.Millisecond)
+
+	// Act
+	sendRaw(t, conn, initiator, "hello", hello)
+
+	// Assert
+	if receiveRaw(conn, "challenge", &challenge) {
+		t.Error("Expected a replayed hello not to be challenged")
+	}
+}
+
+func Test__ReceiveHandshake__ShouldNotChallenge__WhenHelloIsNotSignedBySource(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	responder := handshake.GetFromDI(diCtx)
+
+	if err := responder.Listen("localhost:52014"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	victim, forger := generateIdentity(t), generateIdentity(t)
+	conn := dial(t, "localhost:52014")
+
+	hello := messages.HelloMessage{SrcTag: victim.GetTag(), DstTag: responderId.GetTag(), Nonce: []byte("nonce"), CipherSuites: ciphersuites.Supported}
+	sendRaw(t, conn, forger, "hello", hello)
+
+	var cookie messages.CookieMessage
+	if !receiveRaw(conn, "cookie", &cookie) {
+		t.Fatal("Expected a cookie message")
+	}
+
+	// Act
+	hello.Cookie = cookie.Cookie
+	sendRaw(t, conn, forger, "hello", hello)
+
+	// Assert
+	var challenge messages.ChallengeMessage
+	if receiveRaw(conn, "challenge", &challenge) {
+		t.Error("Expected a hello signed by someone else than its source not to be challenged")
+	}
+}
+
+func Test__ConnectTo__ShouldAgreeOnCipherSuite(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	h1 := handshake.GetFromDI(diCtx)
+	h2 := handshake.GetFromDI(diCtx)
+
+	if err := h1.Listen("localhost:52015"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h1.Close()
+
+	if err := h2.Listen("localhost:52016"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h2.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	// Act
+	session, err := h1.ConnectTo(network.Peer{Id: responderId, Addr: "localhost:52016"})
+
+	// Assert
+	if err != nil {
+		t.Fatal("Error connecting: ", err)
+	}
+
+	if session.CipherSuite != ciphersuites.Supported[0] {
+		t.Errorf("Expected the preferred suite %d, got %d", ciphersuites.Supported[0], session.CipherSuite)
+	}
+
+	peerSession := sessions.GetFromDI(diCtx).GetSession(session.Id)
+	if peerSession == nil || peerSession.CipherSuite != session.CipherSuite {
+		t.Error("Expected both sides to use the same suite")
+	}
+}
+
+func Test__ReceiveHandshake__ShouldNotChallenge__WhenNoCipherSuiteIsSupported(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	responder := handshake.GetFromDI(diCtx)
+
+	if err := responder.Listen("localhost:52017"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	initiator := generateIdentity(t)
+	conn := dial(t, "localhost:52017")
+
+	hello := messages.HelloMessage{SrcTag: initiator.GetTag(), DstTag: responderId.GetTag(), Nonce: []byte("nonce"), CipherSuites: []ciphersuites.Suite{99}}
+	sendRaw(t, conn, initiator, "hello", hello)
+
+	var cookie messages.CookieMessage
+	if !receiveRaw(conn, "cookie", &cookie) {
+		t.Fatal("Expected a cookie message")
+	}
+
+	// Act
+	hello.Cookie = cookie.Cookie
+	sendRaw(t, conn, initiator, "hello", hello)
+
+	// Assert
+	var challenge messages.ChallengeMessage
+	if receiveRaw(conn, "challenge", &challenge) {
+		t.Error("Expected a hello without common cipher suites not to be challenged")
+	}
+}
+
+func Test__ConnectTo__ShouldUseReachedHost__WhenResponderAdvertisesUnspecifiedAddress(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	networkconfig.GetFromDI(diCtx).GossipHost = "0.0.0.0:52019"
+
+	h1 := handshake.GetFromDI(diCtx)
+	h2 := handshake.GetFromDI(diCtx)
+
+	if err := h1.Listen("127.0.0.1:52023"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h1.Close()
+
+	if err := h2.Listen("127.0.0.1:52018"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer h2.Close()
+
+	responderId, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	// Act
+	session, err := h1.ConnectTo(network.Peer{Id: responderId, Addr: "127.0.0.1:52018"})
+
+	// Assert
+	if err != nil {
+		t.Fatal("Error connecting: ", err)
+	}
+
+	if session.Peer.Addr != "127.0.0.1:52019" {
+		t.Errorf("Expected the gossip address at the reached host, got %s", session.Peer.Addr)
+	}
+}
+
+func Test__Punch__ShouldLetHandshakeThroughNat__WhenRelayKnowsBothPeers(t *testing.T) {
+	// Arrange
+	_, relayId := listenIsolated(t, "127.0.0.1:52020", false)
+	initiator, _ := listenIsolated(t, "127.0.0.1:52021", true)
+	responder, responderId := listenIsolated(t, "127.0.0.1:52022", true)
+
+	for _, h := range []connections.Handshaker{initiator, responder} {
+		if _, err := h.ConnectTo(network.Peer{Id: relayId, Addr: "127.0.0.1:52020"}); err != nil {
+			t.Fatal("Error connecting to the relay: ", err)
+		}
+	}
+
+	// Act
+	var peer network.Peer
+	var err error
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		// The relay becomes a contact once the refresh after the handshake is answered
+		if peer, err = initiator.Punch(responderId.GetTag()); err == nil || time.Since(start) > 2*time.Second {
+			break
+		}
+	}
+
+	if err != nil {
+		t.Fatal("Error punching: ", err)
+	}
+
+	_, err = initiator.ConnectTo(peer)
+
+	// Assert
+	if err != nil {
+		t.Fatal("Expected the handshake to go through the punched NAT: ", err)
+	}
+
+	// The NAT translated the port, so only the relay observation gives the reachable address
+	external, _ := natsim.ExternalAddress("127.0.0.1:52022")
+	if peer.Addr != external {
+		t.Errorf("Expected the address observed by the relay, %s, got %s", external, peer.Addr)
+	}
+}
+
+func Test__Listen__ShouldNotAnswerFindNode__WhenSenderIsBanned(t *testing.T) {
+	// Arrange
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	responder := handshake.GetFromDI(diCtx)
+
+	if err := responder.Listen("localhost:52024"); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	defer responder.Close()
+
+	sender := generateIdentity(t)
+	if err := reputation.GetFromDI(diCtx).Ban(sender.GetTag(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := network.NewPeerRecord(sender, "localhost:52025")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dial(t, "localhost:52024")
+	findNode := messages.FindNodeMessage{Target: make([]byte, len(dht.NodeId{})), Nonce: []byte("nonce"), Sender: record}
+
+	// Act
+	sendRaw(t, conn, sender, "find_node", findNode)
+
+	// Assert
+	var nodes messages.NodesMessage
+	if receiveRaw(conn, "nodes", &nodes) {
+		t.Fatal("Expected no answer to a banned sender")
+	}
+
+	if err := reputation.GetFromDI(diCtx).Unban(sender.GetTag()); err != nil {
+		t.Fatal(err)
+	}
+
+	sendRaw(t, conn, sender, "find_node", findNode)
+	if !receiveRaw(conn, "nodes", &nodes) {
+		t.Error("Expected an answer once the sender is unbanned")
+	}
+}
+
+// listenIsolated starts a handshake host with its own identity, optionally behind a simulated NAT.
+func listenIsolated(t *testing.T, address string, behindNat bool) (connections.Handshaker, identity.PublicIdentity) {
+	storageDir := t.TempDir()
+
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+
+	if behindNat {
+		di.AddInterfaceSingleton(diCtx, func(*di.DIContext) handshake.PacketListener {
+			return natsim.Listener{}
+		})
+	}
+
+	h := handshake.GetFromDI(diCtx)
+	if err := h.Listen(address); err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	id, err := identityprovider.GetFromDI(diCtx).GetIdentity()
+	if err != nil {
+		t.Fatal("Error getting identity: ", err)
+	}
+
+	return h, id
+}
+
+func generateIdentity(t *testing.T) identity.PrivateIdentity {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func dial(t *testing.T, address string) net.Conn {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// relay forwards UDP packets between a single client and the target, recording every packet.
+func relay(t *testing.T, address string, target string) func() [][]byte {
+	mux := &sync.Mutex{}
+	recorded := make([][]byte, 0)
+
+	lossyRelay(t, address, target, func(packet []byte) bool {
+		mux.Lock()
+		defer mux.Unlock()
+
+		recorded = append(recorded, packet)
+		return false
+	})
+
+	return func() [][]byte {
+		mux.Lock()
+		defer mux.Unlock()
+		return slices.Clone(recorded)
+	}
+}
+
+// lossyRelay forwards UDP packets between a single client and the target, except the ones drop returns true for.
+func lossyRelay(t *testing.T, address string, target string, drop func(packet []byte) bool) {
+	relayAddr, _ := net.ResolveUDPAddr("udp", address)
+	targetAddr, _ := net.ResolveUDPAddr("udp", target)
+
+	conn, err := net.ListenUDP("udp", relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		var client *net.UDPAddr
+		buf := make([]byte, 2048)
+
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			packet := slices.Clone(buf[:n])
+			fromTarget := from.String() == targetAddr.String()
+			if !fromTarget {
+				client = from
+			}
+
+			if drop(packet) {
+				continue
+			}
+
+			if fromTarget {
+				conn.WriteToUDP(packet, client)
+			} else {
+				conn.WriteToUDP(packet, targetAddr)
+			}
+		}
+	}()
+}
@@ -1,32 +1,117 @@
 package messages
 
+import (
+	"errors"
+
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+)
+
+// Version of the handshake wire protocol. Messages of other versions are dropped.
+const ProtocolVersion = 1
+
+var ErrUnsupportedVersion = errors.New("unsupported handshake protocol version")
+
 type MessageShell struct {
+	Version   int
 	Cmd       string
 	Data      []byte
 	Signature []byte
 }
 
+// DecodeShell decodes a datagram, failing with ErrUnsupportedVersion if it was sent with another protocol version.
+func DecodeShell(bs []byte) (MessageShell, error) {
+	shell, err := encodings.DecodeAs[MessageShell](bs)
+	if err != nil {
+		return MessageShell{}, err
+	}
+
+	if shell.Version != ProtocolVersion {
+		return MessageShell{}, ErrUnsupportedVersion
+	}
+
+	return shell, nil
+}
+
+// HelloMessage starts a handshake. The first hello from a source has no cookie,
+// and is answered with a CookieMessage that must be echoed in a new hello.
+// CipherSuites are the suites the initiator can seal the session with, the preferred first.
 type HelloMessage struct {
-	SrcTag  string
-	DstTag  string
-	SrcAddr string
-	Nonce   []byte
+	SrcTag       string
+	DstTag       string
+	SrcAddr      string
+	Nonce        []byte
+	Cookie       []byte
+	CipherSuites []ciphersuites.Suite
+}
+
+// CookieMessage is not signed, so that answering a hello costs nothing to the responder.
+type CookieMessage struct {
+	Nonce  []byte
+	Cookie []byte
 }
 
+// ChallengeMessage carries the responder ephemeral key, used for the ephemeral-ephemeral key agreement,
+// and the cipher suite it picked among the offered ones.
+// ObservedAddr is the address the hello came from, so an initiator behind a NAT learns its public address.
 type ChallengeMessage struct {
 	ChallengeNonce []byte
 	Nonce          []byte
+	EphKey         []byte
+	CipherSuite    ciphersuites.Suite
+	ObservedAddr   string
 }
 
+// AnswerMessage carries the initiator signature over the transcript of hello, challenge and answer.
+// The signature itself is left out of the transcript.
 type AnswerMessage struct {
-	EphKey         []byte
-	ChallengeNonce []byte
-	AcceptNonce    []byte
+	EphKey              []byte
+	ChallengeNonce      []byte
+	AcceptNonce         []byte
+	TranscriptSignature []byte
 }
 
+// AcceptedMessage carries the responder signature over the same transcript and the accepted fields.
 type AcceptedMessage struct {
-	TcpAddr         string
-	SecretSignature []byte
-	SessionId       string
-	AcceptNonce     []byte
+	TcpAddr             string
+	TranscriptSignature []byte
+	SessionId           string
+	AcceptNonce         []byte
+}
+
+// FindNodeMessage asks for the contacts closest to Target, a DHT node id.
+// Sender is the signed record of the asker, which the receiver may add to its routing table.
+type FindNodeMessage struct {
+	Target []byte
+	Nonce  []byte
+	Sender network.PeerRecord
+}
+
+// NodesMessage answers a FindNodeMessage with the same nonce.
+type NodesMessage struct {
+	Nonce   []byte
+	Sender  network.PeerRecord
+	Records []network.PeerRecord
+}
+
+// PunchRequestMessage asks a relay, a peer both sides completed a handshake with, to introduce SrcTag to DstTag.
+type PunchRequestMessage struct {
+	SrcTag string
+	DstTag string
+	Nonce  []byte
+}
+
+// PunchIntroMessage is sent by the relay to both sides of a punch, with the address it observed the other side at.
+// Each side then sends PunchMessages to that address, so its NAT lets the handshake through.
+type PunchIntroMessage struct {
+	RelayTag string
+	PeerTag  string
+	PeerAddr string
+	Nonce    []byte
+}
+
+// PunchMessage only opens a mapping in the NAT of the sender, and is dropped by the receiver.
+type PunchMessage struct {
+	Nonce []byte
 }
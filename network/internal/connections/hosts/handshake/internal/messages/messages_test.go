@@ -0,0 +1,95 @@
+package messages_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
+)
+
+func Test__DecodeShell__ShouldFail__WhenVersionIsNotSupported(t *testing.T) {
+	// Arrange
+	bs, _ := encodings.Encode(messages.MessageShell{Version: messages.ProtocolVersion + 1, Cmd: "hello"})
+
+	// Act
+	_, err := messages.DecodeShell(bs)
+
+	// Assert
+	if err != messages.ErrUnsupportedVersion {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func FuzzDecodeShell(f *testing.F) {
+	seed, _ := encodings.Encode(messages.MessageShell{Version: messages.ProtocolVersion, Cmd: "hello", Data: []byte{1}, Signature: []byte{2}})
+	f.Add(seed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		shell, err := messages.DecodeShell(bs)
+		if err != nil {
+			return
+		}
+
+		if shell.Version != messages.ProtocolVersion {
+			t.Errorf("Decoded a shell of version %d", shell.Version)
+		}
+
+		roundTrip(t, shell)
+	})
+}
+
+func FuzzDecodeMessages(f *testing.F) {
+	nonce := bytes.Repeat([]byte{7}, 32)
+	for _, msg := range []any{
+		messages.HelloMessage{SrcTag: "src", DstTag: "dst", SrcAddr: "localhost:2503", Nonce: nonce, Cookie: nonce, CipherSuites: ciphersuites.Supported},
+		messages.CookieMessage{Nonce: nonce, Cookie: nonce},
+		messages.ChallengeMessage{ChallengeNonce: nonce, Nonce: nonce, EphKey: nonce, CipherSuite: ciphersuites.AES256GCM, ObservedAddr: "127.0.0.1:2503"},
+		messages.AnswerMessage{EphKey: nonce, ChallengeNonce: nonce, AcceptNonce: nonce, TranscriptSignature: nonce},
+		messages.AcceptedMessage{TcpAddr: "localhost:2504", TranscriptSignature: nonce, SessionId: "session", AcceptNonce: nonce},
+		messages.PunchRequestMessage{SrcTag: "src", DstTag: "dst", Nonce: nonce},
+		messages.PunchIntroMessage{RelayTag: "relay", PeerTag: "peer", PeerAddr: "127.0.0.1:2503", Nonce: nonce},
+	} {
+		seed, _ := encodings.Encode(msg)
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		decodeAndRoundTrip[messages.HelloMessage](t, bs)
+		decodeAndRoundTrip[messages.CookieMessage](t, bs)
+		decodeAndRoundTrip[messages.ChallengeMessage](t, bs)
+		decodeAndRoundTrip[messages.AnswerMessage](t, bs)
+		decodeAndRoundTrip[messages.AcceptedMessage](t, bs)
+		decodeAndRoundTrip[messages.PunchRequestMessage](t, bs)
+		decodeAndRoundTrip[messages.PunchIntroMessage](t, bs)
+	})
+}
+
+func decodeAndRoundTrip[T any](t *testing.T, bs []byte) {
+	msg, err := encodings.DecodeAs[T](bs)
+	if err != nil {
+		return
+	}
+
+	roundTrip(t, msg)
+}
+
+// roundTrip checks that a decoded message can be encoded and decoded back to itself.
+func roundTrip[T any](t *testing.T, msg T) {
+	encoded, err := encodings.Encode(msg)
+	if err != nil {
+		t.Fatalf("Failed to encode decoded message %v: %v", msg, err)
+	}
+
+	decoded, err := encodings.DecodeAs[T](encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode encoded message %v: %v", msg, err)
+	}
+
+	again, _ := encodings.Encode(decoded)
+	if !bytes.Equal(encoded, again) {
+		t.Errorf("Expected %v to survive an encoding round trip", msg)
+	}
+}
@@ -0,0 +1,345 @@
+package handshake
+
+import (
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/dht"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
+)
+
+const (
+	// An observed address is advertised once this many peers agree on it, so a single peer cannot misdirect us
+	minAddressObservations = 2
+	maxAddressObservations = 16
+
+	// Number of peers whose observed addresses are remembered, to relay punches between them
+	observedPeersCapacity = 1024
+
+	// Relays asked to introduce us to a peer, and punches sent to open the NAT mapping towards it
+	punchRelays = 3
+	punchCount  = 3
+)
+
+var ErrNoRelay = errors.New("no relay could introduce the peer")
+
+// PacketListener opens the socket the handshake host listens on.
+// Tests replace it through DI, to put hosts behind a simulated NAT.
+type PacketListener interface {
+	ListenPacket(address string) (net.PacketConn, error)
+}
+
+type udpListener struct{}
+
+func (udpListener) ListenPacket(address string) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.ListenUDP("udp", udpAddr)
+}
+
+func ListenerFactory(diCtx *di.DIContext) PacketListener {
+	return udpListener{}
+}
+
+type addressObservation struct {
+	peerTag string
+	addr    string
+}
+
+// addressObservations keeps the last address each peer observed us at, for the most recent peers.
+type addressObservations struct {
+	observations []addressObservation
+	mux          *sync.Mutex
+}
+
+func newAddressObservations() *addressObservations {
+	return &addressObservations{
+		observations: make([]addressObservation, 0, maxAddressObservations),
+		mux:          &sync.Mutex{},
+	}
+}
+
+func (o *addressObservations) Add(peerTag string, addr string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.observations = slices.DeleteFunc(o.observations, func(observation addressObservation) bool {
+		return observation.peerTag == peerTag
+	})
+
+	if len(o.observations) == maxAddressObservations {
+		o.observations = o.observations[1:]
+	}
+
+	o.observations = append(o.observations, addressObservation{peerTag: peerTag, addr: addr})
+}
+
+// Get returns the address observed by the most peers, if at least minAddressObservations agree on it.
+func (o *addressObservations) Get() (string, bool) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	counts := make(map[string]int)
+	best := ""
+	for _, observation := range o.observations {
+		counts[observation.addr]++
+		if counts[observation.addr] > counts[best] {
+			best = observation.addr
+		}
+	}
+
+	return best, counts[best] >= minAddressObservations
+}
+
+// AdvertisedAddress implements connections.Handshaker.
+// It is AdvertisedHandshakeHost if configured, else the address peers observed us at, else the bind address.
+func (h *HandshakeHost) AdvertisedAddress() string {
+	if h.configuration.AdvertisedHandshakeHost != "" {
+		return h.configuration.AdvertisedHandshakeHost
+	}
+
+	if observed, found := h.observations.Get(); found {
+		return observed
+	}
+
+	return h.address
+}
+
+// advertisedGossipAddress is the gossip address given to peers: AdvertisedGossipHost if configured,
+// else the host peers observed us at with the port of GossipHost, else GossipHost.
+func (h *HandshakeHost) advertisedGossipAddress() string {
+	if h.configuration.AdvertisedGossipHost != "" {
+		return h.configuration.AdvertisedGossipHost
+	}
+
+	observed, found := h.observations.Get()
+	if !found {
+		return h.configuration.GossipHost
+	}
+
+	observedHost, _, err := net.SplitHostPort(observed)
+	if err != nil {
+		return h.configuration.GossipHost
+	}
+
+	_, port, err := net.SplitHostPort(h.configuration.GossipHost)
+	if err != nil {
+		return h.configuration.GossipHost
+	}
+
+	return net.JoinHostPort(observedHost, port)
+}
+
+// reachableAddr replaces an unspecified host in an address advertised by a peer, such as 0.0.0.0,
+// with the host the peer was reached at.
+func reachableAddr(advertised string, reached *net.UDPAddr) string {
+	host, port, err := net.SplitHostPort(advertised)
+	if err != nil {
+		return advertised
+	}
+
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return advertised
+	}
+
+	return net.JoinHostPort(reached.IP.String(), port)
+}
+
+// Punch implements connections.Handshaker.
+// It asks the contacts closest to the peer, among the ones we completed a handshake with, to introduce us.
+// Both sides then send punches to each other, so their NATs let the handshake through.
+func (h *HandshakeHost) Punch(tag string) (network.Peer, error) {
+	id, err := identity.FromTag(tag)
+	if err != nil {
+		return network.Peer{}, err
+	}
+
+	asked := 0
+	for _, contact := range h.routes.Closest(dht.IdFromTag(tag), dht.BucketSize) {
+		if asked == punchRelays {
+			break
+		}
+
+		relayAddr, found := h.observedPeers.Get(contact.Tag)
+		if !found || contact.Tag == tag {
+			continue
+		}
+
+		asked++
+		peerAddr, err := h.requestPunch(contact, relayAddr, tag)
+		if err != nil {
+			log.Println("Relay ", contact.Tag, " failed to introduce ", tag, ": ", err)
+			continue
+		}
+
+		return network.Peer{Id: id, Addr: peerAddr}, nil
+	}
+
+	return network.Peer{}, ErrNoRelay
+}
+
+func (h *HandshakeHost) requestPunch(relay network.PeerRecord, relayAddr string, tag string) (string, error) {
+	relayId, err := relay.GetIdentity()
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", relayAddr)
+	if err != nil {
+		return "", err
+	}
+
+	subscription, err := h.subscribe()
+	if err != nil {
+		return "", err
+	}
+
+	data := HandshakeData{
+		PeerId:       relayId,
+		PeerAddr:     addr,
+		Subscription: subscription,
+	}
+	defer data.Subscription.Unsubscribe()
+
+	nonce := h.generateNonce()
+	request := messages.PunchRequestMessage{
+		SrcTag: h.selfId.GetTag(),
+		DstTag: tag,
+		Nonce:  nonce,
+	}
+
+	shell, err := h.exchange(data, "punch_request", request, "", true, "punch_intro")
+	if err != nil {
+		return "", err
+	}
+
+	var intro messages.PunchIntroMessage
+	if err := encodings.Decode(shell.Data, &intro); err != nil {
+		return "", err
+	}
+
+	if intro.PeerTag != tag || !slices.Equal(intro.Nonce, nonce) {
+		return "", errors.New("punch intro mismatch")
+	}
+
+	// The punches were already sent when the intro went through the listener
+	return intro.PeerAddr, nil
+}
+
+// handlePunchRequest introduces two peers to each other. Only peers we completed a handshake with,
+// from the address we observed them at, are relayed, to both each other's observed addresses.
+func (h *HandshakeHost) handlePunchRequest(shellMsg messages.MessageShell, udpMsg UdpMessage) {
+	if !h.limiter.Allow(udpMsg.Addr.IP.String()) {
+		return
+	}
+
+	var request messages.PunchRequestMessage
+	if encodings.Decode(shellMsg.Data, &request) != nil {
+		return
+	}
+
+	srcAddr, found := h.observedPeers.Get(request.SrcTag)
+	if !found || srcAddr != udpMsg.Addr.String() {
+		return
+	}
+
+	srcId, err := identity.FromTag(request.SrcTag)
+	if err != nil || !signatures.Verify(srcId, shellMsg.Data, shellMsg.Signature) {
+		return
+	}
+
+	dstAddr, found := h.observedPeers.Get(request.DstTag)
+	if !found {
+		return
+	}
+
+	dstUdpAddr, err := net.ResolveUDPAddr("udp", dstAddr)
+	if err != nil {
+		return
+	}
+
+	h.sendIntro(request.DstTag, dstUdpAddr, request.SrcTag, srcAddr, request.Nonce)
+	h.sendIntro(request.SrcTag, udpMsg.Addr, request.DstTag, dstAddr, request.Nonce)
+}
+
+func (h *HandshakeHost) sendIntro(toTag string, to *net.UDPAddr, peerTag string, peerAddr string, nonce []byte) {
+	intro := messages.PunchIntroMessage{
+		RelayTag: h.selfId.GetTag(),
+		PeerTag:  peerTag,
+		PeerAddr: peerAddr,
+		Nonce:    nonce,
+	}
+
+	packet, err := h.seal("punch_intro", intro, true)
+	if err != nil {
+		log.Println("Failed to encode punch intro: ", err)
+		return
+	}
+
+	if err := h.write(packet, to); err != nil {
+		log.Println("Failed to send punch intro to ", toTag, ": ", err)
+	}
+}
+
+// handlePunchIntro punches towards the introduced peer, if the intro comes from a peer we completed a handshake with.
+func (h *HandshakeHost) handlePunchIntro(shellMsg messages.MessageShell, udpMsg UdpMessage) {
+	var intro messages.PunchIntroMessage
+	if encodings.Decode(shellMsg.Data, &intro) != nil {
+		return
+	}
+
+	relayAddr, found := h.observedPeers.Get(intro.RelayTag)
+	if !found || relayAddr != udpMsg.Addr.String() {
+		return
+	}
+
+	relayId, err := identity.FromTag(intro.RelayTag)
+	if err != nil || !signatures.Verify(relayId, shellMsg.Data, shellMsg.Signature) {
+		return
+	}
+
+	if h.reputation.IsBanned(intro.PeerTag) || !h.allowlist.IsAllowed(intro.PeerTag) {
+		return
+	}
+
+	peerAddr, err := net.ResolveUDPAddr("udp", intro.PeerAddr)
+	if err != nil {
+		return
+	}
+
+	go h.punch(peerAddr, intro.Nonce)
+}
+
+// punch sends a few punches, spaced by the retransmission interval, in case the first ones reach the NAT of the peer
+// before it opened its own mapping.
+func (h *HandshakeHost) punch(addr *net.UDPAddr, nonce []byte) {
+	packet, err := h.seal("punch", messages.PunchMessage{Nonce: nonce}, false)
+	if err != nil {
+		log.Println("Failed to encode punch: ", err)
+		return
+	}
+
+	for range punchCount {
+		if err := h.write(packet, addr); err != nil {
+			return
+		}
+
+		select {
+		case <-time.After(h.retransmitInterval):
+		case <-(*h.cancellation).Done():
+			return
+		}
+	}
+}
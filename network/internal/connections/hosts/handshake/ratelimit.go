@@ -0,0 +1,56 @@
+package handshake
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/clru"
+)
+
+// Number of sources tracked by the rate limiter. The least recently seen are forgotten first.
+const rateLimiterCapacity = 4096
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per source.
+type rateLimiter struct {
+	mux     *sync.Mutex
+	buckets *clru.Cache[string, *tokenBucket]
+	rate    float64
+	burst   float64
+}
+
+func newRateLimiter(ratePerSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		mux:     &sync.Mutex{},
+		buckets: clru.New[string, *tokenBucket](rateLimiterCapacity),
+		rate:    ratePerSecond,
+		burst:   float64(burst),
+	}
+}
+
+// Allow consumes a token of the source, if there is one.
+func (r *rateLimiter) Allow(source string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	now := time.Now()
+	bucket, found := r.buckets.Get(source)
+	if !found {
+		bucket = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets.Put(source, bucket)
+	}
+
+	bucket.tokens = min(r.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*r.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
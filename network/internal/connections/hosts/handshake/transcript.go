@@ -0,0 +1,65 @@
+package handshake
+
+import (
+	"github.com/titosilva/drmchain-pos/internal/utils/cryptutil"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake/internal/messages"
+)
+
+const (
+	initiatorRole = "initiator"
+	responderRole = "responder"
+)
+
+// transcript is everything both sides agreed on before the session is created.
+// Each side signs its hash, so a tampered or mixed handshake is rejected by the other side.
+type transcript struct {
+	Hello     messages.HelloMessage
+	Challenge messages.ChallengeMessage
+	Answer    messages.AnswerMessage
+}
+
+type initiatorSignedPart struct {
+	Role           string
+	TranscriptHash []byte
+}
+
+type responderSignedPart struct {
+	Role           string
+	TranscriptHash []byte
+	TcpAddr        string
+	SessionId      string
+	AcceptNonce    []byte
+}
+
+func transcriptHash(hello messages.HelloMessage, challenge messages.ChallengeMessage, answer messages.AnswerMessage) ([]byte, error) {
+	answer.TranscriptSignature = nil
+
+	bs, err := encodings.Encode(transcript{
+		Hello:     hello,
+		Challenge: challenge,
+		Answer:    answer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cryptutil.Hash(bs), nil
+}
+
+func initiatorSignedData(transcriptHash []byte) ([]byte, error) {
+	return encodings.Encode(initiatorSignedPart{
+		Role:           initiatorRole,
+		TranscriptHash: transcriptHash,
+	})
+}
+
+func responderSignedData(transcriptHash []byte, accepted messages.AcceptedMessage) ([]byte, error) {
+	return encodings.Encode(responderSignedPart{
+		Role:           responderRole,
+		TranscriptHash: transcriptHash,
+		TcpAddr:        accepted.TcpAddr,
+		SessionId:      accepted.SessionId,
+		AcceptNonce:    accepted.AcceptNonce,
+	})
+}
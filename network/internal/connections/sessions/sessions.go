@@ -1,38 +1,107 @@
 package sessions
 
 import (
+	"crypto/sha256"
+	"errors"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
 	"github.com/titosilva/drmchain-pos/internal/utils/uuid"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Sessions not connected within their lifetime are evicted
+	DefaultSessionLifetime = time.Hour
+	DefaultTicketLifetime  = 24 * time.Hour
 )
 
 type Session struct {
-	Id      string
-	KeySeed []byte
-	Peer    network.Peer
+	Id          string
+	KeySeed     []byte
+	CipherSuite ciphersuites.Suite
+	Peer        network.Peer
+	ExpiresAt   time.Time // Set when registered, if zero
 
 	previouslyConnectedMux *sync.Mutex
 	previouslyConnected    bool
 }
 
-func NewSession(id string, keySeed []byte, peer network.Peer) *Session {
+func NewSession(id string, keySeed []byte, suite ciphersuites.Suite, peer network.Peer) *Session {
 	return &Session{
 		Id:                     id,
 		KeySeed:                keySeed,
+		CipherSuite:            suite,
 		Peer:                   peer,
 		previouslyConnectedMux: &sync.Mutex{},
 	}
 }
 
+var ErrEmptyTicketId = errors.New("ticket id must not be empty")
+
+// Ticket allows a session with Peer to be resumed without a new handshake.
+// The side that issued the ticket stores it by Id, the side that received it stores it by the peer tag.
+// Both sides derive Secret from the key seed of the session the ticket was issued in.
+type Ticket struct {
+	Id          string
+	Secret      []byte
+	CipherSuite ciphersuites.Suite
+	Peer        network.Peer
+	ExpiresAt   time.Time
+}
+
+// NewTicket creates a ticket to resume the session, with a secret derived from its key seed.
+// Fails with ErrEmptyTicketId if id is empty, since an empty ticket id in an init means there is no ticket.
+func NewTicket(session *Session, id string, lifetime time.Duration) (*Ticket, error) {
+	if id == "" {
+		return nil, ErrEmptyTicketId
+	}
+
+	secret := make([]byte, sha256.Size)
+	keyGen := hkdf.Expand(sha256.New, session.KeySeed, []byte("resumption "+id))
+	if _, err := io.ReadFull(keyGen, secret); err != nil {
+		return nil, err
+	}
+
+	return &Ticket{
+		Id:          id,
+		Secret:      secret,
+		CipherSuite: session.CipherSuite,
+		Peer:        session.Peer,
+		ExpiresAt:   time.Now().Add(lifetime),
+	}, nil
+}
+
+// Resume creates the session resumed with the ticket.
+// Its key seed mixes the ticket secret with fresh nonces from both sides, so each resumption gets new keys.
+func (t *Ticket) Resume(sessionId string, initiatorNonce []byte, responderNonce []byte) *Session {
+	salt := append(append([]byte{}, initiatorNonce...), responderNonce...)
+	keySeed := hkdf.Extract(sha256.New, t.Secret, salt)
+
+	return NewSession(sessionId, keySeed, t.CipherSuite, t.Peer)
+}
+
 type Memory struct {
-	sessions *cmap.CMap[string, *Session]
+	sessions        *cmap.CMap[string, *Session]
+	issuedTickets   *cmap.CMap[string, *Ticket]
+	receivedTickets *cmap.CMap[string, *Ticket]
+	ticketsMux      *sync.Mutex // Makes taking a ticket atomic, so it is used only once
+
+	sessionLifetime time.Duration
 }
 
-func Factory(di *di.DIContext) *Memory {
-	return NewMemory()
+func Factory(diCtx *di.DIContext) *Memory {
+	config := networkconfig.GetFromDI(diCtx)
+
+	memory := NewMemory()
+	memory.sessionLifetime = config.SessionLifetime
+	return memory
 }
 
 func GetFromDI(diCtx *di.DIContext) *Memory {
@@ -51,23 +120,43 @@ func (s *Session) MarkConnected() {
 	s.previouslyConnectedMux.Unlock()
 }
 
+func (s *Session) IsExpired() bool {
+	return !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt)
+}
+
+func (t *Ticket) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
 func NewMemory() *Memory {
 	return &Memory{
-		sessions: cmap.New[string, *Session](),
+		sessions:        cmap.New[string, *Session](),
+		issuedTickets:   cmap.New[string, *Ticket](),
+		receivedTickets: cmap.New[string, *Ticket](),
+		ticketsMux:      &sync.Mutex{},
+		sessionLifetime: DefaultSessionLifetime,
 	}
 }
 
-func (m *Memory) GenerateSession(peer network.Peer, keysSeed []byte) *Session {
-	session := NewSession(uuid.NewUuid(), keysSeed, peer)
+func (m *Memory) GenerateSession(peer network.Peer, keysSeed []byte, suite ciphersuites.Suite) *Session {
+	session := NewSession(uuid.NewUuid(), keysSeed, suite, peer)
 
 	m.RegisterSession(session)
 	return session
 }
 
+// RegisterSession stores the session until it expires. Expired sessions and tickets are evicted on each call.
 func (m *Memory) RegisterSession(session *Session) {
+	m.Evict()
+
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(m.sessionLifetime)
+	}
+
 	m.sessions.Set(session.Id, session)
 }
 
+// GetSession returns nil if the session is unknown or expired.
 func (m *Memory) GetSession(id string) *Session {
 	session, ok := m.sessions.Get(id)
 
@@ -75,5 +164,75 @@ func (m *Memory) GetSession(id string) *Session {
 		return nil
 	}
 
+	if session.IsExpired() {
+		m.sessions.Delete(id)
+		return nil
+	}
+
 	return session
 }
+
+// StoreIssuedTicket keeps a ticket we issued, so the peer can present it later.
+func (m *Memory) StoreIssuedTicket(ticket *Ticket) {
+	m.Evict()
+	m.issuedTickets.Set(ticket.Id, ticket)
+}
+
+// GetIssuedTicket returns the ticket with the id without using it, or nil if it is unknown or expired.
+func (m *Memory) GetIssuedTicket(id string) *Ticket {
+	ticket, ok := m.issuedTickets.Get(id)
+	if !ok || ticket.IsExpired() {
+		return nil
+	}
+
+	return ticket
+}
+
+// TakeIssuedTicket removes and returns the ticket with the id, or nil if it is unknown or expired.
+func (m *Memory) TakeIssuedTicket(id string) *Ticket {
+	return m.take(m.issuedTickets, id)
+}
+
+// StoreReceivedTicket keeps a ticket issued by the peer of the ticket, replacing any older one.
+func (m *Memory) StoreReceivedTicket(ticket *Ticket) {
+	m.Evict()
+	m.receivedTickets.Set(ticket.Peer.Id.GetTag(), ticket)
+}
+
+// TakeReceivedTicket removes and returns the ticket issued by the peer with the tag, or nil if there is none.
+func (m *Memory) TakeReceivedTicket(peerTag string) *Ticket {
+	return m.take(m.receivedTickets, peerTag)
+}
+
+func (m *Memory) take(tickets *cmap.CMap[string, *Ticket], key string) *Ticket {
+	m.ticketsMux.Lock()
+	defer m.ticketsMux.Unlock()
+
+	ticket, ok := tickets.Get(key)
+	if !ok {
+		return nil
+	}
+
+	tickets.Delete(key)
+	if ticket.IsExpired() {
+		return nil
+	}
+
+	return ticket
+}
+
+// Evict removes the expired sessions and tickets.
+func (m *Memory) Evict() {
+	evictExpired(m.sessions)
+	evictExpired(m.issuedTickets)
+	evictExpired(m.receivedTickets)
+}
+
+// evictExpired deletes while iterating, which is safe because All iterates over a snapshot
+func evictExpired[V interface{ IsExpired() bool }](entries *cmap.CMap[string, V]) {
+	for entry := range entries.All() {
+		if entry.Value.IsExpired() {
+			entries.Delete(entry.Key)
+		}
+	}
+}
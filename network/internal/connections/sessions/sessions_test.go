@@ -0,0 +1,124 @@
+package sessions_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+)
+
+func Test__GetSession__ShouldReturnNil__WhenSessionExpired(t *testing.T) {
+	// Arrange
+	memory := sessions.NewMemory()
+	session := sessions.NewSession("expired", []byte("keyseed"), ciphersuites.AES256GCM, network.Peer{})
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	memory.RegisterSession(session)
+
+	// Act
+	found := memory.GetSession("expired")
+
+	// Assert
+	if found != nil {
+		t.Fatal("Expected expired session not to be found")
+	}
+}
+
+func Test__RegisterSession__ShouldSetExpiry__WhenNoneIsSet(t *testing.T) {
+	// Arrange
+	memory := sessions.NewMemory()
+
+	// Act
+	session := memory.GenerateSession(network.Peer{}, []byte("keyseed"), ciphersuites.AES256GCM)
+
+	// Assert
+	if session.ExpiresAt.IsZero() || session.IsExpired() {
+		t.Fatalf("Expected session to expire in the future, got %v", session.ExpiresAt)
+	}
+
+	if memory.GetSession(session.Id) != session {
+		t.Fatal("Expected session to be found")
+	}
+}
+
+func Test__Evict__ShouldRemoveOnlyExpiredTickets(t *testing.T) {
+	// Arrange
+	memory := sessions.NewMemory()
+	memory.StoreIssuedTicket(&sessions.Ticket{Id: "expired", ExpiresAt: time.Now().Add(-time.Second)})
+	memory.StoreIssuedTicket(&sessions.Ticket{Id: "valid", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// Act
+	memory.Evict()
+
+	// Assert
+	if memory.TakeIssuedTicket("expired") != nil {
+		t.Fatal("Expected expired ticket to be evicted")
+	}
+
+	if memory.TakeIssuedTicket("valid") == nil {
+		t.Fatal("Expected valid ticket to be kept")
+	}
+}
+
+func Test__TakeIssuedTicket__ShouldReturnNil__WhenTicketWasAlreadyTaken(t *testing.T) {
+	// Arrange
+	memory := sessions.NewMemory()
+	memory.StoreIssuedTicket(&sessions.Ticket{Id: "ticket", ExpiresAt: time.Now().Add(time.Hour)})
+	memory.TakeIssuedTicket("ticket")
+
+	// Act
+	ticket := memory.TakeIssuedTicket("ticket")
+
+	// Assert
+	if ticket != nil {
+		t.Fatal("Expected ticket to be used only once")
+	}
+}
+
+func Test__Resume__ShouldDeriveSameKeys__WhenBothSidesUseTheSameNonces(t *testing.T) {
+	// Arrange
+	session := sessions.NewSession("session", []byte("keyseed"), ciphersuites.ChaCha20Poly1305, network.Peer{})
+	issued, err := sessions.NewTicket(session, "ticket", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := sessions.NewTicket(session, "ticket", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	resumed1 := issued.Resume("resumed", []byte("initiator"), []byte("responder"))
+	resumed2 := received.Resume("resumed", []byte("initiator"), []byte("responder"))
+	other := received.Resume("resumed", []byte("initiator"), []byte("other"))
+
+	// Assert
+	if !bytes.Equal(resumed1.KeySeed, resumed2.KeySeed) {
+		t.Fatal("Expected both sides to derive the same key seed")
+	}
+
+	if bytes.Equal(resumed1.KeySeed, other.KeySeed) || bytes.Equal(resumed1.KeySeed, session.KeySeed) {
+		t.Fatal("Expected key seed to depend on the nonces")
+	}
+
+	if resumed1.CipherSuite != ciphersuites.ChaCha20Poly1305 {
+		t.Fatalf("Expected resumed session to keep the cipher suite, got %v", resumed1.CipherSuite)
+	}
+}
+
+func Test__NewTicket__ShouldFail__WhenIdIsEmpty(t *testing.T) {
+	// Arrange
+	session := sessions.NewSession("session", []byte("keyseed"), ciphersuites.ChaCha20Poly1305, network.Peer{})
+
+	// Act
+	_, err := sessions.NewTicket(session, "", time.Hour)
+
+	// Assert
+	if !errors.Is(err, sessions.ErrEmptyTicketId) {
+		t.Fatalf("Expected ErrEmptyTicketId, got %v", err)
+	}
+}
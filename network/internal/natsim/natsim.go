@@ -0,0 +1,101 @@
+// Package natsim simulates NATs on a single machine, so NAT traversal can be tested without a real network.
+package natsim
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
+)
+
+// Datagrams leave the NAT from the port of the bind address plus this offset, so peers observe
+// an address other than the one the socket was bound to
+const ExternalPortOffset = 1000
+
+// Conn is a UDP socket behind a port-restricted cone NAT. It keeps its external address for every destination,
+// but only receives datagrams from the addresses it sent to; the others are dropped, as the NAT would.
+// The bind address itself is private: nothing sent to it arrives.
+type Conn struct {
+	*net.UDPConn
+	internal *net.UDPAddr
+	allowed  *cmap.CMap[string, bool]
+	dropped  atomic.Int64
+}
+
+func Listen(address string) (*Conn, error) {
+	internal, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	external := &net.UDPAddr{IP: internal.IP, Port: internal.Port + ExternalPortOffset, Zone: internal.Zone}
+	udpConn, err := net.ListenUDP("udp", external)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{UDPConn: udpConn, internal: internal, allowed: cmap.New[string, bool]()}, nil
+}
+
+// ExternalAddress returns the address the NAT translates the bind address to, which peers observe.
+func ExternalAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(p+ExternalPortOffset)), nil
+}
+
+// ExternalAddr returns the address the NAT translated the bind address to.
+func (c *Conn) ExternalAddr() net.Addr {
+	return c.UDPConn.LocalAddr()
+}
+
+// LocalAddr implements net.PacketConn. It is the bind address, as seen from behind the NAT.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.internal
+}
+
+// ReadFrom implements net.PacketConn, skipping the datagrams the NAT would drop.
+func (c *Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.UDPConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+
+		if _, found := c.allowed.Get(addr.String()); found {
+			return n, addr, nil
+		}
+
+		c.dropped.Add(1)
+	}
+}
+
+// WriteTo implements net.PacketConn, opening a mapping towards the destination.
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.allowed.Set(addr.String(), true)
+	return c.UDPConn.WriteTo(p, addr)
+}
+
+// Dropped returns how many unsolicited datagrams the NAT dropped.
+func (c *Conn) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// Listener opens sockets behind a NAT. It implements handshake.PacketListener.
+type Listener struct{}
+
+func (Listener) ListenPacket(address string) (net.PacketConn, error) {
+	return Listen(address)
+}
+
+// Static impl check
+var _ net.PacketConn = (*Conn)(nil)
@@ -0,0 +1,89 @@
+package natsim_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network/internal/natsim"
+)
+
+func Test__Conn__ShouldDropDatagrams__WhenSourceWasNotContacted(t *testing.T) {
+	// Arrange
+	inside, err := natsim.Listen("127.0.0.1:53900")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inside.Close()
+
+	outside, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outside.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, _, err := inside.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:n])
+		}
+	}()
+
+	// Act
+	outside.WriteTo([]byte("unsolicited"), inside.ExternalAddr())
+	for start := time.Now(); inside.Dropped() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("expected the unsolicited datagram to be dropped")
+		}
+	}
+
+	inside.WriteTo([]byte("punch"), outside.LocalAddr())
+	outside.WriteTo([]byte("answer"), inside.ExternalAddr())
+
+	// Assert
+	select {
+	case data := <-received:
+		if data != "answer" {
+			t.Fatalf("expected only the answer to go through, got %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the answer to go through the NAT")
+	}
+}
+
+func Test__Conn__ShouldTranslatePort__WhenSending(t *testing.T) {
+	// Arrange
+	inside, err := natsim.Listen("127.0.0.1:53901")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inside.Close()
+
+	outside, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outside.Close()
+	outside.SetReadDeadline(time.Now().Add(time.Second))
+
+	// Act
+	inside.WriteTo([]byte("hello"), outside.LocalAddr())
+	buf := make([]byte, 16)
+	_, observed, err := outside.ReadFrom(buf)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inside.LocalAddr().String() != "127.0.0.1:53901" {
+		t.Errorf("expected the bind address to be kept behind the NAT, got %s", inside.LocalAddr())
+	}
+	if observed.String() != "127.0.0.1:54901" {
+		t.Errorf("expected the source to be translated to 127.0.0.1:54901, got %s", observed)
+	}
+}
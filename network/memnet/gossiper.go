@@ -0,0 +1,122 @@
+package memnet
+
+import (
+	"sync"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cbag"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+)
+
+// Gossiper implements connections.Gossiper in memory, connecting sessions agreed on by Handshaker over links.
+type Gossiper struct {
+	net       *Network
+	selfId    identity.PrivateIdentity
+	sessions  *sessions.Memory
+	address   string
+	onConnect func(network.Connection)
+
+	// Closed along with the gossiper, so its peers see it go down
+	links *cbag.CBag[*endpoint]
+	mux   *sync.Mutex
+}
+
+type connection struct {
+	peer   network.Peer
+	tunnel *tunnel.Mux
+}
+
+// GetPeer implements network.Connection.
+func (c *connection) GetPeer() network.Peer {
+	return c.peer
+}
+
+// GetTunnel implements network.Connection.
+func (c *connection) GetTunnel() tunnel.TopicTunnel {
+	return c.tunnel
+}
+
+func newGossiper(net *Network, diCtx *di.DIContext) *Gossiper {
+	selfId, _ := identityprovider.GetFromDI(diCtx).GetIdentity()
+
+	return &Gossiper{
+		net:      net,
+		selfId:   selfId,
+		sessions: sessions.GetFromDI(diCtx),
+		links:    cbag.New[*endpoint](),
+		mux:      &sync.Mutex{},
+	}
+}
+
+// Listen implements connections.Gossiper.
+func (g *Gossiper) Listen(addr string, onConnect func(network.Connection)) error {
+	g.mux.Lock()
+	g.address = normalize(addr)
+	g.onConnect = onConnect
+	g.mux.Unlock()
+
+	g.net.gossipers.Set(g.address, g)
+	return nil
+}
+
+// ConnectTo implements connections.Gossiper.
+func (g *Gossiper) ConnectTo(session *sessions.Session) (network.Connection, error) {
+	responder, found := g.net.gossipers.Get(normalize(session.Peer.Addr))
+	if !found || !g.net.canReach(g.selfId.GetTag(), session.Peer.Id.GetTag()) {
+		return nil, ErrUnreachable
+	}
+
+	responderSession := responder.sessions.GetSession(session.Id)
+	if responderSession == nil || responderSession.WasConnected() {
+		return nil, ErrUnknownSession
+	}
+	responderSession.MarkConnected()
+	session.MarkConnected()
+
+	local, remote := newLink(g.net, g.selfId.GetTag(), session.Peer.Id.GetTag())
+	g.links.Add(local)
+	responder.links.Add(remote)
+	go func() {
+		<-local.WaitClose()
+		g.links.Remove(local)
+		responder.links.Remove(remote)
+	}()
+
+	// Both multiplexers listen before either side can send
+	localConn := &connection{peer: session.Peer, tunnel: tunnel.NewMux(local)}
+	remoteConn := &connection{peer: responderSession.Peer, tunnel: tunnel.NewMux(remote)}
+
+	responder.mux.Lock()
+	onConnect := responder.onConnect
+	responder.mux.Unlock()
+
+	onConnect(remoteConn)
+	return localConn, nil
+}
+
+// Resume implements connections.Gossiper. No tickets are issued in memory, so the handshake is always redone.
+func (g *Gossiper) Resume(peer network.Peer) (network.Connection, error) {
+	return nil, ErrNoTicket
+}
+
+// Close implements connections.Gossiper.
+func (g *Gossiper) Close() error {
+	if current, found := g.net.gossipers.Get(g.address); found && current == g {
+		g.net.gossipers.Delete(g.address)
+	}
+
+	for link := range g.links.All() {
+		link.Close()
+	}
+
+	return nil
+}
+
+// Static impl checks
+var _ connections.Gossiper = (*Gossiper)(nil)
+var _ network.Connection = (*connection)(nil)
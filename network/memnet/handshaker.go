@@ -0,0 +1,114 @@
+package memnet
+
+import (
+	"crypto/rand"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/network/internal/connections"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/ciphersuites"
+	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+)
+
+// Handshaker implements connections.Handshaker in memory. Sessions are agreed on directly between both sides,
+// with the same refusals as the handshake host: hellos to another identity, from banned or unlisted peers.
+type Handshaker struct {
+	net           *Network
+	selfId        identity.PrivateIdentity
+	sessions      *sessions.Memory
+	configuration *networkconfig.NetworkConfig
+	reputation    *reputation.Reputation
+	allowlist     *allowlist.Allowlist
+	address       string
+}
+
+func newHandshaker(net *Network, diCtx *di.DIContext) *Handshaker {
+	selfId, _ := identityprovider.GetFromDI(diCtx).GetIdentity()
+
+	return &Handshaker{
+		net:           net,
+		selfId:        selfId,
+		sessions:      sessions.GetFromDI(diCtx),
+		configuration: networkconfig.GetFromDI(diCtx),
+		reputation:    reputation.GetFromDI(diCtx),
+		allowlist:     allowlist.GetFromDI(diCtx),
+	}
+}
+
+// Listen implements connections.Handshaker.
+func (h *Handshaker) Listen(addr string) error {
+	h.address = normalize(addr)
+	h.net.handshakers.Set(h.address, h)
+	return nil
+}
+
+// AdvertisedAddress implements connections.Handshaker. There are no NATs in memory, so it is the listen address.
+func (h *Handshaker) AdvertisedAddress() string {
+	return h.address
+}
+
+// ConnectTo implements connections.Handshaker. It takes a round trip of the link, on the clock of the network.
+func (h *Handshaker) ConnectTo(peer network.Peer) (*sessions.Session, error) {
+	responder, found := h.net.handshakers.Get(normalize(peer.Addr))
+	if !found || !responder.accepts(h.selfId.GetTag(), peer.Id.GetTag()) {
+		return nil, ErrUnreachable
+	}
+
+	for _, link := range [][2]string{{h.selfId.GetTag(), peer.Id.GetTag()}, {peer.Id.GetTag(), h.selfId.GetTag()}} {
+		dropped, delay, _ := h.net.schedule(link[0], link[1])
+		if dropped {
+			return nil, ErrUnreachable
+		}
+		<-h.net.after(delay)
+	}
+
+	keySeed := make([]byte, 32)
+	if _, err := rand.Read(keySeed); err != nil {
+		return nil, err
+	}
+
+	suite := ciphersuites.Supported[0]
+	responderSession := responder.sessions.GenerateSession(network.Peer{Id: h.selfId, Addr: h.configuration.GossipHost}, keySeed, suite)
+
+	session := sessions.NewSession(responderSession.Id, keySeed, suite, network.Peer{Id: peer.Id, Addr: responder.configuration.GossipHost})
+	h.sessions.RegisterSession(session)
+
+	return session, nil
+}
+
+func (h *Handshaker) accepts(srcTag string, dstTag string) bool {
+	return dstTag == h.selfId.GetTag() && !h.reputation.IsBanned(srcTag) && h.allowlist.IsAllowed(srcTag)
+}
+
+// FindPeer implements connections.Handshaker, looking the tag up among the listening nodes.
+func (h *Handshaker) FindPeer(tag string) (network.Peer, error) {
+	for entry := range h.net.handshakers.All() {
+		if entry.Value.selfId.GetTag() == tag {
+			return network.Peer{Id: entry.Value.selfId, Addr: entry.Key}, nil
+		}
+	}
+
+	return network.Peer{}, ErrPeerNotFound
+}
+
+// Punch implements connections.Handshaker. There are no NATs in memory, so it only finds the peer.
+func (h *Handshaker) Punch(tag string) (network.Peer, error) {
+	return h.FindPeer(tag)
+}
+
+// Close implements connections.Handshaker.
+func (h *Handshaker) Close() error {
+	if current, found := h.net.handshakers.Get(h.address); found && current == h {
+		h.net.handshakers.Delete(h.address)
+	}
+
+	return nil
+}
+
+// Static impl check
+var _ connections.Handshaker = (*Handshaker)(nil)
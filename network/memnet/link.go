@@ -0,0 +1,126 @@
+package memnet
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+)
+
+// Frames waiting for delivery on each end before Send blocks
+const queueSize = 1024
+
+type delivery struct {
+	at   time.Time
+	data []byte
+}
+
+// endpoint is one end of an in-memory link. It implements tunnel.DuplexTunnel.
+// What it sends is delivered to the other end under the conditions of the network.
+type endpoint struct {
+	net      *Network
+	selfTag  string
+	peerTag  string
+	peer     *endpoint
+	received *observable.Observable[[]byte]
+	queue    chan delivery
+
+	// Shared by both ends, so closing either closes the link
+	done chan struct{}
+	once *sync.Once
+}
+
+// newLink returns both ends of a link between two tags, delivering until one of them is closed.
+func newLink(net *Network, tagA string, tagB string) (*endpoint, *endpoint) {
+	done := make(chan struct{})
+	once := &sync.Once{}
+
+	a := &endpoint{net: net, selfTag: tagA, peerTag: tagB, received: observable.New[[]byte](), queue: make(chan delivery, queueSize), done: done, once: once}
+	b := &endpoint{net: net, selfTag: tagB, peerTag: tagA, received: observable.New[[]byte](), queue: make(chan delivery, queueSize), done: done, once: once}
+	a.peer, b.peer = b, a
+
+	go a.deliverLoop()
+	go b.deliverLoop()
+	return a, b
+}
+
+// Send implements tunnel.DuplexTunnel. Dropped frames are not reported, as on a real network.
+func (e *endpoint) Send(data []byte) error {
+	select {
+	case <-e.done:
+		return &tunnel.ErrorTunnelClosed{}
+	default:
+	}
+
+	dropped, delay, reordered := e.net.schedule(e.selfTag, e.peerTag)
+	if dropped {
+		return nil
+	}
+
+	data = slices.Clone(data)
+	if reordered {
+		e.net.clock.AfterFunc(delay, func() { e.peer.deliver(data) })
+		return nil
+	}
+
+	select {
+	case e.queue <- delivery{at: e.net.clock.Now().Add(delay), data: data}:
+		return nil
+	case <-e.done:
+		return &tunnel.ErrorTunnelClosed{}
+	}
+}
+
+// Subscribe implements tunnel.DuplexTunnel.
+func (e *endpoint) Subscribe() *observable.Subscription[[]byte] {
+	return e.received.Subscribe()
+}
+
+// WaitClose implements tunnel.DuplexTunnel.
+func (e *endpoint) WaitClose() <-chan struct{} {
+	return e.done
+}
+
+// Close implements tunnel.DuplexTunnel.
+func (e *endpoint) Close() error {
+	e.once.Do(func() { close(e.done) })
+	return nil
+}
+
+// deliverLoop delivers the queued frames in order, each no earlier than its delay on the clock of the network.
+func (e *endpoint) deliverLoop() {
+	for {
+		select {
+		case d := <-e.queue:
+			select {
+			case <-e.net.after(d.at.Sub(e.net.clock.Now())):
+			case <-e.done:
+				return
+			}
+
+			e.peer.deliver(d.data)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *endpoint) deliver(data []byte) {
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+
+	// Partitions made while the frame was on its way drop it too
+	if !e.net.canReach(e.peerTag, e.selfTag) {
+		return
+	}
+
+	e.received.Notify(data)
+}
+
+// Static impl check
+var _ tunnel.DuplexTunnel = (*endpoint)(nil)
@@ -0,0 +1,195 @@
+// Package memnet is an in-memory transport for the network, so that many nodes can run in a single process.
+// Links between the nodes can be given latency, loss, reordering and partitions. Delays pass in real time,
+// or on the clock given to NewWithClock.
+package memnet
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/cmap"
+	"github.com/titosilva/drmchain-pos/internal/utils/errorutil"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/internal/connections"
+)
+
+var (
+	ErrUnreachable    = errorutil.WithInner("address is unreachable", network.ErrPeerUnreachable)
+	ErrPeerNotFound   = errors.New("peer not found")
+	ErrNoTicket       = errors.New("sessions cannot be resumed in memory")
+	ErrUnknownSession = errors.New("session not found")
+)
+
+// Conditions of the links between nodes. Delays are drawn from Latency plus up to Jitter,
+// and frames are delivered in order unless picked for reordering.
+type Conditions struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64 // Probability that a frame is dropped
+
+	// Probability that a frame is held back for ReorderDelay, so the next frames overtake it
+	Reorder      float64
+	ReorderDelay time.Duration
+}
+
+// Clock on which the delays of the links pass. A simulation passes its virtual clock,
+// so frames are delivered as it advances instead of after real time.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+// Network is shared by the nodes of a simulation. Nodes reach each other by the addresses they listen on,
+// as with sockets. Randomness comes from the seed, so runs with the same seed drop the same frames.
+type Network struct {
+	handshakers *cmap.CMap[string, *Handshaker]
+	gossipers   *cmap.CMap[string, *Gossiper]
+
+	conditions     Conditions
+	linkConditions map[string]Conditions // By link, as fromTag|toTag
+	groups         map[string]int        // Partition group by tag
+	random         *rand.Rand
+	clock          Clock
+	mux            *sync.Mutex
+}
+
+// New returns a network whose delays pass in real time.
+func New(seed uint64) *Network {
+	return NewWithClock(seed, realClock{})
+}
+
+// NewWithClock returns a network whose delays pass on the clock.
+func NewWithClock(seed uint64, clock Clock) *Network {
+	return &Network{
+		handshakers:    cmap.New[string, *Handshaker](),
+		gossipers:      cmap.New[string, *Gossiper](),
+		linkConditions: make(map[string]Conditions),
+		groups:         make(map[string]int),
+		random:         rand.New(rand.NewPCG(seed, seed)),
+		clock:          clock,
+		mux:            &sync.Mutex{},
+	}
+}
+
+// AddTransport makes the network services of the DI context use this network instead of sockets.
+// It must be called after networkdi.AddNetworkServices.
+func (n *Network) AddTransport(diCtx *di.DIContext) *di.DIContext {
+	di.AddInterfaceFactory(diCtx, func(diCtx *di.DIContext) connections.Handshaker {
+		return newHandshaker(n, diCtx)
+	})
+	di.AddInterfaceFactory(diCtx, func(diCtx *di.DIContext) connections.Gossiper {
+		return newGossiper(n, diCtx)
+	})
+
+	return diCtx
+}
+
+// SetConditions sets the conditions of every link without conditions of its own.
+func (n *Network) SetConditions(conditions Conditions) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.conditions = conditions
+}
+
+// SetLinkConditions sets the conditions of the frames sent from one tag to another.
+func (n *Network) SetLinkConditions(fromTag string, toTag string, conditions Conditions) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.linkConditions[fromTag+"|"+toTag] = conditions
+}
+
+// Partition splits the tags into groups that cannot reach each other. Tags left out form a group of their own.
+// Frames sent across groups are dropped, and connections across groups fail.
+func (n *Network) Partition(groups ...[]string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, tag := range group {
+			n.groups[tag] = i + 1
+		}
+	}
+}
+
+// Heal removes the partitions.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+func (n *Network) canReach(fromTag string, toTag string) bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.groups[fromTag] == n.groups[toTag]
+}
+
+// schedule decides what happens to a frame on the link: whether it is dropped, its delay,
+// and whether it leaves the order of the link.
+func (n *Network) schedule(fromTag string, toTag string) (dropped bool, delay time.Duration, reordered bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.groups[fromTag] != n.groups[toTag] {
+		return true, 0, false
+	}
+
+	conditions, found := n.linkConditions[fromTag+"|"+toTag]
+	if !found {
+		conditions = n.conditions
+	}
+
+	if conditions.Loss > 0 && n.random.Float64() < conditions.Loss {
+		return true, 0, false
+	}
+
+	delay = conditions.Latency
+	if conditions.Jitter > 0 {
+		delay += time.Duration(n.random.Int64N(int64(conditions.Jitter)))
+	}
+
+	if conditions.Reorder > 0 && n.random.Float64() < conditions.Reorder {
+		return false, delay + conditions.ReorderDelay, true
+	}
+
+	return false, delay, false
+}
+
+// after returns a channel closed once d passed on the clock. Without a delay, it is closed right away,
+// so nothing waits on a virtual clock that is not being advanced.
+func (n *Network) after(d time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	if d <= 0 {
+		close(done)
+		return done
+	}
+
+	n.clock.AfterFunc(d, func() { close(done) })
+	return done
+}
+
+// normalize resolves the address as the network services do, so "localhost:2503" and "127.0.0.1:2503" match.
+func normalize(address string) string {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return address
+	}
+
+	return udpAddr.String()
+}
@@ -0,0 +1,202 @@
+package memnet_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/consensus/simulation"
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
+	"github.com/titosilva/drmchain-pos/internal/patterns/tunnel"
+	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/memnet"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+)
+
+// openNode opens a node on the in-memory network, with its own identity
+func openNode(t *testing.T, net *memnet.Network, port int) *network.Network {
+	storageDir := t.TempDir()
+
+	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = networkdi.AddNetworkServices(diCtx)
+	diCtx = net.AddTransport(diCtx)
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+
+	config := networkconfig.GetFromDI(diCtx)
+	config.HandshakeHost = network.Address{Host: "127.0.0.1", Port: port}.String()
+	config.GossipHost = network.Address{Host: "127.0.0.1", Port: port + 1}.String()
+
+	nw := di.GetService[network.Network](diCtx)
+	if err := nw.Open(); err != nil {
+		t.Fatalf("Error opening node on port %d: %s", port, err)
+	}
+	t.Cleanup(func() { nw.Close() })
+
+	return nw
+}
+
+// connectPair connects the first node to the second, returning the tunnel of each side
+func connectPair(t *testing.T, nwA *network.Network, nwB *network.Network, portB int) (tunnel.TopicTunnel, tunnel.TopicTunnel) {
+	if err := nwA.GetConnections().ConnectTo(nwB.GetSelf(), network.Address{Host: "127.0.0.1", Port: portB}); err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+
+	for connA := range nwA.GetConnections().Current().All() {
+		for connB := range nwB.GetConnections().Current().All() {
+			return connA.GetTunnel(), connB.GetTunnel()
+		}
+	}
+
+	t.Fatal("Expected both sides to be connected")
+	return nil, nil
+}
+
+func Test__ConnectTo__ShouldConnectBothSides__WhenNodesShareNetwork(t *testing.T) {
+	// Arrange
+	net := memnet.New(1)
+	nwA, nwB := openNode(t, net, 1000), openNode(t, net, 2000)
+
+	// Act
+	tunA, tunB := connectPair(t, nwA, nwB, 2000)
+	sub := tunB.Handle("topic")
+	defer sub.Unsubscribe()
+	tunA.Send("topic", []byte("hello"))
+
+	// Assert
+	if data, ok := sub.WaitNextWithTimeoutMs(500); !ok || string(data) != "hello" {
+		t.Fatalf("Expected the frame to reach the other side, got %q", data)
+	}
+
+	if nwA.GetConnections().Current().Count() != 1 || nwB.GetConnections().Current().Count() != 1 {
+		t.Fatal("Expected one connection on each side")
+	}
+}
+
+func Test__Partition__ShouldDropFramesAndRefuseConnections__UntilHealed(t *testing.T) {
+	// Arrange
+	net := memnet.New(1)
+	nwA, nwB := openNode(t, net, 1000), openNode(t, net, 2000)
+	tunA, tunB := connectPair(t, nwA, nwB, 2000)
+	sub := tunB.Handle("topic")
+	defer sub.Unsubscribe()
+
+	// Act
+	net.Partition([]string{nwA.GetSelf().GetTag()}, []string{nwB.GetSelf().GetTag()})
+	tunA.Send("topic", []byte("lost"))
+	err := nwB.GetConnections().ConnectTo(nwA.GetSelf(), network.Address{Host: "127.0.0.1", Port: 1000})
+
+	// Assert
+	if _, ok := sub.WaitNextWithTimeoutMs(200); ok {
+		t.Fatal("Expected the frame to be dropped by the partition")
+	}
+
+	if err == nil {
+		t.Fatal("Expected connecting across the partition to fail")
+	}
+
+	net.Heal()
+	tunA.Send("topic", []byte("healed"))
+	if data, ok := sub.WaitNextWithTimeoutMs(500); !ok || string(data) != "healed" {
+		t.Fatalf("Expected the frame to go through once healed, got %q", data)
+	}
+}
+
+func Test__SetConditions__ShouldDelayFrames__WhenLatencyIsSet(t *testing.T) {
+	// Arrange
+	net := memnet.New(1)
+	nwA, nwB := openNode(t, net, 1000), openNode(t, net, 2000)
+	tunA, tunB := connectPair(t, nwA, nwB, 2000)
+	sub := tunB.Handle("topic")
+	defer sub.Unsubscribe()
+
+	net.SetConditions(memnet.Conditions{Latency: 100 * time.Millisecond})
+
+	// Act
+	start := time.Now()
+	tunA.Send("topic", []byte("late"))
+	_, ok := sub.WaitNextWithTimeoutMs(500)
+
+	// Assert
+	if !ok {
+		t.Fatal("Expected the frame to arrive")
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Expected the frame to take the latency, took %s", elapsed)
+	}
+}
+
+func Test__SetConditions__ShouldHoldFrames__UntilClockPassesTheLatency(t *testing.T) {
+	// Arrange
+	clock := simulation.NewClock(time.Unix(0, 0))
+	net := memnet.NewWithClock(1, clock)
+	nwA, nwB := openNode(t, net, 1000), openNode(t, net, 2000)
+	tunA, tunB := connectPair(t, nwA, nwB, 2000)
+	sub := tunB.Handle("topic")
+	defer sub.Unsubscribe()
+
+	net.SetConditions(memnet.Conditions{Latency: time.Minute})
+
+	// Act
+	tunA.Send("topic", []byte("late"))
+	_, early := sub.WaitNextWithTimeoutMs(200)
+	clock.Advance(time.Minute)
+	data, ok := sub.WaitNextWithTimeoutMs(500)
+
+	// Assert
+	if early {
+		t.Fatal("Expected the frame to wait for the clock")
+	}
+
+	if !ok || string(data) != "late" {
+		t.Fatalf("Expected the frame to arrive once the clock passed the latency, got %q", data)
+	}
+}
+
+func Test__SetConditions__ShouldDropFrames__WhenLossIsCertain(t *testing.T) {
+	// Arrange
+	net := memnet.New(1)
+	nwA, nwB := openNode(t, net, 1000), openNode(t, net, 2000)
+	tunA, tunB := connectPair(t, nwA, nwB, 2000)
+	sub := tunB.Handle("topic")
+	defer sub.Unsubscribe()
+
+	net.SetConditions(memnet.Conditions{Loss: 1})
+
+	// Act
+	tunA.Send("topic", []byte("lost"))
+
+	// Assert
+	if _, ok := sub.WaitNextWithTimeoutMs(200); ok {
+		t.Fatal("Expected the frame to be lost")
+	}
+}
+
+func Test__SetLinkConditions__ShouldLetLaterFramesOvertake__WhenFrameIsReordered(t *testing.T) {
+	// Arrange
+	net := memnet.New(1)
+	nwA, nwB := openNode(t, net, 1000), openNode(t, net, 2000)
+	tunA, tunB := connectPair(t, nwA, nwB, 2000)
+	sub := tunB.Handle("topic")
+	defer sub.Unsubscribe()
+
+	tagA, tagB := nwA.GetSelf().GetTag(), nwB.GetSelf().GetTag()
+
+	// Act
+	net.SetLinkConditions(tagA, tagB, memnet.Conditions{Reorder: 1, ReorderDelay: 100 * time.Millisecond})
+	tunA.Send("topic", []byte("first"))
+	net.SetLinkConditions(tagA, tagB, memnet.Conditions{})
+	tunA.Send("topic", []byte("second"))
+
+	// Assert
+	for _, expected := range []string{"second", "first"} {
+		if data, ok := sub.WaitNextWithTimeoutMs(500); !ok || string(data) != expected {
+			t.Fatalf("Expected %q, got %q", expected, data)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"errors"
 	"net"
 	"strconv"
 
@@ -46,8 +47,16 @@ func (nw *Network) Close() error {
 
 type Connections interface {
 	Current() structures.Enumerable[Connection]
+	// Outbound returns the current connections to peers we connected to
+	Outbound() structures.Enumerable[Connection]
 	ConnectTo(id identity.PublicIdentity, addr Address) error
+	// ConnectToTag finds the address of the peer in the DHT, then connects to it
+	ConnectToTag(tag string) error
 	Subscribe() *observable.Subscription[Connection]
+	// SubscribeDisconnections notifies the connections removed because their tunnel was closed
+	SubscribeDisconnections() *observable.Subscription[Connection]
+	// AdvertisedAddress returns the handshake address peers can reach us at
+	AdvertisedAddress() string
 }
 
 type ConfigurableConnections interface {
@@ -59,7 +68,7 @@ type ConfigurableConnections interface {
 
 type Connection interface {
 	GetPeer() Peer
-	GetTunnel() tunnel.DuplexTunnel
+	GetTunnel() tunnel.TopicTunnel
 }
 
 type Address struct {
@@ -67,6 +76,31 @@ type Address struct {
 	Port int
 }
 
+var ErrPeerBanned = errors.New("peer is banned")
+
+var ErrPeerNotAllowed = errors.New("peer is not in the allowlist")
+
+// ErrPeerUnreachable is returned by handshakes the peer did not answer in time, or that could not reach it.
+// A NAT between us and the peer may be dropping them.
+var ErrPeerUnreachable = errors.New("peer is unreachable")
+
+var ErrInvalidAddress = errors.New("address must be in the host:port format")
+
+// ParseAddress parses an address in the host:port format.
+func ParseAddress(addr string) (Address, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return Address{}, ErrInvalidAddress
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return Address{}, ErrInvalidAddress
+	}
+
+	return Address{Host: host, Port: port}, nil
+}
+
 func (a Address) AsUdp() *net.UDPAddr {
 	addr, err := net.ResolveUDPAddr("udp", a.String())
 
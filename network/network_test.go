@@ -2,13 +2,18 @@ package network_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
 
 	"github.com/titosilva/drmchain-pos/network/networkconfig"
 	"github.com/titosilva/drmchain-pos/network/networkdi"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+	"github.com/titosilva/drmchain-pos/storage"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
 )
 
 func Test__TwoNetworkHostsConnecting(t *testing.T) {
@@ -45,6 +50,179 @@ func Test__TwoNetworkHostsConnecting(t *testing.T) {
 	}
 }
 
+func Test__Connections__ShouldReconnectOutboundPeer__WhenTunnelDrops(t *testing.T) {
+	// Arrange
+	nw1, err := openNetwork("localhost:2507", "localhost:2508")
+	if err != nil {
+		t.Fatalf("Error opening network 1: %s", err)
+	}
+	defer nw1.Close()
+
+	nw2, err := openNetwork("localhost:2509", "localhost:2510")
+	if err != nil {
+		t.Fatalf("Error opening network 2: %s", err)
+	}
+	defer nw2.Close()
+
+	if err := nw1.GetConnections().ConnectTo(nw2.GetSelf(), network.Address{Host: "localhost", Port: 2509}); err != nil {
+		t.Fatalf("Error connecting network 1 to network 2: %s", err)
+	}
+
+	disconnections := nw1.GetConnections().SubscribeDisconnections()
+	connections := nw1.GetConnections().Subscribe()
+
+	var dropped network.Connection
+	for conn := range nw2.GetConnections().Current().All() {
+		dropped = conn
+	}
+
+	// Act
+	dropped.GetTunnel().Close()
+
+	// Assert
+	select {
+	case conn := <-disconnections.Channel():
+		if conn.GetPeer().Id.GetTag() != nw2.GetSelf().GetTag() {
+			t.Errorf("Expected the disconnection of network 2, got %s", conn.GetPeer().Id.GetTag())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a disconnection event")
+	}
+
+	select {
+	case conn := <-connections.Channel():
+		if conn.GetPeer().Id.GetTag() != nw2.GetSelf().GetTag() {
+			t.Errorf("Expected a reconnection to network 2, got %s", conn.GetPeer().Id.GetTag())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected network 1 to reconnect")
+	}
+
+	if count := nw1.GetConnections().Current().Count(); count != 1 {
+		t.Errorf("Expected 1 connection after reconnecting, got %d", count)
+	}
+}
+
+func Test__ConnectToTag__ShouldFindPeerThroughDHT__WhenAddressIsUnknown(t *testing.T) {
+	// Arrange
+	nwA, _ := openIsolatedNetwork(t, "localhost:2517", "localhost:2518")
+	nwB, _ := openIsolatedNetwork(t, "localhost:2519", "localhost:2520")
+	nwC, _ := openIsolatedNetwork(t, "localhost:2521", "localhost:2522")
+
+	if err := nwB.GetConnections().ConnectTo(nwA.GetSelf(), network.Address{Host: "localhost", Port: 2517}); err != nil {
+		t.Fatalf("Error connecting B to A: %s", err)
+	}
+
+	if err := nwC.GetConnections().ConnectTo(nwA.GetSelf(), network.Address{Host: "localhost", Port: 2517}); err != nil {
+		t.Fatalf("Error connecting C to A: %s", err)
+	}
+
+	// Act
+	var err error
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(100 * time.Millisecond) {
+		if err = nwB.GetConnections().ConnectToTag(nwC.GetSelf().GetTag()); err == nil {
+			break
+		}
+	}
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Error connecting B to C by tag: %s", err)
+	}
+
+	found := false
+	for conn := range nwC.GetConnections().Current().All() {
+		found = found || conn.GetPeer().Id.GetTag() == nwB.GetSelf().GetTag()
+	}
+
+	if !found {
+		t.Fatal("Expected C to be connected to B")
+	}
+}
+
+func Test__Reputation__ShouldDisconnectAndRefusePeer__WhenItIsBanned(t *testing.T) {
+	// Arrange
+	nwA, diA := openIsolatedNetwork(t, "localhost:2535", "localhost:2536")
+	nwB, _ := openIsolatedNetwork(t, "localhost:2537", "localhost:2538")
+
+	if err := nwB.GetConnections().ConnectTo(nwA.GetSelf(), network.Address{Host: "localhost", Port: 2535}); err != nil {
+		t.Fatalf("Error connecting B to A: %s", err)
+	}
+
+	tagB := nwB.GetSelf().GetTag()
+	rep := reputation.GetFromDI(diA)
+
+	// Act
+	for !rep.IsBanned(tagB) {
+		if err := rep.Report(tagB, reputation.MisbehaviorBadSignature); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Assert
+	for start := time.Now(); nwA.GetConnections().Current().Count() > 0; time.Sleep(50 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Expected A to disconnect the banned peer")
+		}
+	}
+
+	if err := nwA.GetConnections().ConnectTo(nwB.GetSelf(), network.Address{Host: "localhost", Port: 2537}); err != network.ErrPeerBanned {
+		t.Fatalf("Expected connecting to a banned peer to fail, got %v", err)
+	}
+
+	// B keeps trying to reconnect, and its hellos are ignored
+	time.Sleep(500 * time.Millisecond)
+	if nwA.GetConnections().Current().Count() != 0 {
+		t.Fatal("Expected the banned peer not to reconnect")
+	}
+}
+
+func Test__Allowlist__ShouldDisconnectAndRefusePeer__WhenItIsRemoved(t *testing.T) {
+	// Arrange
+	nwB, _ := openIsolatedNetwork(t, "localhost:2541", "localhost:2542")
+	tagB := nwB.GetSelf().GetTag()
+
+	storageDir := t.TempDir()
+	diA := newDI("localhost:2539", "localhost:2540")
+	di.AddInterfaceFactory(diA, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+
+	config := networkconfig.GetFromDI(diA)
+	config.Permissioned = true
+	config.Allowlist = []string{tagB}
+
+	nwA := di.GetService[network.Network](diA)
+	if err := nwA.Open(); err != nil {
+		t.Fatalf("Error opening network: %s", err)
+	}
+	defer nwA.Close()
+
+	if err := nwB.GetConnections().ConnectTo(nwA.GetSelf(), network.Address{Host: "localhost", Port: 2539}); err != nil {
+		t.Fatalf("Error connecting B to A: %s", err)
+	}
+
+	// Act
+	allowlist.GetFromDI(diA).Remove(tagB)
+
+	// Assert
+	for start := time.Now(); nwA.GetConnections().Current().Count() > 0; time.Sleep(50 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Expected A to disconnect the removed peer")
+		}
+	}
+
+	if err := nwA.GetConnections().ConnectTo(nwB.GetSelf(), network.Address{Host: "localhost", Port: 2541}); err != network.ErrPeerNotAllowed {
+		t.Fatalf("Expected connecting to an unlisted peer to fail, got %v", err)
+	}
+
+	// B keeps trying to reconnect, and its hellos are ignored
+	time.Sleep(500 * time.Millisecond)
+	if nwA.GetConnections().Current().Count() != 0 {
+		t.Fatal("Expected the removed peer not to reconnect")
+	}
+}
+
 func newDI(handshakeHost string, gossipHost string) *di.DIContext {
 	diCtx := defaultdi.ConfigureDefaultDI()
 	diCtx = networkdi.AddNetworkServices(diCtx)
@@ -52,10 +230,29 @@ func newDI(handshakeHost string, gossipHost string) *di.DIContext {
 	config := networkconfig.GetFromDI(diCtx)
 	config.HandshakeHost = handshakeHost
 	config.GossipHost = gossipHost
+	config.ReconnectMinBackoff = 100 * time.Millisecond
 
 	return diCtx
 }
 
+// openIsolatedNetwork opens a network with its own storage, so it has its own identity
+func openIsolatedNetwork(t *testing.T, handshakeHost string, gossipHost string) (*network.Network, *di.DIContext) {
+	storageDir := t.TempDir()
+
+	diCtx := newDI(handshakeHost, gossipHost)
+	di.AddInterfaceFactory(diCtx, func(*di.DIContext) storage.BlobStorage {
+		return localstorage.New(storageDir)
+	})
+
+	nw := di.GetService[network.Network](diCtx)
+	if err := nw.Open(); err != nil {
+		t.Fatalf("Error opening network on %s: %s", handshakeHost, err)
+	}
+	t.Cleanup(func() { nw.Close() })
+
+	return nw, diCtx
+}
+
 func openNetwork(handshakeHost string, gossipHost string) (*network.Network, error) {
 	diCtx := newDI(handshakeHost, gossipHost)
 	net := di.GetService[network.Network](diCtx)
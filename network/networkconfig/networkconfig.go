@@ -1,16 +1,97 @@
 package networkconfig
 
-import "github.com/titosilva/drmchain-pos/internal/di"
+import (
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+)
 
 type NetworkConfig struct {
+	// Addresses the handshake and gossip hosts bind to
 	HandshakeHost string
 	GossipHost    string
+
+	// Addresses given to peers, for hosts behind a NAT or bound to 0.0.0.0. When empty, the address
+	// observed by the peers is advertised once enough of them agree, and the bind address until then
+	AdvertisedHandshakeHost string
+	AdvertisedGossipHost    string
+
+	// Limits of the handshake listener
+	MaxConcurrentHandshakes int
+	HandshakesPerSecond     float64 // Hellos accepted per second from a single IP
+	HandshakeBurst          int
+
+	// Tunnels ping after KeepAliveInterval without sending, and close after IdleTimeout without receiving
+	KeepAliveInterval time.Duration
+	IdleTimeout       time.Duration
+
+	// Connections to outbound peers are redone after they drop, waiting between attempts
+	// from ReconnectMinBackoff, doubled up to ReconnectMaxBackoff
+	ReconnectMinBackoff time.Duration
+	ReconnectMaxBackoff time.Duration
+
+	// Tunnels are rekeyed every RekeyInterval. Sessions must be connected within SessionLifetime,
+	// and the tickets issued to resume them are valid for TicketLifetime
+	RekeyInterval   time.Duration
+	SessionLifetime time.Duration
+	TicketLifetime  time.Duration
+
+	// Discovery connects to BootstrapPeers, given as tag@host:port, and to the peers they share,
+	// until TargetOutboundPeers are connected. Peers are exchanged every DiscoveryInterval,
+	// and records not refreshed by their owner within PeerRecordLifetime are dropped
+	BootstrapPeers      []string
+	TargetOutboundPeers int
+	DiscoveryInterval   time.Duration
+	PeerRecordLifetime  time.Duration
+
+	// Gossip is sent to GossipFanout random connections and forwarded for at most GossipTTL hops.
+	// Messages are recognized as duplicates for GossipSeenWindow after first seen
+	GossipFanout     int
+	GossipTTL        int
+	GossipSeenWindow time.Duration
+
+	// Peers lose score when they misbehave and regain ScoreRecoveryPerMinute.
+	// A peer whose score reaches BanThreshold is disconnected and refused for BanDuration
+	BanThreshold           float64
+	BanDuration            time.Duration
+	ScoreRecoveryPerMinute float64
+
+	// A Permissioned network only accepts the tags in Allowlist. The tags are added and removed
+	// live by validator set transactions, if signed by one of ValidatorSetAdmins
+	Permissioned       bool
+	Allowlist          []string
+	ValidatorSetAdmins []string
 }
 
 func Factory(diCtx *di.DIContext) *NetworkConfig {
 	return &NetworkConfig{
-		HandshakeHost: "localhost:2503",
-		GossipHost:    "localhost:2504",
+		HandshakeHost:           "localhost:2503",
+		GossipHost:              "localhost:2504",
+		AdvertisedHandshakeHost: "",
+		AdvertisedGossipHost:    "",
+		MaxConcurrentHandshakes: 64,
+		HandshakesPerSecond:     10,
+		HandshakeBurst:          20,
+		KeepAliveInterval:       5 * time.Second,
+		IdleTimeout:             15 * time.Second,
+		ReconnectMinBackoff:     time.Second,
+		ReconnectMaxBackoff:     30 * time.Second,
+		RekeyInterval:           10 * time.Minute,
+		SessionLifetime:         time.Hour,
+		TicketLifetime:          24 * time.Hour,
+		BootstrapPeers:          []string{},
+		TargetOutboundPeers:     8,
+		DiscoveryInterval:       30 * time.Second,
+		PeerRecordLifetime:      24 * time.Hour,
+		GossipFanout:            6,
+		GossipTTL:               6,
+		GossipSeenWindow:        2 * time.Minute,
+		BanThreshold:            0,
+		BanDuration:             24 * time.Hour,
+		ScoreRecoveryPerMinute:  1,
+		Permissioned:            false,
+		Allowlist:               []string{},
+		ValidatorSetAdmins:      []string{},
 	}
 }
 
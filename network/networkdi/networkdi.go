@@ -3,19 +3,28 @@ package networkdi
 import (
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/allowlist"
+	"github.com/titosilva/drmchain-pos/network/discovery"
+	"github.com/titosilva/drmchain-pos/network/gossiprouter"
 	"github.com/titosilva/drmchain-pos/network/internal/connections"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/gossip"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/hosts/handshake"
 	"github.com/titosilva/drmchain-pos/network/internal/connections/sessions"
 	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
 )
 
 func AddNetworkServices(diCtx *di.DIContext) *di.DIContext {
 	di.AddSingleton(diCtx, sessions.Factory)
 	di.AddInterfaceFactory(diCtx, handshake.Factory)
+	di.AddInterfaceSingleton(diCtx, handshake.ListenerFactory)
 	di.AddInterfaceFactory(diCtx, gossip.Factory)
 	di.AddInterfaceFactory(diCtx, connections.Factory)
 	di.AddSingleton(diCtx, network.Factory)
+	di.AddSingleton(diCtx, discovery.Factory)
+	di.AddSingleton(diCtx, gossiprouter.Factory)
+	di.AddSingleton(diCtx, reputation.Factory)
+	di.AddSingleton(diCtx, allowlist.Factory)
 	di.AddSingleton(diCtx, networkconfig.Factory)
 
 	return diCtx
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"errors"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/identity"
+	"github.com/titosilva/drmchain-pos/identity/signatures"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+)
+
+var ErrInvalidSignature = errors.New("peer record signature is invalid")
+
+// PeerRecord tells where a peer can be reached. It is signed by the peer, so anyone can share it.
+// Addr is the address of the handshake host of the peer.
+type PeerRecord struct {
+	Tag       string
+	Addr      string
+	Timestamp int64 // Unix seconds when the owner signed the record. Newer records replace older ones.
+	Signature []byte
+}
+
+type peerRecordContent struct {
+	Tag       string
+	Addr      string
+	Timestamp int64
+}
+
+// NewPeerRecord creates a record for self, signed now.
+func NewPeerRecord(self identity.PrivateIdentity, addr string) (PeerRecord, error) {
+	return SignPeerRecord(self, addr, time.Now())
+}
+
+// SignPeerRecord creates a record for self, signed at the given time.
+func SignPeerRecord(self identity.PrivateIdentity, addr string, at time.Time) (PeerRecord, error) {
+	record := PeerRecord{
+		Tag:       self.GetTag(),
+		Addr:      addr,
+		Timestamp: at.Unix(),
+	}
+
+	content, err := record.content()
+	if err != nil {
+		return PeerRecord{}, err
+	}
+
+	record.Signature, err = signatures.Sign(self, content)
+	if err != nil {
+		return PeerRecord{}, err
+	}
+
+	return record, nil
+}
+
+// Verify checks that the record was signed by the owner of the tag, and that the address is well formed.
+func (r PeerRecord) Verify() error {
+	id, err := identity.FromTag(r.Tag)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ParseAddress(r.Addr); err != nil {
+		return err
+	}
+
+	content, err := r.content()
+	if err != nil {
+		return err
+	}
+
+	if !signatures.Verify(id, content, r.Signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (r PeerRecord) GetIdentity() (identity.PublicIdentity, error) {
+	return identity.FromTag(r.Tag)
+}
+
+func (r PeerRecord) GetAddress() (Address, error) {
+	return ParseAddress(r.Addr)
+}
+
+func (r PeerRecord) content() ([]byte, error) {
+	return encodings.Encode(peerRecordContent{Tag: r.Tag, Addr: r.Addr, Timestamp: r.Timestamp})
+}
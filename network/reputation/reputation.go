@@ -0,0 +1,226 @@
+package reputation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/internal/di"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/clru"
+	"github.com/titosilva/drmchain-pos/internal/structures/concurrency/observable"
+	"github.com/titosilva/drmchain-pos/network/encodings"
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/storage"
+)
+
+// Misbehavior is something a peer did that an honest peer would not.
+// It is only reported for what the peer signed or sent in its own session, so nobody can get another peer blamed.
+type Misbehavior string
+
+const (
+	MisbehaviorUndecodable        Misbehavior = "undecodable"
+	MisbehaviorBadSignature       Misbehavior = "bad-signature"
+	MisbehaviorWrongSequence      Misbehavior = "wrong-sequence"
+	MisbehaviorInvalidTransaction Misbehavior = "invalid-transaction"
+	MisbehaviorInvalidConsensus   Misbehavior = "invalid-consensus"
+)
+
+// Penalties are subtracted from the score of the peer. Invalid transactions weigh little,
+// as an honest peer may relay a transaction that its state accepts and ours does not.
+var penalties = map[Misbehavior]float64{
+	MisbehaviorUndecodable:        10,
+	MisbehaviorBadSignature:       25,
+	MisbehaviorWrongSequence:      25,
+	MisbehaviorInvalidTransaction: 5,
+	MisbehaviorInvalidConsensus:   20,
+}
+
+// Misbehaviors an honest peer may commit lower the score at most halfway to BanThreshold,
+// so they never get a peer banned by themselves, however many transactions it relays.
+var honestMistakes = map[Misbehavior]bool{
+	MisbehaviorInvalidTransaction: true,
+}
+
+const (
+	banListKey = "banlist"
+
+	InitialScore = 100
+
+	// Scores of the peers that misbehaved least recently are forgotten first
+	scoresCapacity = 4096
+)
+
+type score struct {
+	value     float64
+	updatedAt time.Time
+}
+
+type ban struct {
+	Tag   string
+	Until int64 // Unix seconds
+}
+
+type banListData struct {
+	Bans []ban
+}
+
+// Reputation scores peers by identity tag. Scores start at InitialScore, drop with each misbehavior
+// and recover ScoreRecoveryPerMinute back to InitialScore. A peer whose score reaches BanThreshold
+// is banned for BanDuration, and the ban list is persisted in a BlobStorage.
+type Reputation struct {
+	storage       storage.BlobStorage
+	configuration *networkconfig.NetworkConfig
+
+	scores    *clru.Cache[string, score]
+	scoresMux *sync.Mutex
+	bans      map[string]time.Time
+	mux       *sync.Mutex // Orders the changes to the bans with the saves
+
+	banned *observable.Observable[string]
+}
+
+func Factory(diCtx *di.DIContext) *Reputation {
+	return New(storage.GetFromDI(diCtx), networkconfig.GetFromDI(diCtx))
+}
+
+func GetFromDI(diCtx *di.DIContext) *Reputation {
+	return di.GetService[Reputation](diCtx)
+}
+
+func New(storage storage.BlobStorage, config *networkconfig.NetworkConfig) *Reputation {
+	return &Reputation{
+		storage:       storage,
+		configuration: config,
+		scores:        clru.New[string, score](scoresCapacity),
+		scoresMux:     &sync.Mutex{},
+		bans:          make(map[string]time.Time),
+		mux:           &sync.Mutex{},
+		banned:        observable.New[string](),
+	}
+}
+
+// Load reads the bans saved by an earlier run, dropping the expired ones. A ban list never saved loads empty.
+func (r *Reputation) Load() error {
+	exists, err := r.storage.Exists(banListKey)
+	if err != nil || !exists {
+		return err
+	}
+
+	bs, err := r.storage.Retrieve(banListKey)
+	if err != nil {
+		return err
+	}
+
+	var data banListData
+	if err := encodings.Decode(bs, &data); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	now := time.Now()
+	for _, b := range data.Bans {
+		if until := time.Unix(b.Until, 0); until.After(now) {
+			r.bans[b.Tag] = until
+		}
+	}
+
+	return nil
+}
+
+// Report lowers the score of the peer, banning it if the score reaches the threshold.
+// Honest mistakes alone never reach it.
+func (r *Reputation) Report(tag string, misbehavior Misbehavior) error {
+	r.scoresMux.Lock()
+	recovered := r.recoveredScore(tag)
+	current := recovered - penalties[misbehavior]
+	if honestMistakes[misbehavior] {
+		floor := r.configuration.BanThreshold + (InitialScore-r.configuration.BanThreshold)/2
+		current = max(current, min(recovered, floor))
+	}
+
+	if current > r.configuration.BanThreshold {
+		r.scores.Put(tag, score{value: current, updatedAt: time.Now()})
+		r.scoresMux.Unlock()
+		return nil
+	}
+
+	// The next ban, if any, starts from a clean score
+	r.scores.Put(tag, score{value: InitialScore, updatedAt: time.Now()})
+	r.scoresMux.Unlock()
+
+	return r.Ban(tag, r.configuration.BanDuration)
+}
+
+// GetScore returns the score of the peer, with the recovery since its last misbehavior.
+func (r *Reputation) GetScore(tag string) float64 {
+	r.scoresMux.Lock()
+	defer r.scoresMux.Unlock()
+
+	return r.recoveredScore(tag)
+}
+
+// recoveredScore must be called with scoresMux held
+func (r *Reputation) recoveredScore(tag string) float64 {
+	s, found := r.scores.Get(tag)
+	if !found {
+		return InitialScore
+	}
+
+	recovered := s.value + time.Since(s.updatedAt).Minutes()*r.configuration.ScoreRecoveryPerMinute
+	return min(recovered, InitialScore)
+}
+
+// Ban refuses the peer for the duration, and notifies the subscribers of SubscribeBans.
+func (r *Reputation) Ban(tag string, duration time.Duration) error {
+	r.mux.Lock()
+	r.bans[tag] = time.Now().Add(duration)
+	err := r.save()
+	r.mux.Unlock()
+
+	r.banned.Notify(tag)
+	return err
+}
+
+func (r *Reputation) Unban(tag string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	delete(r.bans, tag)
+	return r.save()
+}
+
+func (r *Reputation) IsBanned(tag string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	until, found := r.bans[tag]
+	return found && time.Now().Before(until)
+}
+
+// SubscribeBans notifies the tags of the peers banned from now on.
+func (r *Reputation) SubscribeBans() *observable.Subscription[string] {
+	return r.banned.Subscribe()
+}
+
+// save must be called with mux held. Expired bans are dropped.
+func (r *Reputation) save() error {
+	data := banListData{Bans: make([]ban, 0, len(r.bans))}
+
+	now := time.Now()
+	for tag, until := range r.bans {
+		if !until.After(now) {
+			delete(r.bans, tag)
+			continue
+		}
+
+		data.Bans = append(data.Bans, ban{Tag: tag, Until: until.Unix()})
+	}
+
+	bs, err := encodings.Encode(data)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.Store(banListKey, bs)
+}
@@ -0,0 +1,130 @@
+package reputation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titosilva/drmchain-pos/network/networkconfig"
+	"github.com/titosilva/drmchain-pos/network/reputation"
+	"github.com/titosilva/drmchain-pos/storage/localstorage"
+)
+
+func newConfig() *networkconfig.NetworkConfig {
+	config := networkconfig.Factory(nil)
+	config.ScoreRecoveryPerMinute = 0
+	return config
+}
+
+func Test__Report__ShouldLowerScore__WhenPeerMisbehaves(t *testing.T) {
+	// Arrange
+	rep := reputation.New(localstorage.New(t.TempDir()), newConfig())
+
+	// Act
+	rep.Report("peer", reputation.MisbehaviorUndecodable)
+
+	// Assert
+	if score := rep.GetScore("peer"); score >= reputation.InitialScore {
+		t.Fatalf("expected score below %d, got %f", reputation.InitialScore, score)
+	}
+
+	if rep.GetScore("other") != reputation.InitialScore {
+		t.Fatal("expected other peers to keep their score")
+	}
+}
+
+func Test__Report__ShouldBanAndNotify__WhenScoreReachesThreshold(t *testing.T) {
+	// Arrange
+	rep := reputation.New(localstorage.New(t.TempDir()), newConfig())
+	sub := rep.SubscribeBans()
+	defer sub.Unsubscribe()
+
+	// Act
+	for range 4 {
+		if err := rep.Report("peer", reputation.MisbehaviorBadSignature); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Assert
+	if !rep.IsBanned("peer") {
+		t.Fatal("expected peer to be banned")
+	}
+
+	tag, ok := sub.WaitNextWithTimeoutMs(500)
+	if !ok || tag != "peer" {
+		t.Fatalf("expected ban to be notified, got %q", tag)
+	}
+}
+
+func Test__Report__ShouldNotBan__WhenPeerOnlyRelaysInvalidTransactions(t *testing.T) {
+	// Arrange
+	rep := reputation.New(localstorage.New(t.TempDir()), newConfig())
+
+	// Act
+	for range 100 {
+		if err := rep.Report("peer", reputation.MisbehaviorInvalidTransaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Assert
+	if rep.IsBanned("peer") {
+		t.Fatal("expected invalid transactions alone not to ban the peer")
+	}
+
+	for range 2 {
+		if err := rep.Report("peer", reputation.MisbehaviorBadSignature); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !rep.IsBanned("peer") {
+		t.Fatal("expected other misbehaviors to still ban the peer")
+	}
+}
+
+func Test__GetScore__ShouldRecover__WhenTimePasses(t *testing.T) {
+	// Arrange
+	config := newConfig()
+	config.ScoreRecoveryPerMinute = 60 * 1000 // A point per millisecond
+	rep := reputation.New(localstorage.New(t.TempDir()), config)
+	rep.Report("peer", reputation.MisbehaviorUndecodable)
+
+	// Act
+	time.Sleep(20 * time.Millisecond)
+
+	// Assert
+	if score := rep.GetScore("peer"); score != reputation.InitialScore {
+		t.Fatalf("expected score to recover to %d, got %f", reputation.InitialScore, score)
+	}
+}
+
+func Test__Load__ShouldRestoreBans__WhenSavedByEarlierRun(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	first := reputation.New(localstorage.New(dir), newConfig())
+	if err := first.Ban("banned", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ban("expired", -time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	second := reputation.New(localstorage.New(dir), newConfig())
+
+	// Act
+	err := second.Load()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !second.IsBanned("banned") {
+		t.Fatal("expected ban to be restored")
+	}
+
+	if second.IsBanned("expired") {
+		t.Fatal("expected expired ban to be dropped")
+	}
+}
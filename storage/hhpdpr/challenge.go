@@ -0,0 +1,142 @@
+package hhpdpr
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"slices"
+
+	"github.com/titosilva/drmchain-pos/network/encodings"
+)
+
+var (
+	ErrInvalidChallenge = errors.New("invalid challenge")
+	ErrInvalidProof     = errors.New("proof does not match the file tags")
+)
+
+// Size in bytes of the random coefficients of a challenge
+const CoefficientSize = 16
+
+// Challenge asks the storage node for a random linear combination of some blocks.
+type Challenge struct {
+	Indexes      []int64
+	Coefficients [][]byte
+}
+
+// Proof is the linear combination of the challenged blocks, reduced modulo p - 1.
+type Proof struct {
+	Mu []byte
+}
+
+// NewChallenge picks count distinct blocks, or every block when the file has fewer, with random coefficients.
+func NewChallenge(blockCount int64, count int) (*Challenge, error) {
+	if blockCount <= 0 || count <= 0 {
+		return nil, ErrInvalidChallenge
+	}
+
+	count = int(min(int64(count), blockCount))
+	challenge := &Challenge{
+		Indexes:      make([]int64, 0, count),
+		Coefficients: make([][]byte, 0, count),
+	}
+
+	for len(challenge.Indexes) < count {
+		index, err := rand.Int(rand.Reader, big.NewInt(blockCount))
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(challenge.Indexes, index.Int64()) {
+			continue
+		}
+
+		coefficient := make([]byte, CoefficientSize)
+		if _, err := rand.Read(coefficient); err != nil {
+			return nil, err
+		}
+
+		challenge.Indexes = append(challenge.Indexes, index.Int64())
+		challenge.Coefficients = append(challenge.Coefficients, coefficient)
+	}
+
+	return challenge, nil
+}
+
+// Prove computes the answer of the storage node using the stored blocks.
+func Prove(params *Params, blocks [][]byte, challenge *Challenge) (*Proof, error) {
+	if err := challenge.validate(int64(len(blocks))); err != nil {
+		return nil, err
+	}
+
+	mu := big.NewInt(0)
+	for i, index := range challenge.Indexes {
+		term := new(big.Int).Mul(blockValue(blocks[index]), challenge.coefficient(i))
+		mu.Add(mu, term)
+	}
+
+	mu.Mod(mu, params.exponentModulus())
+	return &Proof{Mu: mu.Bytes()}, nil
+}
+
+// Verify checks the proof using only the public file tags: g^mu must equal the product of tag_i^c_i.
+func Verify(params *Params, tags *FileTags, challenge *Challenge, proof *Proof) error {
+	if err := challenge.validate(tags.GetBlockCount()); err != nil {
+		return err
+	}
+
+	expected := big.NewInt(1)
+	for i, index := range challenge.Indexes {
+		term := new(big.Int).Exp(tags.getTag(index), challenge.coefficient(i), params.Prime)
+		expected = params.MultiplyHidden(expected, term)
+	}
+
+	if !params.VerifyHidden(new(big.Int).SetBytes(proof.Mu), expected) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+func (c *Challenge) validate(blockCount int64) error {
+	if len(c.Indexes) == 0 || len(c.Indexes) != len(c.Coefficients) {
+		return ErrInvalidChallenge
+	}
+
+	for _, index := range c.Indexes {
+		if index < 0 || index >= blockCount {
+			return ErrInvalidChallenge
+		}
+	}
+
+	return nil
+}
+
+func (c *Challenge) coefficient(i int) *big.Int {
+	return new(big.Int).SetBytes(c.Coefficients[i])
+}
+
+func (c *Challenge) Serialize() ([]byte, error) {
+	return encodings.Encode(c)
+}
+
+func DeserializeChallenge(data []byte) (*Challenge, error) {
+	challenge, err := encodings.DecodeAs[Challenge](data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+func (p *Proof) Serialize() ([]byte, error) {
+	return encodings.Encode(p)
+}
+
+func DeserializeProof(data []byte) (*Proof, error) {
+	proof, err := encodings.DecodeAs[Proof](data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proof, nil
+}
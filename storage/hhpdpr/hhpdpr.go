@@ -0,0 +1,81 @@
+// Package hhpdpr implements proofs of data possession based on homomorphic hashes,
+// as prototyped in docs/prototypes/hh_pdpr.sage.
+//
+// A block m is tagged with HH(m) = g^m mod p. Since HH(a + b) = HH(a) * HH(b),
+// a verifier holding only the tags can check a linear combination of blocks computed by the storage node.
+package hhpdpr
+
+import (
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrEmptyFile        = errors.New("file is empty")
+	ErrInvalidBlockSize = errors.New("invalid block size")
+)
+
+// RFC 3526, group 16 (4096 bits)
+const group16Hex = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3BE39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF6955817183995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E208E24FA074E5AB3143DB5BFCE0FD108E4B82D120A92108011A723C12A787E6D788719A10BDBA5B2699C327186AF4E23C1A946834B6150BDA2583E9CA2AD44CE8DBBBC2DB04DE8EF92E8EFC141FBECAA6287C59474E6BC05D99B2964FA090C3A2233BA186515BE7ED1F612970CEE2D7AFB81BDD762170481CD0069127D5B05AA993B4EA988D8FDDC186FFB7DC90A6C08F4DF435C934063199FFFFFFFFFFFFFFFF"
+
+const DefaultBlockSize = 256
+
+// Params are the public group parameters shared by the data owner, the storage node and the verifiers.
+type Params struct {
+	Prime     *big.Int
+	Generator *big.Int
+}
+
+// DefaultParams uses the same group as the Sage prototype.
+func DefaultParams() *Params {
+	prime, _ := new(big.Int).SetString(group16Hex, 16)
+
+	return &Params{
+		Prime:     prime,
+		Generator: big.NewInt(2),
+	}
+}
+
+// Hide computes the homomorphic hash of x.
+func (p *Params) Hide(x *big.Int) *big.Int {
+	return new(big.Int).Exp(p.Generator, x, p.Prime)
+}
+
+// MultiplyHidden combines two hashes. The result is the hash of the sum of the hidden values.
+func (p *Params) MultiplyHidden(hiddenX, hiddenY *big.Int) *big.Int {
+	product := new(big.Int).Mul(hiddenX, hiddenY)
+	return product.Mod(product, p.Prime)
+}
+
+// VerifyHidden checks that expected is the hash of x.
+func (p *Params) VerifyHidden(x *big.Int, expected *big.Int) bool {
+	return p.Hide(x).Cmp(expected) == 0
+}
+
+// exponentModulus is the modulus exponents can be reduced by, since g^(p-1) = 1 mod p.
+func (p *Params) exponentModulus() *big.Int {
+	return new(big.Int).Sub(p.Prime, big.NewInt(1))
+}
+
+// SplitBlocks divides the file into blocks of at most blockSize bytes.
+func SplitBlocks(data []byte, blockSize int) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	if blockSize <= 0 {
+		return nil, ErrInvalidBlockSize
+	}
+
+	blocks := make([][]byte, 0, (len(data)+blockSize-1)/blockSize)
+	for start := 0; start < len(data); start += blockSize {
+		end := min(start+blockSize, len(data))
+		blocks = append(blocks, data[start:end])
+	}
+
+	return blocks, nil
+}
+
+func blockValue(block []byte) *big.Int {
+	return new(big.Int).SetBytes(block)
+}
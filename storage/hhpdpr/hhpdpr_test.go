@@ -0,0 +1,216 @@
+package hhpdpr_test
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/titosilva/drmchain-pos/storage/hhpdpr"
+)
+
+// Generated by docs/prototypes/hh_pdpr_vectors.sage
+type sageVectors struct {
+	AssetSeed             string   `json:"asset_seed"`
+	AssetTag              string   `json:"asset_tag"`
+	AssetKey              string   `json:"asset_key"`
+	AssetKeyTag           string   `json:"asset_key_tag"`
+	AssetEncrypted        string   `json:"asset_encrypted"`
+	File                  string   `json:"file"`
+	BlockSize             int      `json:"block_size"`
+	BlockTags             []string `json:"block_tags"`
+	ChallengeIndexes      []int64  `json:"challenge_indexes"`
+	ChallengeCoefficients []string `json:"challenge_coefficients"`
+	Mu                    string   `json:"mu"`
+}
+
+func Test__Hide__ShouldMatchSageVectors__ForAssetVerification(t *testing.T) {
+	// Arrange
+	params := hhpdpr.DefaultParams()
+	vectors := loadVectors(t)
+	seedHash := sha256.Sum256([]byte(vectors.AssetSeed))
+	asset := new(big.Int).SetBytes(seedHash[:])
+	key := fromHex(t, vectors.AssetKey)
+
+	// Act
+	assetTag := params.Hide(asset)
+	keyTag := params.Hide(key)
+	encrypted := new(big.Int).Add(asset, key)
+
+	// Assert
+	if assetTag.Cmp(fromHex(t, vectors.AssetTag)) != 0 {
+		t.Error("Asset tag does not match the Sage vector")
+	}
+
+	if keyTag.Cmp(fromHex(t, vectors.AssetKeyTag)) != 0 {
+		t.Error("Key tag does not match the Sage vector")
+	}
+
+	if encrypted.Cmp(fromHex(t, vectors.AssetEncrypted)) != 0 {
+		t.Error("Encrypted asset does not match the Sage vector")
+	}
+
+	if !params.VerifyHidden(encrypted, params.MultiplyHidden(assetTag, keyTag)) {
+		t.Error("Expected HH(F + K) = HH(F) * HH(K)")
+	}
+}
+
+func Test__Prove__ShouldMatchSageVectors__ForFileChallenge(t *testing.T) {
+	// Arrange
+	params := hhpdpr.DefaultParams()
+	vectors := loadVectors(t)
+	challenge := &hhpdpr.Challenge{Indexes: vectors.ChallengeIndexes}
+	for _, coefficient := range vectors.ChallengeCoefficients {
+		challenge.Coefficients = append(challenge.Coefficients, fromHex(t, coefficient).Bytes())
+	}
+
+	// Act
+	tags, err := hhpdpr.Setup(params, []byte(vectors.File), vectors.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, _ := hhpdpr.SplitBlocks([]byte(vectors.File), vectors.BlockSize)
+	proof, err := hhpdpr.Prove(params, blocks, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if len(tags.Tags) != len(vectors.BlockTags) {
+		t.Fatalf("Expected %d tags, got %d", len(vectors.BlockTags), len(tags.Tags))
+	}
+
+	for i, tag := range vectors.BlockTags {
+		if new(big.Int).SetBytes(tags.Tags[i]).Cmp(fromHex(t, tag)) != 0 {
+			t.Errorf("Tag of block %d does not match the Sage vector", i)
+		}
+	}
+
+	if new(big.Int).SetBytes(proof.Mu).Cmp(fromHex(t, vectors.Mu)) != 0 {
+		t.Error("Proof does not match the Sage vector")
+	}
+
+	if err := hhpdpr.Verify(params, tags, challenge, proof); err != nil {
+		t.Errorf("Expected proof to be valid, got %v", err)
+	}
+}
+
+func Test__Verify__ShouldAccept__WhenNodeHoldsFile(t *testing.T) {
+	// Arrange
+	params := hhpdpr.DefaultParams()
+	data := randomBytes(t, 10*hhpdpr.DefaultBlockSize+3)
+	tags, err := hhpdpr.Setup(params, data, hhpdpr.DefaultBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, _ := hhpdpr.SplitBlocks(data, hhpdpr.DefaultBlockSize)
+	challenge, err := hhpdpr.NewChallenge(tags.GetBlockCount(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	proof, err := hhpdpr.Prove(params, blocks, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if err := hhpdpr.Verify(params, tags, challenge, proof); err != nil {
+		t.Errorf("Expected proof to be valid, got %v", err)
+	}
+}
+
+func Test__Verify__ShouldReject__WhenBlockIsTampered(t *testing.T) {
+	// Arrange
+	params := hhpdpr.DefaultParams()
+	data := randomBytes(t, 4*hhpdpr.DefaultBlockSize)
+	tags, _ := hhpdpr.Setup(params, data, hhpdpr.DefaultBlockSize)
+	blocks, _ := hhpdpr.SplitBlocks(data, hhpdpr.DefaultBlockSize)
+	challenge, _ := hhpdpr.NewChallenge(tags.GetBlockCount(), 4)
+
+	blocks[challenge.Indexes[0]] = randomBytes(t, hhpdpr.DefaultBlockSize)
+
+	// Act
+	proof, err := hhpdpr.Prove(params, blocks, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if err := hhpdpr.Verify(params, tags, challenge, proof); !errors.Is(err, hhpdpr.ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func Test__Deserialize__ShouldReturnObjects__SerializedBefore(t *testing.T) {
+	// Arrange
+	params := hhpdpr.DefaultParams()
+	data := randomBytes(t, 3*hhpdpr.DefaultBlockSize)
+	tags, _ := hhpdpr.Setup(params, data, hhpdpr.DefaultBlockSize)
+	blocks, _ := hhpdpr.SplitBlocks(data, hhpdpr.DefaultBlockSize)
+	challenge, _ := hhpdpr.NewChallenge(tags.GetBlockCount(), 2)
+	proof, _ := hhpdpr.Prove(params, blocks, challenge)
+
+	tagsBs, _ := tags.Serialize()
+	challengeBs, _ := challenge.Serialize()
+	proofBs, _ := proof.Serialize()
+
+	// Act
+	decodedTags, err := hhpdpr.DeserializeFileTags(tagsBs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedChallenge, err := hhpdpr.DeserializeChallenge(challengeBs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedProof, err := hhpdpr.DeserializeProof(proofBs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if err := hhpdpr.Verify(params, decodedTags, decodedChallenge, decodedProof); err != nil {
+		t.Errorf("Expected decoded proof to be valid, got %v", err)
+	}
+}
+
+func loadVectors(t *testing.T) sageVectors {
+	bs, err := os.ReadFile("testdata/sage_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors sageVectors
+	if err := json.Unmarshal(bs, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	return vectors
+}
+
+func fromHex(t *testing.T, value string) *big.Int {
+	x, ok := new(big.Int).SetString(value, 16)
+	if !ok {
+		t.Fatalf("Invalid hex vector %q", value)
+	}
+
+	return x
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	bs := make([]byte, size)
+	if _, err := rand.Read(bs); err != nil {
+		t.Fatal(err)
+	}
+
+	return bs
+}
@@ -0,0 +1,59 @@
+package hhpdpr
+
+import (
+	"math/big"
+
+	"github.com/titosilva/drmchain-pos/network/encodings"
+)
+
+// FileTags are the public homomorphic hashes of the blocks of a file.
+// They are computed once by the data owner and are enough to audit a storage node.
+type FileTags struct {
+	BlockSize int64
+	Tags      [][]byte
+}
+
+// Setup splits the file into blocks and tags each of them.
+func Setup(params *Params, data []byte, blockSize int) (*FileTags, error) {
+	blocks, err := SplitBlocks(data, blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := &FileTags{
+		BlockSize: int64(blockSize),
+		Tags:      make([][]byte, 0, len(blocks)),
+	}
+
+	for _, block := range blocks {
+		tags.Tags = append(tags.Tags, TagBlock(params, block).Bytes())
+	}
+
+	return tags, nil
+}
+
+// TagBlock computes the homomorphic hash of a single block.
+func TagBlock(params *Params, block []byte) *big.Int {
+	return params.Hide(blockValue(block))
+}
+
+func (ft *FileTags) GetBlockCount() int64 {
+	return int64(len(ft.Tags))
+}
+
+func (ft *FileTags) getTag(index int64) *big.Int {
+	return new(big.Int).SetBytes(ft.Tags[index])
+}
+
+func (ft *FileTags) Serialize() ([]byte, error) {
+	return encodings.Encode(ft)
+}
+
+func DeserializeFileTags(data []byte) (*FileTags, error) {
+	tags, err := encodings.DecodeAs[FileTags](data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tags, nil
+}
@@ -3,11 +3,13 @@ package transactionnetwork_test
 import (
 	"testing"
 
+	"github.com/titosilva/drmchain-pos/blocks/blocksdi"
 	"github.com/titosilva/drmchain-pos/identity/signatures"
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/internal/di/defaultdi"
 	identityprovider "github.com/titosilva/drmchain-pos/internal/shared/identity_provider"
 	"github.com/titosilva/drmchain-pos/network"
+	"github.com/titosilva/drmchain-pos/network/memnet"
 	"github.com/titosilva/drmchain-pos/network/networkconfig"
 	"github.com/titosilva/drmchain-pos/network/networkdi"
 	"github.com/titosilva/drmchain-pos/transactions"
@@ -17,19 +19,21 @@ import (
 )
 
 func Test__TransactionPropagationWith3Hosts(t *testing.T) {
-	nw1, di1, err := openNetwork("localhost:2503", "localhost:2504")
+	mem := memnet.New(1)
+
+	nw1, di1, err := openNetwork(mem, "localhost:2503", "localhost:2504")
 	if err != nil {
 		t.Fatalf("Error opening network 1: %s", err)
 	}
 	defer nw1.Close()
 
-	nw2, di2, err := openNetwork("localhost:2505", "localhost:2506")
+	nw2, di2, err := openNetwork(mem, "localhost:2505", "localhost:2506")
 	if err != nil {
 		t.Fatalf("Error opening network 2: %s", err)
 	}
 	defer nw2.Close()
 
-	nw3, di3, err := openNetwork("localhost:2507", "localhost:2508")
+	nw3, di3, err := openNetwork(mem, "localhost:2507", "localhost:2508")
 	if err != nil {
 		t.Fatalf("Error opening network 3: %s", err)
 	}
@@ -130,9 +134,12 @@ func Test__TransactionPropagationWith3Hosts(t *testing.T) {
 	}
 }
 
-func newDI(handshakeHost string, gossipHost string) *di.DIContext {
+// newDI connects the node through the in-memory network, so the addresses are not bound
+func newDI(mem *memnet.Network, handshakeHost string, gossipHost string) *di.DIContext {
 	diCtx := defaultdi.ConfigureDefaultDI()
+	diCtx = blocksdi.AddBlocksServices(diCtx)
 	diCtx = networkdi.AddNetworkServices(diCtx)
+	diCtx = mem.AddTransport(diCtx)
 	diCtx = transactionsdi.AddTransactionServices(diCtx)
 
 	config := networkconfig.GetFromDI(diCtx)
@@ -142,8 +149,8 @@ func newDI(handshakeHost string, gossipHost string) *di.DIContext {
 	return diCtx
 }
 
-func openNetwork(handshakeHost string, gossipHost string) (*network.Network, *di.DIContext, error) {
-	diCtx := newDI(handshakeHost, gossipHost)
+func openNetwork(mem *memnet.Network, handshakeHost string, gossipHost string) (*network.Network, *di.DIContext, error) {
+	diCtx := newDI(mem, handshakeHost, gossipHost)
 	net := di.GetService[network.Network](diCtx)
 
 	if err := net.Open(); err != nil {
@@ -1,6 +1,8 @@
 package workflow
 
 import (
+	"github.com/titosilva/drmchain-pos/blocks/history"
+	"github.com/titosilva/drmchain-pos/drm/drmmiddlewares"
 	"github.com/titosilva/drmchain-pos/internal/di"
 	"github.com/titosilva/drmchain-pos/transactions"
 	"github.com/titosilva/drmchain-pos/transactions/middlewares"
@@ -17,6 +19,7 @@ func Factory(diCtx *di.DIContext) transactions.TransactionWorkflow {
 
 	notifier := notifier.GetFromDI(diCtx)
 	builder.AddMiddleware(middlewares.NewSignatureValidatorMiddleware())
+	builder.AddMiddleware(drmmiddlewares.NewUsageValidatorMiddleware(history.GetFromDI(diCtx)))
 	builder.AddMiddleware(middlewares.NewTransactionNotifierMiddleware(notifier))
 
 	pool := pool.GetFromDI(diCtx)